@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ============================================================================
+// CONTRÔLE DES ACCÈS - Droits et quotas des comptes sur les opérations du partage
+// ============================================================================
+
+// Actions vérifiées par AccessController (CanAccess et CheckQuota)
+const (
+	accessRead     = "read"
+	accessDownload = "download"
+	accessCreate   = "create"
+	accessWrite    = "write"
+	accessUpload   = "upload"
+	accessDelete   = "delete"
+)
+
+// quotaMu protège les compteurs des quotas, partagés par les connexions d'un même compte
+var quotaMu sync.Mutex
+
+// CanRead vérifie si le compte du client peut voir un chemin (arborescence,
+// manifeste, diffusions). Un dossier reste visible s'il mène à un chemin autorisé.
+func (cs *ClientSession) CanRead(path string, isDir bool) bool {
+	if cs.User == nil {
+		return true
+	}
+	if ok, _ := GetAccessController().CanAccess(cs.User.ID, path, accessRead); ok {
+		return true
+	}
+	if !isDir {
+		return false
+	}
+	user, ok := GetUserManager().GetUser(cs.User.ID)
+	return ok && leadsToAllowedPath(user, path)
+}
+
+// account retourne l'état actuel du compte du client: les rôles et droits
+// peuvent changer au rechargement de la configuration. nil si le compte a été supprimé.
+func (cs *ClientSession) account() *User {
+	user, ok := GetUserManager().GetUser(cs.User.ID)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// leadsToAllowedPath indique si un dossier contient un chemin autorisé du compte
+func leadsToAllowedPath(user *User, dir string) bool {
+	perms := user.Permissions
+	if perms == nil || !user.IsActive || !user.Role.CanRead() {
+		return false
+	}
+	for _, denied := range perms.DeniedPaths {
+		if matchPath(dir, denied) {
+			return false
+		}
+	}
+	for _, allowed := range perms.AllowedPaths {
+		if strings.HasPrefix(allowed, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize vérifie les droits du compte d'un client pour une action sur un chemin
+// Un refus est journalisé, enregistré dans l'audit et signalé au client.
+func (s *Server) authorize(sess *ClientSession, refID, path, action string) bool {
+	if _, ok := cleanRelPath(path); !ok {
+		addLog(fmt.Sprintf("🚫 %s: %s refusé sur %s (chemin invalide)", sess.Name, action, path))
+		return false
+	}
+	if sess.User == nil {
+		return true
+	}
+	ok, reason := GetAccessController().CanAccess(sess.User.ID, path, action)
+	if !ok {
+		s.denyAccess(sess, refID, path, action, reason)
+	}
+	return ok
+}
+
+// authorizeChange vérifie les droits nécessaires à une opération reçue d'un client
+func (s *Server) authorizeChange(sess *ClientSession, refID string, msg FileChange) bool {
+	switch msg.Op {
+	case "move":
+		return s.authorize(sess, refID, msg.OldName, accessDelete) &&
+			s.authorize(sess, refID, msg.FileName, accessCreate)
+	case "remove":
+		return s.authorize(sess, refID, msg.FileName, accessDelete)
+	case "create", "mkdir":
+		return s.authorize(sess, refID, msg.FileName, accessCreate)
+	default:
+		return s.authorize(sess, refID, msg.FileName, accessWrite)
+	}
+}
+
+// checkQuota vérifie que le compte d'un client peut envoyer (upload) ou
+// recevoir (download) size octets, et créer un fichier de plus
+func (s *Server) checkQuota(sess *ClientSession, refID, path, action string, size int64) bool {
+	if sess.User == nil {
+		return true
+	}
+
+	quotaMu.Lock()
+	ok, reason := GetAccessController().CheckQuota(sess.User.ID, size, action)
+	if ok && action == accessUpload {
+		quota := sess.User.Quota
+		if _, err := os.Stat(filepath.Join(s.WatchDir, filepath.FromSlash(path))); os.IsNotExist(err) && quota.MaxFiles > 0 && quota.UsedFiles >= quota.MaxFiles {
+			ok = false
+			reason = fmt.Sprintf("Quota de fichiers atteint (%d)", quota.MaxFiles)
+		}
+	}
+	quotaMu.Unlock()
+
+	if !ok {
+		s.denyAccess(sess, refID, path, action, reason)
+	}
+	return ok
+}
+
+// recordUsage met à jour les quotas du compte après une opération appliquée
+// before est l'élément remplacé ou supprimé (nil s'il n'existait pas).
+func (s *Server) recordUsage(sess *ClientSession, op string, size int64, before os.FileInfo) {
+	if sess.User == nil {
+		return
+	}
+
+	ac := GetAccessController()
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if before != nil && !before.IsDir() && (op == "create" || op == "write" || op == "remove") {
+		ac.UpdateQuotaUsage(sess.User.ID, before.Size(), accessDelete)
+	}
+	switch op {
+	case "create", "write":
+		ac.UpdateQuotaUsage(sess.User.ID, size, accessUpload)
+	case accessDownload:
+		ac.UpdateQuotaUsage(sess.User.ID, size, accessDownload)
+	}
+}
+
+// denyAccess journalise et signale au client un accès refusé par les droits de son compte
+func (s *Server) denyAccess(sess *ClientSession, refID, path, action, reason string) {
+	addLog(fmt.Sprintf("🚫 %s: %s refusé sur %s (%s)", sess.Name, action, path, reason))
+	AuditAccessDeniedEvent(sess.User.ID, sess.IP, path, reason)
+	if err := sess.SendAccessDenied(AccessDenied{
+		Path:   path,
+		Action: action,
+		Reason: reason,
+		RefID:  refID,
+	}); err != nil {
+		addLog(fmt.Sprintf("❌ Erreur envoi refus à %s: %v", sess.Name, err))
+	}
+}
+
+// readableTrash retourne les éléments de la corbeille visibles par le client
+func (s *Server) readableTrash(sess *ClientSession) []TrashEntry {
+	entries := s.trash.List()
+	if sess.User == nil {
+		return entries
+	}
+	visible := entries[:0]
+	for _, entry := range entries {
+		if sess.CanRead(entry.Path, entry.IsDir) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible
+}
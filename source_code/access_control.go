@@ -83,10 +83,10 @@ type UserPermissions struct {
 
 // Permission pour un dossier
 type Permission struct {
-	Read   bool
-	Write  bool
-	Delete bool
-	Share  bool
+	Read   bool `json:"read"`
+	Write  bool `json:"write"`
+	Delete bool `json:"delete"`
+	Share  bool `json:"share,omitempty"`
 }
 
 // UserQuota quotas utilisateur
@@ -150,12 +150,42 @@ func (um *UserManager) AddUser(user *User) {
 	um.users[user.ID] = user
 }
 
-// GetUser récupère un utilisateur
+// GetUser récupère une copie d'un utilisateur
+// Les comptes sont modifiés par UpdateUser pendant que d'autres connexions les
+// lisent: une copie ne change pas sous les yeux de l'appelant. Le quota, mis à
+// jour sous quotaMu, reste partagé.
 func (um *UserManager) GetUser(id string) (*User, bool) {
 	um.mu.RLock()
 	defer um.mu.RUnlock()
 	user, ok := um.users[id]
-	return user, ok
+	if !ok {
+		return nil, false
+	}
+	copied := *user
+	return &copied, true
+}
+
+// UpdateUser modifie un utilisateur sous le verrou du gestionnaire
+// La modification porte sur une copie qui remplace l'utilisateur.
+func (um *UserManager) UpdateUser(id string, update func(user *User)) bool {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	user, ok := um.users[id]
+	if !ok {
+		return false
+	}
+	next := *user
+	update(&next)
+	um.users[id] = &next
+	return true
+}
+
+// RecordLogin enregistre la date de dernière connexion d'un utilisateur
+// Plusieurs connexions du même compte peuvent être acceptées en parallèle.
+func (um *UserManager) RecordLogin(id string, at time.Time) {
+	um.UpdateUser(id, func(user *User) {
+		user.LastLogin = at
+	})
 }
 
 // RemoveUser supprime un utilisateur
@@ -165,14 +195,15 @@ func (um *UserManager) RemoveUser(id string) {
 	delete(um.users, id)
 }
 
-// GetUsers retourne tous les utilisateurs
+// GetUsers retourne une copie de tous les utilisateurs
 func (um *UserManager) GetUsers() []*User {
 	um.mu.RLock()
 	defer um.mu.RUnlock()
 	
 	users := make([]*User, 0, len(um.users))
 	for _, user := range um.users {
-		users = append(users, user)
+		copied := *user
+		users = append(users, &copied)
 	}
 	return users
 }
@@ -564,6 +595,13 @@ func (sm *ShareManager) RevokeShare(shareID string) {
 
 // matchPath vérifie si un chemin correspond à un pattern
 func matchPath(path, pattern string) bool {
+	// Un chemin qui remonte d'un dossier ne correspond à aucun pattern
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return false
+		}
+	}
+
 	// Pattern * = tout
 	if pattern == "*" {
 		return true
@@ -531,6 +531,13 @@ func NewRetentionManager() *RetentionManager {
 		SecureDelete: false,
 	})
 	
+	// Politique par défaut pour la corbeille des dossiers synchronisés
+	rm.AddPolicy(&RetentionPolicy{
+		Name:         trashPolicyName,
+		MaxAge:       defaultTrashMaxAge,
+		ApplyToFiles: true,
+	})
+	
 	return rm
 }
 
@@ -563,8 +570,7 @@ func init() {
 	globalActivityMonitor = NewActivityMonitor(globalAuditLogger)
 	
 	// Définir le fichier de log d'audit
-	execDir := getExecutableDir()
-	logPath := filepath.Join(execDir, "audit.log")
+	logPath := filepath.Join(getDataDir(), "audit.log")
 	globalAuditLogger.SetLogFile(logPath)
 }
 
@@ -3,6 +3,7 @@ package main
 import (
 	"archive/zip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -26,6 +27,10 @@ const (
 	BackupDifferential BackupType = "DIFFERENTIAL"
 )
 
+// Format de stockage d'une sauvegarde
+// Les anciennes sauvegardes (champ vide) sont des archives ZIP
+const BackupStorageChunks = "chunks"
+
 // BackupConfig configuration de sauvegarde
 type BackupConfig struct {
 	Enabled          bool          `json:"enabled"`
@@ -68,6 +73,16 @@ type BackupInfo struct {
 	BaseBackup  string     `json:"base_backup,omitempty"`
 	Description string     `json:"description,omitempty"`
 	Checksum    string     `json:"checksum,omitempty"`
+	Storage     string     `json:"storage,omitempty"`
+	StoredSize  int64      `json:"stored_size,omitempty"` // Octets ajoutés au stockage
+}
+
+// BackupEntry fichier d'une sauvegarde, référencé par son manifeste
+type BackupEntry struct {
+	Path     string    `json:"path"`
+	Manifest string    `json:"manifest"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
 }
 
 // BackupManager gère les sauvegardes
@@ -78,6 +93,7 @@ type BackupManager struct {
 	stopChan   chan bool
 	running    bool
 	lastBackup time.Time
+	locked     bool // backups.json chiffré, pas encore relu
 	
 	// États des fichiers pour incrémentiel
 	fileStates map[string]*FileBackupState
@@ -101,6 +117,8 @@ func NewBackupManager(config *BackupConfig) *BackupManager {
 	}
 	
 	bm.loadMetadata()
+	GetChunkStore().RegisterRoots("backups", bm.storeRoots)
+	GetChunkStore().RegisterSealed("backups", bm.reloadMetadata)
 	
 	return bm
 }
@@ -125,7 +143,7 @@ func (bm *BackupManager) StartAutoBackup() {
 			case <-bm.stopChan:
 				return
 			case <-ticker.C:
-				sourcePath := getExecutableDir()
+				sourcePath := getDataDir()
 				bm.CreateBackup(sourcePath, BackupIncremental, "Auto backup")
 			}
 		}
@@ -151,7 +169,7 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 	defer bm.mu.Unlock()
 	
 	// Créer le répertoire
-	backupDir := filepath.Join(getExecutableDir(), bm.config.BackupPath)
+	backupDir := filepath.Join(getDataDir(), bm.config.BackupPath)
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		return nil, err
 	}
@@ -159,28 +177,21 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 	// Nom du fichier
 	timestamp := time.Now().Format("20060102_150405")
 	backupID := fmt.Sprintf("backup_%s_%s", timestamp, strings.ToLower(string(backupType)))
-	backupFile := filepath.Join(backupDir, backupID+".zip")
-	
-	// Créer le ZIP
-	zipFile, err := os.Create(backupFile)
-	if err != nil {
-		return nil, err
-	}
-	defer zipFile.Close()
-	
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	backupFile := filepath.Join(backupDir, backupID+".json")
 	
 	// Collecter les fichiers
 	var filesToBackup []string
-	var totalSize int64
 	
-	err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 		
 		if info.IsDir() {
+			// Dossier interne (stockage, transferts)
+			if info.Name() == internalDirName {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		
@@ -212,7 +223,6 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 		}
 		
 		filesToBackup = append(filesToBackup, path)
-		totalSize += info.Size()
 		
 		return nil
 	})
@@ -221,42 +231,66 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 		return nil, err
 	}
 	
-	// Ajouter les fichiers au ZIP
+	// Sauvegarde chiffrée: contenu et liste des fichiers chiffrés par la clé du stockage
+	store := GetChunkStore()
+	var sealer *FileEncryptor
+	if bm.config.EncryptBackups {
+		if sealer, err = store.Encryptor(); err != nil {
+			return nil, err
+		}
+	}
+	
+	// Enregistrer les fichiers dans le stockage: seuls les morceaux nouveaux sont écrits,
+	// un fichier synchronisé qui n'a pas changé réutilise son manifeste
+	entries := make([]BackupEntry, 0, len(filesToBackup))
+	var totalSize, storedSize int64
+	
 	for _, filePath := range filesToBackup {
 		relPath, _ := filepath.Rel(sourcePath, filePath)
 		
-		writer, err := zipWriter.Create(relPath)
+		info, err := os.Stat(filePath)
 		if err != nil {
 			continue
 		}
 		
-		file, err := os.Open(filePath)
+		var manifest *FileManifest
+		if sealer != nil {
+			manifest, err = store.PutFileEncrypted(filePath)
+		} else {
+			manifest, err = store.TrackedManifest(filePath)
+		}
 		if err != nil {
+			addLog(fmt.Sprintf("⚠️ Backup %s: %v", relPath, err))
 			continue
 		}
 		
-		io.Copy(writer, file)
-		file.Close()
+		entries = append(entries, BackupEntry{
+			Path:     filepath.ToSlash(relPath),
+			Manifest: manifest.ID,
+			Size:     manifest.Size,
+			ModTime:  info.ModTime(),
+		})
+		totalSize += manifest.Size
+		storedSize += manifest.Stored
 		
 		// Mettre à jour l'état
-		info, _ := os.Stat(filePath)
-		if info != nil {
-			bm.fileStates[relPath] = &FileBackupState{
-				Path:    relPath,
-				ModTime: info.ModTime(),
-				Size:    info.Size(),
-			}
+		bm.fileStates[relPath] = &FileBackupState{
+			Path:    relPath,
+			ModTime: info.ModTime(),
+			Size:    info.Size(),
+			Hash:    manifest.ID,
 		}
 	}
 	
-	zipWriter.Close()
-	zipFile.Close()
-	
-	// Taille finale
-	finalInfo, _ := os.Stat(backupFile)
-	var finalSize int64
-	if finalInfo != nil {
-		finalSize = finalInfo.Size()
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if data, err = sealObject(sealer, data); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(getDataDir(), backupFile, data); err != nil {
+		return nil, err
 	}
 	
 	// Info backup
@@ -266,11 +300,13 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 		CreatedAt:   time.Now(),
 		SourcePath:  sourcePath,
 		BackupPath:  backupFile,
-		Size:        finalSize,
-		FileCount:   len(filesToBackup),
+		Size:        totalSize,
+		FileCount:   len(entries),
 		Compressed:  true,
-		Encrypted:   bm.config.EncryptBackups,
+		Encrypted:   sealer != nil,
 		Description: description,
+		Storage:     BackupStorageChunks,
+		StoredSize:  storedSize,
 	}
 	
 	bm.backups = append(bm.backups, backup)
@@ -279,11 +315,58 @@ func (bm *BackupManager) CreateBackup(sourcePath string, backupType BackupType,
 	bm.rotateBackups()
 	bm.saveMetadata()
 	
-	addLog(fmt.Sprintf("✅ Backup créé: %s (%d fichiers, %s)", backupID, len(filesToBackup), FormatFileSize(finalSize)))
+	lock := ""
+	if backup.Encrypted {
+		lock = "🔒 "
+	}
+	addLog(fmt.Sprintf("✅ Backup créé: %s%s (%d fichiers, %s, %s nouveaux)", lock, backupID, len(entries), FormatFileSize(totalSize), FormatFileSize(storedSize)))
 	
 	return backup, nil
 }
 
+// loadBackupEntries lit la liste des fichiers d'une sauvegarde par morceaux
+// La liste d'une sauvegarde chiffrée demande le stockage déverrouillé.
+func loadBackupEntries(backup *BackupInfo) ([]BackupEntry, error) {
+	data, err := os.ReadFile(backup.BackupPath)
+	if os.IsNotExist(err) {
+		// Dossier des données déplacé: la liste est à côté de backups.json
+		data, err = os.ReadFile(filepath.Join(getDataDir(), globalBackupConfig.BackupPath, backup.ID+".json"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = GetChunkStore().openObject(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", backup.ID, err)
+	}
+	
+	var entries []BackupEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// storeRoots retourne les manifestes référencés par les sauvegardes
+func (bm *BackupManager) storeRoots() []string {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+	
+	var ids []string
+	for _, backup := range bm.backups {
+		if backup.Storage != BackupStorageChunks {
+			continue
+		}
+		entries, err := loadBackupEntries(backup)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.Manifest)
+		}
+	}
+	return ids
+}
+
 // rotateBackups supprime les anciens backups
 func (bm *BackupManager) rotateBackups() {
 	if len(bm.backups) <= bm.config.MaxBackups {
@@ -303,30 +386,74 @@ func (bm *BackupManager) rotateBackups() {
 	}
 	
 	bm.backups = bm.backups[toRemove:]
+	
+	// Libérer les morceaux qui ne sont plus référencés
+	go GetChunkStore().GC()
 }
 
+// metadataPath retourne l'emplacement de la liste des backups
+func (bm *BackupManager) metadataPath() string {
+	return filepath.Join(getDataDir(), bm.config.BackupPath, "backups.json")
+}
+
+// saveMetadata enregistre la liste des backups (bm.mu verrouillé)
+// Une liste chiffrée pas encore relue n'est pas écrasée.
 func (bm *BackupManager) saveMetadata() {
-	backupDir := filepath.Join(getExecutableDir(), bm.config.BackupPath)
-	metaPath := filepath.Join(backupDir, "backups.json")
+	if bm.locked {
+		return
+	}
 	
 	data, err := json.MarshalIndent(bm.backups, "", "  ")
 	if err != nil {
 		return
 	}
 	
-	os.WriteFile(metaPath, data, 0644)
+	if err := GetChunkStore().WriteSealed(getDataDir(), bm.metadataPath(), data); err != nil {
+		addLog(fmt.Sprintf("⚠️ Liste des backups: %v", err))
+	}
 }
 
+// loadMetadata lit la liste des backups (bm.mu verrouillé)
+// Les backups créés avant la lecture d'une liste chiffrée sont conservés.
 func (bm *BackupManager) loadMetadata() {
-	backupDir := filepath.Join(getExecutableDir(), bm.config.BackupPath)
-	metaPath := filepath.Join(backupDir, "backups.json")
-	
-	data, err := os.ReadFile(metaPath)
+	data, err := GetChunkStore().ReadSealed(bm.metadataPath())
+	if errors.Is(err, errStoreLocked) {
+		bm.locked = true
+		return
+	}
 	if err != nil {
 		return
 	}
 	
-	json.Unmarshal(data, &bm.backups)
+	var loaded []*BackupInfo
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return
+	}
+	known := make(map[string]bool, len(loaded))
+	for _, b := range loaded {
+		known[b.ID] = true
+	}
+	for _, b := range bm.backups {
+		if !known[b.ID] {
+			loaded = append(loaded, b)
+		}
+	}
+	bm.backups = loaded
+	bm.locked = false
+}
+
+// reloadMetadata relit la liste chiffrée après le déverrouillage du stockage,
+// puis la chiffre si elle était encore en clair
+func (bm *BackupManager) reloadMetadata() {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	
+	if bm.locked {
+		bm.loadMetadata()
+	}
+	if GetChunkStore().NeedsSealing(bm.metadataPath()) {
+		bm.saveMetadata()
+	}
 }
 
 // GetBackups retourne la liste des backups
@@ -355,7 +482,11 @@ func (bm *BackupManager) RestoreBackup(backupID, destPath string) error {
 		return fmt.Errorf("backup non trouvé: %s", backupID)
 	}
 	
-	// Ouvrir le ZIP
+	if backup.Storage == BackupStorageChunks {
+		return bm.restoreFromStore(backup, destPath)
+	}
+	
+	// Ancien format: ouvrir le ZIP
 	reader, err := zip.OpenReader(backup.BackupPath)
 	if err != nil {
 		return err
@@ -397,6 +528,34 @@ func (bm *BackupManager) RestoreBackup(backupID, destPath string) error {
 	return nil
 }
 
+// restoreFromStore reconstruit les fichiers d'une sauvegarde depuis le stockage
+func (bm *BackupManager) restoreFromStore(backup *BackupInfo, destPath string) error {
+	entries, err := loadBackupEntries(backup)
+	if err != nil {
+		return err
+	}
+	
+	store := GetChunkStore()
+	failed := 0
+	for _, entry := range entries {
+		destFile := filepath.Join(destPath, filepath.FromSlash(entry.Path))
+		if err := store.Restore(entry.Manifest, destFile); err != nil {
+			addLog(fmt.Sprintf("❌ Restauration %s: %v", entry.Path, err))
+			failed++
+			continue
+		}
+		os.Chtimes(destFile, entry.ModTime, entry.ModTime)
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("%d fichiers non restaurés", failed)
+	}
+	
+	addLog(fmt.Sprintf("✅ Backup restauré: %s -> %s", backup.ID, destPath))
+	
+	return nil
+}
+
 // DeleteBackup supprime un backup
 func (bm *BackupManager) DeleteBackup(backupID string) error {
 	bm.mu.Lock()
@@ -407,6 +566,7 @@ func (bm *BackupManager) DeleteBackup(backupID string) error {
 			os.Remove(b.BackupPath)
 			bm.backups = append(bm.backups[:i], bm.backups[i+1:]...)
 			bm.saveMetadata()
+			go GetChunkStore().GC()
 			return nil
 		}
 	}
@@ -432,11 +592,12 @@ type Snapshot struct {
 
 // FileSnap état d'un fichier
 type FileSnap struct {
-	Path    string    `json:"path"`
-	Size    int64     `json:"size"`
-	ModTime time.Time `json:"mod_time"`
-	Hash    string    `json:"hash,omitempty"`
-	IsDir   bool      `json:"is_dir"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Hash     string    `json:"hash,omitempty"`
+	IsDir    bool      `json:"is_dir"`
+	Manifest string    `json:"manifest,omitempty"` // Contenu dans le stockage
 }
 
 // SnapshotManager gère les snapshots
@@ -457,6 +618,8 @@ func NewSnapshotManager(snapshotPath string, maxSnapshots int) *SnapshotManager
 	
 	os.MkdirAll(snapshotPath, 0755)
 	sm.loadSnapshots()
+	GetChunkStore().RegisterRoots("snapshots:"+snapshotPath, sm.storeRoots)
+	GetChunkStore().RegisterSealed("snapshots:"+snapshotPath, sm.reloadSnapshots)
 	
 	return sm
 }
@@ -478,11 +641,16 @@ func (sm *SnapshotManager) CreateSnapshot(sourcePath, name, description string)
 		Description: description,
 	}
 	
+	store := GetChunkStore()
 	err := filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
 		
+		if info.IsDir() && info.Name() == internalDirName {
+			return filepath.SkipDir
+		}
+		
 		relPath, _ := filepath.Rel(sourcePath, path)
 		
 		fileSnap := &FileSnap{
@@ -492,9 +660,11 @@ func (sm *SnapshotManager) CreateSnapshot(sourcePath, name, description string)
 			IsDir:   info.IsDir(),
 		}
 		
-		if !info.IsDir() && info.Size() < 50*1024*1024 {
-			if hash, err := StreamHash(path); err == nil {
-				fileSnap.Hash = hash
+		if !info.IsDir() {
+			// Le contenu est conservé: seuls les morceaux nouveaux sont écrits
+			if manifest, err := store.TrackedManifest(path); err == nil {
+				fileSnap.Hash = manifest.ID
+				fileSnap.Manifest = manifest.ID
 			}
 			snapshot.TotalSize += info.Size()
 			snapshot.FileCount++
@@ -581,6 +751,60 @@ func (sm *SnapshotManager) CompareSnapshots(id1, id2 string) (*SnapshotDiff, err
 	return diff, nil
 }
 
+// RestoreSnapshot reconstruit les fichiers d'un snapshot dans destPath
+func (sm *SnapshotManager) RestoreSnapshot(id, destPath string) error {
+	sm.mu.RLock()
+	snap, ok := sm.snapshots[id]
+	sm.mu.RUnlock()
+	
+	if !ok {
+		return fmt.Errorf("snapshot non trouvé: %s", id)
+	}
+	
+	store := GetChunkStore()
+	failed := 0
+	for relPath, file := range snap.Files {
+		dest := filepath.Join(destPath, relPath)
+		if file.IsDir {
+			os.MkdirAll(dest, 0755)
+			continue
+		}
+		if file.Manifest == "" {
+			failed++
+			continue
+		}
+		if err := store.Restore(file.Manifest, dest); err != nil {
+			failed++
+			continue
+		}
+		os.Chtimes(dest, file.ModTime, file.ModTime)
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("%d fichiers non restaurés", failed)
+	}
+	
+	addLog(fmt.Sprintf("✅ Snapshot restauré: %s -> %s", snap.Name, destPath))
+	
+	return nil
+}
+
+// storeRoots retourne les manifestes référencés par les snapshots
+func (sm *SnapshotManager) storeRoots() []string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	
+	var ids []string
+	for _, snap := range sm.snapshots {
+		for _, file := range snap.Files {
+			if file.Manifest != "" {
+				ids = append(ids, file.Manifest)
+			}
+		}
+	}
+	return ids
+}
+
 // SnapshotDiff différences entre snapshots
 type SnapshotDiff struct {
 	Snapshot1 string
@@ -603,6 +827,7 @@ func (sm *SnapshotManager) DeleteSnapshot(id string) error {
 	os.Remove(snapFile)
 	
 	delete(sm.snapshots, id)
+	go GetChunkStore().GC()
 	
 	return nil
 }
@@ -610,9 +835,13 @@ func (sm *SnapshotManager) DeleteSnapshot(id string) error {
 func (sm *SnapshotManager) saveSnapshot(snap *Snapshot) {
 	snapFile := filepath.Join(sm.snapshotPath, snap.ID+".json")
 	data, _ := json.MarshalIndent(snap, "", "  ")
-	os.WriteFile(snapFile, data, 0644)
+	if err := GetChunkStore().WriteSealed(getDataDir(), snapFile, data); err != nil {
+		addLog(fmt.Sprintf("⚠️ Snapshot %s: %v", snap.ID, err))
+	}
 }
 
+// loadSnapshots lit les snapshots (sm.mu verrouillé)
+// Un snapshot chiffré est ignoré tant que le stockage est verrouillé.
 func (sm *SnapshotManager) loadSnapshots() {
 	entries, err := os.ReadDir(sm.snapshotPath)
 	if err != nil {
@@ -622,7 +851,7 @@ func (sm *SnapshotManager) loadSnapshots() {
 	for _, entry := range entries {
 		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
 			snapFile := filepath.Join(sm.snapshotPath, entry.Name())
-			data, err := os.ReadFile(snapFile)
+			data, err := GetChunkStore().ReadSealed(snapFile)
 			if err != nil {
 				continue
 			}
@@ -635,6 +864,20 @@ func (sm *SnapshotManager) loadSnapshots() {
 	}
 }
 
+// reloadSnapshots relit les snapshots chiffrés après le déverrouillage du
+// stockage, puis chiffre ceux qui étaient encore en clair
+func (sm *SnapshotManager) reloadSnapshots() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	
+	sm.loadSnapshots()
+	for _, snap := range sm.snapshots {
+		if GetChunkStore().NeedsSealing(filepath.Join(sm.snapshotPath, snap.ID+".json")) {
+			sm.saveSnapshot(snap)
+		}
+	}
+}
+
 func (sm *SnapshotManager) rotateSnapshots() {
 	if len(sm.snapshots) <= sm.maxSnapshots {
 		return
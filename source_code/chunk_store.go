@@ -0,0 +1,803 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// STOCKAGE PAR MORCEAUX ADRESSÉS PAR LE CONTENU (hôte)
+// ============================================================================
+//
+// Les fichiers sont découpés en morceaux de taille variable dont les limites
+// dépendent du contenu (hash "gear"): une insertion ne modifie que les morceaux
+// voisins. Chaque morceau est enregistré une seule fois sous son hash SHA-256:
+//   .spiralydata/store/chunks/ab/abcd...     données (brutes ou compressées)
+//   .spiralydata/store/manifests/ab/abcd...  liste des morceaux d'un fichier
+//   .spiralydata/store/index.json            fichiers synchronisés -> manifeste
+// Un manifeste est identifié par le hash du fichier complet: deux fichiers
+// identiques partagent le même manifeste. Les objets peuvent être chiffrés
+// (voir store_encryption.go).
+//
+// Les fichiers écrits par le serveur sont enregistrés dans le stockage, puis le
+// dossier synchronisé est reconstruit à partir de leur manifeste: il n'en est
+// qu'une copie de travail. Les versions, les sauvegardes et les snapshots
+// référencent ces mêmes manifestes au lieu de recopier les fichiers. Le
+// ramasse-miettes supprime ce qui n'est plus référencé.
+
+const (
+	storeDirName = "store"
+
+	// Découpage: morceaux de 16 KB à 256 KB, 64 KB en moyenne
+	cdcMinSize  = 16 * 1024
+	cdcMaxSize  = 256 * 1024
+	cdcAvgBits  = 16
+	cdcHashBits = 64
+
+	// storeGCGrace âge minimum d'un objet non référencé avant suppression
+	// Protège les écritures en cours dont le manifeste n'est pas encore référencé
+	storeGCGrace = time.Hour
+
+	// Premier octet d'un morceau enregistré
+	chunkRaw  byte = 'R'
+	chunkGzip byte = 'Z'
+)
+
+// Erreurs du stockage
+var (
+	ErrStoreMissing = errors.New("objet absent du stockage")
+	ErrStoreCorrupt = errors.New("objet du stockage corrompu")
+)
+
+// cdcGear table du hash glissant, identique sur toutes les machines
+var cdcGear = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// cdcChunker découpe un flux en morceaux définis par le contenu
+type cdcChunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newCDCChunker(r io.Reader) *cdcChunker {
+	return &cdcChunker{
+		r:   bufio.NewReaderSize(r, 64*1024),
+		buf: make([]byte, 0, cdcMaxSize),
+	}
+}
+
+// Next retourne le morceau suivant, io.EOF à la fin du flux
+// Le tampon retourné est réutilisé à l'appel suivant.
+func (c *cdcChunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var h uint64
+
+	for len(c.buf) < cdcMaxSize {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.buf = append(c.buf, b)
+
+		// Les bits de poids fort dépendent des 64 derniers octets
+		h = (h << 1) + cdcGear[b]
+		if len(c.buf) >= cdcMinSize && h>>(cdcHashBits-cdcAvgBits) == 0 {
+			break
+		}
+	}
+
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
+
+// ChunkRef référence un morceau dans un manifeste
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// FileManifest liste les morceaux d'un fichier
+type FileManifest struct {
+	ID     string     `json:"id"` // Hash SHA-256 du fichier complet
+	Size   int64      `json:"size"`
+	Chunks []ChunkRef `json:"chunks"`
+	Stored int64      `json:"-"` // Octets ajoutés au stockage par cet enregistrement
+}
+
+// StoreStats statistiques du stockage
+type StoreStats struct {
+	Chunks     int
+	StoredSize int64
+	Manifests  int
+	Files      int
+}
+
+// ChunkStore stockage dédupliqué de l'hôte
+type ChunkStore struct {
+	base  string // Dossier contenant .spiralydata
+	mu    sync.Mutex
+	index map[string]string // Chemin absolu -> manifeste
+	roots map[string]func() []string
+	gcMu  sync.Mutex
+	saver *Debouncer
+
+	sealed      map[string]func() // Index relus au déverrouillage (RegisterSealed)
+	indexLocked bool              // index.json chiffré, pas encore relu
+
+	keyMu   sync.RWMutex
+	crypt   *FileEncryptor // Clé du stockage déverrouillée (nil = verrouillé)
+	encrypt bool           // Nouveaux objets chiffrés (host.encrypt_store)
+}
+
+// NewChunkStore crée un stockage dans base/.spiralydata/store
+func NewChunkStore(base string) *ChunkStore {
+	cs := &ChunkStore{
+		base:  base,
+		index: make(map[string]string),
+		roots: make(map[string]func() []string),
+		saver: NewDebouncer(2 * time.Second),
+
+		sealed: make(map[string]func()),
+	}
+	cs.loadIndex()
+	cs.sealed["index"] = cs.reloadIndex
+	return cs
+}
+
+// dir retourne le dossier du stockage
+func (cs *ChunkStore) dir() string {
+	return filepath.Join(cs.base, internalDirName, storeDirName)
+}
+
+// objectPath retourne le chemin d'un objet (morceau ou manifeste)
+func (cs *ChunkStore) objectPath(kind, hash string) string {
+	return filepath.Join(cs.dir(), kind, hash[:2], hash)
+}
+
+// touchObject vérifie qu'un objet existe et repousse sa date
+// Un objet réutilisé ne doit pas être supprimé par un ramasse-miettes concurrent.
+func touchObject(path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// isObjectHash vérifie qu'un nom est un hash SHA-256 hexadécimal
+func isObjectHash(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// ============================================================================
+// ÉCRITURE ET LECTURE
+// ============================================================================
+
+// Put découpe un flux et enregistre ses morceaux et son manifeste
+func (cs *ChunkStore) Put(r io.Reader) (*FileManifest, error) {
+	fe, err := cs.sealer(false)
+	if err != nil {
+		return nil, err
+	}
+	return cs.put(r, fe)
+}
+
+// put enregistre un flux, chiffré par fe (nil = en clair)
+func (cs *ChunkStore) put(r io.Reader, fe *FileEncryptor) (*FileManifest, error) {
+	chunker := newCDCChunker(r)
+	fileHasher := sha256.New()
+	manifest := &FileManifest{Chunks: []ChunkRef{}}
+
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		fileHasher.Write(data)
+
+		ref, stored, err := cs.putChunk(data, fe)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Chunks = append(manifest.Chunks, ref)
+		manifest.Size += ref.Size
+		manifest.Stored += stored
+	}
+
+	manifest.ID = hex.EncodeToString(fileHasher.Sum(nil))
+	if err := cs.saveManifest(manifest, fe); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// PutFile enregistre un fichier du disque
+func (cs *ChunkStore) PutFile(path string) (*FileManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return cs.Put(file)
+}
+
+// PutFileEncrypted enregistre un fichier du disque chiffré, même si
+// host.encrypt_store est désactivé (sauvegardes chiffrées)
+func (cs *ChunkStore) PutFileEncrypted(path string) (*FileManifest, error) {
+	fe, err := cs.Encryptor()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return cs.put(file, fe)
+}
+
+// putChunk enregistre un morceau s'il n'existe pas encore
+// Un morceau enregistré en clair est rechiffré si fe n'est pas nil.
+// Retourne la référence et le nombre d'octets ajoutés sur le disque
+func (cs *ChunkStore) putChunk(data []byte, fe *FileEncryptor) (ChunkRef, int64, error) {
+	sum := sha256.Sum256(data)
+	ref := ChunkRef{Hash: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+
+	path := cs.objectPath("chunks", ref.Hash)
+	exists := touchObject(path)
+	if exists && (fe == nil || isSealedObject(path)) {
+		return ref, 0, nil
+	}
+
+	payload := append([]byte{chunkRaw}, data...)
+	if packed, err := CompressData(data, 6); err == nil && len(packed) < len(data)*9/10 {
+		payload = append([]byte{chunkGzip}, packed...)
+	}
+	payload, err := sealObject(fe, payload)
+	if err != nil {
+		return ref, 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ref, 0, err
+	}
+	if err := writeFileAtomic(cs.base, path, payload); err != nil {
+		return ref, 0, err
+	}
+	if exists {
+		return ref, 0, nil
+	}
+	return ref, int64(len(payload)), nil
+}
+
+// readChunk lit un morceau et vérifie son hash
+func (cs *ChunkStore) readChunk(hash string) ([]byte, error) {
+	payload, err := os.ReadFile(cs.objectPath("chunks", hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: morceau %s", ErrStoreMissing, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if payload, err = cs.openObject(payload); err != nil {
+		if errors.Is(err, errStoreLocked) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: morceau %s", ErrStoreCorrupt, hash)
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("%w: morceau %s", ErrStoreCorrupt, hash)
+	}
+
+	data := payload[1:]
+	if payload[0] == chunkGzip {
+		if data, err = DecompressData(data); err != nil {
+			return nil, fmt.Errorf("%w: morceau %s", ErrStoreCorrupt, hash)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("%w: morceau %s", ErrStoreCorrupt, hash)
+	}
+	return data, nil
+}
+
+// saveManifest enregistre un manifeste s'il n'existe pas encore
+// Un manifeste enregistré en clair est rechiffré si fe n'est pas nil.
+func (cs *ChunkStore) saveManifest(manifest *FileManifest, fe *FileEncryptor) error {
+	path := cs.objectPath("manifests", manifest.ID)
+	if touchObject(path) && (fe == nil || isSealedObject(path)) {
+		return nil
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if data, err = sealObject(fe, data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(cs.base, path, data)
+}
+
+// LoadManifest lit un manifeste
+func (cs *ChunkStore) LoadManifest(id string) (*FileManifest, error) {
+	if !isObjectHash(id) {
+		return nil, fmt.Errorf("%w: manifeste %q", ErrStoreCorrupt, id)
+	}
+
+	data, err := os.ReadFile(cs.objectPath("manifests", id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: manifeste %s", ErrStoreMissing, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = cs.openObject(data); err != nil {
+		if errors.Is(err, errStoreLocked) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: manifeste %s", ErrStoreCorrupt, id)
+	}
+
+	var manifest FileManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.ID != id {
+		return nil, fmt.Errorf("%w: manifeste %s", ErrStoreCorrupt, id)
+	}
+	return &manifest, nil
+}
+
+// Restore reconstruit un fichier à partir de son manifeste
+// Le fichier est écrit à côté puis renommé: la destination n'est jamais partielle.
+func (cs *ChunkStore) Restore(id, dest string) error {
+	return cs.restoreIn(cs.base, id, dest)
+}
+
+// restoreIn reconstruit un fichier en passant par le dossier temporaire de root
+// Sur le même disque que la destination, le renommage final reste atomique.
+func (cs *ChunkStore) restoreIn(root, id, dest string) error {
+	manifest, err := cs.LoadManifest(id)
+	if err != nil {
+		return err
+	}
+
+	tmpDir := internalTempDir(root)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "restore-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	hasher := sha256.New()
+	for _, ref := range manifest.Chunks {
+		data, err := cs.readChunk(ref.Hash)
+		if err == nil {
+			hasher.Write(data)
+			_, err = tmp.Write(data)
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if hex.EncodeToString(hasher.Sum(nil)) != manifest.ID {
+		os.Remove(tmpPath)
+		return fmt.Errorf("%w: manifeste %s", ErrStoreCorrupt, id)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return moveFile(tmpPath, dest)
+}
+
+// ============================================================================
+// FICHIERS SYNCHRONISÉS
+// ============================================================================
+
+// WriteFile enregistre un contenu puis reconstruit le fichier synchronisé
+// à partir de son manifeste. Rien n'est écrit si le stockage échoue.
+func (cs *ChunkStore) WriteFile(root, path string, data []byte) error {
+	manifest, err := cs.Put(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return cs.checkout(root, manifest.ID, path)
+}
+
+// CommitFile enregistre un fichier temporaire puis reconstruit le fichier
+// synchronisé à partir de son manifeste. Le fichier temporaire est supprimé.
+func (cs *ChunkStore) CommitFile(root, src, path string) error {
+	manifest, err := cs.PutFile(src)
+	os.Remove(src)
+	if err != nil {
+		return err
+	}
+	return cs.checkout(root, manifest.ID, path)
+}
+
+// checkout écrit dans le dossier synchronisé le fichier d'un manifeste
+func (cs *ChunkStore) checkout(root, id, path string) error {
+	if err := cs.restoreIn(root, id, path); err != nil {
+		return err
+	}
+	cs.Track(path, id)
+	return nil
+}
+
+// RestoreFile reconstruit un fichier synchronisé à partir d'un manifeste
+func (cs *ChunkStore) RestoreFile(id, path string) error {
+	if err := cs.Restore(id, path); err != nil {
+		return err
+	}
+	cs.Track(path, id)
+	return nil
+}
+
+// ManifestOf retourne le manifeste du contenu actuel d'un fichier synchronisé
+func (cs *ChunkStore) ManifestOf(path string) (string, error) {
+	manifest, err := cs.TrackedManifest(path)
+	if err != nil {
+		return "", err
+	}
+	return manifest.ID, nil
+}
+
+// TrackedManifest retourne le manifeste d'un fichier synchronisé
+// Celui de l'index est réutilisé si le fichier n'a pas changé depuis son
+// écriture; un fichier modifié directement sur l'hôte est enregistré.
+func (cs *ChunkStore) TrackedManifest(path string) (*FileManifest, error) {
+	cs.mu.Lock()
+	id, ok := cs.index[filepath.Clean(path)]
+	cs.mu.Unlock()
+
+	if ok {
+		if hash, err := GetHashCache().GetHash(path); err == nil && hash == id {
+			if touchObject(cs.objectPath("manifests", id)) {
+				if manifest, err := cs.LoadManifest(id); err == nil {
+					return manifest, nil
+				}
+			}
+		}
+	}
+
+	manifest, err := cs.PutFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cs.Track(path, manifest.ID)
+	return manifest, nil
+}
+
+// Track associe un fichier à son manifeste
+func (cs *ChunkStore) Track(path, id string) {
+	cs.mu.Lock()
+	cs.index[filepath.Clean(path)] = id
+	cs.mu.Unlock()
+	cs.saver.Call(cs.saveIndex)
+}
+
+// Untrack retire un fichier de l'index
+func (cs *ChunkStore) Untrack(path string) {
+	cs.mu.Lock()
+	delete(cs.index, filepath.Clean(path))
+	cs.mu.Unlock()
+	cs.saver.Call(cs.saveIndex)
+}
+
+// UntrackDir retire de l'index tous les fichiers d'un dossier
+func (cs *ChunkStore) UntrackDir(path string) {
+	prefix := filepath.Clean(path) + string(filepath.Separator)
+
+	cs.mu.Lock()
+	for p := range cs.index {
+		if strings.HasPrefix(p, prefix) {
+			delete(cs.index, p)
+		}
+	}
+	cs.mu.Unlock()
+	cs.saver.Call(cs.saveIndex)
+}
+
+// MoveTracked reporte dans l'index le déplacement d'un fichier ou d'un dossier
+func (cs *ChunkStore) MoveTracked(from, to string) {
+	from = filepath.Clean(from)
+	to = filepath.Clean(to)
+	prefix := from + string(filepath.Separator)
+
+	cs.mu.Lock()
+	for p, id := range cs.index {
+		if p == from || strings.HasPrefix(p, prefix) {
+			delete(cs.index, p)
+			cs.index[to+p[len(from):]] = id
+		}
+	}
+	cs.mu.Unlock()
+	cs.saver.Call(cs.saveIndex)
+}
+
+// saveIndex enregistre l'index des fichiers synchronisés
+// Un index perdu n'entraîne aucune perte: les fichiers restent sur le disque
+// et sont réenregistrés à la prochaine version ou sauvegarde.
+// Un index chiffré pas encore relu n'est pas écrasé.
+func (cs *ChunkStore) saveIndex() {
+	cs.mu.Lock()
+	if cs.indexLocked {
+		cs.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(cs.index)
+	cs.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := cs.WriteSealed(cs.base, cs.indexPath(), data); err != nil {
+		addLog(fmt.Sprintf("⚠️ Index du stockage: %v", err))
+	}
+}
+
+func (cs *ChunkStore) indexPath() string {
+	return filepath.Join(cs.dir(), "index.json")
+}
+
+func (cs *ChunkStore) loadIndex() {
+	data, err := cs.ReadSealed(cs.indexPath())
+	if errors.Is(err, errStoreLocked) {
+		cs.mu.Lock()
+		cs.indexLocked = true
+		cs.mu.Unlock()
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	loaded := make(map[string]string)
+	json.Unmarshal(data, &loaded)
+	cs.mu.Lock()
+	for p, id := range loaded {
+		if _, ok := cs.index[p]; !ok {
+			cs.index[p] = id
+		}
+	}
+	cs.indexLocked = false
+	cs.mu.Unlock()
+}
+
+// reloadIndex relit l'index chiffré après le déverrouillage, puis le chiffre
+// s'il était encore en clair
+func (cs *ChunkStore) reloadIndex() {
+	cs.mu.Lock()
+	locked := cs.indexLocked
+	cs.mu.Unlock()
+
+	if locked {
+		cs.loadIndex()
+	}
+	if cs.NeedsSealing(cs.indexPath()) {
+		cs.saveIndex()
+	}
+}
+
+// ============================================================================
+// RAMASSE-MIETTES
+// ============================================================================
+
+// RegisterRoots ajoute une source de manifestes à conserver (sauvegardes, snapshots...)
+func (cs *ChunkStore) RegisterRoots(name string, fn func() []string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.roots[name] = fn
+}
+
+// GC supprime les manifestes et les morceaux qui ne sont plus référencés
+// Retourne le nombre de morceaux supprimés et l'espace libéré
+// Les fichiers synchronisés de l'index sont conservés; ceux qui ont disparu ou
+// changé sur l'hôte en sont d'abord retirés.
+// Un stockage chiffré verrouillé n'est pas nettoyé: les manifestes et les
+// listes des sauvegardes illisibles feraient supprimer des objets utilisés.
+func (cs *ChunkStore) GC() (int, int64, error) {
+	cs.gcMu.Lock()
+	defer cs.gcMu.Unlock()
+
+	if cs.HasKey() && !cs.Unlocked() {
+		return 0, 0, errStoreLocked
+	}
+
+	cs.pruneIndex()
+
+	live := make(map[string]bool)
+	cs.mu.Lock()
+	for _, id := range cs.index {
+		live[id] = true
+	}
+	sources := make([]func() []string, 0, len(cs.roots))
+	for _, fn := range cs.roots {
+		sources = append(sources, fn)
+	}
+	cs.mu.Unlock()
+
+	for _, fn := range sources {
+		for _, id := range fn() {
+			live[id] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-storeGCGrace)
+
+	// Manifestes: conserver les référencés et les récents
+	kept := make(map[string]bool)
+	_, _, err := cs.sweep("manifests", func(id string, modTime time.Time) bool {
+		if live[id] || modTime.After(cutoff) {
+			kept[id] = true
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Morceaux: conserver ceux des manifestes restants et les récents
+	chunks := make(map[string]bool)
+	for id := range kept {
+		manifest, err := cs.LoadManifest(id)
+		if err != nil {
+			continue
+		}
+		for _, ref := range manifest.Chunks {
+			chunks[ref.Hash] = true
+		}
+	}
+
+	removed, freed, err := cs.sweep("chunks", func(hash string, modTime time.Time) bool {
+		return chunks[hash] || modTime.After(cutoff)
+	})
+	if err != nil {
+		return removed, freed, err
+	}
+
+	if removed > 0 {
+		addLog(fmt.Sprintf("🧹 Stockage: %d morceaux supprimés (%s libérés)", removed, FormatFileSize(freed)))
+	}
+	return removed, freed, nil
+}
+
+// pruneIndex retire de l'index les fichiers supprimés ou modifiés hors du serveur
+// Leur ancien contenu reste dans le stockage tant qu'une version le référence.
+func (cs *ChunkStore) pruneIndex() {
+	cs.mu.Lock()
+	tracked := make(map[string]string, len(cs.index))
+	for p, id := range cs.index {
+		tracked[p] = id
+	}
+	cs.mu.Unlock()
+
+	stale := make([]string, 0)
+	for p, id := range tracked {
+		if hash, err := GetHashCache().GetHash(p); err != nil || hash != id {
+			stale = append(stale, p)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	cs.mu.Lock()
+	for _, p := range stale {
+		if cs.index[p] == tracked[p] {
+			delete(cs.index, p)
+		}
+	}
+	cs.mu.Unlock()
+	cs.saver.Call(cs.saveIndex)
+}
+
+// sweep parcourt un type d'objets et supprime ceux que keep refuse
+func (cs *ChunkStore) sweep(kind string, keep func(hash string, modTime time.Time) bool) (int, int64, error) {
+	removed := 0
+	var freed int64
+
+	err := filepath.Walk(filepath.Join(cs.dir(), kind), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !isObjectHash(info.Name()) {
+			return nil
+		}
+		if keep(info.Name(), info.ModTime()) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+			freed += info.Size()
+		}
+		return nil
+	})
+
+	return removed, freed, err
+}
+
+// Stats retourne l'occupation du stockage
+func (cs *ChunkStore) Stats() StoreStats {
+	var stats StoreStats
+
+	cs.mu.Lock()
+	stats.Files = len(cs.index)
+	cs.mu.Unlock()
+
+	filepath.Walk(filepath.Join(cs.dir(), "chunks"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			stats.Chunks++
+			stats.StoredSize += info.Size()
+		}
+		return nil
+	})
+	filepath.Walk(filepath.Join(cs.dir(), "manifests"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			stats.Manifests++
+		}
+		return nil
+	})
+
+	return stats
+}
+
+// ============================================================================
+// GLOBAL INSTANCE
+// ============================================================================
+
+// Initialisé avant les init() pour que les sauvegardes puissent s'y enregistrer
+var globalChunkStore = NewChunkStore(getDataDir())
+
+// GetChunkStore retourne le stockage de l'hôte
+func GetChunkStore() *ChunkStore { return globalChunkStore }
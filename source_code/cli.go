@@ -0,0 +1,929 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ============================================================================
+// MODE LIGNE DE COMMANDE (HEADLESS)
+// ============================================================================
+
+// Codes de sortie de la ligne de commande
+const (
+	ExitOK         = 0 // Succès
+	ExitError      = 1 // Erreur générale
+	ExitUsage      = 2 // Arguments invalides
+	ExitConnection = 3 // Connexion impossible ou perdue
+	ExitAuth       = 4 // Authentification refusée
+)
+
+// passwordEnvVar variable d'environnement contenant le mot de passe de -user
+const passwordEnvVar = "SPIRALY_PASSWORD"
+
+// passphraseEnvVar variable d'environnement contenant la phrase secrète de -e2e
+const passphraseEnvVar = "SPIRALY_PASSPHRASE"
+
+// storePassphraseEnvVar variable d'environnement contenant la phrase secrète du stockage chiffré
+const storePassphraseEnvVar = "SPIRALY_STORE_PASSPHRASE"
+
+// cliOptions regroupe les options communes aux commandes client
+type cliOptions struct {
+	server  string
+	hostID  string
+	share   string
+	syncDir string
+	user    string // Compte utilisateur (mot de passe: SPIRALY_PASSWORD)
+	e2e     bool   // Partage chiffré de bout en bout (phrase secrète: SPIRALY_PASSPHRASE)
+	// Identité ouvrant le trousseau (vide = spiraly_identity.json du compte)
+	identity string
+	timeout  time.Duration
+	all      bool // sync: tous les partages de la section "mounts"
+}
+
+// runCLI exécute la sous-commande demandée
+// Retourne handled=false si aucune sous-commande n'est reconnue (lancement de la GUI)
+func runCLI(args []string) (int, bool) {
+	switch args[0] {
+	case "serve":
+		return cliServe(args[1:]), true
+	case "sync":
+		return cliSync(args[1:]), true
+	case "pull":
+		return cliPull(args[1:]), true
+	case "push":
+		return cliPush(args[1:]), true
+	case "user":
+		return cliUser(args[1:]), true
+	case "rotate-key":
+		return cliRotateKey(args[1:]), true
+	case "share-key":
+		return cliShareKey(args[1:]), true
+	case "decrypt-backup":
+		return cliDecryptBackup(args[1:]), true
+	case "help", "-h", "-help", "--help":
+		printCLIUsage(os.Stdout)
+		return ExitOK, true
+	}
+	return 0, false
+}
+
+// printCLIUsage affiche l'aide générale
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spiralydata [commande] [options]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Sans commande, l'interface graphique est lancée.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commandes:")
+	fmt.Fprintln(w, "  serve   Démarre le serveur (Host) sans interface")
+	fmt.Fprintln(w, "  sync    Connecte le client et synchronise en continu (-all: tous les partages configurés)")
+	fmt.Fprintln(w, "  pull    Reçoit les fichiers du serveur puis quitte")
+	fmt.Fprintln(w, "  push    Envoie les modifications locales puis quitte")
+	fmt.Fprintln(w, "  user    Gère les comptes utilisateurs de l'hôte (add, remove, reset-key, list)")
+	fmt.Fprintln(w, "  rotate-key  Remplace la clé de chiffrement d'un partage chiffré de bout en bout")
+	fmt.Fprintln(w, "  share-key   Gère les accès à un partage chiffré de bout en bout (list, grant, revoke, recovery)")
+	fmt.Fprintln(w, "  decrypt-backup  Restaure une sauvegarde de l'hôte, chiffrée ou non, sans démarrer le serveur")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Les valeurs par défaut proviennent de spiraly_config.json")
+	fmt.Fprintln(w, "et spiraly_sync_config.json.")
+	fmt.Fprintln(w, "Avec -user, le mot de passe est lu dans la variable SPIRALY_PASSWORD.")
+	fmt.Fprintln(w, "Avec -e2e, la phrase secrète est lue dans la variable SPIRALY_PASSPHRASE;")
+	fmt.Fprintln(w, "avec -user, l'identité du compte (spiraly_identity.json) peut la remplacer.")
+	fmt.Fprintln(w, "La phrase secrète du stockage chiffré de l'hôte est lue dans -keyfile,")
+	fmt.Fprintln(w, "la variable SPIRALY_STORE_PASSPHRASE ou l'entrée standard.")
+	fmt.Fprintln(w, "Utilisez 'spiralydata <commande> -h' pour le détail des options.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Codes de sortie: 0 succès, 1 erreur, 2 arguments invalides,")
+	fmt.Fprintln(w, "3 connexion impossible ou perdue, 4 authentification refusée ou certificat de l'hôte modifié.")
+}
+
+// cliServe démarre le serveur jusqu'à réception de SIGINT/SIGTERM
+func cliServe(args []string) int {
+	config, _ := LoadConfig()
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	port := fs.String("port", config.ServerPort, "port d'écoute")
+	hostID := fs.String("id", config.HostID, "ID du serveur (6 caractères minimum)")
+	dir := fs.String("dir", "", "dossier partagé (défaut: partages de la config, sinon <exe>/Spiralydata)")
+	keyFile := fs.String("keyfile", config.Host.StoreKeyFile, "fichier contenant la phrase secrète du stockage chiffré")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	if *port == "" {
+		fmt.Fprintln(os.Stderr, "Le port est requis (-port)")
+		return ExitUsage
+	}
+	if len(*hostID) < 6 {
+		fmt.Fprintln(os.Stderr, "L'ID doit contenir au moins 6 caractères (-id)")
+		return ExitUsage
+	}
+
+	headlessMode = true
+
+	shareDir := ""
+	if *dir != "" {
+		absDir, err := filepath.Abs(*dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Dossier invalide: %v\n", err)
+			return ExitUsage
+		}
+		shareDir = absDir
+	}
+	shares := sharesFromConfig(config, shareDir)
+	if err := ValidateShares(shares); err != nil {
+		fmt.Fprintf(os.Stderr, "Partages invalides: %v\n", err)
+		return ExitUsage
+	}
+
+	if code := unlockStoreCLI(*keyFile, config.Host.NeedsStoreKey()); code != ExitOK {
+		return code
+	}
+
+	host := NewHost(*hostID, shares, config.Host)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- host.Start(*port)
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return ExitError
+		}
+		return ExitOK
+	case <-sigChan:
+		host.Stop()
+		<-errChan
+		return ExitOK
+	}
+}
+
+// cliSync connecte le client et active la synchronisation automatique
+func cliSync(args []string) int {
+	opts, code := parseClientFlags("sync", args, false)
+	if opts == nil {
+		return code
+	}
+	if opts.all {
+		return cliSyncAll()
+	}
+
+	client, done, code := connectCLI(opts)
+	if client == nil {
+		return code
+	}
+
+	client.ToggleAutoSync()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return ExitConnection
+		}
+		return ExitOK
+	case <-sigChan:
+		addLog("Deconnexion...")
+		client.Disconnect()
+		<-done
+		return ExitOK
+	}
+}
+
+// cliSyncAll synchronise en continu chaque partage de la section "mounts",
+// chacun dans son dossier local, éventuellement depuis des hôtes différents
+func cliSyncAll() int {
+	config, _ := LoadConfig()
+	if config == nil || len(config.Mounts) == 0 {
+		fmt.Fprintln(os.Stderr, "Aucun partage dans la section \"mounts\" de spiraly_config.json")
+		return ExitUsage
+	}
+
+	var clients []*Client
+	done := make(chan error, len(config.Mounts))
+	code := ExitOK
+	for _, mount := range config.Mounts {
+		absDir, err := filepath.Abs(mount.Dir)
+		if err != nil || mount.Server == "" || len(mount.HostID) < 6 {
+			fmt.Fprintf(os.Stderr, "Partage %s ignoré: adresse, ID ou dossier invalide\n", shareDisplayName(mount.Share))
+			code = ExitUsage
+			continue
+		}
+		if mount.E2E && mount.User == "" && os.Getenv(passphraseEnvVar) == "" {
+			fmt.Fprintf(os.Stderr, "Partage %s ignoré: phrase secrète requise (variable %s)\n", shareDisplayName(mount.Share), passphraseEnvVar)
+			code = ExitUsage
+			continue
+		}
+		opts := &cliOptions{server: mount.Server, hostID: mount.HostID, share: mount.Share, syncDir: absDir, user: mount.User, e2e: mount.E2E}
+		client, clientDone, clientCode := connectCLI(opts)
+		if client == nil {
+			code = clientCode
+			continue
+		}
+		client.ToggleAutoSync()
+		clients = append(clients, client)
+		go func() {
+			done <- <-clientDone
+		}()
+	}
+	if len(clients) == 0 {
+		return code
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	// Jusqu'au signal d'arrêt, ou jusqu'à la fin de toutes les connexions
+	for remaining := len(clients); remaining > 0; remaining-- {
+		select {
+		case err := <-done:
+			if err != nil {
+				code = ExitConnection
+			}
+		case <-sigChan:
+			addLog("Deconnexion...")
+			for _, client := range clients {
+				client.Disconnect()
+			}
+			for ; remaining > 0; remaining-- {
+				<-done
+			}
+			return code
+		}
+	}
+	return code
+}
+
+// cliPull reçoit l'ensemble des fichiers du serveur puis quitte
+func cliPull(args []string) int {
+	opts, code := parseClientFlags("pull", args, true)
+	if opts == nil {
+		return code
+	}
+
+	if GetSyncConfig().Mode == SyncModeUserToHost {
+		fmt.Fprintf(os.Stderr, "Réception désactivée par le mode de synchronisation (%s)\n", GetSyncConfig().GetModeName())
+		return ExitError
+	}
+
+	client, done, code := connectCLI(opts)
+	if client == nil {
+		return code
+	}
+	defer client.Disconnect()
+
+	// Laisser le serveur terminer l'envoi initial
+	if !client.WaitIdle(2*time.Second, opts.timeout) {
+		return cliWaitFailed(done)
+	}
+
+	client.PullAllFromServer()
+	time.Sleep(500 * time.Millisecond)
+
+	if !client.WaitIdle(2*time.Second, opts.timeout) {
+		return cliWaitFailed(done)
+	}
+
+	// Appliquer ce qui serait arrivé après la fin de la fenêtre de réception
+	client.pendingMu.Lock()
+	remaining := len(client.pendingChanges)
+	client.pendingMu.Unlock()
+	if remaining > 0 {
+		client.applyPendingChanges()
+	}
+
+	return ExitOK
+}
+
+// cliPush envoie les fichiers locaux nouveaux ou modifiés puis quitte
+func cliPush(args []string) int {
+	opts, code := parseClientFlags("push", args, true)
+	if opts == nil {
+		return code
+	}
+
+	if GetSyncConfig().Mode == SyncModeHostToUser {
+		fmt.Fprintf(os.Stderr, "Envoi désactivé par le mode de synchronisation (%s)\n", GetSyncConfig().GetModeName())
+		return ExitError
+	}
+
+	client, done, code := connectCLI(opts)
+	if client == nil {
+		return code
+	}
+	defer client.Disconnect()
+
+	// L'envoi initial du serveur sert de référence pour la comparaison
+	if !client.WaitIdle(2*time.Second, opts.timeout) {
+		return cliWaitFailed(done)
+	}
+	client.adoptServerState()
+
+	client.PushLocalChanges()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return ExitConnection
+		}
+	default:
+	}
+	return ExitOK
+}
+
+// cliRotateKey remplace la clé de contenu d'un partage chiffré de bout en bout
+// Les fichiers sont rechiffrés avec la nouvelle clé à leur prochain envoi.
+func cliRotateKey(args []string) int {
+	opts, code := parseClientFlags("rotate-key", args, false)
+	if opts == nil {
+		return code
+	}
+	if !opts.e2e {
+		fmt.Fprintln(os.Stderr, "rotate-key concerne un partage chiffré de bout en bout (-e2e)")
+		return ExitUsage
+	}
+
+	client, done, code := connectCLI(opts)
+	if client == nil {
+		return code
+	}
+	defer client.Disconnect()
+
+	if err := client.RotateE2EKey(); err != nil {
+		fmt.Fprintf(os.Stderr, "Rotation impossible: %v\n", err)
+		return ExitError
+	}
+
+	// Laisser l'hôte enregistrer le trousseau (ou le refuser)
+	if !client.WaitIdle(time.Second, 30*time.Second) {
+		return cliWaitFailed(done)
+	}
+	return ExitOK
+}
+
+// cliShareKey gère les destinataires d'un partage chiffré de bout en bout
+// grant, revoke et recovery sont réservés aux administrateurs de l'hôte.
+func cliShareKey(args []string) int {
+	usage := "Usage: spiralydata share-key list | grant <id> | revoke <id> | recovery <fichier> [options]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return ExitUsage
+	}
+	action, target := args[0], ""
+	switch action {
+	case "list":
+		args = args[1:]
+	case "grant", "revoke", "recovery":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			fmt.Fprintln(os.Stderr, usage)
+			return ExitUsage
+		}
+		target, args = args[1], args[2:]
+	default:
+		fmt.Fprintf(os.Stderr, "Sous-commande inconnue: %s (list, grant, revoke, recovery)\n", action)
+		return ExitUsage
+	}
+
+	opts, code := parseClientFlags("share-key "+action, args, false)
+	if opts == nil {
+		return code
+	}
+	if !opts.e2e {
+		fmt.Fprintln(os.Stderr, "share-key concerne un partage chiffré de bout en bout (-e2e)")
+		return ExitUsage
+	}
+
+	var recovery *E2EIdentity
+	if action == "recovery" {
+		id, created, err := loadE2EIdentity(target, true, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Clé de secours: %v\n", err)
+			return ExitError
+		}
+		if created {
+			fmt.Printf("Clé de secours créée dans %s: conservez-la hors ligne, elle ouvre le partage avec -identity\n", target)
+		}
+		recovery = id
+	}
+
+	client, done, code := connectCLI(opts)
+	if client == nil {
+		return code
+	}
+	defer client.Disconnect()
+
+	var err error
+	switch action {
+	case "list":
+		printShareAccess(client)
+		return ExitOK
+	case "grant":
+		err = client.GrantE2EAccess(target)
+	case "revoke":
+		err = client.RevokeE2EAccess(target)
+	case "recovery":
+		err = client.SetE2ERecovery(recovery)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Accès non modifié: %v\n", err)
+		return ExitError
+	}
+
+	// Laisser l'hôte enregistrer le trousseau (ou le refuser)
+	if !client.WaitIdle(time.Second, 30*time.Second) {
+		return cliWaitFailed(done)
+	}
+	return ExitOK
+}
+
+// printShareAccess affiche les destinataires du trousseau et les comptes de
+// l'annuaire qui n'y ont pas accès, avec les empreintes de leurs clés publiques
+func printShareAccess(client *Client) {
+	if client.e2e == nil {
+		return
+	}
+	keyring := client.e2e.Keyring()
+	if keyring.KDF != "" {
+		fmt.Println("phrase secrète\t(accès par SPIRALY_PASSPHRASE)")
+	}
+	for _, r := range keyring.Recipients {
+		fmt.Printf("%s\t%s\n", r.User, publicKeyFingerprint(r.PublicKey))
+	}
+	if keyring.Recovery != nil {
+		fmt.Printf("(secours)\t%s\n", publicKeyFingerprint(keyring.Recovery.PublicKey))
+	}
+
+	client.mu.Lock()
+	directory := client.publicKeys
+	client.mu.Unlock()
+	for _, user := range sortedUsers(directory) {
+		if keyring.recipient(user) == nil {
+			fmt.Printf("%s\t%s\t(sans accès)\n", user, publicKeyFingerprint(directory[user]))
+		}
+	}
+}
+
+// cliDecryptBackup restaure une sauvegarde de l'hôte dans un dossier, sans
+// démarrer le serveur (hôte arrêté, ou dossier des données copié ailleurs et
+// désigné par SPIRALY_CONFIG). Sans argument, les sauvegardes sont listées.
+func cliDecryptBackup(args []string) int {
+	config, _ := LoadConfig()
+
+	fs := flag.NewFlagSet("decrypt-backup", flag.ContinueOnError)
+	keyFile := fs.String("keyfile", config.Host.StoreKeyFile, "fichier contenant la phrase secrète du stockage chiffré")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: spiralydata decrypt-backup [-keyfile fichier] [<id> <dossier>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	headlessMode = true
+	backups := GetBackupManager().GetBackups()
+
+	if fs.NArg() == 0 {
+		if len(backups) == 0 {
+			fmt.Printf("Aucune sauvegarde dans %s\n", getDataDir())
+			return ExitOK
+		}
+		for _, backup := range backups {
+			lock := ""
+			if backup.Encrypted {
+				lock = " 🔒"
+			}
+			fmt.Printf("%s  %s  %d fichiers, %s%s\n", backup.ID, backup.CreatedAt.Format("2006-01-02 15:04"),
+				backup.FileCount, FormatFileSize(backup.Size), lock)
+		}
+		return ExitOK
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+
+	backupID := fs.Arg(0)
+	destDir, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dossier invalide: %v\n", err)
+		return ExitUsage
+	}
+
+	if code := unlockStoreCLI(*keyFile, false); code != ExitOK {
+		return code
+	}
+	if err := GetBackupManager().RestoreBackup(backupID, destDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Restauration impossible: %v\n", err)
+		return ExitError
+	}
+	fmt.Printf("Sauvegarde %s restaurée dans %s\n", backupID, destDir)
+	return ExitOK
+}
+
+// unlockStoreCLI déverrouille le stockage chiffré de l'hôte si nécessaire
+// (clé existante, ou chiffrement demandé par la configuration)
+func unlockStoreCLI(keyFile string, encrypt bool) int {
+	store := GetChunkStore()
+	if !store.NeedsUnlock(encrypt) {
+		return ExitOK
+	}
+
+	passphrase, err := readStorePassphrase(keyFile, !store.HasKey())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Phrase secrète du stockage: %v\n", err)
+		return ExitUsage
+	}
+	if err := store.Unlock(passphrase); err != nil {
+		fmt.Fprintf(os.Stderr, "Stockage chiffré: %v\n", err)
+		if errors.Is(err, errStorePassphrase) {
+			return ExitAuth
+		}
+		return ExitError
+	}
+	return ExitOK
+}
+
+// readStorePassphrase lit la phrase secrète du stockage: fichier -keyfile,
+// variable SPIRALY_STORE_PASSPHRASE, sinon première ligne de l'entrée standard
+func readStorePassphrase(keyFile string, create bool) (string, error) {
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if passphrase := os.Getenv(storePassphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+
+	if create {
+		fmt.Fprintf(os.Stderr, "Nouvelle phrase secrète du stockage (%d caractères min): ", storeMinPassphrase)
+	} else {
+		fmt.Fprint(os.Stderr, "Phrase secrète du stockage: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", errors.New("phrase secrète vide")
+	}
+	return passphrase, nil
+}
+
+// cliUser gère les comptes de la section host.users de la configuration
+// Un hôte démarré recharge la configuration: les changements s'appliquent sans redémarrage.
+func cliUser(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: spiralydata user add <id> [-role read_write] [-name nom] | remove <id> | reset-key <id> | list")
+		return ExitUsage
+	}
+
+	config, err := LoadConfig()
+	if err != nil && !os.IsNotExist(err) {
+		// Ne pas écraser une configuration illisible
+		fmt.Fprintf(os.Stderr, "Configuration illisible (%s): %v\n", configFilePath, err)
+		return ExitError
+	}
+	switch args[0] {
+	case "list":
+		for _, uc := range config.Host.Users {
+			state := ""
+			if uc.Disabled {
+				state = " (désactivé)"
+			}
+			if uc.PublicKey != "" {
+				state += " 🔑 " + publicKeyFingerprint(uc.PublicKey)
+			}
+			fmt.Printf("%s\t%s\t%s%s\n", uc.ID, uc.Role, uc.Name, state)
+		}
+		return ExitOK
+
+	case "add":
+		fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+		role := fs.String("role", "read_write", "rôle: read_only, read_write ou admin")
+		name := fs.String("name", "", "nom affiché")
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			fmt.Fprintln(os.Stderr, "Identifiant requis: spiralydata user add <id> [options]")
+			return ExitUsage
+		}
+		id := args[1]
+		if err := fs.Parse(args[2:]); err != nil {
+			return flagExitCode(err)
+		}
+		if _, err := parseUserRole(*role); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitUsage
+		}
+		password, err := readNewPassword()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitUsage
+		}
+
+		replaced := false
+		for i := range config.Host.Users {
+			// Compte existant: ses chemins et quotas sont conservés
+			if uc := &config.Host.Users[i]; uc.ID == id {
+				uc.Role = *role
+				uc.PasswordHash = HashPassword(password)
+				if *name != "" {
+					uc.Name = *name
+				}
+				replaced = true
+			}
+		}
+		if !replaced {
+			config.Host.Users = append(config.Host.Users, UserConfig{ID: id, Name: *name, Role: *role, PasswordHash: HashPassword(password)})
+		}
+
+	case "remove":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Identifiant requis: spiralydata user remove <id>")
+			return ExitUsage
+		}
+		kept := config.Host.Users[:0]
+		for _, uc := range config.Host.Users {
+			if uc.ID != args[1] {
+				kept = append(kept, uc)
+			}
+		}
+		if len(kept) == len(config.Host.Users) {
+			fmt.Fprintf(os.Stderr, "Utilisateur inconnu: %s\n", args[1])
+			return ExitError
+		}
+		config.Host.Users = kept
+
+	case "reset-key":
+		// Le compte enregistrera la clé publique de sa prochaine connexion
+		// (nouvel appareil, identité perdue)
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "Identifiant requis: spiralydata user reset-key <id>")
+			return ExitUsage
+		}
+		found := false
+		for i := range config.Host.Users {
+			if uc := &config.Host.Users[i]; uc.ID == args[1] {
+				uc.PublicKey = ""
+				found = true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "Utilisateur inconnu: %s\n", args[1])
+			return ExitError
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Sous-commande inconnue: %s (add, remove, reset-key, list)\n", args[0])
+		return ExitUsage
+	}
+
+	if err := SaveConfig(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Erreur sauvegarde config: %v\n", err)
+		return ExitError
+	}
+	fmt.Printf("Comptes enregistrés dans %s\n", configFilePath)
+	return ExitOK
+}
+
+// readNewPassword lit le mot de passe d'un compte: variable SPIRALY_PASSWORD,
+// sinon première ligne de l'entrée standard
+func readNewPassword() (string, error) {
+	if password := os.Getenv(passwordEnvVar); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Mot de passe: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", errors.New("mot de passe vide")
+	}
+	return password, nil
+}
+
+// parseClientFlags analyse les options communes aux commandes client
+// Retourne nil et le code de sortie si les arguments sont invalides
+func parseClientFlags(name string, args []string, withTimeout bool) (*cliOptions, int) {
+	config, _ := LoadConfig()
+
+	defaultServer := ""
+	if config.ServerIP != "" && config.ServerPort != "" {
+		defaultServer = config.ServerIP + ":" + config.ServerPort
+	}
+	defaultDir := config.SyncDirectory
+	if defaultDir == "" {
+		defaultDir = filepath.Join(getExecutableDir(), "Spiralydata")
+	}
+
+	opts := &cliOptions{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.server, "server", defaultServer, "adresse du serveur (ip:port)")
+	fs.StringVar(&opts.hostID, "id", config.HostID, "ID du host")
+	fs.StringVar(&opts.share, "share", config.Share, "partage de l'hôte (vide = partage par défaut)")
+	fs.StringVar(&opts.syncDir, "dir", defaultDir, "dossier de synchronisation local")
+	fs.StringVar(&opts.user, "user", config.Username, "compte utilisateur (mot de passe: SPIRALY_PASSWORD)")
+	fs.BoolVar(&opts.e2e, "e2e", config.E2E, "partage chiffré de bout en bout (phrase secrète: SPIRALY_PASSPHRASE)")
+	fs.StringVar(&opts.identity, "identity", "", "identité ouvrant le trousseau chiffré de bout en bout (ex: clé de secours)")
+	if name == "sync" {
+		fs.BoolVar(&opts.all, "all", false, "synchronise tous les partages de la section \"mounts\" de la config")
+	}
+	if withTimeout {
+		fs.DurationVar(&opts.timeout, "timeout", 10*time.Minute, "durée maximale de l'opération")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, flagExitCode(err)
+	}
+
+	if opts.all {
+		SetSyncConfig(LoadSyncConfigFromFile())
+		return opts, ExitOK
+	}
+
+	if opts.server == "" {
+		fmt.Fprintln(os.Stderr, "L'adresse du serveur est requise (-server ip:port)")
+		return nil, ExitUsage
+	}
+	if len(opts.hostID) < 6 {
+		fmt.Fprintln(os.Stderr, "L'ID doit contenir au moins 6 caractères (-id)")
+		return nil, ExitUsage
+	}
+	if opts.user != "" && os.Getenv(passwordEnvVar) == "" {
+		fmt.Fprintf(os.Stderr, "Mot de passe requis pour -user (variable %s)\n", passwordEnvVar)
+		return nil, ExitUsage
+	}
+	if opts.e2e && opts.user == "" && opts.identity == "" && os.Getenv(passphraseEnvVar) == "" {
+		fmt.Fprintf(os.Stderr, "Phrase secrète (variable %s), compte (-user) ou identité (-identity) requis pour -e2e\n", passphraseEnvVar)
+		return nil, ExitUsage
+	}
+	if opts.identity != "" {
+		if !opts.e2e {
+			fmt.Fprintln(os.Stderr, "-identity concerne un partage chiffré de bout en bout (-e2e)")
+			return nil, ExitUsage
+		}
+		absIdentity, err := filepath.Abs(opts.identity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Identité invalide: %v\n", err)
+			return nil, ExitUsage
+		}
+		opts.identity = absIdentity
+	}
+
+	absDir, err := filepath.Abs(opts.syncDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Dossier invalide: %v\n", err)
+		return nil, ExitUsage
+	}
+	opts.syncDir = absDir
+
+	SetSyncConfig(LoadSyncConfigFromFile())
+
+	return opts, ExitOK
+}
+
+// connectCLI établit la connexion et démarre la boucle de lecture
+// Le channel done reçoit le résultat de la boucle à la fermeture de la connexion
+func connectCLI(opts *cliOptions) (*Client, chan error, int) {
+	headlessMode = true
+
+	addLog("🔌 Connexion au serveur " + opts.server)
+	addLog(fmt.Sprintf("⚙️ Mode: %s", GetSyncConfig().GetModeName()))
+
+	var creds *Credentials
+	if opts.user != "" || opts.e2e {
+		creds = &Credentials{}
+		if opts.user != "" {
+			creds.Username = opts.user
+			creds.Password = os.Getenv(passwordEnvVar)
+		}
+		if opts.e2e {
+			creds.E2E = true
+			creds.Passphrase = os.Getenv(passphraseEnvVar)
+			creds.IdentityFile = opts.identity
+		}
+	}
+
+	ws, authResp, err := dialServer(opts.server, opts.hostID, opts.share, opts.syncDir, creds)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			return nil, nil, ExitAuth
+		}
+		if changed, ok := err.(*CertChangedError); ok {
+			showCertChangedWarning(changed, nil)
+			return nil, nil, ExitAuth
+		}
+		return nil, nil, ExitConnection
+	}
+
+	client := NewClient(ws, opts.server, opts.hostID, opts.share, opts.syncDir, creds, authResp)
+	client.start()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.run()
+	}()
+
+	return client, done, ExitOK
+}
+
+// cliWaitFailed détermine le code de sortie après un échec d'attente
+func cliWaitFailed(done chan error) int {
+	select {
+	case err := <-done:
+		if err != nil {
+			return ExitConnection
+		}
+		return ExitError
+	default:
+		addLog("⏱️ Délai dépassé")
+		return ExitError
+	}
+}
+
+// flagExitCode convertit une erreur d'analyse des options en code de sortie
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
+
+// adoptServerState remplace l'état connu par celui envoyé par le serveur
+// à la connexion, pour que PushLocalChanges n'envoie que ce qui diffère.
+// Les fichiers absents localement ne sont pas repris pour ne pas être supprimés.
+// Avec un manifeste, l'état connu en vient déjà: seuls les éléments reçus le complètent.
+func (c *Client) adoptServerState() {
+	c.pendingMu.Lock()
+	changes := c.pendingChanges
+	c.pendingChanges = []FileChange{}
+	c.pendingMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.manifestApplied {
+		c.knownFiles = make(map[string]time.Time)
+		c.knownDirs = make(map[string]time.Time)
+	}
+
+	for _, change := range changes {
+		c.adoptServerChange(change)
+		change.Discard()
+	}
+}
+
+// adoptServerChange met à jour l'état connu pour un élément envoyé par le serveur
+// L'appelant doit détenir c.mu
+func (c *Client) adoptServerChange(change FileChange) {
+	localPath := filepath.Join(c.localDir, filepath.FromSlash(change.FileName))
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return
+	}
+
+	switch change.Op {
+	case "mkdir":
+		if info.IsDir() {
+			c.knownDirs[change.FileName] = info.ModTime()
+		}
+	case "create", "write":
+		if info.IsDir() {
+			return
+		}
+		localData, err := os.ReadFile(localPath)
+		if err != nil {
+			return
+		}
+		serverData, err := change.ReadContent()
+		if err == nil && sha256.Sum256(localData) == sha256.Sum256(serverData) {
+			c.knownFiles[change.FileName] = info.ModTime()
+		} else {
+			// Contenu différent: considéré comme modifié localement
+			c.knownFiles[change.FileName] = time.Time{}
+		}
+	}
+}
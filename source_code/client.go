@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -35,47 +37,77 @@ type Client struct {
 	pendingMu          sync.Mutex
 	filesReceivedCount int
 	lastLogTime        time.Time
+	lastMessageTime    time.Time // Dernier message reçu du serveur
 	explorerActive     bool
 	treeItemsChan      chan FileTreeItemMessage
 	downloadActive     bool
 	downloadChan       chan FileChange
+	versionsChan       chan VersionList // Réponses aux demandes d'historique
+	trashChan          chan TrashList   // Réponses aux demandes sur la corbeille de l'hôte
 	ctx                context.Context
 	cancel             context.CancelFunc
 	watcherDone        chan struct{}
 	opQueue            chan func() // Queue d'opérations pour éviter les race conditions
 	skipTracking       bool        // Ignorer le tracking pendant Recevoir/Vider local
+	protocolVersion    int         // Version du protocole négociée avec le serveur
+	capabilities       []string    // Capacités négociées avec le serveur
+	handlers           map[string]clientHandler
+	receiver           *StreamReceiver // Fichiers reçus par morceaux
+	offers             deltaOffers     // Offres de delta envoyées au serveur
+	serverAddr         string          // Adresse du serveur (reconnexion)
+	hostID             string          // ID du host (reconnexion)
+	share              string          // Partage demandé à l'hôte (vide = par défaut)
+	creds              *Credentials    // Compte utilisateur (nil = ID de l'hôte seul)
+	uploadOffsets      map[string]int64 // Positions des envois interrompus reçues du serveur
+	manifestEntries    []ManifestEntry  // Lots du manifeste reçus, jusqu'au dernier
+	manifestPending    bool             // Manifeste annoncé mais pas encore comparé
+	manifestApplied    bool             // L'état connu vient du manifeste du serveur
+	state              *SyncState       // État persistant de la dernière synchronisation
+	trash              *Trash           // Éléments supprimés par les autres pairs
+	ignores            *IgnoreMatcher   // Règles des fichiers .spiralyignore
+	e2e                *E2ECipher       // Chiffrement de bout en bout du partage (nil = désactivé)
+	publicKeys         map[string]string // Clés publiques des comptes (administrateurs, share-key grant)
 }
 
-func StartClientGUI(serverAddr, hostID, syncDir string, stopAnimation, connectionSuccess *bool, loadingLabel, statusLabel, infoLabel *widget.Label, client **Client) {
-	addLog("🔌 Connexion au serveur " + serverAddr)
-	
-	time.Sleep(300 * time.Millisecond)
-	
+// clientHandler traite un type de message reçu du serveur
+type clientHandler func(env *Envelope) error
+
+// Erreurs retournées par dialServer selon l'étape qui a échoué
+var (
+	errAuthSend       = errors.New("erreur d'authentification")
+	errAuthNoResponse = errors.New("pas de réponse du serveur")
+	errAuthFailed     = errors.New("authentification refusée")
+)
+
+// dialServer ouvre la connexion WebSocket chiffrée et effectue l'authentification
+// Le certificat de l'hôte doit correspondre à l'empreinte enregistrée lors de
+// la première connexion (CertChangedError sinon).
+// Le client annonce sa version du protocole et ses capacités, le serveur
+// répond avec celles qu'il retient (absentes si le serveur est en v1).
+// Les transferts interrompus trouvés dans syncDir sont annoncés pour être repris.
+// share est le nom du partage demandé (vide = partage par défaut de l'hôte),
+// creds le compte de l'utilisateur et/ou la phrase secrète du partage (nil si
+// l'hôte n'en demande pas): le jeton reçu et les clés du partage sont conservés
+// dans creds pour les reconnexions.
+// Avec une phrase secrète, aucun nom en clair n'est annoncé (abonnements,
+// filtres, envois à reprendre) et un partage non chiffré est refusé.
+func dialServer(serverAddr, hostID, share, syncDir string, creds *Credentials) (*websocket.Conn, AuthResponse, error) {
+	certs := newCertCheck(serverAddr)
 	dialer := &websocket.Dialer{
 		HandshakeTimeout:  10 * time.Second,
 		ReadBufferSize:    10 * 1024 * 1024, // 10MB
 		WriteBufferSize:   10 * 1024 * 1024, // 10MB
+		TLSClientConfig:   certs.tlsConfig(),
 	}
 	
-	ws, _, err := dialer.Dial("ws://"+serverAddr+"/ws", nil)
+	ws, _, err := dialer.Dial("wss://"+serverAddr+"/ws", nil)
 	if err != nil {
+		if certs.changed != nil {
+			addLog(fmt.Sprintf("🚨 Le certificat de %s a changé: %s (attendu %s)", serverAddr, certs.changed.Presented, certs.changed.Pinned))
+			return nil, AuthResponse{}, certs.changed
+		}
 		addLog(fmt.Sprintf("❌ Impossible de se connecter: %v", err))
-		*stopAnimation = true
-		loadingLabel.SetText("✗ Connexion échouée")
-		loadingLabel.Refresh()
-		statusLabel.SetText("Statut: Échec de connexion")
-		statusLabel.Refresh()
-		infoLabel.SetText(fmt.Sprintf(
-			"ÉCHEC DE CONNEXION\n\n"+
-				"Serveur: %s\n"+
-				"ID: %s\n"+
-				"Dossier: %s\n\n"+
-				"Impossible de se connecter au serveur.\n"+
-				"Vérifiez l'adresse IP et le port.",
-			serverAddr, hostID, syncDir,
-		))
-		infoLabel.Refresh()
-		return
+		return nil, AuthResponse{}, err
 	}
 	
 	// Augmenter la limite de lecture pour les gros fichiers
@@ -85,21 +117,50 @@ func StartClientGUI(serverAddr, hostID, syncDir string, stopAnimation, connectio
 
 	time.Sleep(200 * time.Millisecond)
 
+	e2e := creds != nil && (creds.E2E || creds.Passphrase != "")
+	resume, uploads := loadResumePoints(syncDir)
 	authReq := AuthRequest{
-		Type:   "auth_request",
-		HostID: hostID,
+		Type:            "auth_request",
+		HostID:          hostID,
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    clientCapabilities(e2e),
+		Resume:          resume,
+		Share:           share,
+	}
+	if !e2e {
+		authReq.Uploads = uploads
+		authReq.Subscription = GetFilterConfig().Subscription()
+		authReq.Filters, _ = GetFilterConfig().ToJSON()
+	}
+	if creds != nil && creds.Username != "" {
+		authReq.Username = creds.Username
+		if creds.Token != "" {
+			authReq.Token = creds.Token
+		} else {
+			authReq.Password = creds.Password
+		}
+	}
+	if e2e {
+		// Clé publique présentée à l'hôte, qui l'enregistre pour le compte
+		id, err := creds.e2eIdentity()
+		if err != nil {
+			addLog(fmt.Sprintf("🔐 %v", err))
+			ws.Close()
+			return nil, AuthResponse{}, fmt.Errorf("%w: %v", errAuthFailed, err)
+		}
+		if id != nil && !id.Recovery && creds.Username != "" {
+			authReq.PublicKey = id.PublicKey
+		}
+	}
+	if len(resume)+len(uploads) > 0 {
+		addLog(fmt.Sprintf("⏸️ Transferts interrompus: %d réception(s), %d envoi(s)", len(resume), len(uploads)))
 	}
 
 	addLog("🔐 Authentification en cours...")
 	if err := ws.WriteJSON(authReq); err != nil {
 		addLog(fmt.Sprintf("❌ Erreur d'authentification: %v", err))
 		ws.Close()
-		*stopAnimation = true
-		loadingLabel.SetText("✗ Erreur d'authentification")
-		loadingLabel.Refresh()
-		statusLabel.SetText("Statut: Erreur d'authentification")
-		statusLabel.Refresh()
-		return
+		return nil, AuthResponse{}, fmt.Errorf("%w: %v", errAuthSend, err)
 	}
 
 	time.Sleep(300 * time.Millisecond)
@@ -110,70 +171,44 @@ func StartClientGUI(serverAddr, hostID, syncDir string, stopAnimation, connectio
 	if err := ws.ReadJSON(&authResp); err != nil {
 		addLog(fmt.Sprintf("❌ Pas de réponse du serveur: %v", err))
 		ws.Close()
-		*stopAnimation = true
-		loadingLabel.SetText("✗ Pas de réponse")
-		loadingLabel.Refresh()
-		statusLabel.SetText("Statut: Pas de réponse")
-		statusLabel.Refresh()
-		return
+		return nil, AuthResponse{}, fmt.Errorf("%w: %v", errAuthNoResponse, err)
 	}
 	ws.SetReadDeadline(time.Time{})
 
 	if authResp.Type == "auth_failed" {
-		addLog(fmt.Sprintf("🚫 Authentification refusée: %s", authResp.Message))
 		ws.Close()
-		*stopAnimation = true
-		loadingLabel.SetText("✗ ID incorrect")
-		loadingLabel.Refresh()
-		statusLabel.SetText("Statut: ID incorrect")
-		statusLabel.Refresh()
-		infoLabel.SetText(fmt.Sprintf(
-			"AUTHENTIFICATION REFUSÉE\n\n"+
-				"Serveur: %s\n"+
-				"ID: %s\n"+
-				"Dossier: %s\n\n"+
-				"L'ID du host est incorrect.\n"+
-				"Vérifiez l'ID et réessayez.",
-			serverAddr, hostID, syncDir,
-		))
-		infoLabel.Refresh()
-		return
+		if authReq.Token != "" && creds.Password != "" {
+			// Jeton expiré ou révoqué: nouvelle tentative avec le mot de passe
+			addLog("🔑 Jeton refusé, authentification par mot de passe...")
+			creds.Token = ""
+			return dialServer(serverAddr, hostID, share, syncDir, creds)
+		}
+		addLog(fmt.Sprintf("🚫 Authentification refusée: %s", authResp.Message))
+		return nil, AuthResponse{}, fmt.Errorf("%w: %s", errAuthFailed, authResp.Message)
+	}
+	if creds != nil && authResp.Token != "" {
+		creds.Token = authResp.Token
+	}
+	if e2e {
+		if err := unlockE2E(ws, authResp, creds, syncDir); err != nil {
+			ws.Close()
+			addLog(fmt.Sprintf("🔐 Chiffrement de bout en bout impossible: %v", err))
+			return nil, AuthResponse{}, fmt.Errorf("%w: %v", errAuthFailed, err)
+		}
 	}
 
-	*stopAnimation = true
-	*connectionSuccess = true
+	certs.pinIfNew()
 	addLog(fmt.Sprintf("🎉 Connecté au serveur %s", serverAddr))
 	addLog(fmt.Sprintf("🔒 ID validé: %s", hostID))
-	
-	time.Sleep(200 * time.Millisecond)
-	
-	loadingLabel.SetText("✓ Connecté")
-	loadingLabel.Refresh()
-	statusLabel.SetText("Statut: Connecté (Mode Manuel)")
-	statusLabel.Refresh()
-	
-	infoLabel.SetText(fmt.Sprintf(
-		"CONNECTÉ\n\n"+
-			"Serveur: %s\n"+
-			"ID: %s\n"+
-			"Dossier: %s\n\n"+
-			"Mode: Manuel\n"+
-			"Activez la sync pour synchroniser automatiquement",
-		serverAddr, hostID, syncDir,
-	))
-	infoLabel.Refresh()
-
-	if err := os.MkdirAll(syncDir, 0755); err != nil {
-		addLog(fmt.Sprintf("❌ Impossible de créer le dossier: %v", err))
-	} else {
-		addLog(fmt.Sprintf("📂 Dossier: %s", syncDir))
-	}
 
-	time.Sleep(300 * time.Millisecond)
+	return ws, authResp, nil
+}
 
+// NewClient crée un client à partir d'une connexion déjà authentifiée
+func NewClient(ws *websocket.Conn, serverAddr, hostID, share, syncDir string, creds *Credentials, authResp AuthResponse) *Client {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	*client = &Client{
+	c := &Client{
 		ws:                 ws,
 		localDir:           syncDir,
 		skipNext:           make(map[string]time.Time),
@@ -190,109 +225,860 @@ func StartClientGUI(serverAddr, hostID, syncDir string, stopAnimation, connectio
 		pendingChanges:     []FileChange{},
 		filesReceivedCount: 0,
 		lastLogTime:        time.Now(),
+		lastMessageTime:    time.Now(),
 		explorerActive:     false,
 		downloadActive:     false,
 		ctx:                ctx,
 		cancel:             cancel,
 		watcherDone:        make(chan struct{}),
 		opQueue:            make(chan func(), 100),
+		handlers:           make(map[string]clientHandler),
+		receiver:           NewStreamReceiver(syncDir),
+		serverAddr:         serverAddr,
+		hostID:             hostID,
+		share:              share,
+		creds:              creds,
+		ignores:            NewIgnoreMatcher(syncDir),
+	}
+	if creds != nil {
+		c.e2e = creds.e2e
+	}
+	c.registerHandlers()
+	c.applyAuthResponse(ws, authResp)
+
+	return c
+}
+
+// applyAuthResponse retient la version, les capacités et les positions de
+// reprise renvoyées par le serveur pour la connexion ws
+func (c *Client) applyAuthResponse(ws *websocket.Conn, authResp AuthResponse) {
+	version := negotiateVersion(authResp.ProtocolVersion)
+	caps := []string{}
+	if version >= ProtocolVersion {
+		caps = negotiateCapabilities(authResp.Capabilities)
+	}
+
+	offsets := make(map[string]int64)
+	if hasCapability(caps, CapResume) {
+		for _, r := range authResp.Resume {
+			offsets[r.TransferID] = r.Offset
+		}
+	}
+
+	c.mu.Lock()
+	c.protocolVersion = version
+	c.capabilities = caps
+	c.uploadOffsets = offsets
+	c.publicKeys = authResp.PublicKeys
+	c.manifestEntries = nil
+	c.manifestPending = hasCapability(caps, CapManifest) && !(hasCapability(caps, CapShares) && authResp.WriteOnly)
+	c.lastMessageTime = time.Now()
+	c.mu.Unlock()
+
+	if c.share != "" && !hasCapability(caps, CapShares) {
+		addLog(fmt.Sprintf("⚠️ L'hôte ne gère pas les partages: %s remplacé par son dossier unique", c.share))
+	} else if hasCapability(caps, CapShares) {
+		switch {
+		case authResp.ReadOnly && authResp.WriteOnly:
+			addLog(fmt.Sprintf("📁 Partage %s: aucun échange autorisé", shareDisplayName(authResp.Share)))
+		case authResp.ReadOnly:
+			addLog(fmt.Sprintf("📁 Partage %s: lecture seule", shareDisplayName(authResp.Share)))
+		case authResp.WriteOnly:
+			addLog(fmt.Sprintf("📁 Partage %s: envoi seul", shareDisplayName(authResp.Share)))
+		default:
+			addLog(fmt.Sprintf("📁 Partage %s", shareDisplayName(authResp.Share)))
+		}
+	}
+
+	if hasCapability(caps, CapE2E) {
+		addLog("🔐 Partage chiffré de bout en bout")
+	}
+
+	if authResp.User != "" {
+		role, _ := parseUserRole(authResp.Role)
+		addLog(fmt.Sprintf("👤 Connecté en tant que %s (%s)", authResp.User, role.String()))
+	}
+
+	// Les gros fichiers arrivent par morceaux: inutile d'accepter des trames de 50MB
+	if hasCapability(caps, CapChunked) {
+		ws.SetReadLimit(chunkedReadLimit)
 	}
 
+	if version < ProtocolVersion {
+		addLog(fmt.Sprintf("ℹ️ Serveur en ancien protocole (v%d)", version))
+	}
+
+	GetConnectionManager().SetState(StateConnected)
+}
+
+// registerHandler associe un gestionnaire à un type de message
+func (c *Client) registerHandler(msgType string, h clientHandler) {
+	c.handlers[msgType] = h
+}
+
+// registerHandlers enregistre les gestionnaires de tous les messages serveur
+func (c *Client) registerHandlers() {
+	c.registerHandler(MsgFileChange, c.handleFileChangeMsg)
+	c.registerHandler(MsgFileTreeItem, c.handleFileTreeMsg)
+	c.registerHandler(MsgFileTreeComplete, c.handleFileTreeMsg)
+	c.registerHandler(MsgError, c.handleErrorMsg)
+	c.registerHandler(MsgTransferBegin, c.handleTransferBegin)
+	c.registerHandler(MsgTransferCommit, c.handleTransferCommit)
+	c.registerHandler(MsgTransferAbort, c.handleTransferAbort)
+	c.registerHandler(MsgDeltaOffer, c.handleDeltaOffer)
+	c.registerHandler(MsgDeltaSignature, c.handleDeltaSignature)
+	c.registerHandler(MsgSyncManifest, c.handleSyncManifest)
+	c.registerHandler(MsgVersionList, c.handleVersionList)
+	c.registerHandler(MsgTrashList, c.handleTrashList)
+	c.registerHandler(MsgFilterReport, c.handleFilterReport)
+	c.registerHandler(MsgAccessDenied, c.handleAccessDenied)
+	c.registerHandler(MsgE2EKeyring, c.handleE2EKeyring)
+}
+
+// start prépare le dossier local, lance le worker et le watcher
+func (c *Client) start() {
+	if err := os.MkdirAll(c.localDir, 0755); err != nil {
+		addLog(fmt.Sprintf("❌ Impossible de créer le dossier: %v", err))
+	} else {
+		addLog(fmt.Sprintf("📂 Dossier: %s", c.localDir))
+	}
+	cleanTransferTemp(c.localDir)
+	c.state = openSyncStateOrWarn(c.localDir)
+	configureTrashRetention()
+	c.trash = NewTrash(c.localDir)
+	registerRunningClient(c)
+	GetConflictManager().SetAncestorLookup(ancestorForPath)
+	GetConflictManager().SetOnResolvedCallback(conflictResolvedForPath)
+
+	time.Sleep(300 * time.Millisecond)
+
 	// Démarrer le worker pour traiter les opérations
-	go (*client).processOperationQueue()
+	go c.processOperationQueue()
 
 	addLog("🔍 Scan initial du dossier local...")
 	time.Sleep(200 * time.Millisecond)
-	(*client).scanInitial()
+	c.scanInitial()
 	
 	// Détecter les différences avec le serveur pour les ajouter aux pending actions
-	(*client).ScanAndDetectDifferences()
+	c.ScanAndDetectDifferences()
 	
 	addLog("✅ Client prêt - Mode Manuel")
 	addLog("👀 En attente de commandes...")
 
 	time.Sleep(300 * time.Millisecond)
-	go (*client).watchRecursive()
+	go c.watchRecursive()
 
+	c.refreshResumableQueue()
+	go c.resumeUploads()
+}
+
+// run lit les messages du serveur et rétablit la connexion en cas de coupure
+// Retourne une erreur si la connexion est perdue et n'a pas pu être rétablie
+func (c *Client) run() error {
 	for {
-		var rawMsg json.RawMessage
-		if err := ws.ReadJSON(&rawMsg); err != nil {
-			if !(*client).shouldExit {
-				addLog("💔 Connexion perdue")
-				*connectionSuccess = false
-				loadingLabel.SetText("✗ Connexion perdue")
-				loadingLabel.Refresh()
-				statusLabel.SetText("Statut: Déconnecté")
-				statusLabel.Refresh()
+		err := c.readLoop()
+		if err == nil {
+			return nil
+		}
+
+		// Les réceptions en cours restent sur disque pour être reprises
+		c.receiver.Close()
+		c.refreshResumableQueue()
+
+		if !c.reconnect() {
+			c.cleanup()
+			return err
+		}
+	}
+}
+
+// reconnect rétablit la connexion selon la stratégie de reconnexion configurée
+// Les transferts interrompus sont annoncés au serveur et reprennent au dernier
+// morceau vérifié
+func (c *Client) reconnect() bool {
+	strategy := NewReconnectStrategy(GetNetworkConfig())
+	if !strategy.ShouldRetry() {
+		GetConnectionManager().SetState(StateDisconnected)
+		return false
+	}
+	GetConnectionManager().SetState(StateReconnecting)
+
+	for strategy.ShouldRetry() {
+		delay := strategy.GetDelay()
+		addLog(fmt.Sprintf("🔄 Reconnexion dans %v (tentative %d)...", delay, strategy.GetAttempts()+1))
+
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return false
+		}
+
+		ws, authResp, err := dialServer(c.serverAddr, c.hostID, c.share, c.localDir, c.creds)
+		if err != nil {
+			strategy.RecordAttempt(false)
+			if errors.Is(err, errAuthFailed) {
+				break
 			}
-			
-			(*client).cleanup()
-			ws.Close()
-			break
+			if changed, ok := err.(*CertChangedError); ok {
+				showCertChangedWarning(changed, nil)
+				break
+			}
+			continue
 		}
+		strategy.RecordAttempt(true)
 
-		time.Sleep(30 * time.Millisecond)
+		c.wsMu.Lock()
+		c.ws = ws
+		c.wsMu.Unlock()
+		c.applyAuthResponse(ws, authResp)
+
+		addLog("✅ Connexion rétablie")
+		go c.resumeUploads()
+		return true
+	}
+
+	GetConnectionManager().SetState(StateFailed)
+	addLog("❌ Reconnexion abandonnée")
+	return false
+}
+
+// resumeUploads relance les envois interrompus par une coupure ou un arrêt
+// Chaque envoi repart de la position vérifiée annoncée par le serveur
+func (c *Client) resumeUploads() {
+	if !c.HasCapability(CapResume) || !GetSyncConfig().ShouldReceiveFromUser() {
+		return
+	}
+
+	states := listTransferStates(c.localDir, TransferOut)
+	if len(states) == 0 {
+		return
+	}
+
+	addLog(fmt.Sprintf("⏩ Reprise de %d envoi(s) interrompu(s)", len(states)))
+	for _, state := range states {
+		if c.shouldExit {
+			return
+		}
+		if err := c.sendFileContent(state.FileName, state.Op); err != nil {
+			addLog(fmt.Sprintf("⚠️ Reprise de %s impossible: %v", state.FileName, err))
+			continue
+		}
+
+		fullPath := filepath.Join(c.localDir, filepath.FromSlash(state.FileName))
+		if info, err := os.Stat(fullPath); err == nil {
+			c.mu.Lock()
+			c.knownFiles[state.FileName] = info.ModTime()
+			c.lastState[state.FileName] = info.ModTime()
+			c.mu.Unlock()
+		}
+	}
+
+	c.refreshResumableQueue()
+}
+
+// refreshResumableQueue affiche les transferts interrompus dans la file de transfert
+func (c *Client) refreshResumableQueue() {
+	var items []*TransferItem
+	for _, direction := range []string{TransferIn, TransferOut} {
+		for _, state := range listTransferStates(c.localDir, direction) {
+			if c.e2e != nil && direction == TransferIn {
+				state.FileName = c.e2e.displayPath(state.FileName)
+			}
+			items = append(items, &TransferItem{
+				Path:      state.FileName,
+				Size:      state.Size,
+				Operation: state.Op,
+				Direction: direction,
+				Offset:    state.Offset,
+				Resumable: true,
+				AddedAt:   state.UpdatedAt,
+			})
+		}
+	}
+	GetTransferQueue().SetResumable(items)
+}
 
-		var treeItem FileTreeItemMessage
-		if err := json.Unmarshal(rawMsg, &treeItem); err == nil {
-			if treeItem.Type == "file_tree_item" || treeItem.Type == "file_tree_complete" {
-				// Toujours essayer d'envoyer si le channel existe
-				if (*client).treeItemsChan != nil {
-					select {
-					case (*client).treeItemsChan <- treeItem:
-						// Message envoyé
-					default:
-						// Channel plein ou fermé, ignorer
-					}
+// readLoop lit les messages du serveur jusqu'à la fermeture de la connexion
+// Retourne une erreur si la connexion a été perdue sans demande de déconnexion
+func (c *Client) readLoop() error {
+	ws := c.ws
+	for {
+		frameType, r, err := ws.NextReader()
+		var rawMsg []byte
+		if err == nil {
+			if frameType == websocket.BinaryMessage {
+				err = c.receiver.WriteChunk(r)
+				c.mu.Lock()
+				c.lastMessageTime = time.Now()
+				c.mu.Unlock()
+				if err != nil {
+					addLog(fmt.Sprintf("⚠️ Morceau rejeté (%v)", err))
 				}
 				continue
 			}
+			rawMsg, err = io.ReadAll(r)
+		}
+		if err != nil {
+			ws.Close()
+			if c.shouldExit {
+				c.cleanup()
+				return nil
+			}
+			addLog("💔 Connexion perdue")
+			return err
 		}
 
-		var msg FileChange
-		if err := json.Unmarshal(rawMsg, &msg); err == nil {
-			if msg.Origin != "client" {
-				if (*client).downloadActive {
-					(*client).downloadChan <- msg
-					continue
-				}
-				
-				if (*client).autoSync {
-					(*client).filesReceivedCount++
-					if time.Since((*client).lastLogTime) > 2*time.Second {
-						if (*client).filesReceivedCount > 0 {
-							addLog(fmt.Sprintf("📥 %d fichiers reçus", (*client).filesReceivedCount))
-							(*client).filesReceivedCount = 0
-							(*client).lastLogTime = time.Now()
-						}
-					}
-					
-					time.Sleep(50 * time.Millisecond)
-					(*client).applyChange(msg)
-				} else {
-					if (*client).isProcessing {
-						(*client).filesReceivedCount++
-						if time.Since((*client).lastLogTime) > 2*time.Second {
-							if (*client).filesReceivedCount > 0 {
-								addLog(fmt.Sprintf("📥 Réception: %d fichiers", (*client).filesReceivedCount))
-								(*client).filesReceivedCount = 0
-								(*client).lastLogTime = time.Now()
-							}
-						}
-						
-						time.Sleep(50 * time.Millisecond)
-						(*client).applyChange(msg)
-					} else {
-						(*client).pendingMu.Lock()
-						(*client).pendingChanges = append((*client).pendingChanges, msg)
-						(*client).pendingMu.Unlock()
-					}
+		c.mu.Lock()
+		c.lastMessageTime = time.Now()
+		c.mu.Unlock()
+
+		time.Sleep(30 * time.Millisecond)
+
+		env, err := decodeFrame(rawMsg)
+		if err != nil {
+			addLog(fmt.Sprintf("⚠️ Message ignoré (%v)", err))
+			continue
+		}
+
+		handler, ok := c.handlers[env.Type]
+		if !ok {
+			addLog(fmt.Sprintf("⚠️ Type de message inconnu \"%s\"", env.Type))
+			continue
+		}
+
+		if err := handler(env); err != nil {
+			addLog(fmt.Sprintf("⚠️ Erreur %s (%v)", env.Type, err))
+		}
+	}
+}
+
+// handleFileTreeMsg transmet les éléments de l'arborescence à l'explorateur
+func (c *Client) handleFileTreeMsg(env *Envelope) error {
+	var treeItem FileTreeItemMessage
+	if err := json.Unmarshal(env.Payload, &treeItem); err != nil {
+		return err
+	}
+	if treeItem.Type == "" {
+		treeItem.Type = env.Type
+	}
+	if c.e2e != nil {
+		var ok bool
+		if treeItem, ok = c.e2e.OpenTreeItem(treeItem); !ok {
+			return nil
+		}
+	}
+
+	// Toujours essayer d'envoyer si le channel existe
+	if c.treeItemsChan != nil {
+		select {
+		case c.treeItemsChan <- treeItem:
+			// Message envoyé
+		default:
+			// Channel plein ou fermé, ignorer
+		}
+	}
+	return nil
+}
+
+// handleFileChangeMsg décode une opération du serveur
+func (c *Client) handleFileChangeMsg(env *Envelope) error {
+	msg, err := unpackFileChange(env)
+	if err != nil {
+		return err
+	}
+	c.receiveFileChange(msg)
+	return nil
+}
+
+// handleTransferBegin prépare la réception d'un fichier par morceaux
+func (c *Client) handleTransferBegin(env *Envelope) error {
+	var begin TransferBegin
+	if err := json.Unmarshal(env.Payload, &begin); err != nil {
+		return err
+	}
+	return c.receiver.Begin(begin)
+}
+
+// handleTransferCommit vérifie un fichier reçu par morceaux et le traite
+// comme une opération classique (son contenu est dans LocalFile)
+func (c *Client) handleTransferCommit(env *Envelope) error {
+	var commit TransferCommit
+	if err := json.Unmarshal(env.Payload, &commit); err != nil {
+		return err
+	}
+
+	msg, err := c.receiver.Commit(commit)
+	c.refreshResumableQueue()
+	if err != nil {
+		return err
+	}
+	c.receiveFileChange(msg)
+	return nil
+}
+
+// handleTransferAbort abandonne un transfert en cours
+func (c *Client) handleTransferAbort(env *Envelope) error {
+	var abort TransferAbort
+	if err := json.Unmarshal(env.Payload, &abort); err != nil {
+		return err
+	}
+	c.receiver.Abort(abort.TransferID)
+	addLog(fmt.Sprintf("⚠️ Transfert annulé par le serveur (%s)", abort.Reason))
+	return nil
+}
+
+// receiveFileChange applique ou met en attente une opération du serveur
+func (c *Client) receiveFileChange(msg FileChange) {
+	if c.e2e != nil && msg.Origin != "client" {
+		opened, err := c.e2e.OpenChange(msg, internalTempDir(c.localDir))
+		if err != nil {
+			addLog(fmt.Sprintf("🔐 Élément de l'hôte ignoré: %v", err))
+			return
+		}
+		msg = opened
+	}
+	if msg.Origin == "client" {
+		msg.Discard()
+		return
+	}
+	fileName, ok := cleanRelPath(msg.FileName)
+	if !ok {
+		addLog(fmt.Sprintf("⚠️ Chemin refusé: %s", msg.FileName))
+		msg.Discard()
+		return
+	}
+	msg.FileName = fileName
+	if msg.Op == "move" {
+		oldName, ok := cleanRelPath(msg.OldName)
+		if !ok {
+			addLog(fmt.Sprintf("⚠️ Déplacement ignoré: %s → %s", msg.OldName, msg.FileName))
+			msg.Discard()
+			return
+		}
+		msg.OldName = oldName
+	}
+
+	if msg.Op == "delta" {
+		resolved, err := resolveDeltaChange(c, c.localDir, msg)
+		if err != nil {
+			addLog(fmt.Sprintf("⚠️ Delta %s non appliqué (%v)", msg.FileName, err))
+			return
+		}
+		msg = resolved
+	}
+
+	if c.downloadActive {
+		c.downloadChan <- msg
+		return
+	}
+
+	// Hors des dossiers abonnés (serveur sans la capacité "subscribe")
+	if msg.Op != "move" && !GetFilterConfig().IncludesPath(msg.FileName, msg.IsDir) {
+		msg.Discard()
+		return
+	}
+
+	// Exclu par les fichiers .spiralyignore locaux
+	if msg.Op != "move" && msg.Op != "remove" && c.ignores.Ignored(msg.FileName, msg.IsDir) {
+		msg.Discard()
+		return
+	}
+
+	if !msg.IsDir && (msg.Op == "create" || msg.Op == "write") && c.flagConflict(msg) {
+		return
+	}
+	
+	if c.autoSync {
+		c.filesReceivedCount++
+		if time.Since(c.lastLogTime) > 2*time.Second {
+			if c.filesReceivedCount > 0 {
+				addLog(fmt.Sprintf("📥 %d fichiers reçus", c.filesReceivedCount))
+				c.filesReceivedCount = 0
+				c.lastLogTime = time.Now()
+			}
+		}
+		
+		time.Sleep(50 * time.Millisecond)
+		c.applyChange(msg)
+	} else {
+		if c.isProcessing {
+			c.filesReceivedCount++
+			if time.Since(c.lastLogTime) > 2*time.Second {
+				if c.filesReceivedCount > 0 {
+					addLog(fmt.Sprintf("📥 Réception: %d fichiers", c.filesReceivedCount))
+					c.filesReceivedCount = 0
+					c.lastLogTime = time.Now()
 				}
 			}
+			
+			time.Sleep(50 * time.Millisecond)
+			c.applyChange(msg)
+		} else {
+			c.pendingMu.Lock()
+			c.pendingChanges = append(c.pendingChanges, msg)
+			c.pendingMu.Unlock()
 		}
 	}
 }
 
+// flagConflict retient un fichier du serveur modifié en parallèle de la copie locale
+// Retourne true si le changement est devenu un conflit à résoudre (non appliqué)
+func (c *Client) flagConflict(msg FileChange) bool {
+	if msg.Vector == nil {
+		return false // Serveur sans vecteurs de version
+	}
+	localVector := c.state.VectorFor(msg.FileName)
+	if localVector == nil || localVector.Compare(msg.Vector) != VectorConcurrent {
+		return false
+	}
+
+	content, err := msg.ReadContent()
+	if err != nil {
+		return false
+	}
+
+	fullPath := filepath.Join(c.localDir, filepath.FromSlash(msg.FileName))
+	remote := &FileVersion{
+		Hash:    HashData(content),
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+		Content: content,
+		Vector:  msg.Vector,
+	}
+	if _, conflict := GetConflictManager().DetectConflict(fullPath, localVector, remote); !conflict {
+		return false // Même contenu des deux côtés
+	}
+
+	msg.Discard()
+	return true
+}
+
+// conflictResolved met à jour l'état de synchronisation après un conflit
+// La version locale ou fusionnée succède aux deux versions: elle est renvoyée au serveur.
+func (c *Client) conflictResolved(conflict *Conflict) {
+	relPath, err := filepath.Rel(c.localDir, conflict.Path)
+	if err != nil || conflict.RemoteVersion == nil {
+		return
+	}
+	relPath = filepath.ToSlash(relPath)
+	remote := conflict.RemoteVersion
+
+	switch conflict.Resolution.KeptVersion {
+	case "remote", "both":
+		// Le fichier contient maintenant la version distante
+		c.state.RecordRemote(relPath, remote.Vector)
+		if info, err := os.Stat(conflict.Path); err == nil {
+			c.mu.Lock()
+			c.knownFiles[relPath] = info.ModTime()
+			c.lastState[relPath] = info.ModTime()
+			c.mu.Unlock()
+		}
+		return
+	}
+
+	c.state.AcknowledgeRemote(relPath, remote.Hash, remote.Size, remote.Vector)
+	if c.autoSync {
+		go c.sendFileNow(relPath)
+	} else if info, err := os.Stat(conflict.Path); err == nil {
+		GetPendingActions().Add(&PendingAction{
+			Type:    ActionModify,
+			Path:    relPath,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+}
+
+// handleDeltaOffer répond à une offre de delta avec la signature de la copie locale
+func (c *Client) handleDeltaOffer(env *Envelope) error {
+	var offer DeltaOffer
+	if err := json.Unmarshal(env.Payload, &offer); err != nil {
+		return err
+	}
+	if isInternalPath(offer.FileName) {
+		return nil
+	}
+
+	return c.Send(MsgDeltaSignature, buildDeltaSignature(c.localDir, offer))
+}
+
+// handleDeltaSignature envoie au serveur le delta d'un fichier proposé
+// Le calcul peut être long: il est fait hors de la boucle de lecture
+func (c *Client) handleDeltaSignature(env *Envelope) error {
+	var msg DeltaSignature
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		return err
+	}
+
+	go func() {
+		journaled := false
+		if !msg.Same && c.HasCapability(CapResume) {
+			journaled = saveUploadJournal(c.localDir, msg.FileName, "write", 0) == nil
+		}
+
+		if err := sendDelta(c, c.localDir, msg, "client"); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi %s: %v", msg.FileName, err))
+			return
+		}
+		c.state.Record(msg.FileName)
+		if journaled {
+			removeUploadJournal(c.localDir, msg.FileName)
+		}
+	}()
+	return nil
+}
+
+// handleSyncManifest rassemble les lots du manifeste puis le compare au dossier local
+// La comparaison peut demander des calculs de hash: elle est faite hors de la boucle de lecture
+func (c *Client) handleSyncManifest(env *Envelope) error {
+	var manifest SyncManifest
+	if err := json.Unmarshal(env.Payload, &manifest); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.manifestEntries = append(c.manifestEntries, manifest.Entries...)
+	entries := c.manifestEntries
+	if manifest.Complete {
+		c.manifestEntries = nil
+	}
+	c.mu.Unlock()
+
+	if manifest.Complete {
+		go c.applyManifest(entries)
+	}
+	return nil
+}
+
+// handleVersionList transmet l'historique d'un fichier à l'appel ListVersions en attente
+func (c *Client) handleVersionList(env *Envelope) error {
+	var list VersionList
+	if err := json.Unmarshal(env.Payload, &list); err != nil {
+		return err
+	}
+	if c.e2e != nil {
+		list = c.e2e.OpenVersionList(list)
+	}
+
+	if c.versionsChan != nil {
+		select {
+		case c.versionsChan <- list:
+		default:
+		}
+	}
+	return nil
+}
+
+// handleTrashList transmet le contenu de la corbeille de l'hôte à l'appel en attente
+func (c *Client) handleTrashList(env *Envelope) error {
+	var list TrashList
+	if err := json.Unmarshal(env.Payload, &list); err != nil {
+		return err
+	}
+	if c.e2e != nil {
+		list = c.e2e.OpenTrashList(list)
+	}
+
+	if c.trashChan != nil {
+		select {
+		case c.trashChan <- list:
+		default:
+		}
+	}
+	return nil
+}
+
+// handleFilterReport journalise les éléments que le serveur n'a pas envoyés
+// parce qu'ils sont exclus par les filtres
+func (c *Client) handleFilterReport(env *Envelope) error {
+	var report FilterReport
+	if err := json.Unmarshal(env.Payload, &report); err != nil {
+		return err
+	}
+
+	if report.Path != "" {
+		if c.e2e != nil {
+			report.Path = c.e2e.displayPath(report.Path)
+		}
+		addLog(fmt.Sprintf("🔍 Ignoré par le serveur (filtre): %s", report.Path))
+	} else {
+		addLog(fmt.Sprintf("🔍 %d éléments ignorés par le serveur (filtres)", report.Ignored))
+	}
+	return nil
+}
+
+// handleAccessDenied journalise une opération refusée par les droits du compte
+func (c *Client) handleAccessDenied(env *Envelope) error {
+	var denied AccessDenied
+	if err := json.Unmarshal(env.Payload, &denied); err != nil {
+		return err
+	}
+	if c.e2e != nil {
+		denied.Path = c.e2e.displayPath(denied.Path)
+	}
+	addLog(fmt.Sprintf("🚫 Accès refusé (%s %s): %s", denied.Action, denied.Path, denied.Reason))
+	return nil
+}
+
+// handleErrorMsg journalise une erreur signalée par le serveur
+func (c *Client) handleErrorMsg(env *Envelope) error {
+	var errMsg ErrorMessage
+	if err := json.Unmarshal(env.Payload, &errMsg); err != nil {
+		return err
+	}
+	if c.e2e != nil && errMsg.Code == ErrCodeMoveSourceMissing {
+		errMsg.Message = c.e2e.displayPath(errMsg.Message)
+	}
+	addLog(fmt.Sprintf("⚠️ Erreur serveur %s: %s", errMsg.Code, errMsg.Message))
+
+	if errMsg.Code == ErrCodeE2EKeyring {
+		// Un autre client a créé le trousseau du partage avec une autre phrase
+		// secrète: les fichiers chiffrés ici seraient illisibles pour lui
+		addLog("🚨 Trousseau refusé par l'hôte: déconnexion")
+		go c.Disconnect()
+		return nil
+	}
+	if errMsg.Code == ErrCodeMoveSourceMissing && isSafeRelPath(errMsg.Message) {
+		go c.resendMoved(errMsg.Message)
+	}
+	return nil
+}
+
+// Disconnect ferme proprement la connexion au serveur
+func (c *Client) Disconnect() {
+	c.cleanup()
+	if c.ws != nil {
+		c.ws.Close()
+	}
+}
+
+// WaitIdle attend qu'aucun message n'ait été reçu pendant la durée quiet
+// et qu'aucune opération ne soit en cours. Retourne false si timeout est atteint.
+func (c *Client) WaitIdle(quiet, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		idle := time.Since(c.lastMessageTime) >= quiet && !c.manifestPending
+		exited := c.shouldExit
+		c.mu.Unlock()
+
+		if exited {
+			return false
+		}
+		if idle && !c.isProcessing {
+			return true
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return false
+}
+
+func StartClientGUI(serverAddr, hostID, share, syncDir string, creds *Credentials, stopAnimation, connectionSuccess *bool, loadingLabel, statusLabel, infoLabel *widget.Label, client **Client) {
+	addLog("🔌 Connexion au serveur " + serverAddr)
+	
+	time.Sleep(300 * time.Millisecond)
+	
+	ws, authResp, err := dialServer(serverAddr, hostID, share, syncDir, creds)
+	if err != nil {
+		*stopAnimation = true
+		switch {
+		case errors.Is(err, errAuthFailed):
+			loadingLabel.SetText("✗ Authentification refusée")
+			loadingLabel.Refresh()
+			statusLabel.SetText("Statut: Authentification refusée")
+			statusLabel.Refresh()
+			infoLabel.SetText(fmt.Sprintf(
+				"AUTHENTIFICATION REFUSÉE\n\n"+
+					"Serveur: %s\n"+
+					"ID: %s\n"+
+					"Dossier: %s\n\n"+
+					"%s\n"+
+					"Vérifiez l'ID, l'utilisateur et le mot de passe.",
+				serverAddr, hostID, syncDir, err,
+			))
+			infoLabel.Refresh()
+		case errors.Is(err, errCertChanged):
+			loadingLabel.SetText("✗ Certificat modifié")
+			loadingLabel.Refresh()
+			statusLabel.SetText("Statut: Certificat modifié")
+			statusLabel.Refresh()
+			infoLabel.SetText(fmt.Sprintf(
+				"CERTIFICAT MODIFIÉ\n\n"+
+					"Serveur: %s\n"+
+					"ID: %s\n"+
+					"Dossier: %s\n\n"+
+					"Le certificat de l'hôte a changé\n"+
+					"depuis la première connexion.",
+				serverAddr, hostID, syncDir,
+			))
+			infoLabel.Refresh()
+			if changed, ok := err.(*CertChangedError); ok {
+				showCertChangedWarning(changed, func() {
+					showUserConnecting(myWindow, serverAddr, hostID, share, syncDir, creds)
+				})
+			}
+		case errors.Is(err, errAuthNoResponse):
+			loadingLabel.SetText("✗ Pas de réponse")
+			loadingLabel.Refresh()
+			statusLabel.SetText("Statut: Pas de réponse")
+			statusLabel.Refresh()
+		case errors.Is(err, errAuthSend):
+			loadingLabel.SetText("✗ Erreur d'authentification")
+			loadingLabel.Refresh()
+			statusLabel.SetText("Statut: Erreur d'authentification")
+			statusLabel.Refresh()
+		default:
+			loadingLabel.SetText("✗ Connexion échouée")
+			loadingLabel.Refresh()
+			statusLabel.SetText("Statut: Échec de connexion")
+			statusLabel.Refresh()
+			infoLabel.SetText(fmt.Sprintf(
+				"ÉCHEC DE CONNEXION\n\n"+
+					"Serveur: %s\n"+
+					"ID: %s\n"+
+					"Dossier: %s\n\n"+
+					"Impossible de se connecter au serveur.\n"+
+					"Vérifiez l'adresse IP et le port.",
+				serverAddr, hostID, syncDir,
+			))
+			infoLabel.Refresh()
+		}
+		return
+	}
+
+	*stopAnimation = true
+	*connectionSuccess = true
+	
+	time.Sleep(200 * time.Millisecond)
+	
+	loadingLabel.SetText("✓ Connecté")
+	loadingLabel.Refresh()
+	statusLabel.SetText("Statut: Connecté (Mode Manuel)")
+	statusLabel.Refresh()
+	
+	infoLabel.SetText(fmt.Sprintf(
+		"CONNECTÉ\n\n"+
+			"Serveur: %s\n"+
+			"ID: %s\n"+
+			"Dossier: %s\n\n"+
+			"Mode: Manuel\n"+
+			"Activez la sync pour synchroniser automatiquement",
+		serverAddr, hostID, syncDir,
+	))
+	infoLabel.Refresh()
+
+	*client = NewClient(ws, serverAddr, hostID, share, syncDir, creds, authResp)
+	(*client).start()
+
+	if err := (*client).run(); err != nil {
+		*connectionSuccess = false
+		loadingLabel.SetText("✗ Connexion perdue")
+		loadingLabel.Refresh()
+		statusLabel.SetText("Statut: Déconnecté")
+		statusLabel.Refresh()
+	}
+}
+
 func (c *Client) cleanup() {
 	c.mu.Lock()
 	if c.shouldExit {
@@ -301,11 +1087,16 @@ func (c *Client) cleanup() {
 	}
 	c.shouldExit = true
 	c.mu.Unlock()
+	unregisterRunningClient(c)
 
 	if c.cancel != nil {
 		c.cancel()
 	}
 
+	if c.receiver != nil {
+		c.receiver.Close()
+	}
+
 	if c.watcherActive {
 		c.watcherActive = false
 		select {
@@ -347,6 +1138,11 @@ func (c *Client) cleanup() {
 		}
 		c.opQueue = nil
 	}
+
+	if c.e2e != nil {
+		c.e2e.Flush()
+	}
+	c.state.Close()
 }
 
 // processOperationQueue traite les opérations en arrière-plan
@@ -376,6 +1172,123 @@ func (c *Client) WriteJSONSafe(v interface{}) error {
 	return err
 }
 
+// Send envoie un message au serveur selon le protocole négocié
+// Sur un partage chiffré de bout en bout, les chemins sont chiffrés à l'envoi.
+func (c *Client) Send(msgType string, payload interface{}) error {
+	change, isChange := payload.(FileChange)
+	if c.e2e != nil {
+		payload = c.e2e.SealPayload(payload)
+	}
+	if err := c.sendFrame(msgType, payload); err != nil {
+		return err
+	}
+
+	// Les contenus de fichiers sont enregistrés par sendFileContent
+	if isChange {
+		switch change.Op {
+		case "mkdir":
+			c.state.Record(change.FileName)
+		case "remove":
+			c.state.Forget(change.FileName)
+		case "move":
+			c.state.Move(change.OldName, change.FileName)
+		}
+	}
+	return nil
+}
+
+// sendFrame encode et envoie un message tel quel
+func (c *Client) sendFrame(msgType string, payload interface{}) error {
+	if change, ok := payload.(FileChange); ok {
+		payload = packFileChange(change, hasCapability(c.capabilities, CapCompression))
+	}
+
+	frame, err := encodeFrame(c.protocolVersion, msgType, payload)
+	if err != nil {
+		return err
+	}
+	return c.WriteJSONSafe(frame)
+}
+
+// sendBinary envoie une trame binaire (morceau de fichier) de manière thread-safe
+func (c *Client) sendBinary(header, data []byte) error {
+	c.wsMu.Lock()
+	defer c.wsMu.Unlock()
+
+	if c.ws == nil {
+		return fmt.Errorf("connexion WebSocket fermée")
+	}
+
+	c.ws.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	err := writeBinaryFrame(c.ws, header, data)
+	c.ws.SetWriteDeadline(time.Time{})
+
+	return err
+}
+
+// HasCapability vérifie si une capacité a été négociée avec le serveur
+func (c *Client) HasCapability(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return hasCapability(c.capabilities, name)
+}
+
+// pendingDeltas retourne les offres de delta envoyées au serveur
+func (c *Client) pendingDeltas() *deltaOffers {
+	return &c.offers
+}
+
+// resumeOffset retourne (et consomme) la position d'un envoi interrompu côté serveur
+func (c *Client) resumeOffset(transferID string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset := c.uploadOffsets[transferID]
+	delete(c.uploadOffsets, transferID)
+	return offset
+}
+
+// fileVector retourne le vecteur de version d'un fichier local
+func (c *Client) fileVector(relPath string) VersionVector {
+	return c.state.VectorFor(relPath)
+}
+
+// sendFileContent envoie un fichier local au serveur, par morceaux si possible
+// Les envois par morceaux sont notés pour être repris après une coupure
+// Sur un partage chiffré de bout en bout, le fichier est chiffré avant l'envoi.
+func (c *Client) sendFileContent(relPath, op string) error {
+	if c.e2e != nil {
+		return c.sendEncryptedContent(relPath, op)
+	}
+
+	fullPath := filepath.Join(c.localDir, filepath.FromSlash(relPath))
+	chunked := hasCapability(c.capabilities, CapChunked)
+
+	journaled := false
+	if chunked && c.HasCapability(CapResume) {
+		if info, err := os.Stat(fullPath); err == nil && info.Size() > streamThreshold {
+			journaled = saveUploadJournal(c.localDir, relPath, op, info.Size()) == nil
+		}
+	}
+
+	// Un delta proposé n'est envoyé qu'après la réponse du serveur (handleDeltaSignature)
+	offered := false
+	if op == "write" && c.HasCapability(CapDelta) {
+		if info, err := os.Stat(fullPath); err == nil && info.Size() > deltaThreshold {
+			offered = true
+		}
+	}
+
+	err := sendFileContent(c, fullPath, relPath, op, "client", chunked)
+	if err == nil && !offered {
+		c.state.Record(relPath)
+	}
+	if journaled && err == nil {
+		removeUploadJournal(c.localDir, relPath)
+	}
+	return err
+}
+
 func (c *Client) ToggleAutoSync() {
 	c.mu.Lock()
 	c.autoSync = !c.autoSync
@@ -422,11 +1335,92 @@ func (c *Client) ToggleAutoSync() {
 	}
 }
 
+// scanInitial initialise l'état local de référence
+// Avec un état persistant, la référence est la dernière synchronisation: les
+// changements faits hors connexion (suppressions comprises) restent détectables.
 func (c *Client) scanInitial() {
 	time.Sleep(200 * time.Millisecond)
+
+	if c.state.Len() > 0 {
+		files, dirs := c.state.Known()
+		lastFiles, lastDirs := c.state.Known()
+
+		c.mu.Lock()
+		c.knownFiles, c.knownDirs = files, dirs
+		c.lastState, c.lastDirs = lastFiles, lastDirs
+		c.mu.Unlock()
+
+		addLog(fmt.Sprintf("📋 État de synchronisation chargé (%d éléments)", c.state.Len()))
+		return
+	}
+
 	c.scanDirRecursive(c.localDir, "")
 }
 
+// ancestorOf retourne le contenu d'un fichier local lors de sa dernière synchronisation
+func (c *Client) ancestorOf(path string) ([]byte, bool) {
+	relPath, err := filepath.Rel(c.localDir, path)
+	if err != nil {
+		return nil, false
+	}
+	return c.state.Ancestor(filepath.ToSlash(relPath))
+}
+
+// Clients démarrés dans ce processus, un par partage synchronisé
+// Les conflits, communs à tous, sont confiés au client dont le dossier les contient.
+var (
+	runningClientsMu sync.Mutex
+	runningClients   []*Client
+)
+
+// registerRunningClient ajoute un client aux partages synchronisés
+func registerRunningClient(c *Client) {
+	runningClientsMu.Lock()
+	defer runningClientsMu.Unlock()
+	runningClients = append(runningClients, c)
+}
+
+// unregisterRunningClient retire un client des partages synchronisés
+func unregisterRunningClient(c *Client) {
+	runningClientsMu.Lock()
+	defer runningClientsMu.Unlock()
+	for i, other := range runningClients {
+		if other == c {
+			runningClients = append(runningClients[:i], runningClients[i+1:]...)
+			return
+		}
+	}
+}
+
+// clientForPath retourne le client dont le dossier local contient path
+func clientForPath(path string) *Client {
+	runningClientsMu.Lock()
+	defer runningClientsMu.Unlock()
+
+	var found *Client
+	for _, c := range runningClients {
+		if isSameOrSubdir(path, c.localDir) && (found == nil || len(c.localDir) > len(found.localDir)) {
+			found = c
+		}
+	}
+	return found
+}
+
+// ancestorForPath retourne la version de base d'un fichier auprès du client de son dossier
+func ancestorForPath(path string) ([]byte, bool) {
+	if c := clientForPath(path); c != nil {
+		return c.ancestorOf(path)
+	}
+	return nil, false
+}
+
+// conflictResolvedForPath transmet la résolution d'un conflit au client de son dossier
+func conflictResolvedForPath(conflict *Conflict) {
+	if c := clientForPath(conflict.Path); c != nil {
+		c.conflictResolved(conflict)
+	}
+}
+
 func (c *Client) scanDirRecursive(basePath, relPath string) {
 	fullPath := filepath.Join(basePath, relPath)
 	entries, err := os.ReadDir(fullPath)
@@ -436,6 +1430,9 @@ func (c *Client) scanDirRecursive(basePath, relPath string) {
 	
 	for i, entry := range entries {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) {
+			continue
+		}
 		info, _ := entry.Info()
 		
 		if entry.IsDir() {
@@ -474,29 +1471,62 @@ func (c *Client) applyChange(msg FileChange) {
 		c.knownDirs[msg.FileName] = time.Now()
 		c.lastDirs[msg.FileName] = time.Now()
 		c.mu.Unlock()
+		c.state.Record(msg.FileName)
 		
+	case "move":
+		c.mu.Lock()
+		c.skipNext[msg.OldName] = time.Now().Add(5 * time.Second)
+		c.mu.Unlock()
+
+		move := FileMove{From: msg.OldName, To: msg.FileName, IsDir: msg.IsDir}
+		err := renameSyncedPath(c.localDir, c.trash, move, msg.Author)
+		if errors.Is(err, os.ErrNotExist) {
+			// Ancien chemin absent localement: le serveur envoie le contenu
+			addLog(fmt.Sprintf("⚠️ %s introuvable, contenu de %s demandé", msg.OldName, msg.FileName))
+			c.Send(MsgError, ErrorMessage{Code: ErrCodeMoveSourceMissing, Message: msg.FileName})
+			break
+		}
+		if err != nil {
+			addLog(fmt.Sprintf("❌ Erreur déplacement %s: %v", msg.OldName, err))
+			break
+		}
+		c.mu.Lock()
+		c.moveKnownPaths(move)
+		c.mu.Unlock()
+		c.state.Move(move.From, move.To)
+		addLog(fmt.Sprintf("📥 Déplacé: %s → %s", move.From, move.To))
+
 	case "remove":
+		c.trash.Remove(msg.FileName, msg.Author)
 		if msg.IsDir {
-			os.RemoveAll(target)
 			c.mu.Lock()
 			delete(c.knownDirs, msg.FileName)
 			delete(c.lastDirs, msg.FileName)
 			c.mu.Unlock()
 		} else {
-			os.Remove(target)
 			c.mu.Lock()
 			delete(c.knownFiles, msg.FileName)
 			delete(c.lastState, msg.FileName)
 			c.mu.Unlock()
 		}
+		c.state.Forget(msg.FileName)
 		
 	case "create", "write":
 		dir := filepath.Dir(target)
 		os.MkdirAll(dir, 0755)
 		
-		data, _ := base64.StdEncoding.DecodeString(msg.Content)
-		time.Sleep(50 * time.Millisecond)
-		os.WriteFile(target, data, 0644)
+		var err error
+		if msg.LocalFile != "" {
+			// Fichier reçu par morceaux: renommage atomique
+			err = moveFile(msg.LocalFile, target)
+		} else {
+			data, _ := base64.StdEncoding.DecodeString(msg.Content)
+			time.Sleep(50 * time.Millisecond)
+			err = writeFileAtomic(c.localDir, target, data)
+		}
+		if err != nil {
+			addLog(fmt.Sprintf("❌ Erreur écriture %s: %v", msg.FileName, err))
+		}
 		info, _ := os.Stat(target)
 		c.mu.Lock()
 		if info != nil {
@@ -504,6 +1534,9 @@ func (c *Client) applyChange(msg FileChange) {
 			c.lastState[msg.FileName] = info.ModTime()
 		}
 		c.mu.Unlock()
+		if err == nil {
+			c.state.RecordRemote(msg.FileName, msg.Vector)
+		}
 	}
 	
 	time.Sleep(100 * time.Millisecond)
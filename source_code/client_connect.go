@@ -14,7 +14,7 @@ import (
 
 // showUserConnecting affiche l'interface de connexion en cours
 // Gère l'animation et la tentative de connexion au serveur
-func showUserConnecting(win fyne.Window, serverAddr, hostID, syncDir string) {
+func showUserConnecting(win fyne.Window, serverAddr, hostID, share, syncDir string, creds *Credentials) {
 	addLog(fmt.Sprintf("Connexion à %s...", serverAddr))
 	addLog(fmt.Sprintf("Dossier de sync: %s", syncDir))
 
@@ -31,9 +31,10 @@ func showUserConnecting(win fyne.Window, serverAddr, hostID, syncDir string) {
 		"CONNEXION EN COURS\n\n"+
 			"Serveur: %s\n"+
 			"ID: %s\n"+
+			"Partage: %s\n"+
 			"Dossier: %s\n\n"+
 			"Statut: Connexion...",
-		serverAddr, hostID, syncDir,
+		serverAddr, hostID, shareDisplayName(share), syncDir,
 	)
 
 	info := widget.NewLabel(infoText)
@@ -206,6 +207,31 @@ func showUserConnecting(win fyne.Window, serverAddr, hostID, syncDir string) {
 	})
 	backupBtn.Importance = widget.LowImportance
 
+	// Bouton corbeille (serveur et ce poste)
+	trashBtn := widget.NewButton("Corbeille", func() {
+		if client == nil {
+			addLog("Non connecte au serveur")
+			return
+		}
+		ShowClientTrashDialog(win, client)
+	})
+	trashBtn.Importance = widget.LowImportance
+
+	// Bouton filtres, appliqués aussi par le serveur avant l'envoi
+	filterBtn := widget.NewButton("Filtres", func() {
+		ShowFilterDialog(GetFilterConfig(), win, func() {
+			if client == nil {
+				return
+			}
+			if err := client.SendFilters(); err != nil {
+				addLog(fmt.Sprintf("Erreur envoi des filtres: %v", err))
+				return
+			}
+			addLog("Filtres mis a jour")
+		})
+	})
+	filterBtn.Importance = widget.LowImportance
+
 	// Mise à jour du compteur de conflits en arrière-plan
 	go func() {
 		for !stopAnimation {
@@ -254,6 +280,8 @@ func showUserConnecting(win fyne.Window, serverAddr, hostID, syncDir string) {
 				conflictBtn,
 				queueBtn,
 				backupBtn,
+				trashBtn,
+				filterBtn,
 			),
 		),
 	)
@@ -328,7 +356,7 @@ func showUserConnecting(win fyne.Window, serverAddr, hostID, syncDir string) {
 		addLog(fmt.Sprintf("Connexion au serveur %s avec l'ID %s", serverAddr, hostID))
 		addLog(fmt.Sprintf("Utilisation du dossier: %s", syncDir))
 
-		go StartClientGUI(serverAddr, hostID, syncDir, &stopAnimation, &connectionSuccess, loadingLabel, statusLabel, info, &client)
+		go StartClientGUI(serverAddr, hostID, share, syncDir, creds, &stopAnimation, &connectionSuccess, loadingLabel, statusLabel, info, &client)
 
 		time.Sleep(2 * time.Second)
 		if connectionSuccess {
@@ -523,6 +551,31 @@ func showUserConnected(win fyne.Window, serverAddr, hostID, syncDir string, clie
 	})
 	backupBtn.Importance = widget.LowImportance
 
+	// Bouton corbeille (serveur et ce poste)
+	trashBtn := widget.NewButton("Corbeille", func() {
+		if client == nil {
+			addLog("Non connecte au serveur")
+			return
+		}
+		ShowClientTrashDialog(win, client)
+	})
+	trashBtn.Importance = widget.LowImportance
+
+	// Bouton filtres, appliqués aussi par le serveur avant l'envoi
+	filterBtn := widget.NewButton("Filtres", func() {
+		ShowFilterDialog(GetFilterConfig(), win, func() {
+			if client == nil {
+				return
+			}
+			if err := client.SendFilters(); err != nil {
+				addLog(fmt.Sprintf("Erreur envoi des filtres: %v", err))
+				return
+			}
+			addLog("Filtres mis a jour")
+		})
+	})
+	filterBtn.Importance = widget.LowImportance
+
 	manualControlsContainer := container.NewVBox(
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Controles Manuels", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
@@ -554,6 +607,8 @@ func showUserConnected(win fyne.Window, serverAddr, hostID, syncDir string, clie
 				conflictBtn,
 				queueBtn,
 				backupBtn,
+				trashBtn,
+				filterBtn,
 			),
 		),
 	)
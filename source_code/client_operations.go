@@ -3,9 +3,11 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -22,37 +24,7 @@ func (c *Client) PullAllFromServer() {
 	c.skipTracking = true // Ignorer le tracking pendant la réception
 	time.Sleep(100 * time.Millisecond)
 
-	c.pendingMu.Lock()
-	pendingCount := len(c.pendingChanges)
-	if pendingCount > 0 {
-		addLog(fmt.Sprintf("📦 Traitement de %d changements en attente...", pendingCount))
-		applied := 0
-		skipped := 0
-
-		for _, change := range c.pendingChanges {
-			if c.shouldApplyChange(change) {
-				c.applyChange(change)
-				applied++
-			} else {
-				skipped++
-			}
-
-			if applied > 0 && applied%20 == 0 {
-				time.Sleep(50 * time.Millisecond)
-			}
-		}
-		c.pendingChanges = []FileChange{}
-
-		if skipped > 0 {
-			addLog(fmt.Sprintf("⭐ %d fichiers ignorés (déjà à jour)", skipped))
-		}
-		if applied > 0 {
-			addLog(fmt.Sprintf("✅ %d fichiers appliqués", applied))
-		}
-	} else {
-		addLog("ℹ️ Aucun changement en attente")
-	}
-	c.pendingMu.Unlock()
+	c.applyPendingChanges()
 
 	time.Sleep(100 * time.Millisecond)
 
@@ -63,7 +35,7 @@ func (c *Client) PullAllFromServer() {
 		"origin": "client",
 	}
 
-	if err := c.WriteJSONSafe(reqMsg); err != nil {
+	if err := c.Send(MsgRequestAllFiles, reqMsg); err != nil {
 		addLog("❌ Erreur envoi")
 		c.isProcessing = false
 		c.skipTracking = false
@@ -78,18 +50,68 @@ func (c *Client) PullAllFromServer() {
 	}()
 }
 
+// applyPendingChanges applique les changements reçus en mode manuel
+// en ignorant les fichiers déjà identiques localement
+func (c *Client) applyPendingChanges() (applied, skipped int) {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	pendingCount := len(c.pendingChanges)
+	if pendingCount == 0 {
+		addLog("ℹ️ Aucun changement en attente")
+		return 0, 0
+	}
+
+	addLog(fmt.Sprintf("📦 Traitement de %d changements en attente...", pendingCount))
+	for _, change := range c.pendingChanges {
+		if c.shouldApplyChange(change) {
+			c.applyChange(change)
+			applied++
+		} else {
+			change.Discard()
+			skipped++
+		}
+
+		if applied > 0 && applied%20 == 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	c.pendingChanges = []FileChange{}
+
+	if skipped > 0 {
+		addLog(fmt.Sprintf("⭐ %d fichiers ignorés (déjà à jour)", skipped))
+	}
+	if applied > 0 {
+		addLog(fmt.Sprintf("✅ %d fichiers appliqués", applied))
+	}
+	return applied, skipped
+}
+
 func (c *Client) shouldApplyChange(change FileChange) bool {
 	if change.IsDir {
 		return true
 	}
 	
-	if change.Op == "remove" {
+	if change.Op == "remove" || change.Op == "move" {
 		return true
 	}
 	
 	normalizedPath := filepath.FromSlash(change.FileName)
 	localPath := filepath.Join(c.localDir, normalizedPath)
 	
+	if change.LocalFile != "" {
+		// Fichier reçu par morceaux: comparer sans charger en mémoire
+		localHash, err := StreamHash(localPath)
+		if err != nil {
+			return true
+		}
+		serverHash, err := StreamHash(change.LocalFile)
+		if err != nil {
+			return true
+		}
+		return localHash != serverHash
+	}
+	
 	localData, err := os.ReadFile(localPath)
 	if err != nil {
 		return true
@@ -134,7 +156,7 @@ func (c *Client) PushLocalChanges() {
 	filteredCount := 0
 
 	for dirPath, modTime := range allDirs {
-		if !filterConfig.Filters.Path.ShouldFilter(dirPath) {
+		if !filterConfig.ShouldFilterPath(dirPath) && !c.ignores.Ignored(dirPath, true) {
 			filteredDirs[dirPath] = modTime
 		} else {
 			filteredCount++
@@ -149,7 +171,7 @@ func (c *Client) PushLocalChanges() {
 			size = info.Size()
 		}
 
-		if !filterConfig.ShouldFilterFile(filePath, size, false) {
+		if !filterConfig.ShouldFilterFile(filePath, size, false) && !c.ignores.Ignored(filePath, false) {
 			filteredFiles[filePath] = modTime
 		} else {
 			filteredCount++
@@ -160,6 +182,21 @@ func (c *Client) PushLocalChanges() {
 		addLog(fmt.Sprintf("🔍 %d fichiers/dossiers ignorés (filtres actifs)", filteredCount))
 	}
 
+	// Envoyer d'abord les renommages comme déplacements: l'état connu est
+	// mis à jour et leur contenu n'apparaît plus comme nouveau
+	moved := 0
+	if c.HasCapability(CapMove) {
+		c.mu.Lock()
+		vanished, appeared := diffPaths(c.knownFiles, c.knownDirs, filteredFiles, filteredDirs, nil)
+		c.mu.Unlock()
+		for _, move := range c.state.DetectMoves(vanished, appeared) {
+			if c.sendMove(move) == nil {
+				moved++
+			}
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+
 	// Demander l'état du serveur pour comparaison
 	serverFiles, serverDirs := c.getServerState()
 
@@ -179,7 +216,7 @@ func (c *Client) PushLocalChanges() {
 	// Détecter les dossiers supprimés localement
 	c.mu.Lock()
 	for knownDir := range c.knownDirs {
-		if _, exists := filteredDirs[knownDir]; !exists {
+		if _, exists := filteredDirs[knownDir]; !exists && !filterConfig.ShouldFilterPath(knownDir) && !c.ignores.Ignored(knownDir, true) {
 			deletedDirs = append(deletedDirs, knownDir)
 		}
 	}
@@ -208,7 +245,7 @@ func (c *Client) PushLocalChanges() {
 	// Détecter les fichiers supprimés localement
 	c.mu.Lock()
 	for knownFile := range c.knownFiles {
-		if _, exists := allFiles[knownFile]; !exists {
+		if _, exists := allFiles[knownFile]; !exists && !c.ignores.Ignored(knownFile, false) {
 			deletedFiles = append(deletedFiles, knownFile)
 		}
 	}
@@ -216,16 +253,22 @@ func (c *Client) PushLocalChanges() {
 
 	// Afficher le résumé
 	totalOps := len(newDirs) + len(newFiles) + len(modifiedFiles) + len(deletedDirs) + len(deletedFiles)
+	if totalOps == 0 && moved > 0 {
+		addLog(fmt.Sprintf("✅ %d déplacements envoyés", moved))
+		c.isProcessing = false
+		GetPendingActions().Clear()
+		return
+	}
 	if totalOps == 0 {
 		addLog("✅ Aucune modification à envoyer")
 		c.isProcessing = false
 		return
 	}
 
-	addLog(fmt.Sprintf("📊 Résumé: %d dossiers, %d nouveaux fichiers, %d modifiés, %d supprimés",
-		len(newDirs), len(newFiles), len(modifiedFiles), len(deletedDirs)+len(deletedFiles)))
+	addLog(fmt.Sprintf("📊 Résumé: %d dossiers, %d nouveaux fichiers, %d modifiés, %d supprimés, %d déplacés",
+		len(newDirs), len(newFiles), len(modifiedFiles), len(deletedDirs)+len(deletedFiles), moved))
 
-	sent := 0
+	sent := moved
 
 	// 1. Envoyer les suppressions de dossiers
 	for _, dirPath := range deletedDirs {
@@ -235,7 +278,7 @@ func (c *Client) PushLocalChanges() {
 			IsDir:    true,
 			Origin:   "client",
 		}
-		if err := c.WriteJSONSafe(change); err == nil {
+		if err := c.Send(MsgFileChange, change); err == nil {
 			c.mu.Lock()
 			delete(c.knownDirs, dirPath)
 			c.mu.Unlock()
@@ -252,7 +295,7 @@ func (c *Client) PushLocalChanges() {
 			IsDir:    false,
 			Origin:   "client",
 		}
-		if err := c.WriteJSONSafe(change); err == nil {
+		if err := c.Send(MsgFileChange, change); err == nil {
 			c.mu.Lock()
 			delete(c.knownFiles, filePath)
 			c.mu.Unlock()
@@ -270,7 +313,7 @@ func (c *Client) PushLocalChanges() {
 			IsDir:    true,
 			Origin:   "client",
 		}
-		if err := c.WriteJSONSafe(change); err == nil {
+		if err := c.Send(MsgFileChange, change); err == nil {
 			c.mu.Lock()
 			c.knownDirs[dirPath] = time.Now()
 			c.mu.Unlock()
@@ -316,22 +359,9 @@ func (c *Client) PushLocalChanges() {
 func (c *Client) sendFile(relPath string) error {
 	fullPath := filepath.Join(c.localDir, filepath.FromSlash(relPath))
 
-	data, err := os.ReadFile(fullPath)
-	if err != nil {
-		return err
-	}
-
 	info, _ := os.Stat(fullPath)
 
-	change := FileChange{
-		FileName: relPath,
-		Op:       "write",
-		Content:  base64.StdEncoding.EncodeToString(data),
-		IsDir:    false,
-		Origin:   "client",
-	}
-
-	if err := c.WriteJSONSafe(change); err != nil {
+	if err := c.sendFileContent(relPath, "write"); err != nil {
 		return err
 	}
 
@@ -433,6 +463,7 @@ func (c *Client) ClearLocalFiles() {
 	c.lastState = make(map[string]time.Time)
 	c.lastDirs = make(map[string]time.Time)
 	c.mu.Unlock()
+	c.state.Reset()
 	
 	// Vider les pending actions car les fichiers n'existent plus
 	GetPendingActions().Clear()
@@ -473,6 +504,9 @@ func (c *Client) watchRecursive() {
 			if !ok {
 				return
 			}
+			if isInternalFile(c.localDir, event.Name) {
+				continue
+			}
 			
 			// Tracker le changement local SEULEMENT si sync auto désactivée
 			// Si sync auto active, pas besoin de tracker car envoyé immédiatement
@@ -520,7 +554,7 @@ func (c *Client) TrackLocalChange(event fsnotify.Event) {
 
 	// Vérifier les filtres
 	filterConfig := GetFilterConfig()
-	if filterConfig.Filters.Path.ShouldFilter(relPath) {
+	if filterConfig.ShouldFilterPath(relPath) || c.ignored(relPath) {
 		return
 	}
 
@@ -551,6 +585,28 @@ func (c *Client) TrackLocalChange(event fsnotify.Event) {
 			return
 		}
 
+		// Renommage d'un élément connu
+		if event.Op&fsnotify.Create != 0 && c.HasCapability(CapMove) {
+			if move, ok := c.state.MovedFrom(relPath); ok {
+				c.mu.Lock()
+				_, fromDir := c.knownDirs[move.From]
+				_, fromFile := c.knownFiles[move.From]
+				c.mu.Unlock()
+				if fromDir || fromFile {
+					pendingActions.Remove(move.From)
+					pendingActions.Add(&PendingAction{
+						Type:    ActionMove,
+						Path:    relPath,
+						OldPath: move.From,
+						Size:    info.Size(),
+						IsDir:   move.IsDir,
+						ModTime: info.ModTime(),
+					})
+					return
+				}
+			}
+		}
+
 		if info.IsDir() {
 			// Nouveau dossier
 			c.mu.Lock()
@@ -591,6 +647,18 @@ func (c *Client) TrackLocalChange(event fsnotify.Event) {
 	}
 }
 
+// ignored vérifie si un chemin local est exclu par les fichiers .spiralyignore
+// Un élément supprimé est reconnu comme dossier d'après l'état connu
+func (c *Client) ignored(relPath string) bool {
+	c.mu.Lock()
+	_, isDir := c.knownDirs[relPath]
+	c.mu.Unlock()
+	if !isDir {
+		return c.ignores.IgnoredPath(relPath)
+	}
+	return c.ignores.Ignored(relPath, true)
+}
+
 func (c *Client) handleLocalEvent(event fsnotify.Event) {
 	relPath, err := filepath.Rel(c.localDir, event.Name)
 	if err != nil {
@@ -600,7 +668,7 @@ func (c *Client) handleLocalEvent(event fsnotify.Event) {
 
 	// Vérifier le filtrage par chemin/dossier
 	filterConfig := GetFilterConfig()
-	if filterConfig.Filters.Path.ShouldFilter(relPath) {
+	if filterConfig.ShouldFilterPath(relPath) || c.ignored(relPath) {
 		return // Fichier/dossier filtré
 	}
 
@@ -613,6 +681,11 @@ func (c *Client) handleLocalEvent(event fsnotify.Event) {
 
 	time.Sleep(50 * time.Millisecond)
 
+	// Élément renommé: envoyé comme déplacement, sans son contenu
+	if event.Op&fsnotify.Create != 0 && c.detectMove(relPath) {
+		return
+	}
+
 	if event.Op&fsnotify.Create != 0 || event.Op&fsnotify.Write != 0 {
 		info, err := os.Stat(event.Name)
 		if err != nil {
@@ -626,7 +699,7 @@ func (c *Client) handleLocalEvent(event fsnotify.Event) {
 				IsDir:    true,
 				Origin:   "client",
 			}
-			c.WriteJSONSafe(change)
+			c.Send(MsgFileChange, change)
 		} else {
 			// Vérifier le filtrage par extension et taille
 			if filterConfig.ShouldFilterFile(relPath, info.Size(), false) {
@@ -635,18 +708,9 @@ func (c *Client) handleLocalEvent(event fsnotify.Event) {
 			}
 
 			time.Sleep(50 * time.Millisecond)
-			data, err := os.ReadFile(event.Name)
-			if err != nil {
+			if err := c.sendFileContent(relPath, "write"); err != nil {
 				return
 			}
-			change := FileChange{
-				FileName: relPath,
-				Op:       "write",
-				Content:  base64.StdEncoding.EncodeToString(data),
-				IsDir:    false,
-				Origin:   "client",
-			}
-			c.WriteJSONSafe(change)
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
@@ -663,6 +727,9 @@ func (c *Client) addDirToWatcher(watcher *fsnotify.Watcher, dir string) {
 	for i, entry := range entries {
 		if entry.IsDir() {
 			subDir := filepath.Join(dir, entry.Name())
+			if isInternalFile(c.localDir, subDir) {
+				continue
+			}
 			c.addDirToWatcher(watcher, subDir)
 			
 			if i > 0 && i%5 == 0 {
@@ -703,12 +770,29 @@ func (c *Client) periodicScanner() {
 			dirsCreated := 0
 			filesModified := 0
 			filesRemoved := 0
+			moved := 0
+
+			// Déplacements: chemins disparus associés aux chemins apparus
+			if c.HasCapability(CapMove) {
+				vanished, appeared := diffPaths(c.lastState, c.lastDirs, currentFiles, currentDirs, func(path string) bool {
+					until, exists := c.skipNext[path]
+					return exists && time.Now().Before(until)
+				})
+				for _, move := range c.state.DetectMoves(vanished, appeared) {
+					c.mu.Unlock()
+					if c.sendMove(move) == nil {
+						moved++
+					}
+					c.mu.Lock()
+					time.Sleep(50 * time.Millisecond)
+				}
+			}
 
 			for oldDir := range c.lastDirs {
 				if until, exists := c.skipNext[oldDir]; exists && time.Now().Before(until) {
 					continue
 				}
-				if _, exists := currentDirs[oldDir]; !exists {
+				if _, exists := currentDirs[oldDir]; !exists && !c.ignores.Ignored(oldDir, true) {
 					change := FileChange{
 						FileName: oldDir,
 						Op:       "remove",
@@ -716,7 +800,7 @@ func (c *Client) periodicScanner() {
 						Origin:   "client",
 					}
 					c.mu.Unlock()
-					c.WriteJSONSafe(change)
+					c.Send(MsgFileChange, change)
 					c.mu.Lock()
 					delete(c.knownDirs, oldDir)
 					dirsRemoved++
@@ -725,7 +809,7 @@ func (c *Client) periodicScanner() {
 			}
 
 			for newDir, modTime := range currentDirs {
-				if _, known := c.lastDirs[newDir]; !known {
+				if _, known := c.lastDirs[newDir]; !known && !c.ignores.Ignored(newDir, true) {
 					if until, exists := c.skipNext[newDir]; exists && time.Now().Before(until) {
 						continue
 					}
@@ -736,7 +820,7 @@ func (c *Client) periodicScanner() {
 						Origin:   "client",
 					}
 					c.mu.Unlock()
-					c.WriteJSONSafe(change)
+					c.Send(MsgFileChange, change)
 					c.mu.Lock()
 					c.knownDirs[newDir] = modTime
 					dirsCreated++
@@ -748,9 +832,16 @@ func (c *Client) periodicScanner() {
 				if until, exists := c.skipNext[name]; exists && time.Now().Before(until) {
 					continue
 				}
+				if c.ignores.Ignored(name, false) {
+					continue
+				}
 
 				lastMod, known := c.lastState[name]
 				if !known || modTime.After(lastMod) {
+					// Date changée mais contenu identique à la dernière synchronisation
+					if known && c.state.Unchanged(name) {
+						continue
+					}
 					c.mu.Unlock()
 					time.Sleep(30 * time.Millisecond)
 					c.sendFileNow(name)
@@ -760,7 +851,7 @@ func (c *Client) periodicScanner() {
 			}
 
 			for oldFile := range c.lastState {
-				if _, still := currentFiles[oldFile]; !still {
+				if _, still := currentFiles[oldFile]; !still && !c.ignores.Ignored(oldFile, false) {
 					if until, exists := c.skipNext[oldFile]; exists && time.Now().Before(until) {
 						delete(c.skipNext, oldFile)
 						continue
@@ -773,7 +864,7 @@ func (c *Client) periodicScanner() {
 						Origin:   "client",
 					}
 					c.mu.Unlock()
-					c.WriteJSONSafe(change)
+					c.Send(MsgFileChange, change)
 					c.mu.Lock()
 					delete(c.knownFiles, oldFile)
 					filesRemoved++
@@ -785,8 +876,11 @@ func (c *Client) periodicScanner() {
 			c.lastDirs = currentDirs
 			c.mu.Unlock()
 
-			if dirsRemoved > 0 || dirsCreated > 0 || filesModified > 0 || filesRemoved > 0 {
+			if dirsRemoved > 0 || dirsCreated > 0 || filesModified > 0 || filesRemoved > 0 || moved > 0 {
 				var changes []string
+				if moved > 0 {
+					changes = append(changes, fmt.Sprintf("%d déplacés", moved))
+				}
 				if dirsCreated > 0 {
 					changes = append(changes, fmt.Sprintf("%d dossiers créés", dirsCreated))
 				}
@@ -814,6 +908,9 @@ func (c *Client) scanCurrentState(basePath, relPath string, files map[string]tim
 
 	for i, entry := range entries {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) {
+			continue
+		}
 		info, _ := entry.Info()
 		
 		if entry.IsDir() {
@@ -838,24 +935,284 @@ func (c *Client) sendFileNow(relPath string) {
 
 	time.Sleep(30 * time.Millisecond)
 
-	data, err := os.ReadFile(fullPath)
-	if err != nil {
+	if _, err := os.Stat(fullPath); err != nil {
 		return
 	}
 
+	if err := c.sendFileContent(relPath, "write"); err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.knownFiles[relPath] = time.Now()
+	c.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+}
+
+// detectMove envoie comme déplacement un élément apparu qui correspond à un
+// chemin connu disparu du disque. Retourne false si ce n'est pas un déplacement.
+func (c *Client) detectMove(relPath string) bool {
+	if !c.HasCapability(CapMove) {
+		return false
+	}
+
+	c.mu.Lock()
+	_, knownDir := c.lastDirs[relPath]
+	_, knownFile := c.lastState[relPath]
+	c.mu.Unlock()
+	if knownDir || knownFile {
+		return false
+	}
+
+	move, ok := c.state.MovedFrom(relPath)
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	_, fromDir := c.lastDirs[move.From]
+	_, fromFile := c.lastState[move.From]
+	c.mu.Unlock()
+	if !fromDir && !fromFile {
+		return false
+	}
+
+	return c.sendMove(move) == nil
+}
+
+// sendMove envoie un déplacement local au serveur et le reporte dans l'état connu
+func (c *Client) sendMove(move FileMove) error {
 	change := FileChange{
-		FileName: relPath,
-		Op:       "write",
-		Content:  base64.StdEncoding.EncodeToString(data),
-		IsDir:    false,
+		FileName: move.To,
+		OldName:  move.From,
+		Op:       "move",
+		IsDir:    move.IsDir,
 		Origin:   "client",
 	}
+	if err := c.Send(MsgFileChange, change); err != nil {
+		return err
+	}
 
-	c.WriteJSONSafe(change)
 	c.mu.Lock()
-	c.knownFiles[relPath] = time.Now()
+	c.moveKnownPaths(move)
 	c.mu.Unlock()
-	time.Sleep(50 * time.Millisecond)
+	addLog(fmt.Sprintf("📤 Déplacé: %s → %s", move.From, move.To))
+	return nil
+}
+
+// moveKnownPaths reporte un déplacement dans les états connus (c.mu verrouillé)
+func (c *Client) moveKnownPaths(move FileMove) {
+	movePaths(c.knownFiles, move.From, move.To)
+	movePaths(c.knownDirs, move.From, move.To)
+	movePaths(c.lastState, move.From, move.To)
+	movePaths(c.lastDirs, move.From, move.To)
+}
+
+// resendMoved renvoie le contenu d'un élément déplacé dont l'ancien chemin
+// n'existait pas sur le serveur
+func (c *Client) resendMoved(relPath string) {
+	root := filepath.Join(c.localDir, filepath.FromSlash(relPath))
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(c.localDir, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			return c.Send(MsgFileChange, FileChange{
+				FileName: rel,
+				Op:       "mkdir",
+				IsDir:    true,
+				Origin:   "client",
+			})
+		}
+		if err := c.sendFileContent(rel, "create"); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi %s: %v", rel, err))
+		}
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+}
+
+// applyManifest compare le manifeste du serveur au dossier local et demande
+// uniquement les éléments absents ou dont le contenu diffère.
+// Les éléments présents des deux côtés deviennent l'état connu du serveur pour
+// ScanAndDetectDifferences; les éléments absents le deviendront à leur réception.
+// L'état persistant distingue les changements faits de chaque côté pendant la
+// déconnexion: un élément supprimé ou modifié localement n'est pas redemandé,
+// un élément supprimé sur le serveur est supprimé localement.
+func (c *Client) applyManifest(entries []ManifestEntry) {
+	defer func() {
+		c.mu.Lock()
+		c.manifestPending = false
+		c.mu.Unlock()
+	}()
+
+	if c.e2e != nil {
+		entries = c.e2e.OpenManifest(entries)
+	}
+
+	localFiles := make(map[string]time.Time)
+	localDirs := make(map[string]time.Time)
+	c.scanCurrentState(c.localDir, "", localFiles, localDirs)
+
+	knownFiles := make(map[string]time.Time)
+	knownDirs := make(map[string]time.Time)
+	onServer := make(map[string]bool, len(entries))
+	var missing []string
+	var syncedDirs []string
+	syncedFiles := make(map[string]VersionVector)
+	identical := 0
+	filterConfig := GetFilterConfig()
+
+	for _, entry := range entries {
+		if !isSafeRelPath(entry.Path) {
+			continue
+		}
+		onServer[entry.Path] = true
+		if filterConfig.ShouldFilterFile(entry.Path, entry.Size, entry.IsDir) || c.ignores.Ignored(entry.Path, entry.IsDir) {
+			continue // Exclu par les filtres: ni demandé, ni supprimé localement
+		}
+		rec, recorded := c.state.Get(entry.Path)
+
+		if entry.IsDir {
+			if _, exists := localDirs[entry.Path]; exists {
+				knownDirs[entry.Path] = entry.ModTime
+				syncedDirs = append(syncedDirs, entry.Path)
+			} else if recorded && rec.IsDir {
+				// Supprimé localement pendant la déconnexion
+				knownDirs[entry.Path] = entry.ModTime
+			} else {
+				missing = append(missing, entry.Path)
+			}
+			continue
+		}
+
+		// Contenu inchangé sur le serveur depuis la dernière synchronisation
+		serverUnchanged := recorded && !rec.IsDir && rec.Hash == entry.Hash
+
+		localMod, exists := localFiles[entry.Path]
+		if !exists {
+			if serverUnchanged {
+				// Supprimé localement pendant la déconnexion
+				knownFiles[entry.Path] = rec.ModTime
+				continue
+			}
+			missing = append(missing, entry.Path)
+			continue
+		}
+		if c.matchesManifest(entry) {
+			// Identique: la date locale ne doit pas passer pour une modification
+			knownFiles[entry.Path] = localMod
+			syncedFiles[entry.Path] = entry.Vector
+			identical++
+			continue
+		}
+		if serverUnchanged {
+			// Modifié localement pendant la déconnexion: à envoyer, pas à recevoir
+			knownFiles[entry.Path] = rec.ModTime
+			continue
+		}
+		knownFiles[entry.Path] = entry.ModTime
+		missing = append(missing, entry.Path)
+	}
+
+	// Éléments synchronisés auparavant mais absents du serveur: supprimés sur le
+	// serveur pendant la déconnexion, sauf s'ils ont été modifiés localement depuis
+	// Les éléments hors des dossiers abonnés ou exclus par les fichiers
+	// .spiralyignore ne figurent pas dans le manifeste: la copie locale est
+	// conservée telle quelle
+	for path := range localFiles {
+		if !filterConfig.IncludesPath(path, false) || c.ignores.Ignored(path, false) {
+			delete(localFiles, path)
+		}
+	}
+	for path := range localDirs {
+		if !filterConfig.IncludesPath(path, true) || c.ignores.Ignored(path, true) {
+			delete(localDirs, path)
+		}
+	}
+
+	var removed []FileChange
+	kept := make(map[string]bool) // Dossiers contenant un élément à conserver
+	keepParents := func(path string) {
+		for dir := filepath.ToSlash(filepath.Dir(path)); dir != "."; dir = filepath.ToSlash(filepath.Dir(dir)) {
+			kept[dir] = true
+		}
+	}
+	for path := range localFiles {
+		if onServer[path] {
+			continue
+		}
+		if _, recorded := c.state.Get(path); recorded && c.state.Unchanged(path) {
+			removed = append(removed, FileChange{FileName: path, Op: "remove", Origin: "server"})
+			continue
+		}
+		keepParents(path)
+	}
+	for path := range localDirs {
+		if rec, recorded := c.state.Get(path); onServer[path] || !recorded || !rec.IsDir {
+			keepParents(path)
+		}
+	}
+	for path := range localDirs {
+		if onServer[path] || kept[path] {
+			continue
+		}
+		if rec, recorded := c.state.Get(path); recorded && rec.IsDir {
+			removed = append(removed, FileChange{FileName: path, Op: "remove", IsDir: true, Origin: "server"})
+		}
+	}
+
+	c.mu.Lock()
+	c.knownFiles = knownFiles
+	c.knownDirs = knownDirs
+	c.manifestApplied = true
+	c.mu.Unlock()
+	c.state.Record(syncedDirs...)
+	c.state.RecordRemoteAll(syncedFiles)
+
+	addLog(fmt.Sprintf("📋 Manifeste: %d éléments, %d identiques, %d à recevoir", len(entries), identical, len(missing)))
+	if len(removed) > 0 {
+		addLog(fmt.Sprintf("🗑️ %d éléments supprimés sur le serveur pendant la déconnexion", len(removed)))
+	}
+
+	// Les dossiers les plus profonds d'abord, comme pour une suppression reçue en direct
+	sort.Slice(removed, func(i, j int) bool {
+		return strings.Count(removed[i].FileName, "/") > strings.Count(removed[j].FileName, "/")
+	})
+	for _, change := range removed {
+		c.receiveFileChange(change)
+	}
+
+	for start := 0; start < len(missing); start += manifestBatchSize {
+		end := start + manifestBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+		if err := c.Send(MsgDownloadRequest, DownloadRequest{Type: MsgDownloadRequest, Items: missing[start:end]}); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur demande des fichiers: %v", err))
+			return
+		}
+	}
+
+	c.ScanAndDetectDifferences()
+}
+
+// matchesManifest vérifie si la copie locale correspond à une entrée du manifeste
+func (c *Client) matchesManifest(entry ManifestEntry) bool {
+	fullPath := filepath.Join(c.localDir, filepath.FromSlash(entry.Path))
+
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() || info.Size() != entry.Size {
+		return false
+	}
+
+	hash, err := GetHashCache().GetHash(fullPath)
+	return err == nil && hash == entry.Hash
 }
 
 // ScanAndDetectDifferences scanne le dossier local et détecte les fichiers
@@ -878,9 +1235,22 @@ func (c *Client) ScanAndDetectDifferences() {
 			return nil
 		}
 		relPath = filepath.ToSlash(relPath)
+		if isInternalPath(relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		if c.ignores.Ignored(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		
 		if info.IsDir() {
-			if !filterConfig.Filters.Path.ShouldFilter(relPath) {
+			if !filterConfig.ShouldFilterPath(relPath) {
 				localDirs[relPath] = info
 			}
 		} else {
@@ -899,6 +1269,10 @@ func (c *Client) ScanAndDetectDifferences() {
 	// Détecter les nouveaux dossiers
 	for dirPath, info := range localDirs {
 		if _, existsOnServer := serverDirs[dirPath]; !existsOnServer {
+			if rec, recorded := c.state.Get(dirPath); recorded && rec.IsDir {
+				// Synchronisé auparavant: suppression côté serveur en attente
+				continue
+			}
 			pendingActions.Add(&PendingAction{
 				Type:    ActionCreate,
 				Path:    dirPath,
@@ -914,6 +1288,10 @@ func (c *Client) ScanAndDetectDifferences() {
 		serverModTime, existsOnServer := serverFiles[filePath]
 		
 		if !existsOnServer {
+			if c.state.Unchanged(filePath) {
+				// Synchronisé auparavant: suppression côté serveur en attente
+				continue
+			}
 			// Nouveau fichier
 			pendingActions.Add(&PendingAction{
 				Type:    ActionCreate,
@@ -923,7 +1301,7 @@ func (c *Client) ScanAndDetectDifferences() {
 				ModTime: info.ModTime(),
 			})
 			detectedCount++
-		} else if info.ModTime().After(serverModTime) {
+		} else if info.ModTime().After(serverModTime) && !c.state.Unchanged(filePath) {
 			// Fichier modifié localement (plus récent que le serveur)
 			pendingActions.Add(&PendingAction{
 				Type:    ActionModify,
@@ -936,6 +1314,37 @@ func (c *Client) ScanAndDetectDifferences() {
 		}
 	}
 	
+	// Détecter les suppressions locales: éléments synchronisés puis supprimés,
+	// y compris pendant que le client était arrêté
+	for filePath := range serverFiles {
+		if _, err := os.Stat(filepath.Join(c.localDir, filepath.FromSlash(filePath))); err == nil {
+			continue
+		}
+		if _, recorded := c.state.Get(filePath); !recorded {
+			continue
+		}
+		pendingActions.Add(&PendingAction{
+			Type:  ActionDelete,
+			Path:  filePath,
+			IsDir: false,
+		})
+		detectedCount++
+	}
+	for dirPath := range serverDirs {
+		if _, err := os.Stat(filepath.Join(c.localDir, filepath.FromSlash(dirPath))); err == nil {
+			continue
+		}
+		if _, recorded := c.state.Get(dirPath); !recorded {
+			continue
+		}
+		pendingActions.Add(&PendingAction{
+			Type:  ActionDelete,
+			Path:  dirPath,
+			IsDir: true,
+		})
+		detectedCount++
+	}
+	
 	if detectedCount > 0 {
 		addLog(fmt.Sprintf("📋 %d différences locales détectées", detectedCount))
 	}
@@ -975,7 +1384,7 @@ func (c *Client) performBackupViaPull(backupDir string) {
 	c.explorerActive = true
 	c.treeItemsChan = make(chan FileTreeItemMessage, 1000)
 	
-	if err := c.WriteJSONSafe(map[string]string{"type": "request_file_tree", "origin": "client"}); err != nil {
+	if err := c.Send(MsgRequestFileTree, map[string]string{"type": MsgRequestFileTree, "origin": "client"}); err != nil {
 		addLog(fmt.Sprintf("❌ Erreur: %v", err))
 		c.explorerActive = false
 		return
@@ -1020,7 +1429,7 @@ countLoop:
 		"origin": "client",
 	}
 	
-	if err := c.WriteJSONSafe(reqMsg); err != nil {
+	if err := c.Send(MsgRequestAllFiles, reqMsg); err != nil {
 		addLog(fmt.Sprintf("❌ Erreur: %v", err))
 		return
 	}
@@ -1129,4 +1538,116 @@ func countLocalFiles(dir string) int {
 		return nil
 	})
 	return count
-} 
\ No newline at end of file
+} 
+
+// ListVersions demande au serveur les versions précédentes d'un fichier
+func (c *Client) ListVersions(relPath string) ([]FileRevision, error) {
+	if !c.HasCapability(CapVersions) {
+		return nil, fmt.Errorf("historique non disponible sur ce serveur")
+	}
+
+	c.versionsChan = make(chan VersionList, 4)
+	defer func() { c.versionsChan = nil }()
+
+	if err := c.Send(MsgListVersions, VersionListRequest{Path: relPath}); err != nil {
+		return nil, err
+	}
+
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case list := <-c.versionsChan:
+			if list.Path == relPath {
+				return list.Versions, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("pas de réponse du serveur")
+		}
+	}
+}
+
+// RestoreVersion demande au serveur de remplacer un fichier par une de ses versions
+// Le fichier restauré est ensuite reçu comme une modification du serveur.
+func (c *Client) RestoreVersion(relPath, versionID string) error {
+	if !c.HasCapability(CapVersions) {
+		return fmt.Errorf("historique non disponible sur ce serveur")
+	}
+	addLog(fmt.Sprintf("⏪ Restauration demandée: %s", relPath))
+	return c.Send(MsgRestoreVersion, RestoreVersionRequest{Path: relPath, VersionID: versionID})
+}
+
+// ListServerTrash demande au serveur le contenu de sa corbeille
+func (c *Client) ListServerTrash() ([]TrashEntry, error) {
+	return c.requestTrash(MsgListTrash, struct{}{})
+}
+
+// RestoreServerTrash demande au serveur de restaurer un élément de sa corbeille
+// L'élément restauré est ensuite reçu comme un ajout du serveur.
+func (c *Client) RestoreServerTrash(id string) ([]TrashEntry, error) {
+	return c.requestTrash(MsgRestoreTrash, TrashRestoreRequest{ID: id})
+}
+
+// requestTrash envoie une requête sur la corbeille et attend le contenu mis à jour
+func (c *Client) requestTrash(msgType string, payload interface{}) ([]TrashEntry, error) {
+	if !c.HasCapability(CapTrash) {
+		return nil, fmt.Errorf("corbeille non disponible sur ce serveur")
+	}
+
+	c.trashChan = make(chan TrashList, 4)
+	defer func() { c.trashChan = nil }()
+
+	if err := c.Send(msgType, payload); err != nil {
+		return nil, err
+	}
+
+	select {
+	case list := <-c.trashChan:
+		if list.Error != "" {
+			return list.Entries, fmt.Errorf("%s", list.Error)
+		}
+		return list.Entries, nil
+	case <-time.After(15 * time.Second):
+		return nil, fmt.Errorf("pas de réponse du serveur")
+	}
+}
+
+// SetSubscription remplace les dossiers synchronisés (sync sélective)
+// Les dossiers sont enregistrés dans la configuration et envoyés au serveur,
+// qui renvoie son manifeste pour les dossiers ajoutés. Les copies locales des
+// dossiers retirés sont conservées mais ne sont plus mises à jour.
+func (c *Client) SetSubscription(folders []string) error {
+	filterConfig := GetFilterConfig()
+	filterConfig.SetSubscription(folders)
+	if err := SaveFiltersToConfig(filterConfig); err != nil {
+		addLog(fmt.Sprintf("⚠️ Sauvegarde de la sync sélective impossible: %v", err))
+	}
+
+	folders = filterConfig.Subscription()
+	if len(folders) > 0 {
+		addLog(fmt.Sprintf("📁 Sync sélective: %s", strings.Join(folders, ", ")))
+	} else {
+		addLog("📁 Sync de tout le partage")
+	}
+
+	if !c.HasCapability(CapSubscribe) {
+		addLog("ℹ️ Serveur sans sync sélective: les autres dossiers sont ignorés à la réception")
+		return nil
+	}
+	if !c.HasCapability(CapManifest) {
+		addLog("ℹ️ Utilisez RECEVOIR pour obtenir le contenu des dossiers ajoutés")
+	}
+	return c.Send(MsgSetSubscription, SubscriptionUpdate{Folders: folders})
+}
+
+// SendFilters envoie au serveur les filtres actuels (extensions, taille, chemins)
+// Le serveur n'envoie plus les éléments exclus au lieu de laisser le client les ignorer.
+func (c *Client) SendFilters() error {
+	if !c.HasCapability(CapFilters) {
+		return nil
+	}
+	data, err := GetFilterConfig().ToJSON()
+	if err != nil {
+		return err
+	}
+	return c.Send(MsgSetFilters, json.RawMessage(data))
+}
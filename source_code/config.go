@@ -12,6 +12,9 @@ type AppConfig struct {
 	ServerPort    string  `json:"server_port"`
 	HostID        string  `json:"host_id"`
 	SyncDirectory string  `json:"sync_directory"`
+	Share         string  `json:"share,omitempty"` // Partage choisi sur l'hôte (vide = par défaut)
+	Username      string  `json:"username,omitempty"` // Compte utilisateur (le mot de passe n'est jamais enregistré)
+	E2E           bool    `json:"e2e,omitempty"`      // Partage chiffré de bout en bout (la phrase secrète n'est jamais enregistrée)
 	SaveConfig    bool    `json:"save_config"`
 	AutoConnect   bool    `json:"auto_connect"`
 	WindowWidth   float32 `json:"window_width,omitempty"`
@@ -26,17 +29,32 @@ type AppConfig struct {
 	FilterExcludeFolders []string `json:"filter_exclude_folders,omitempty"`
 	FilterMaxSize       int64    `json:"filter_max_size,omitempty"`
 	FilterExcludeHidden bool     `json:"filter_exclude_hidden,omitempty"`
-	// Limites serveur (Host)
-	MaxStorageSize     int64 `json:"max_storage_size,omitempty"`     // Limite totale en bytes (0 = illimité)
-	MaxFileSize        int64 `json:"max_file_size,omitempty"`        // Taille max par fichier
-	MaxFilesCount      int64 `json:"max_files_count,omitempty"`      // Nombre max de fichiers
-	WarnStoragePercent int   `json:"warn_storage_percent,omitempty"` // Alerte à ce % (ex: 80)
+	SubscribedFolders   []string `json:"subscribed_folders,omitempty"` // Sync sélective (vide = tout)
+	// Données, écoute, limites et sécurité (Host)
+	Host HostConfig `json:"host"`
+	// Historique des versions (Host, 0 = valeur par défaut)
+	VersionsMaxCount   int   `json:"versions_max_count,omitempty"`    // Versions conservées par fichier
+	VersionsMaxAgeDays int   `json:"versions_max_age_days,omitempty"` // Âge maximum en jours
+	VersionsMaxSize    int64 `json:"versions_max_size,omitempty"`     // Taille cumulée en bytes
+	// Corbeille (Host et User)
+	TrashRetentionDays int   `json:"trash_retention_days,omitempty"` // Durée de conservation (0 = 30 jours)
+	TrashMaxSize       int64 `json:"trash_max_size,omitempty"`       // Taille max en bytes (0 = illimitée)
+	// Partages publiés (Host, vide = <exe>/Spiralydata) et partages synchronisés (User)
+	Shares []ShareConfig `json:"shares,omitempty"`
+	Mounts []ShareMount  `json:"mounts,omitempty"`
+	// Empreintes SHA-256 des certificats des hôtes, enregistrées à la première connexion (User)
+	PinnedCerts map[string]string `json:"pinned_certs,omitempty"`
 }
 
-var configFilePath string
+// configFilePath fichier de configuration: $SPIRALY_CONFIG, sinon à côté de l'exécutable
+// Initialisé avant les autres variables globales, qui peuvent lire host.data_dir.
+var configFilePath = defaultConfigFilePath()
 
-func init() {
-	configFilePath = filepath.Join(getExecutableDir(), "spiraly_config.json")
+func defaultConfigFilePath() string {
+	if path := os.Getenv("SPIRALY_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(getExecutableDir(), "spiraly_config.json")
 }
 
 func LoadConfig() (*AppConfig, error) {
@@ -82,6 +100,9 @@ func SaveFiltersToConfig(fc *FilterConfig) error {
 	// Sauvegarder la taille max
 	config.FilterMaxSize = fc.Filters.Size.MaxSize
 
+	// Sauvegarder la sync sélective
+	config.SubscribedFolders = fc.Subscription()
+
 	return SaveConfig(config)
 }
 
@@ -111,6 +132,9 @@ func LoadFiltersFromConfig(fc *FilterConfig) {
 		fc.Filters.Size.MaxSize = config.FilterMaxSize
 		fc.Filters.Size.Enabled = true
 	}
+
+	// Charger la sync sélective
+	fc.Filters.Subscription.SetFolders(config.SubscribedFolders)
 }
 
 // SyncConfigFile représente la configuration de synchronisation sauvegardée
@@ -20,6 +20,7 @@ type FileVersion struct {
 	Content    []byte
 	IsLocal    bool
 	IsRemote   bool
+	Vector     VersionVector // Modifications par nœud ayant produit cette version
 }
 
 // Conflict représente un conflit de synchronisation
@@ -28,6 +29,7 @@ type Conflict struct {
 	Path         string
 	LocalVersion *FileVersion
 	RemoteVersion *FileVersion
+	BaseVersion  *FileVersion // Dernière version synchronisée (nil si inconnue)
 	DetectedAt   time.Time
 	Resolved     bool
 	Resolution   ConflictResolution
@@ -40,6 +42,7 @@ type ConflictResolution struct {
 	KeptVersion string // "local", "remote", "both", "merged"
 	NewPath     string // Pour "both" - nouveau chemin du fichier renommé
 	MergedHash  string // Pour "merged" - hash du fichier fusionné
+	Clean       bool   // Pour "merged" - fusion sans zone en conflit
 }
 
 // ConflictManager gère les conflits
@@ -51,6 +54,8 @@ type ConflictManager struct {
 	autoResolve   bool
 	strategy      ConflictStrategy
 	onConflict    func(*Conflict)
+	onResolved    func(*Conflict)
+	ancestorOf    func(path string) ([]byte, bool) // Contenu à la dernière synchronisation
 }
 
 // NewConflictManager crée un nouveau gestionnaire de conflits
@@ -79,8 +84,29 @@ func (cm *ConflictManager) SetOnConflictCallback(callback func(*Conflict)) {
 	cm.onConflict = callback
 }
 
-// DetectConflict détecte si un fichier est en conflit
-func (cm *ConflictManager) DetectConflict(localPath string, remoteHash string, remoteModTime time.Time, remoteSize int64) (*Conflict, bool) {
+// SetOnResolvedCallback définit le callback appelé après la résolution d'un conflit
+func (cm *ConflictManager) SetOnResolvedCallback(callback func(*Conflict)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.onResolved = callback
+}
+
+// SetAncestorLookup définit la source des versions de base pour la fusion à trois voies
+func (cm *ConflictManager) SetAncestorLookup(lookup func(path string) ([]byte, bool)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.ancestorOf = lookup
+}
+
+// DetectConflict détecte si un fichier est en conflit avec une version distante
+// Avec des vecteurs de version des deux côtés, seules les modifications
+// concurrentes sont des conflits: une version qui succède à l'autre la remplace.
+// Sans vecteur (pair plus ancien), des contenus différents suffisent.
+func (cm *ConflictManager) DetectConflict(localPath string, localVector VersionVector, remote *FileVersion) (*Conflict, bool) {
+	if localVector != nil && remote.Vector != nil && localVector.Compare(remote.Vector) != VectorConcurrent {
+		return nil, false
+	}
+
 	// Lire le fichier local
 	localInfo, err := os.Stat(localPath)
 	if err != nil {
@@ -96,22 +122,23 @@ func (cm *ConflictManager) DetectConflict(localPath string, remoteHash string, r
 	localHash := HashData(localContent)
 	
 	// Si les hash sont identiques, pas de conflit
-	if localHash == remoteHash {
+	if localHash == remote.Hash {
 		return nil, false
 	}
 	
-	// Si les dates de modification sont différentes des deux côtés, c'est un conflit
-	// (le fichier a été modifié localement ET à distance)
-	
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
 	
 	// Vérifier si un conflit existe déjà pour ce fichier
 	if existing, ok := cm.conflicts[localPath]; ok && !existing.Resolved {
+		// Garder la dernière version distante reçue
+		existing.RemoteVersion = remote
+		cm.mu.Unlock()
 		return existing, true
 	}
 	
 	// Créer un nouveau conflit
+	remote.Path = localPath
+	remote.IsRemote = true
 	conflict := &Conflict{
 		ID:   generateConflictID(),
 		Path: localPath,
@@ -122,30 +149,39 @@ func (cm *ConflictManager) DetectConflict(localPath string, remoteHash string, r
 			ModTime: localInfo.ModTime(),
 			Content: localContent,
 			IsLocal: true,
+			Vector:  localVector,
 		},
-		RemoteVersion: &FileVersion{
-			Path:     localPath,
-			Hash:     remoteHash,
-			Size:     remoteSize,
-			ModTime:  remoteModTime,
-			IsRemote: true,
-		},
-		DetectedAt: time.Now(),
-		Resolved:   false,
+		RemoteVersion: remote,
+		DetectedAt:    time.Now(),
+		Resolved:      false,
+	}
+
+	if cm.ancestorOf != nil {
+		if base, ok := cm.ancestorOf(localPath); ok {
+			conflict.BaseVersion = &FileVersion{
+				Path:    localPath,
+				Hash:    HashData(base),
+				Size:    int64(len(base)),
+				Content: base,
+			}
+		}
 	}
 	
 	cm.conflicts[localPath] = conflict
+	onConflict := cm.onConflict
+	autoResolve, strategy := cm.autoResolve, cm.strategy
+	cm.mu.Unlock()
 	
 	addLog(fmt.Sprintf("⚠️ Conflit détecté: %s", filepath.Base(localPath)))
 	
 	// Appeler le callback si défini
-	if cm.onConflict != nil {
-		go cm.onConflict(conflict)
+	if onConflict != nil {
+		go onConflict(conflict)
 	}
 	
 	// Auto-résoudre si activé
-	if cm.autoResolve {
-		cm.ResolveConflict(conflict.ID, cm.strategy, nil)
+	if autoResolve {
+		cm.ResolveConflict(conflict.ID, strategy, nil)
 	}
 	
 	return conflict, true
@@ -175,6 +211,11 @@ func (cm *ConflictManager) ResolveConflict(conflictID string, strategy ConflictS
 	}
 	
 	cm.mu.Unlock()
+
+	// Contenu distant reçu lors de la détection
+	if remoteContent == nil && conflict.RemoteVersion != nil {
+		remoteContent = conflict.RemoteVersion.Content
+	}
 	
 	var resolution ConflictResolution
 	resolution.Strategy = strategy
@@ -231,21 +272,30 @@ func (cm *ConflictManager) ResolveConflict(conflictID string, strategy ConflictS
 		addLog(fmt.Sprintf("✅ Conflit résolu (les deux gardés): %s", filepath.Base(conflict.Path)))
 		
 	case ConflictAutoMerge:
-		// Pour les fichiers texte, tenter une fusion simple
+		// Pour les fichiers texte, fusion à trois voies avec la dernière version synchronisée
 		if IsTextFile(conflict.Path) && remoteContent != nil {
-			merged, err := SimpleMerge(conflict.LocalVersion.Content, remoteContent)
+			if conflict.BaseVersion == nil {
+				// Sans ancêtre commun, impossible de savoir quel côté a changé: garder les deux
+				return cm.ResolveConflict(conflictID, ConflictKeepBoth, remoteContent)
+			}
+
+			result, err := Merge3(conflict.BaseVersion.Content, conflict.LocalVersion.Content, remoteContent)
 			if err != nil {
-				// Si la fusion échoue, garder les deux
+				addLog(fmt.Sprintf("⚠️ %s: %v, les deux versions sont gardées", filepath.Base(conflict.Path), err))
 				return cm.ResolveConflict(conflictID, ConflictKeepBoth, remoteContent)
 			}
-			
-			if err := os.WriteFile(conflict.Path, merged, 0644); err != nil {
+			if err := os.WriteFile(conflict.Path, result.Content, 0644); err != nil {
 				return fmt.Errorf("erreur écriture fusionnée: %v", err)
 			}
 			
 			resolution.KeptVersion = "merged"
-			resolution.MergedHash = HashData(merged)
-			addLog(fmt.Sprintf("✅ Conflit résolu (fusion auto): %s", filepath.Base(conflict.Path)))
+			resolution.MergedHash = HashData(result.Content)
+			resolution.Clean = result.Clean
+			if result.Clean {
+				addLog(fmt.Sprintf("✅ Conflit résolu (fusion auto): %s", filepath.Base(conflict.Path)))
+			} else {
+				addLog(fmt.Sprintf("⚠️ Fusion avec %d zone(s) en conflit, à corriger: %s", result.Conflicts, filepath.Base(conflict.Path)))
+			}
 		} else {
 			// Pour les fichiers binaires, garder le plus récent
 			return cm.ResolveConflict(conflictID, ConflictKeepNewest, remoteContent)
@@ -268,7 +318,12 @@ func (cm *ConflictManager) ResolveConflict(conflictID string, strategy ConflictS
 	
 	// Supprimer des conflits actifs
 	delete(cm.conflicts, conflict.Path)
+	onResolved := cm.onResolved
 	cm.mu.Unlock()
+
+	if onResolved != nil {
+		onResolved(conflict)
+	}
 	
 	return nil
 }
@@ -384,47 +439,6 @@ func IsTextFile(path string) bool {
 	return textExtensions[ext]
 }
 
-// SimpleMerge tente une fusion simple de deux fichiers texte
-func SimpleMerge(local, remote []byte) ([]byte, error) {
-	localLines := strings.Split(string(local), "\n")
-	remoteLines := strings.Split(string(remote), "\n")
-	
-	// Si les fichiers sont identiques
-	if string(local) == string(remote) {
-		return local, nil
-	}
-	
-	// Fusion simple: prendre les lignes uniques des deux côtés
-	// C'est une approche basique, pas un vrai merge 3-way
-	
-	seen := make(map[string]bool)
-	var merged []string
-	
-	// Ajouter les lignes locales
-	for _, line := range localLines {
-		if !seen[line] {
-			merged = append(merged, line)
-			seen[line] = true
-		}
-	}
-	
-	// Ajouter les lignes distantes qui ne sont pas déjà présentes
-	for _, line := range remoteLines {
-		if !seen[line] {
-			merged = append(merged, line)
-			seen[line] = true
-		}
-	}
-	
-	// Si la fusion a plus de lignes que le max des deux, ajouter un marqueur
-	if len(merged) > len(localLines) && len(merged) > len(remoteLines) {
-		header := "<<<< MERGED AUTOMATICALLY >>>>\n"
-		return []byte(header + strings.Join(merged, "\n")), nil
-	}
-	
-	return []byte(strings.Join(merged, "\n")), nil
-}
-
 func generateConflictID() string {
 	return fmt.Sprintf("conflict_%d", time.Now().UnixNano())
 }
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ============================================================================
+// SYNCHRONISATION DIFFÉRENTIELLE (capacité "delta")
+// ============================================================================
+//
+// Pour un gros fichier modifié, seuls les blocs changés sont transmis:
+//   1. delta_offer (émetteur)      : nom, taille et hash de la nouvelle version
+//   2. delta_signature (récepteur) : somme glissante et hash de chaque bloc de sa copie
+//   3. l'émetteur cherche ces blocs dans son fichier et envoie un delta (op "delta")
+//      composé de références aux blocs existants et des données nouvelles
+//   4. le récepteur reconstruit le fichier à partir de sa copie et vérifie le hash
+//
+// Une signature sans base (BaseHash vide) demande l'envoi du fichier complet.
+
+const (
+	// deltaThreshold taille au-delà de laquelle un fichier modifié est envoyé en delta
+	deltaThreshold = 1024 * 1024
+
+	// Taille des blocs de signature (racine carrée de la taille du fichier, bornée)
+	deltaMinBlock  = 2 * 1024
+	deltaMaxBlock  = 1024 * 1024
+	deltaMaxBlocks = 200000
+
+	// deltaMaxLiteral taille maximale d'un bloc de données nouvelles dans le delta
+	deltaMaxLiteral = 64 * 1024
+
+	// deltaMaxRatio au-delà de cette proportion du fichier, l'envoi complet est préféré
+	deltaMaxRatio = 0.9
+
+	deltaMagic = "SPDELTA1"
+
+	deltaOpCopy    byte = 'C'
+	deltaOpLiteral byte = 'L'
+	deltaOpEnd     byte = 'E'
+)
+
+// Erreurs du delta
+var (
+	ErrDeltaFormat = errors.New("delta invalide")
+	ErrDeltaBase   = errors.New("la copie locale ne correspond pas à la base du delta")
+	ErrDeltaTarget = errors.New("fichier reconstruit invalide")
+)
+
+// FileSignature signature par blocs d'un fichier
+type FileSignature struct {
+	BlockSize int
+	Size      int64
+	Weak      []uint32
+	Strong    [][16]byte
+}
+
+// deltaBlockSize choisit la taille des blocs pour un fichier
+func deltaBlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 1023) / 1024 * 1024
+
+	if minSize := int((size + deltaMaxBlocks - 1) / deltaMaxBlocks); bs < minSize {
+		bs = minSize
+	}
+	if bs < deltaMinBlock {
+		bs = deltaMinBlock
+	}
+	if bs > deltaMaxBlock {
+		bs = deltaMaxBlock
+	}
+	return bs
+}
+
+// weakSum calcule la somme glissante (type rsync) d'un bloc
+func weakSum(data []byte) (a, b uint32) {
+	l := uint32(len(data))
+	for i, x := range data {
+		a += uint32(x)
+		b += (l - uint32(i)) * uint32(x)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+// strongSum calcule le hash d'un bloc
+func strongSum(parts ...[]byte) [16]byte {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// blockLen retourne la taille du bloc i (le dernier peut être plus court)
+func (sig *FileSignature) blockLen(i int) int {
+	if i == len(sig.Weak)-1 {
+		return int(sig.Size - int64(i)*int64(sig.BlockSize))
+	}
+	return sig.BlockSize
+}
+
+// computeSignature calcule la signature d'un fichier et son hash complet
+func computeSignature(path string) (*FileSignature, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sig := &FileSignature{
+		BlockSize: deltaBlockSize(info.Size()),
+		Size:      info.Size(),
+	}
+
+	fileHasher := sha256.New()
+	buf := make([]byte, sig.BlockSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			fileHasher.Write(buf[:n])
+			a, b := weakSum(buf[:n])
+			sig.Weak = append(sig.Weak, a|b<<16)
+			sig.Strong = append(sig.Strong, strongSum(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return sig, hex.EncodeToString(fileHasher.Sum(nil)), nil
+}
+
+// encodeBlocks sérialise les sommes des blocs (4 octets + 16 octets par bloc)
+func (sig *FileSignature) encodeBlocks() string {
+	buf := make([]byte, 0, len(sig.Weak)*20)
+	for i, w := range sig.Weak {
+		buf = binary.BigEndian.AppendUint32(buf, w)
+		buf = append(buf, sig.Strong[i][:]...)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// decodeSignature reconstruit une signature reçue
+func decodeSignature(msg DeltaSignature) (*FileSignature, error) {
+	raw, err := base64.StdEncoding.DecodeString(msg.Blocks)
+	if err != nil || len(raw)%20 != 0 || msg.BlockSize <= 0 {
+		return nil, ErrDeltaFormat
+	}
+
+	count := len(raw) / 20
+	if int64(count) != (msg.BaseSize+int64(msg.BlockSize)-1)/int64(msg.BlockSize) {
+		return nil, ErrDeltaFormat
+	}
+
+	sig := &FileSignature{
+		BlockSize: msg.BlockSize,
+		Size:      msg.BaseSize,
+		Weak:      make([]uint32, count),
+		Strong:    make([][16]byte, count),
+	}
+	for i := 0; i < count; i++ {
+		entry := raw[i*20 : (i+1)*20]
+		sig.Weak[i] = binary.BigEndian.Uint32(entry[:4])
+		copy(sig.Strong[i][:], entry[4:])
+	}
+	return sig, nil
+}
+
+// ============================================================================
+// CALCUL DU DELTA (émetteur)
+// ============================================================================
+
+// patchWriter écrit les opérations d'un delta en regroupant les blocs consécutifs
+type patchWriter struct {
+	w         *bufio.Writer
+	copyStart uint32
+	copyCount uint32
+	literal   []byte
+	written   int64
+}
+
+func (pw *patchWriter) write(data []byte) {
+	n, _ := pw.w.Write(data)
+	pw.written += int64(n)
+}
+
+func (pw *patchWriter) flushCopy() {
+	if pw.copyCount == 0 {
+		return
+	}
+	op := []byte{deltaOpCopy}
+	op = binary.BigEndian.AppendUint32(op, pw.copyStart)
+	op = binary.BigEndian.AppendUint32(op, pw.copyCount)
+	pw.write(op)
+	pw.copyCount = 0
+}
+
+func (pw *patchWriter) flushLiteral() {
+	if len(pw.literal) == 0 {
+		return
+	}
+	op := []byte{deltaOpLiteral}
+	op = binary.BigEndian.AppendUint32(op, uint32(len(pw.literal)))
+	pw.write(op)
+	pw.write(pw.literal)
+	pw.literal = pw.literal[:0]
+}
+
+func (pw *patchWriter) addCopy(block int) {
+	pw.flushLiteral()
+	if pw.copyCount > 0 && uint32(block) == pw.copyStart+pw.copyCount {
+		pw.copyCount++
+		return
+	}
+	pw.flushCopy()
+	pw.copyStart = uint32(block)
+	pw.copyCount = 1
+}
+
+func (pw *patchWriter) addLiteral(b byte) {
+	pw.flushCopy()
+	pw.literal = append(pw.literal, b)
+	if len(pw.literal) >= deltaMaxLiteral {
+		pw.flushLiteral()
+	}
+}
+
+func (pw *patchWriter) close() error {
+	pw.flushCopy()
+	pw.flushLiteral()
+	pw.write([]byte{deltaOpEnd})
+	return pw.w.Flush()
+}
+
+// writeDelta parcourt src avec une fenêtre glissante et écrit dans pw les
+// blocs retrouvés dans la signature ainsi que les données nouvelles
+func writeDelta(src io.Reader, sig *FileSignature, pw *patchWriter) error {
+	bs := sig.BlockSize
+	index := make(map[uint32][]int, len(sig.Weak))
+	for i, w := range sig.Weak {
+		index[w] = append(index[w], i)
+	}
+
+	br := bufio.NewReaderSize(src, 1024*1024)
+	ring := make([]byte, bs)
+	var head, length int
+	var a, b uint32
+	eof := false
+
+	// fill charge une nouvelle fenêtre complète
+	fill := func() error {
+		n, err := io.ReadFull(br, ring)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		head, length = 0, n
+		eof = n < bs
+		a, b = weakSum(ring[:n])
+		return nil
+	}
+
+	// window retourne le hash du contenu de la fenêtre
+	window := func() [16]byte {
+		if head+length <= bs {
+			return strongSum(ring[head : head+length])
+		}
+		return strongSum(ring[head:], ring[:head+length-bs])
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+
+	for length > 0 {
+		if candidates, ok := index[a|b<<16]; ok {
+			strong := window()
+			matched := -1
+			for _, i := range candidates {
+				if sig.blockLen(i) == length && sig.Strong[i] == strong {
+					matched = i
+					break
+				}
+			}
+			if matched >= 0 {
+				pw.addCopy(matched)
+				if err := fill(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		// Pas de bloc correspondant: l'octet sort de la fenêtre comme donnée nouvelle
+		out := ring[head]
+		pw.addLiteral(out)
+
+		if !eof {
+			in, err := br.ReadByte()
+			if err == nil {
+				ring[head] = in
+				head = (head + 1) % bs
+				a = (a - uint32(out) + uint32(in)) & 0xffff
+				b = (b - uint32(bs)*uint32(out) + a) & 0xffff
+				continue
+			}
+			if err != io.EOF {
+				return err
+			}
+			eof = true
+		}
+
+		// Fin du fichier: la fenêtre rétrécit
+		a = (a - uint32(out)) & 0xffff
+		b = (b - uint32(length)*uint32(out)) & 0xffff
+		head = (head + 1) % bs
+		length--
+	}
+
+	return pw.close()
+}
+
+// createDeltaPatch écrit dans le dossier temporaire le delta de fullPath par
+// rapport à la signature reçue. Retourne le chemin du delta et sa taille.
+func createDeltaPatch(root, fullPath, targetHash string, msg DeltaSignature) (string, int64, error) {
+	sig, err := decodeSignature(msg)
+	if err != nil {
+		return "", 0, err
+	}
+
+	baseHash, err := hex.DecodeString(msg.BaseHash)
+	if err != nil || len(baseHash) != sha256.Size {
+		return "", 0, ErrDeltaFormat
+	}
+	target, err := hex.DecodeString(targetHash)
+	if err != nil || len(target) != sha256.Size {
+		return "", 0, ErrDeltaFormat
+	}
+
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	tmpDir := internalTempDir(root)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", 0, err
+	}
+	out, err := os.CreateTemp(tmpDir, "delta-*")
+	if err != nil {
+		return "", 0, err
+	}
+
+	pw := &patchWriter{w: bufio.NewWriter(out)}
+	header := []byte(deltaMagic)
+	header = binary.BigEndian.AppendUint32(header, uint32(sig.BlockSize))
+	header = append(header, baseHash...)
+	header = binary.BigEndian.AppendUint64(header, uint64(info.Size()))
+	header = append(header, target...)
+	pw.write(header)
+
+	if err := writeDelta(src, sig, pw); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", 0, err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", 0, err
+	}
+
+	return out.Name(), pw.written, nil
+}
+
+// ============================================================================
+// APPLICATION DU DELTA (récepteur)
+// ============================================================================
+
+// applyDeltaPatch reconstruit la nouvelle version à partir de basePath
+// Retourne le chemin du fichier reconstruit et le hash attendu (même en cas d'erreur
+// sur la base, pour pouvoir redemander le fichier complet)
+func applyDeltaPatch(root, basePath string, patch io.Reader) (string, string, error) {
+	pr := bufio.NewReader(patch)
+
+	header := make([]byte, len(deltaMagic)+4+sha256.Size+8+sha256.Size)
+	if _, err := io.ReadFull(pr, header); err != nil || string(header[:len(deltaMagic)]) != deltaMagic {
+		return "", "", ErrDeltaFormat
+	}
+	pos := len(deltaMagic)
+	bs := int64(binary.BigEndian.Uint32(header[pos:]))
+	pos += 4
+	baseHash := hex.EncodeToString(header[pos : pos+sha256.Size])
+	pos += sha256.Size
+	targetSize := int64(binary.BigEndian.Uint64(header[pos:]))
+	pos += 8
+	targetHash := hex.EncodeToString(header[pos : pos+sha256.Size])
+
+	if bs <= 0 {
+		return "", targetHash, ErrDeltaFormat
+	}
+
+	localHash, err := GetHashCache().GetHash(basePath)
+	if err != nil || localHash != baseHash {
+		return "", targetHash, ErrDeltaBase
+	}
+
+	base, err := os.Open(basePath)
+	if err != nil {
+		return "", targetHash, err
+	}
+	defer base.Close()
+
+	tmpDir := internalTempDir(root)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", targetHash, err
+	}
+	out, err := os.CreateTemp(tmpDir, "delta-*")
+	if err != nil {
+		return "", targetHash, err
+	}
+
+	fail := func(err error) (string, string, error) {
+		out.Close()
+		os.Remove(out.Name())
+		return "", targetHash, err
+	}
+
+	hasher := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(out, hasher))
+	var written int64
+
+	for {
+		op, err := pr.ReadByte()
+		if err != nil {
+			return fail(ErrDeltaFormat)
+		}
+		if op == deltaOpEnd {
+			break
+		}
+
+		var args [8]byte
+		switch op {
+		case deltaOpCopy:
+			if _, err := io.ReadFull(pr, args[:8]); err != nil {
+				return fail(ErrDeltaFormat)
+			}
+			start := int64(binary.BigEndian.Uint32(args[:4]))
+			count := int64(binary.BigEndian.Uint32(args[4:]))
+			if _, err := base.Seek(start*bs, io.SeekStart); err != nil {
+				return fail(err)
+			}
+			n, err := io.Copy(bw, io.LimitReader(base, count*bs))
+			if err != nil {
+				return fail(err)
+			}
+			written += n
+		case deltaOpLiteral:
+			if _, err := io.ReadFull(pr, args[:4]); err != nil {
+				return fail(ErrDeltaFormat)
+			}
+			length := int64(binary.BigEndian.Uint32(args[:4]))
+			n, err := io.CopyN(bw, pr, length)
+			if err != nil {
+				return fail(ErrDeltaFormat)
+			}
+			written += n
+		default:
+			return fail(ErrDeltaFormat)
+		}
+
+		if written > targetSize {
+			return fail(ErrDeltaTarget)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fail(err)
+	}
+	if written != targetSize || hex.EncodeToString(hasher.Sum(nil)) != targetHash {
+		return fail(ErrDeltaTarget)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", targetHash, err
+	}
+
+	return out.Name(), targetHash, nil
+}
+
+// ============================================================================
+// ÉCHANGES
+// ============================================================================
+
+// deltaOffers retient les offres de delta envoyées à un pair: seule une
+// signature répondant à l'une d'elles est servie, jamais un chemin choisi par le pair
+type deltaOffers struct {
+	mu     sync.Mutex
+	hashes map[string]string // Chemin proposé → hash de la version proposée
+}
+
+// remember enregistre une offre envoyée
+func (d *deltaOffers) remember(relPath, hash string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hashes == nil {
+		d.hashes = make(map[string]string)
+	}
+	d.hashes[relPath] = hash
+}
+
+// matches vérifie qu'une signature répond à une offre en attente
+func (d *deltaOffers) matches(msg DeltaSignature) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	hash, ok := d.hashes[msg.FileName]
+	return ok && hash == msg.Hash
+}
+
+// done retire une offre servie
+func (d *deltaOffers) done(relPath string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.hashes, relPath)
+}
+
+// offerDelta propose l'envoi d'un fichier modifié en delta
+func offerDelta(fs frameSender, fullPath, relPath string, size int64) error {
+	fileHash, err := GetHashCache().GetHash(fullPath)
+	if err != nil {
+		return err
+	}
+	fs.pendingDeltas().remember(relPath, fileHash)
+	return fs.Send(MsgDeltaOffer, DeltaOffer{
+		FileName: relPath,
+		Size:     size,
+		Hash:     fileHash,
+	})
+}
+
+// buildDeltaSignature prépare la réponse à une offre de delta
+// Sans copie locale la base reste vide (envoi complet), et si la copie est
+// déjà identique l'émetteur n'envoie rien
+func buildDeltaSignature(root string, offer DeltaOffer) DeltaSignature {
+	resp := DeltaSignature{
+		FileName: offer.FileName,
+		Hash:     offer.Hash,
+	}
+
+	basePath := filepath.Join(root, filepath.FromSlash(offer.FileName))
+	sig, baseHash, err := GetHashCache().GetSignature(basePath)
+	if err != nil {
+		return resp
+	}
+
+	if baseHash == offer.Hash {
+		resp.Same = true
+		return resp
+	}
+
+	resp.BaseHash = baseHash
+	resp.BlockSize = sig.BlockSize
+	resp.BaseSize = sig.Size
+	resp.Blocks = sig.encodeBlocks()
+	return resp
+}
+
+// sendDelta répond à une signature: envoie le delta, ou le fichier complet
+// si le récepteur n'a pas de base exploitable ou si le delta n'est pas rentable.
+// Seules les signatures répondant à une offre envoyée sont servies; après un
+// delta l'offre reste ouverte pour que le récepteur puisse redemander le
+// fichier complet si le patch échoue.
+func sendDelta(fs frameSender, root string, msg DeltaSignature, origin string) error {
+	if !isSafeRelPath(msg.FileName) || !fs.pendingDeltas().matches(msg) {
+		return fmt.Errorf("signature de delta sans offre: %s", msg.FileName)
+	}
+	if msg.Same {
+		fs.pendingDeltas().done(msg.FileName)
+		return nil
+	}
+
+	fullPath := filepath.Join(root, filepath.FromSlash(msg.FileName))
+	chunked := fs.HasCapability(CapChunked)
+
+	currentHash, err := GetHashCache().GetHash(fullPath)
+	if err != nil {
+		return err
+	}
+
+	// Fichier modifié depuis l'offre ou pas de base: envoi complet
+	if currentHash != msg.Hash || msg.BaseHash == "" {
+		fs.pendingDeltas().done(msg.FileName)
+		return sendFullContent(fs, fullPath, msg.FileName, "write", origin, chunked)
+	}
+
+	patchPath, patchSize, err := createDeltaPatch(root, fullPath, currentHash, msg)
+	if err != nil {
+		fs.pendingDeltas().done(msg.FileName)
+		return sendFullContent(fs, fullPath, msg.FileName, "write", origin, chunked)
+	}
+	defer os.Remove(patchPath)
+
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	if float64(patchSize) > float64(info.Size())*deltaMaxRatio {
+		fs.pendingDeltas().done(msg.FileName)
+		return sendFullContent(fs, fullPath, msg.FileName, "write", origin, chunked)
+	}
+
+	addLog(fmt.Sprintf("🧩 Delta %s: %s au lieu de %s", msg.FileName, FormatFileSize(patchSize), FormatFileSize(info.Size())))
+	return sendFullContent(fs, patchPath, msg.FileName, "delta", origin, chunked)
+}
+
+// resolveDeltaChange reconstruit le fichier décrit par un delta reçu et
+// retourne l'opération d'écriture correspondante (contenu dans LocalFile).
+// Si la copie locale a changé entre-temps, le fichier complet est redemandé.
+func resolveDeltaChange(fs frameSender, root string, msg FileChange) (FileChange, error) {
+	defer msg.Discard()
+
+	var patch io.Reader
+	if msg.LocalFile != "" {
+		file, err := os.Open(msg.LocalFile)
+		if err != nil {
+			return msg, err
+		}
+		defer file.Close()
+		patch = file
+	} else {
+		data, err := base64.StdEncoding.DecodeString(msg.Content)
+		if err != nil {
+			return msg, err
+		}
+		patch = bytes.NewReader(data)
+	}
+
+	basePath := filepath.Join(root, filepath.FromSlash(msg.FileName))
+	outPath, targetHash, err := applyDeltaPatch(root, basePath, patch)
+	if err != nil {
+		if targetHash != "" {
+			fs.Send(MsgDeltaSignature, DeltaSignature{FileName: msg.FileName, Hash: targetHash})
+		}
+		return msg, err
+	}
+
+	return FileChange{
+		FileName:  msg.FileName,
+		Op:        "write",
+		IsDir:     false,
+		Origin:    msg.Origin,
+		LocalFile: outPath,
+		Vector:    msg.Vector,
+	}, nil
+}
@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// CHIFFREMENT DE BOUT EN BOUT - Partages dont l'hôte ne voit que du contenu chiffré
+// ============================================================================
+//
+// Les clients d'un partage "e2e" connaissent une phrase secrète, ou y ont reçu
+// un accès personnel (e2e_access.go). La clé maître est dérivée de la phrase
+// secrète (argon2id, sel et paramètres dans le trousseau), ou aléatoire et
+// chiffrée pour chaque destinataire, et chiffre les clés du trousseau, que
+// l'hôte conserve sans pouvoir les lire:
+//   - la clé des noms: chaque élément d'un chemin est chiffré de façon
+//     déterministe (AES-GCM, nonce = HMAC du nom), l'arborescence de l'hôte
+//     reste stable et les déplacements restent possibles;
+//   - les clés de contenu: chaque fichier est chiffré par FileEncryptor avec la
+//     clé active, dont l'ID est noté dans l'en-tête (EncryptedFileHeader).
+// Une rotation (KeyManager.RotateKey) ajoute une clé active au trousseau: les
+// fichiers existants restent lisibles avec leur clé et sont rechiffrés avec la
+// nouvelle à leur prochain envoi.
+
+const (
+	e2eKeyringFileName = "e2e_keyring.json" // Hôte: trousseau du partage
+	e2eIndexFileName   = "e2e_index.json"   // Client: contenus en clair des fichiers chiffrés connus
+	e2eIndexSaveDelay  = 30 * time.Second
+	e2eKeyLifetime     = 365 * 24 * time.Hour // Indicative: les rotations sont manuelles
+)
+
+// Erreurs du chiffrement de bout en bout
+var (
+	errE2EPassphrase = errors.New("phrase secrète incorrecte")
+	errE2EPlainShare = errors.New("le partage n'est pas chiffré de bout en bout")
+	errE2EName       = errors.New("nom chiffré invalide")
+	errE2EKeyring    = errors.New("trousseau d'un autre partage ou d'une autre phrase secrète")
+	errE2ENoSecret   = errors.New("phrase secrète ou compte utilisateur requis")
+)
+
+// e2eContent contenu en clair correspondant à un contenu chiffré de l'hôte
+type e2eContent struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// E2ECipher clés d'un partage chiffré de bout en bout, côté client
+// Il est conservé dans Credentials et réutilisé aux reconnexions.
+type E2ECipher struct {
+	keys     *KeyManager
+	identity *E2EIdentity // Identité ouvrant la clé maître (nil = phrase secrète)
+	files    *FileEncryptor
+	names    cipher.AEAD // Chiffrement des noms
+	nameMAC  []byte      // Clé des nonces des noms
+	root     string
+	mu       sync.Mutex
+	keyring  E2EKeyring
+	index    map[string]e2eContent // Par hash du contenu chiffré
+	dirty    bool
+	savedAt  time.Time
+}
+
+// unlockE2E ouvre le trousseau du partage reçu dans auth_success
+// Le premier client d'un partage le crée et l'envoie à l'hôte: avec la phrase
+// secrète s'il en a une, sinon avec une clé maître dont il est le seul
+// destinataire. Aux reconnexions, le trousseau déjà ouvert est complété par les
+// clés ajoutées entre-temps.
+func unlockE2E(ws *websocket.Conn, authResp AuthResponse, creds *Credentials, syncDir string) error {
+	if !hasCapability(authResp.Capabilities, CapE2E) {
+		return errE2EPlainShare
+	}
+
+	if creds.e2e != nil {
+		if authResp.Keyring == nil {
+			return errE2EKeyring
+		}
+		return creds.e2e.Load(*authResp.Keyring)
+	}
+
+	id, err := creds.e2eIdentity()
+	if err != nil {
+		return err
+	}
+	if authResp.Keyring != nil {
+		e, err := openE2ECipher(*authResp.Keyring, id, creds.Passphrase, syncDir)
+		if err != nil {
+			return err
+		}
+		creds.e2e = e
+		return nil
+	}
+
+	e, keyring, err := createE2ECipher(creds.Passphrase, id, authResp.User, syncDir)
+	if err != nil {
+		return err
+	}
+	frame, err := encodeFrame(negotiateVersion(authResp.ProtocolVersion), MsgE2EKeyring, keyring)
+	if err != nil {
+		return err
+	}
+	if err := ws.WriteJSON(frame); err != nil {
+		return err
+	}
+	creds.e2e = e
+	addLog("🔐 Trousseau de bout en bout créé pour le partage")
+	return nil
+}
+
+// createE2ECipher crée le trousseau d'un partage: sel de la phrase secrète (ou
+// clé maître chiffrée pour l'identité du compte user), clé des noms et première
+// clé de contenu
+func createE2ECipher(passphrase string, id *E2EIdentity, user, root string) (*E2ECipher, E2EKeyring, error) {
+	km := NewKeyManager()
+	var keyring E2EKeyring
+	switch {
+	case passphrase != "":
+		km.SetMasterKey(passphrase)
+		keyring.KDF = km.MasterKeyParams().String()
+	case id != nil && user != "":
+		if err := km.GenerateMasterKey(); err != nil {
+			return nil, E2EKeyring{}, err
+		}
+		keyring.Master = GenerateSecureToken(8)
+	default:
+		return nil, E2EKeyring{}, errE2ENoSecret
+	}
+
+	nameKey, err := km.GenerateKey(e2eKeyLifetime)
+	if err != nil {
+		return nil, E2EKeyring{}, err
+	}
+	contentKey, err := km.GenerateKey(e2eKeyLifetime)
+	if err != nil {
+		return nil, E2EKeyring{}, err
+	}
+
+	keyring.Active = contentKey.ID
+	if keyring.NameKey, err = wrapE2EKey(km, nameKey); err != nil {
+		return nil, E2EKeyring{}, err
+	}
+	wrapped, err := wrapE2EKey(km, contentKey)
+	if err != nil {
+		return nil, E2EKeyring{}, err
+	}
+	keyring.Keys = []E2EWrappedKey{wrapped}
+
+	if keyring.KDF == "" {
+		self, err := sealE2ERecipient(km, user, id.PublicKey)
+		if err != nil {
+			return nil, E2EKeyring{}, err
+		}
+		keyring.Recipients = []E2ERecipient{self}
+		if keyring.MAC, err = recipientsMAC(km, keyring); err != nil {
+			return nil, E2EKeyring{}, err
+		}
+	}
+
+	e, err := newE2ECipher(km, keyring, id, root)
+	return e, keyring, err
+}
+
+// openE2ECipher ouvre la clé maître (identité destinataire ou phrase secrète)
+// et importe les clés du trousseau
+// La clé des noms ne se déchiffre qu'avec la bonne clé maître.
+func openE2ECipher(keyring E2EKeyring, id *E2EIdentity, passphrase, root string) (*E2ECipher, error) {
+	km := NewKeyManager()
+	if err := unlockE2EMaster(km, keyring, id, passphrase); err != nil {
+		return nil, err
+	}
+	if err := km.ImportKey(keyring.NameKey.ID, keyring.NameKey.Key); err != nil {
+		return nil, errE2EPassphrase
+	}
+
+	e, err := newE2ECipher(km, E2EKeyring{KDF: keyring.KDF, Master: keyring.Master, NameKey: keyring.NameKey}, id, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Load(keyring); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// newE2ECipher prépare le chiffrement des noms et charge l'index des contenus
+func newE2ECipher(km *KeyManager, keyring E2EKeyring, id *E2EIdentity, root string) (*E2ECipher, error) {
+	nameKey, err := km.GetKey(keyring.NameKey.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Sous-clés distinctes pour le chiffrement des noms et pour leurs nonces
+	names, err := newGCM(hmacSum(nameKey.Key, "spiralydata-e2e-name"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := NewEncryptionConfig()
+	config.Enabled = true
+	config.EncryptFilenames = true
+
+	e := &E2ECipher{
+		keys:     km,
+		identity: id,
+		files:    NewFileEncryptor(km, config),
+		names:    names,
+		nameMAC:  hmacSum(nameKey.Key, "spiralydata-e2e-nonce"),
+		root:     root,
+		keyring:  keyring,
+		index:    make(map[string]e2eContent),
+		savedAt:  time.Now(),
+	}
+	e.loadIndex()
+	return e, nil
+}
+
+// wrapE2EKey chiffre une clé par la clé maître pour le trousseau
+func wrapE2EKey(km *KeyManager, key *EncryptionKey) (E2EWrappedKey, error) {
+	exported, err := km.ExportKey(key.ID)
+	if err != nil {
+		return E2EWrappedKey{}, err
+	}
+	return E2EWrappedKey{ID: key.ID, Key: exported, CreatedAt: key.CreatedAt}, nil
+}
+
+// hmacSum retourne HMAC-SHA256(key, label)
+func hmacSum(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// Load importe les clés d'un trousseau reçu de l'hôte (connexion, rotation
+// ou changement des accès fait par un autre client) et retient sa clé active
+func (e *E2ECipher) Load(keyring E2EKeyring) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if keyring.NameKey.ID != e.keyring.NameKey.ID {
+		return errE2EKeyring
+	}
+	if e2eMasterID(keyring) != e2eMasterID(e.keyring) {
+		// Clé maître remplacée par une révocation: seuls les destinataires restants
+		// peuvent l'ouvrir
+		if e.identity == nil {
+			return errE2EKeyring
+		}
+		if err := unlockE2EMaster(e.keys, keyring, e.identity, ""); err != nil {
+			return err
+		}
+		addLog("🔐 Clé maître du partage remplacée")
+	}
+	if err := checkRecipientsMAC(e.keys, keyring); err != nil {
+		return err
+	}
+	for _, key := range keyring.Keys {
+		if _, err := e.keys.GetKey(key.ID); err == nil {
+			continue
+		}
+		if err := e.keys.ImportKey(key.ID, key.Key); err != nil {
+			return fmt.Errorf("clé %s illisible: %w", key.ID, err)
+		}
+	}
+	if err := e.keys.SetActiveKey(keyring.Active); err != nil {
+		return err
+	}
+	e.keyring = keyring
+	return nil
+}
+
+// Rotate remplace la clé de contenu active et retourne le trousseau à envoyer à l'hôte
+// Les anciennes clés sont conservées pour lire les fichiers pas encore rechiffrés.
+func (e *E2ECipher) Rotate() (E2EKeyring, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key, err := e.keys.RotateKey(e2eKeyLifetime)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+	wrapped, err := wrapE2EKey(e.keys, key)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+
+	keyring := e.keyring
+	keyring.Keys = append(append([]E2EWrappedKey{}, e.keyring.Keys...), wrapped)
+	keyring.Active = key.ID
+	e.keyring = keyring
+	return keyring, nil
+}
+
+// ============================================================================
+// NOMS
+// ============================================================================
+
+// EncryptPath chiffre chaque élément d'un chemin relatif
+// Un même nom donne toujours le même nom chiffré (base64 URL, sans remplissage).
+func (e *E2ECipher) EncryptPath(relPath string) string {
+	if relPath == "" {
+		return ""
+	}
+	parts := strings.Split(relPath, "/")
+	for i, name := range parts {
+		mac := hmac.New(sha256.New, e.nameMAC)
+		mac.Write([]byte(name))
+		nonce := mac.Sum(nil)[:e.names.NonceSize()]
+		parts[i] = base64.RawURLEncoding.EncodeToString(e.names.Seal(nonce, nonce, []byte(name), nil))
+	}
+	return strings.Join(parts, "/")
+}
+
+// DecryptPath déchiffre un chemin reçu de l'hôte
+// Un nom qui n'a pas été chiffré par un client du partage est refusé.
+func (e *E2ECipher) DecryptPath(encPath string) (string, error) {
+	if encPath == "" {
+		return "", nil
+	}
+	parts := strings.Split(encPath, "/")
+	nonceSize := e.names.NonceSize()
+	for i, part := range parts {
+		sealed, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil || len(sealed) < nonceSize {
+			return "", errE2EName
+		}
+		name, err := e.names.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+		if err != nil || len(name) == 0 || string(name) == "." || string(name) == ".." || bytes.ContainsRune(name, '/') {
+			return "", errE2EName
+		}
+		parts[i] = string(name)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// displayPath retourne le chemin en clair, ou le chemin reçu s'il ne se déchiffre pas
+func (e *E2ECipher) displayPath(encPath string) string {
+	if plain, err := e.DecryptPath(encPath); err == nil {
+		return plain
+	}
+	return encPath
+}
+
+// SealPayload chiffre les chemins d'un message envoyé à l'hôte
+// Le contenu des fichiers est chiffré avant l'envoi (sendEncryptedContent).
+func (e *E2ECipher) SealPayload(payload interface{}) interface{} {
+	switch p := payload.(type) {
+	case FileChange:
+		p.FileName = e.EncryptPath(p.FileName)
+		p.OldName = e.EncryptPath(p.OldName)
+		return p
+	case DownloadRequest:
+		items := make([]string, len(p.Items))
+		for i, item := range p.Items {
+			items[i] = e.EncryptPath(item)
+		}
+		p.Items = items
+		return p
+	case VersionListRequest:
+		p.Path = e.EncryptPath(p.Path)
+		return p
+	case RestoreVersionRequest:
+		p.Path = e.EncryptPath(p.Path)
+		return p
+	case ErrorMessage:
+		if p.Code == ErrCodeMoveSourceMissing {
+			p.Message = e.EncryptPath(p.Message)
+		}
+		return p
+	}
+	return payload
+}
+
+// ============================================================================
+// CONTENU
+// ============================================================================
+
+// SealFile chiffre un fichier local avec la clé active dans un fichier
+// temporaire de tempDir, à supprimer par l'appelant
+func (e *E2ECipher) SealFile(fullPath, tempDir string) (string, error) {
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(tempDir, "e2e-*")
+	if err != nil {
+		return "", err
+	}
+
+	plainHash := sha256.New()
+	sealedHash := sha256.New()
+	err = e.files.EncryptTo(io.MultiWriter(tmp, sealedHash), io.TeeReader(src, plainHash), info.Size())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	e.remember(hex.EncodeToString(sealedHash.Sum(nil)), e2eContent{
+		Hash: hex.EncodeToString(plainHash.Sum(nil)),
+		Size: info.Size(),
+	})
+	return tmp.Name(), nil
+}
+
+// OpenChange déchiffre les chemins et le contenu d'une opération reçue de l'hôte
+// Le contenu en clair est placé dans LocalFile; le contenu chiffré est supprimé.
+func (e *E2ECipher) OpenChange(msg FileChange, tempDir string) (FileChange, error) {
+	defer msg.Discard()
+
+	opened := msg
+	var err error
+	if opened.FileName, err = e.DecryptPath(msg.FileName); err != nil {
+		return FileChange{}, err
+	}
+	if opened.OldName, err = e.DecryptPath(msg.OldName); err != nil {
+		return FileChange{}, err
+	}
+
+	if !msg.IsDir && (msg.Op == "create" || msg.Op == "write") {
+		opened.LocalFile, err = e.openContent(msg, tempDir)
+		if err != nil {
+			return FileChange{}, err
+		}
+		opened.Content = ""
+	}
+	return opened, nil
+}
+
+// openContent déchiffre le contenu d'une opération dans un fichier temporaire de tempDir
+func (e *E2ECipher) openContent(msg FileChange, tempDir string) (string, error) {
+	var src io.Reader
+	if msg.LocalFile != "" {
+		file, err := os.Open(msg.LocalFile)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		src = file
+	} else {
+		data, err := base64.StdEncoding.DecodeString(msg.Content)
+		if err != nil {
+			return "", err
+		}
+		src = bytes.NewReader(data)
+	}
+
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(tempDir, "e2e-*")
+	if err != nil {
+		return "", err
+	}
+
+	plainHash := sha256.New()
+	sealedHash := sha256.New()
+	header, err := e.files.DecryptTo(io.MultiWriter(tmp, plainHash), io.TeeReader(src, sealedHash))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	e.remember(hex.EncodeToString(sealedHash.Sum(nil)), e2eContent{
+		Hash: hex.EncodeToString(plainHash.Sum(nil)),
+		Size: header.OrigSize,
+	})
+	return tmp.Name(), nil
+}
+
+// ============================================================================
+// LISTES REÇUES DE L'HÔTE
+// ============================================================================
+
+// OpenManifest déchiffre les chemins du manifeste complet et remplace le hash et
+// la taille de chaque fichier par ceux de son contenu en clair, s'il est connu.
+// Un contenu inconnu (hash vide) sera redemandé. L'index est réduit aux
+// contenus encore présents sur l'hôte.
+func (e *E2ECipher) OpenManifest(entries []ManifestEntry) []ManifestEntry {
+	opened := make([]ManifestEntry, 0, len(entries))
+	kept := make(map[string]e2eContent)
+	invalid := 0
+
+	e.mu.Lock()
+	for _, entry := range entries {
+		plain, err := e.DecryptPath(entry.Path)
+		if err != nil {
+			invalid++
+			continue
+		}
+		entry.Path = plain
+		if !entry.IsDir {
+			content, known := e.index[entry.Hash]
+			if known {
+				kept[entry.Hash] = content
+			}
+			entry.Hash = content.Hash
+			entry.Size = content.Size
+		}
+		opened = append(opened, entry)
+	}
+	e.index = kept
+	e.dirty = true
+	e.mu.Unlock()
+
+	if invalid > 0 {
+		addLog(fmt.Sprintf("⚠️ %d éléments de l'hôte ignorés (noms non chiffrés par le partage)", invalid))
+	}
+	e.Flush()
+	return opened
+}
+
+// OpenTreeItem déchiffre le chemin et le nom d'un élément de l'arborescence
+func (e *E2ECipher) OpenTreeItem(item FileTreeItemMessage) (FileTreeItemMessage, bool) {
+	if item.Path == "" {
+		return item, true // file_tree_complete
+	}
+	plain, err := e.DecryptPath(item.Path)
+	if err != nil {
+		return item, false
+	}
+	item.Path = plain
+	item.Name = path.Base(plain)
+	return item, true
+}
+
+// OpenVersionList déchiffre les chemins d'un historique
+func (e *E2ECipher) OpenVersionList(list VersionList) VersionList {
+	list.Path = e.displayPath(list.Path)
+	versions := make([]FileRevision, len(list.Versions))
+	for i, rev := range list.Versions {
+		rev.Path = e.displayPath(rev.Path)
+		versions[i] = rev
+	}
+	list.Versions = versions
+	return list
+}
+
+// OpenTrashList déchiffre les chemins des éléments de la corbeille de l'hôte
+func (e *E2ECipher) OpenTrashList(list TrashList) TrashList {
+	entries := make([]TrashEntry, len(list.Entries))
+	for i, entry := range list.Entries {
+		entry.Path = e.displayPath(entry.Path)
+		entries[i] = entry
+	}
+	list.Entries = entries
+	return list
+}
+
+// ============================================================================
+// INDEX DES CONTENUS
+// ============================================================================
+
+// remember retient le contenu en clair d'un contenu chiffré (envoyé ou reçu)
+func (e *E2ECipher) remember(sealedHash string, content e2eContent) {
+	e.mu.Lock()
+	e.index[sealedHash] = content
+	e.dirty = true
+	due := time.Since(e.savedAt) > e2eIndexSaveDelay
+	e.mu.Unlock()
+
+	if due {
+		e.Flush()
+	}
+}
+
+// indexPath emplacement de l'index dans le dossier interne
+func (e *E2ECipher) indexPath() string {
+	return filepath.Join(e.root, internalDirName, e2eIndexFileName)
+}
+
+// loadIndex lit l'index enregistré lors des sessions précédentes
+func (e *E2ECipher) loadIndex() {
+	data, err := os.ReadFile(e.indexPath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &e.index); err != nil {
+		addLog(fmt.Sprintf("⚠️ Index du chiffrement illisible: %v", err))
+		e.index = make(map[string]e2eContent)
+	}
+}
+
+// Flush enregistre l'index s'il a changé
+func (e *E2ECipher) Flush() {
+	e.mu.Lock()
+	if !e.dirty {
+		e.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(e.index)
+	e.dirty = false
+	e.savedAt = time.Now()
+	e.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	target := e.indexPath()
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+	writeFileAtomic(e.root, target, data)
+}
+
+// ============================================================================
+// CLIENT
+// ============================================================================
+
+// e2eSender envoie le contenu chiffré d'un fichier sous son nom chiffré
+// Les messages ne repassent pas par Client.Send (noms déjà chiffrés). Le contenu
+// chiffré changeant à chaque envoi, un envoi interrompu ne peut pas être repris.
+type e2eSender struct {
+	*Client
+	plainPath string
+}
+
+func (s e2eSender) Send(msgType string, payload interface{}) error {
+	return s.sendFrame(msgType, payload)
+}
+
+func (s e2eSender) HasCapability(c string) bool {
+	return c != CapResume && c != CapDelta && s.Client.HasCapability(c)
+}
+
+func (s e2eSender) fileVector(string) VersionVector {
+	return s.Client.fileVector(s.plainPath)
+}
+
+// sendEncryptedContent chiffre un fichier local puis l'envoie à l'hôte
+func (c *Client) sendEncryptedContent(relPath, op string) error {
+	fullPath := filepath.Join(c.localDir, filepath.FromSlash(relPath))
+	sealed, err := c.e2e.SealFile(fullPath, internalTempDir(c.localDir))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sealed)
+
+	chunked := hasCapability(c.capabilities, CapChunked)
+	err = sendFileContent(e2eSender{Client: c, plainPath: relPath}, sealed, c.e2e.EncryptPath(relPath), op, "client", chunked)
+	if err == nil {
+		c.state.Record(relPath)
+	}
+	return err
+}
+
+// RotateE2EKey remplace la clé de contenu du partage et envoie le trousseau à l'hôte
+// Les fichiers sont rechiffrés avec la nouvelle clé à leur prochain envoi.
+func (c *Client) RotateE2EKey() error {
+	if c.e2e == nil {
+		return errE2EPlainShare
+	}
+	keyring, err := c.e2e.Rotate()
+	if err != nil {
+		return err
+	}
+	if err := c.Send(MsgE2EKeyring, keyring); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("🔐 Nouvelle clé de chiffrement %s (rechiffrement au prochain envoi de chaque fichier)", keyring.Active))
+	return nil
+}
+
+// handleE2EKeyring importe le trousseau mis à jour par un autre client
+func (c *Client) handleE2EKeyring(env *Envelope) error {
+	var keyring E2EKeyring
+	if err := json.Unmarshal(env.Payload, &keyring); err != nil {
+		return err
+	}
+	if c.e2e == nil {
+		return nil
+	}
+	if err := c.e2e.Load(keyring); err != nil {
+		if errors.Is(err, errE2EAccess) || errors.Is(err, errE2EKeyring) {
+			// Accès retiré: les prochains envois ne seraient pas lisibles
+			addLog(fmt.Sprintf("🚨 %v: déconnexion", err))
+			go c.Disconnect()
+			return nil
+		}
+		return err
+	}
+	addLog(fmt.Sprintf("🔐 Nouvelle clé de chiffrement du partage: %s", keyring.Active))
+	return nil
+}
+
+// ============================================================================
+// HÔTE
+// ============================================================================
+
+// e2eKeyringPath emplacement du trousseau dans le dossier interne du partage
+func (s *Server) e2eKeyringPath() string {
+	return filepath.Join(s.WatchDir, internalDirName, e2eKeyringFileName)
+}
+
+// loadE2EKeyring lit le trousseau du partage (nil tant qu'aucun client ne l'a créé)
+func (s *Server) loadE2EKeyring() (*E2EKeyring, error) {
+	data, err := os.ReadFile(s.e2eKeyringPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keyring E2EKeyring
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return nil, err
+	}
+	return &keyring, nil
+}
+
+// checkE2EKeyringUpdate vérifie qu'un trousseau reçu peut remplacer le trousseau
+// actuel: même clé des noms, aucune clé retirée, et clés inchangées tant que la
+// clé maître (phrase secrète) reste la même
+func checkE2EKeyringUpdate(current *E2EKeyring, next E2EKeyring) error {
+	if next.KDF != "" {
+		if _, _, err := ParseKDFParams(next.KDF); err != nil {
+			return err
+		}
+	}
+	if err := checkE2ERecipients(next); err != nil {
+		return err
+	}
+	if next.NameKey.ID == "" || next.NameKey.Key == "" {
+		return errors.New("clé des noms absente")
+	}
+
+	keys := make(map[string]string, len(next.Keys))
+	for _, key := range next.Keys {
+		if key.ID == "" || key.Key == "" || key.ID == next.NameKey.ID {
+			return errors.New("clé invalide")
+		}
+		keys[key.ID] = key.Key
+	}
+	if _, ok := keys[next.Active]; !ok {
+		return errors.New("clé active absente du trousseau")
+	}
+
+	if current == nil {
+		return nil
+	}
+	// Clé maître remplacée (révocation): les clés sont rechiffrées
+	rewrapped := e2eMasterID(next) != e2eMasterID(*current)
+	if next.NameKey.ID != current.NameKey.ID || (!rewrapped && next.NameKey != current.NameKey) {
+		return errE2EKeyring
+	}
+	for _, key := range current.Keys {
+		wrapped, ok := keys[key.ID]
+		if !ok || (!rewrapped && wrapped != key.Key) {
+			return fmt.Errorf("clé %s retirée ou modifiée", key.ID)
+		}
+	}
+	return nil
+}
+
+// handleE2EKeyring enregistre le trousseau créé ou complété par un client et le
+// transmet aux autres clients du partage
+func (s *Server) handleE2EKeyring(sess *ClientSession, env *Envelope) error {
+	if !sess.HasCapability(CapE2E) {
+		return fmt.Errorf("capacité %s non négociée", CapE2E)
+	}
+	var keyring E2EKeyring
+	if err := json.Unmarshal(env.Payload, &keyring); err != nil {
+		return err
+	}
+	if !s.receivesFromClients() {
+		return sess.SendError(env.ID, ErrCodeShareReadOnly, e2eKeyringFileName)
+	}
+	if sess.User != nil && (sess.account() == nil || !sess.account().Role.CanWrite()) {
+		s.denyAccess(sess, env.ID, "", accessWrite, "Rôle en lecture seule")
+		return nil
+	}
+
+	s.keyringMu.Lock()
+	current, err := s.loadE2EKeyring()
+	if err == nil {
+		err = checkE2EKeyringUpdate(current, keyring)
+	}
+	if err == nil {
+		err = checkE2EAccessUpdate(sess, current, keyring)
+	}
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(keyring, "", "  ")
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(s.e2eKeyringPath()), 0755)
+		}
+		if err == nil {
+			err = writeFileAtomic(s.WatchDir, s.e2eKeyringPath(), data)
+		}
+	}
+	s.keyringMu.Unlock()
+
+	if err != nil {
+		addLog(fmt.Sprintf("🚫 %s: trousseau refusé (%v)", sess.Name, err))
+		return sess.SendError(env.ID, ErrCodeE2EKeyring, err.Error())
+	}
+	removed := removedE2ERecipients(current, keyring)
+	switch {
+	case current == nil:
+		addLog(fmt.Sprintf("🔐 %s: trousseau de bout en bout créé", sess.Name))
+	case len(removed) > 0:
+		addLog(fmt.Sprintf("🔐 %s: accès de %s retiré, nouvelle clé de contenu %s", sess.Name, strings.Join(removed, ", "), keyring.Active))
+	case e2eAccessChanged(current, keyring):
+		addLog(fmt.Sprintf("🔐 %s: destinataires du trousseau modifiés", sess.Name))
+	default:
+		addLog(fmt.Sprintf("🔐 %s: nouvelle clé de contenu %s", sess.Name, keyring.Active))
+	}
+
+	s.mu.Lock()
+	var others []*ClientSession
+	for _, other := range s.Clients {
+		if other != sess && other.HasCapability(CapE2E) {
+			others = append(others, other)
+		}
+	}
+	s.mu.Unlock()
+	for _, other := range others {
+		if err := other.Send(MsgE2EKeyring, keyring); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi trousseau à %s: %v", other.Name, err))
+		}
+	}
+	// Les comptes retirés ne liraient plus les prochains envois: leurs
+	// connexions au partage sont fermées
+	for _, userID := range removed {
+		s.disconnectUser(userID)
+	}
+	return nil
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ACCÈS PAR UTILISATEUR - Clé maître d'un partage chiffrée pour chaque destinataire
+// ============================================================================
+//
+// Chaque utilisateur possède une identité X25519 (spiraly_identity.json, à côté
+// de la configuration), créée à sa première connexion à un partage chiffré de
+// bout en bout. L'hôte enregistre la clé publique dans host.users à la première
+// connexion du compte; la clé privée ne quitte pas le client.
+// La clé maître du trousseau est chiffrée pour la clé publique de chaque
+// destinataire (SealForRecipient) et, en option, pour une clé de secours des
+// administrateurs. Sans phrase secrète, le premier client d'un partage crée une
+// clé maître aléatoire dont il est le seul destinataire.
+//   - share-key grant: la clé maître est chiffrée pour un utilisateur;
+//   - share-key revoke: l'utilisateur est retiré, la clé maître remplacée, les
+//     clés du trousseau rechiffrées et une nouvelle clé de contenu activée; la
+//     phrase secrète éventuelle ne donne plus accès;
+//   - share-key recovery: la clé maître est chiffrée pour une clé de secours,
+//     conservée hors ligne et utilisée avec -identity.
+// La liste des destinataires est authentifiée par la clé maître (MAC): l'hôte ne
+// peut pas y glisser sa propre clé publique.
+
+const (
+	e2eIdentityFileName = "spiraly_identity.json"
+	e2eRecipientsLabel  = "spiralydata-e2e-recipients"
+)
+
+// Erreurs des accès par utilisateur
+var (
+	errE2EAccess     = errors.New("accès au partage non accordé à cette identité")
+	errE2ERecipients = errors.New("destinataires du trousseau modifiés hors d'un client autorisé")
+)
+
+// E2EIdentity paire de clés X25519 d'un utilisateur, ou clé de secours
+type E2EIdentity struct {
+	PublicKey  string    `json:"public_key"`
+	PrivateKey string    `json:"private_key"`
+	Recovery   bool      `json:"recovery,omitempty"` // Clé de secours: jamais présentée comme clé d'un compte
+	CreatedAt  time.Time `json:"created_at"`
+	priv       *ecdh.PrivateKey
+}
+
+// defaultIdentityPath emplacement de l'identité, à côté de spiraly_config.json
+func defaultIdentityPath() string {
+	return filepath.Join(filepath.Dir(configFilePath), e2eIdentityFileName)
+}
+
+// loadE2EIdentity lit une identité, créée si elle est absente et que create est vrai
+// Retourne aussi si elle vient d'être créée.
+func loadE2EIdentity(path string, create, recovery bool) (*E2EIdentity, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		id, err := createE2EIdentity(path, recovery)
+		return id, err == nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var id E2EIdentity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, false, fmt.Errorf("%s illisible: %w", filepath.Base(path), err)
+	}
+	raw, err := base64.StdEncoding.DecodeString(id.PrivateKey)
+	if err == nil {
+		id.priv, err = ecdh.X25519().NewPrivateKey(raw)
+	}
+	if err != nil || base64.StdEncoding.EncodeToString(id.priv.PublicKey().Bytes()) != id.PublicKey {
+		return nil, false, fmt.Errorf("%s: clé privée invalide", filepath.Base(path))
+	}
+	return &id, false, nil
+}
+
+// createE2EIdentity génère une identité et l'enregistre, lisible du seul propriétaire
+func createE2EIdentity(path string, recovery bool) (*E2EIdentity, error) {
+	priv, err := GenerateRecipientKey()
+	if err != nil {
+		return nil, err
+	}
+	id := &E2EIdentity{
+		PublicKey:  base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		PrivateKey: base64.StdEncoding.EncodeToString(priv.Bytes()),
+		Recovery:   recovery,
+		CreatedAt:  time.Now(),
+		priv:       priv,
+	}
+	data, err := json.MarshalIndent(id, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return id, nil
+}
+
+// Fingerprint retourne l'empreinte de la clé publique de l'identité
+func (id *E2EIdentity) Fingerprint() string {
+	return publicKeyFingerprint(id.PublicKey)
+}
+
+// publicKeyFingerprint empreinte courte d'une clé publique, à comparer avec son
+// détenteur par un autre canal que l'hôte
+func publicKeyFingerprint(publicKey string) string {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "?"
+	}
+	sum := sha256.Sum256(raw)
+	encoded := strings.ToUpper(hex.EncodeToString(sum[:8]))
+	return encoded[:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:]
+}
+
+// e2eIdentity retourne l'identité des identifiants, lue (ou créée) au premier appel
+// Sans compte ni fichier désigné par -identity, aucune identité n'est utilisée.
+func (c *Credentials) e2eIdentity() (*E2EIdentity, error) {
+	if c.identity != nil || (c.Username == "" && c.IdentityFile == "") {
+		return c.identity, nil
+	}
+
+	path := c.IdentityFile
+	if path == "" {
+		path = defaultIdentityPath()
+	}
+	id, created, err := loadE2EIdentity(path, c.IdentityFile == "", false)
+	if err != nil {
+		return nil, fmt.Errorf("identité de chiffrement: %w", err)
+	}
+	if created {
+		addLog(fmt.Sprintf("🔑 Identité de chiffrement créée (%s), empreinte %s", path, id.Fingerprint()))
+	}
+	c.identity = id
+	return id, nil
+}
+
+// ============================================================================
+// TROUSSEAU
+// ============================================================================
+
+// e2eMasterID identifie la clé maître d'un trousseau: paramètres de la phrase
+// secrète, ou identifiant de la clé maître aléatoire
+func e2eMasterID(keyring E2EKeyring) string {
+	if keyring.KDF != "" {
+		return keyring.KDF
+	}
+	return keyring.Master
+}
+
+// recipient retourne l'entrée d'un utilisateur (nil s'il n'est pas destinataire)
+func (k E2EKeyring) recipient(user string) *E2ERecipient {
+	for i := range k.Recipients {
+		if k.Recipients[i].User == user {
+			return &k.Recipients[i]
+		}
+	}
+	return nil
+}
+
+// recipientFor retourne l'entrée d'une clé publique: destinataire ou clé de secours
+func (k E2EKeyring) recipientFor(publicKey string) *E2ERecipient {
+	for i := range k.Recipients {
+		if k.Recipients[i].PublicKey == publicKey {
+			return &k.Recipients[i]
+		}
+	}
+	if k.Recovery != nil && k.Recovery.PublicKey == publicKey {
+		return k.Recovery
+	}
+	return nil
+}
+
+// unlockE2EMaster ouvre la clé maître d'un trousseau: entrée de l'identité parmi
+// les destinataires (ou clé de secours), sinon phrase secrète
+func unlockE2EMaster(km *KeyManager, keyring E2EKeyring, id *E2EIdentity, passphrase string) error {
+	if id != nil {
+		if entry := keyring.recipientFor(id.PublicKey); entry != nil {
+			if err := km.ImportMasterKeyFrom(entry.Key, id.priv); err != nil {
+				return fmt.Errorf("clé maître illisible: %w", err)
+			}
+			return nil
+		}
+	}
+	if keyring.KDF != "" && passphrase != "" {
+		params, _, err := ParseKDFParams(keyring.KDF)
+		if err != nil {
+			return err
+		}
+		km.UnlockMasterKey(passphrase, params)
+		return nil
+	}
+
+	if id != nil {
+		addLog(fmt.Sprintf("🔑 Empreinte de votre identité: %s (à transmettre à un administrateur du partage)", id.Fingerprint()))
+	}
+	if keyring.KDF != "" && id == nil {
+		return errE2ENoSecret
+	}
+	return errE2EAccess
+}
+
+// sealE2ERecipient chiffre la clé maître pour la clé publique d'un destinataire
+func sealE2ERecipient(km *KeyManager, user, publicKey string) (E2ERecipient, error) {
+	pub, err := ParseRecipientKey(publicKey)
+	if err != nil {
+		return E2ERecipient{}, err
+	}
+	sealed, err := km.ExportMasterKeyFor(pub)
+	if err != nil {
+		return E2ERecipient{}, err
+	}
+	return E2ERecipient{User: user, PublicKey: publicKey, Key: sealed}, nil
+}
+
+// recipientsMAC authentifie la clé maître, les destinataires et la clé de
+// secours d'un trousseau
+func recipientsMAC(km *KeyManager, keyring E2EKeyring) (string, error) {
+	var b strings.Builder
+	b.WriteString(e2eMasterID(keyring))
+	for _, r := range keyring.Recipients {
+		fmt.Fprintf(&b, "\nuser %s %s", r.User, r.PublicKey)
+	}
+	if keyring.Recovery != nil {
+		fmt.Fprintf(&b, "\nrecovery %s", keyring.Recovery.PublicKey)
+	}
+	mac, err := km.MasterMAC(e2eRecipientsLabel, []byte(b.String()))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(mac), nil
+}
+
+// checkRecipientsMAC vérifie que les destinataires d'un trousseau ont été écrits
+// par un client détenant la clé maître
+// Un trousseau à phrase secrète sans destinataire n'a pas de MAC.
+func checkRecipientsMAC(km *KeyManager, keyring E2EKeyring) error {
+	if keyring.KDF != "" && len(keyring.Recipients) == 0 && keyring.Recovery == nil && keyring.MAC == "" {
+		return nil
+	}
+	mac, err := recipientsMAC(km, keyring)
+	if err != nil || !hmac.Equal([]byte(mac), []byte(keyring.MAC)) {
+		return errE2ERecipients
+	}
+	return nil
+}
+
+// rewrapE2EKey chiffre à nouveau une clé du trousseau par la clé maître actuelle
+func rewrapE2EKey(km *KeyManager, wrapped E2EWrappedKey) (E2EWrappedKey, error) {
+	exported, err := km.ExportKey(wrapped.ID)
+	if err != nil {
+		return E2EWrappedKey{}, err
+	}
+	return E2EWrappedKey{ID: wrapped.ID, Key: exported, CreatedAt: wrapped.CreatedAt}, nil
+}
+
+// Keyring retourne le trousseau ouvert
+func (e *E2ECipher) Keyring() E2EKeyring {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.keyring
+}
+
+// Grant donne accès au partage à un utilisateur: la clé maître est chiffrée pour
+// sa clé publique. Retourne le trousseau à envoyer à l'hôte.
+func (e *E2ECipher) Grant(user, publicKey string) (E2EKeyring, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry, err := sealE2ERecipient(e.keys, user, publicKey)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+	keyring := e.keyring
+	keyring.Recipients = make([]E2ERecipient, 0, len(e.keyring.Recipients)+1)
+	for _, r := range e.keyring.Recipients {
+		if r.User != user {
+			keyring.Recipients = append(keyring.Recipients, r)
+		}
+	}
+	keyring.Recipients = append(keyring.Recipients, entry)
+	if keyring.MAC, err = recipientsMAC(e.keys, keyring); err != nil {
+		return E2EKeyring{}, err
+	}
+	e.keyring = keyring
+	return keyring, nil
+}
+
+// SetRecovery chiffre la clé maître pour la clé de secours des administrateurs
+func (e *E2ECipher) SetRecovery(publicKey string) (E2EKeyring, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry, err := sealE2ERecipient(e.keys, "", publicKey)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+	keyring := e.keyring
+	keyring.Recovery = &entry
+	if keyring.MAC, err = recipientsMAC(e.keys, keyring); err != nil {
+		return E2EKeyring{}, err
+	}
+	e.keyring = keyring
+	return keyring, nil
+}
+
+// Revoke retire l'accès d'un utilisateur et remplace la clé maître
+// Les clés du trousseau sont rechiffrées par la nouvelle clé maître, chiffrée
+// pour les destinataires restants et la clé de secours, et une nouvelle clé de
+// contenu devient active: l'utilisateur retiré ne lit pas les prochains envois.
+// La clé des noms, qu'il connaît, n'est pas remplacée.
+func (e *E2ECipher) Revoke(user string) (E2EKeyring, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.keyring.recipient(user) == nil {
+		return E2EKeyring{}, fmt.Errorf("%s n'est pas destinataire du trousseau", user)
+	}
+	var kept []E2ERecipient
+	for _, r := range e.keyring.Recipients {
+		if r.User != user {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == 0 && e.keyring.Recovery == nil {
+		return E2EKeyring{}, errors.New("dernier destinataire: donnez d'abord accès à un autre utilisateur ou ajoutez une clé de secours")
+	}
+
+	if err := e.keys.GenerateMasterKey(); err != nil {
+		return E2EKeyring{}, err
+	}
+	keyring := E2EKeyring{Master: GenerateSecureToken(8)}
+	var err error
+	if keyring.NameKey, err = rewrapE2EKey(e.keys, e.keyring.NameKey); err != nil {
+		return E2EKeyring{}, err
+	}
+	for _, key := range e.keyring.Keys {
+		wrapped, err := rewrapE2EKey(e.keys, key)
+		if err != nil {
+			return E2EKeyring{}, err
+		}
+		keyring.Keys = append(keyring.Keys, wrapped)
+	}
+	key, err := e.keys.RotateKey(e2eKeyLifetime)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+	wrapped, err := wrapE2EKey(e.keys, key)
+	if err != nil {
+		return E2EKeyring{}, err
+	}
+	keyring.Keys = append(keyring.Keys, wrapped)
+	keyring.Active = key.ID
+
+	for _, r := range kept {
+		entry, err := sealE2ERecipient(e.keys, r.User, r.PublicKey)
+		if err != nil {
+			return E2EKeyring{}, err
+		}
+		keyring.Recipients = append(keyring.Recipients, entry)
+	}
+	if e.keyring.Recovery != nil {
+		entry, err := sealE2ERecipient(e.keys, "", e.keyring.Recovery.PublicKey)
+		if err != nil {
+			return E2EKeyring{}, err
+		}
+		keyring.Recovery = &entry
+	}
+	if keyring.MAC, err = recipientsMAC(e.keys, keyring); err != nil {
+		return E2EKeyring{}, err
+	}
+	e.keyring = keyring
+	return keyring, nil
+}
+
+// ============================================================================
+// CLIENT
+// ============================================================================
+
+// GrantE2EAccess donne accès au partage à un compte de l'hôte
+// Sa clé publique provient de l'annuaire que l'hôte transmet aux administrateurs:
+// son empreinte est journalisée pour être vérifiée avec l'utilisateur.
+func (c *Client) GrantE2EAccess(user string) error {
+	if c.e2e == nil {
+		return errE2EPlainShare
+	}
+	c.mu.Lock()
+	publicKey, ok := c.publicKeys[user]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("aucune clé publique enregistrée pour %s (le compte doit s'être connecté une fois à un partage chiffré, l'annuaire n'est transmis qu'aux administrateurs)", user)
+	}
+
+	keyring, err := c.e2e.Grant(user, publicKey)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(MsgE2EKeyring, keyring); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("🔐 Accès au partage donné à %s (empreinte %s)", user, publicKeyFingerprint(publicKey)))
+	return nil
+}
+
+// RevokeE2EAccess retire l'accès d'un utilisateur et remplace la clé maître du partage
+func (c *Client) RevokeE2EAccess(user string) error {
+	if c.e2e == nil {
+		return errE2EPlainShare
+	}
+	keyring, err := c.e2e.Revoke(user)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(MsgE2EKeyring, keyring); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("🔐 Accès de %s retiré, nouvelle clé de chiffrement %s", user, keyring.Active))
+	return nil
+}
+
+// SetE2ERecovery chiffre la clé maître du partage pour une clé de secours
+func (c *Client) SetE2ERecovery(id *E2EIdentity) error {
+	if c.e2e == nil {
+		return errE2EPlainShare
+	}
+	keyring, err := c.e2e.SetRecovery(id.PublicKey)
+	if err != nil {
+		return err
+	}
+	if err := c.Send(MsgE2EKeyring, keyring); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("🔐 Clé de secours du partage: empreinte %s", id.Fingerprint()))
+	return nil
+}
+
+// ============================================================================
+// HÔTE
+// ============================================================================
+
+// checkE2ERecipients vérifie les destinataires d'un trousseau reçu
+func checkE2ERecipients(keyring E2EKeyring) error {
+	if keyring.KDF == "" && (keyring.Master == "" || keyring.MAC == "") {
+		return errors.New("trousseau sans phrase secrète ni clé maître")
+	}
+	if keyring.KDF == "" && len(keyring.Recipients) == 0 && keyring.Recovery == nil {
+		return errors.New("trousseau sans destinataire")
+	}
+
+	entries := keyring.Recipients
+	if keyring.Recovery != nil {
+		entries = append(append([]E2ERecipient{}, entries...), *keyring.Recovery)
+	}
+	users := make(map[string]bool)
+	for i, r := range entries {
+		if r.Key == "" {
+			return errors.New("destinataire sans clé")
+		}
+		if _, err := ParseRecipientKey(r.PublicKey); err != nil {
+			return err
+		}
+		if i == len(keyring.Recipients) {
+			break // Clé de secours
+		}
+		if r.User == "" || users[r.User] {
+			return fmt.Errorf("destinataire invalide ou en double: %q", r.User)
+		}
+		users[r.User] = true
+	}
+	return nil
+}
+
+// checkE2EAccessUpdate réserve aux administrateurs les changements des accès
+// Le client qui crée le trousseau peut s'en déclarer seul destinataire. Un
+// destinataire ajouté doit être un compte dont la clé publique est enregistrée.
+func checkE2EAccessUpdate(sess *ClientSession, current *E2EKeyring, next E2EKeyring) error {
+	admin := false
+	if sess.User != nil {
+		account := sess.account()
+		admin = account != nil && account.Role.CanAdmin()
+	}
+	if current == nil && len(next.Recipients) == 1 && next.Recovery == nil {
+		if sess.User == nil || next.Recipients[0].User != sess.User.ID {
+			return errors.New("le créateur du trousseau doit en être le destinataire")
+		}
+	} else if e2eAccessChanged(current, next) && !admin {
+		return errors.New("seul un administrateur peut modifier les accès au partage")
+	}
+
+	keys := userPublicKeys()
+	for _, r := range next.Recipients {
+		if current != nil {
+			if previous := current.recipient(r.User); previous != nil && previous.PublicKey == r.PublicKey {
+				continue
+			}
+		}
+		registered, ok := keys[r.User]
+		if !ok {
+			return fmt.Errorf("compte %s sans clé publique enregistrée", r.User)
+		}
+		if registered != r.PublicKey {
+			return fmt.Errorf("clé publique de %s différente de celle enregistrée", r.User)
+		}
+	}
+	return nil
+}
+
+// e2eAccessChanged indique si un trousseau change la clé maître, les destinataires
+// ou la clé de secours
+func e2eAccessChanged(current *E2EKeyring, next E2EKeyring) bool {
+	if current == nil {
+		return len(next.Recipients) > 1 || next.Recovery != nil
+	}
+	if e2eMasterID(*current) != e2eMasterID(next) || current.MAC != next.MAC ||
+		len(current.Recipients) != len(next.Recipients) || (current.Recovery == nil) != (next.Recovery == nil) {
+		return true
+	}
+	for i, r := range current.Recipients {
+		if next.Recipients[i] != r {
+			return true
+		}
+	}
+	return current.Recovery != nil && *current.Recovery != *next.Recovery
+}
+
+// removedE2ERecipients retourne les comptes retirés des destinataires
+func removedE2ERecipients(current *E2EKeyring, next E2EKeyring) []string {
+	if current == nil {
+		return nil
+	}
+	var removed []string
+	for _, r := range current.Recipients {
+		if next.recipient(r.User) == nil {
+			removed = append(removed, r.User)
+		}
+	}
+	return removed
+}
+
+// registerUserPublicKey enregistre dans host.users la clé publique présentée par
+// un compte qui n'en a pas encore
+// Une clé différente de celle enregistrée est ignorée: l'administrateur l'efface
+// (user reset-key) pour que le compte en enregistre une nouvelle.
+func registerUserPublicKey(user *User, publicKey string) {
+	if _, err := ParseRecipientKey(publicKey); err != nil {
+		return
+	}
+	configUsersMu.Lock()
+	uc, ok := configUsers[user.ID]
+	registered := uc.PublicKey
+	if ok && registered == "" {
+		uc.PublicKey = publicKey
+		configUsers[user.ID] = uc
+	}
+	configUsersMu.Unlock()
+	if !ok || registered == publicKey {
+		return
+	}
+	if registered != "" {
+		addLog(fmt.Sprintf("⚠️ %s: clé publique %s différente de celle enregistrée (%s), ignorée",
+			user.ID, publicKeyFingerprint(publicKey), publicKeyFingerprint(registered)))
+		return
+	}
+
+	config, err := LoadConfig()
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ Clé publique de %s non enregistrée: %v", user.ID, err))
+		return
+	}
+	changed := false
+	for i := range config.Host.Users {
+		if uc := &config.Host.Users[i]; uc.ID == user.ID && uc.PublicKey == "" {
+			uc.PublicKey = publicKey
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := SaveConfig(config); err != nil {
+		addLog(fmt.Sprintf("⚠️ Clé publique de %s non enregistrée: %v", user.ID, err))
+		return
+	}
+	addLog(fmt.Sprintf("🔑 Clé publique de %s enregistrée (empreinte %s)", user.ID, publicKeyFingerprint(publicKey)))
+}
+
+// userPublicKeys retourne les clés publiques enregistrées des comptes
+func userPublicKeys() map[string]string {
+	configUsersMu.Lock()
+	defer configUsersMu.Unlock()
+
+	keys := make(map[string]string)
+	for id, uc := range configUsers {
+		if uc.PublicKey != "" {
+			keys[id] = uc.PublicKey
+		}
+	}
+	return keys
+}
+
+// sortedUsers retourne les identifiants d'un annuaire de clés publiques, triés
+func sortedUsers(keys map[string]string) []string {
+	users := make([]string, 0, len(keys))
+	for user := range keys {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -24,7 +32,7 @@ import (
 type EncryptionConfig struct {
 	Enabled          bool   `json:"enabled"`
 	Algorithm        string `json:"algorithm"` // "aes-256-gcm"
-	KeyDerivation    string `json:"key_derivation"` // "sha256"
+	KeyDerivation    string `json:"key_derivation"` // "argon2id"
 	EncryptMetadata  bool   `json:"encrypt_metadata"`
 	EncryptFilenames bool   `json:"encrypt_filenames"`
 	PerFileKeys      bool   `json:"per_file_keys"`
@@ -35,7 +43,7 @@ func NewEncryptionConfig() *EncryptionConfig {
 	return &EncryptionConfig{
 		Enabled:          false,
 		Algorithm:        "aes-256-gcm",
-		KeyDerivation:    "sha256",
+		KeyDerivation:    kdfAlgorithm,
 		EncryptMetadata:  false,
 		EncryptFilenames: false,
 		PerFileKeys:      false,
@@ -61,6 +69,7 @@ type KeyManager struct {
 	keys       map[string]*EncryptionKey
 	activeKey  string
 	masterKey  []byte
+	masterKDF  KDFParams // Sel et paramètres de dérivation de la clé maître
 	mu         sync.RWMutex
 }
 
@@ -71,14 +80,27 @@ func NewKeyManager() *KeyManager {
 	}
 }
 
-// SetMasterKey définit la clé maître (dérivée du mot de passe)
+// SetMasterKey définit la clé maître, dérivée du mot de passe avec un nouveau sel
+// Les paramètres (MasterKeyParams) doivent être conservés avec les clés exportées.
 func (km *KeyManager) SetMasterKey(password string) {
+	km.UnlockMasterKey(password, NewKDFParams())
+}
+
+// UnlockMasterKey dérive la clé maître avec des paramètres enregistrés
+func (km *KeyManager) UnlockMasterKey(password string, params KDFParams) {
+	key := DeriveKey(password, params)
+
 	km.mu.Lock()
 	defer km.mu.Unlock()
-	
-	// Dériver la clé avec SHA256 (pour production, utiliser PBKDF2 ou Argon2)
-	hash := sha256.Sum256([]byte("spiralydata_master_" + password))
-	km.masterKey = hash[:]
+	km.masterKey = key
+	km.masterKDF = params
+}
+
+// MasterKeyParams retourne le sel et les paramètres de dérivation de la clé maître
+func (km *KeyManager) MasterKeyParams() KDFParams {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.masterKDF
 }
 
 // HasMasterKey vérifie si une clé maître est définie
@@ -98,6 +120,7 @@ func (km *KeyManager) ClearKeys() {
 		km.masterKey[i] = 0
 	}
 	km.masterKey = nil
+	km.masterKDF = KDFParams{}
 	
 	for keyID, key := range km.keys {
 		for i := range key.Key {
@@ -166,6 +189,25 @@ func (km *KeyManager) GetKey(keyID string) (*EncryptionKey, error) {
 	return key, nil
 }
 
+// SetActiveKey choisit la clé utilisée pour les nouveaux chiffrements
+// (clé importée, par exemple d'un trousseau partagé)
+func (km *KeyManager) SetActiveKey(keyID string) error {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	
+	key, ok := km.keys[keyID]
+	if !ok {
+		return fmt.Errorf("clé non trouvée: %s", keyID)
+	}
+	
+	for _, other := range km.keys {
+		other.IsActive = false
+	}
+	key.IsActive = true
+	km.activeKey = keyID
+	return nil
+}
+
 // RotateKey effectue une rotation de clé
 func (km *KeyManager) RotateKey(duration time.Duration) (*EncryptionKey, error) {
 	// Désactiver l'ancienne clé (mais la garder pour déchiffrement)
@@ -236,21 +278,155 @@ func (km *KeyManager) ImportKey(keyID string, encryptedKey string) error {
 }
 
 // ============================================================================
-// AES-256-GCM ENCRYPTION
+// DESTINATAIRES (X25519)
 // ============================================================================
 
-// EncryptAESGCM chiffre des données avec AES-256-GCM
-func EncryptAESGCM(plaintext, key []byte) ([]byte, error) {
-	if len(key) != 32 {
-		return nil, errors.New("clé doit être de 32 bytes (AES-256)")
+// recipientKeyInfo contexte HKDF des données chiffrées pour un destinataire
+const recipientKeyInfo = "spiralydata-recipient-key"
+
+// GenerateRecipientKey génère une paire de clés X25519 (identité d'un utilisateur)
+func GenerateRecipientKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// ParseRecipientKey lit une clé publique X25519 encodée en base64
+func ParseRecipientKey(encoded string) (*ecdh.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("clé publique invalide: %w", err)
 	}
-	
-	block, err := aes.NewCipher(key)
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("clé publique invalide: %w", err)
+	}
+	return pub, nil
+}
+
+// SealForRecipient chiffre des données que seul le détenteur de la clé privée
+// de pub pourra lire
+// Une clé éphémère X25519 est combinée à pub (ECDH puis HKDF-SHA256) pour
+// obtenir une clé AES-256-GCM à usage unique.
+// Format: clé publique éphémère (32 octets) + nonce + données chiffrées
+func SealForRecipient(plaintext []byte, pub *ecdh.PublicKey) ([]byte, error) {
+	ephemeral, err := GenerateRecipientKey()
 	if err != nil {
 		return nil, err
 	}
-	
-	gcm, err := cipher.NewGCM(block)
+	shared, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	key, err := recipientAEADKey(shared, ephemeralPub, pub.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := EncryptAESGCM(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPub, sealed...), nil
+}
+
+// OpenForRecipient déchiffre des données chiffrées par SealForRecipient
+func OpenForRecipient(sealed []byte, priv *ecdh.PrivateKey) ([]byte, error) {
+	size := len(priv.PublicKey().Bytes())
+	if len(sealed) < size {
+		return nil, errors.New("données chiffrées trop courtes")
+	}
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(sealed[:size])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := priv.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := recipientAEADKey(shared, sealed[:size], priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return DecryptAESGCM(sealed[size:], key)
+}
+
+// recipientAEADKey dérive la clé à usage unique du secret partagé, liée aux
+// deux clés publiques
+func recipientAEADKey(shared, ephemeralPub, recipientPub []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPub...), recipientPub...)
+	return hkdf.Key(sha256.New, shared, salt, recipientKeyInfo, 32)
+}
+
+// GenerateMasterKey remplace la clé maître par une clé aléatoire, sans phrase secrète
+// Elle n'est transmise que chiffrée pour des destinataires (ExportMasterKeyFor).
+func (km *KeyManager) GenerateMasterKey() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.masterKey = key
+	km.masterKDF = KDFParams{}
+	return nil
+}
+
+// ExportMasterKeyFor chiffre la clé maître pour un destinataire
+func (km *KeyManager) ExportMasterKeyFor(pub *ecdh.PublicKey) (string, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if len(km.masterKey) == 0 {
+		return "", errors.New("clé maître non définie")
+	}
+	sealed, err := SealForRecipient(km.masterKey, pub)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// ImportMasterKeyFrom remplace la clé maître par celle chiffrée pour priv
+// Les clés déjà importées sont conservées.
+func (km *KeyManager) ImportMasterKeyFrom(sealedKey string, priv *ecdh.PrivateKey) error {
+	sealed, err := base64.StdEncoding.DecodeString(sealedKey)
+	if err != nil {
+		return err
+	}
+	key, err := OpenForRecipient(sealed, priv)
+	if err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.masterKey = key
+	km.masterKDF = KDFParams{}
+	return nil
+}
+
+// MasterMAC authentifie des données avec une sous-clé de la clé maître
+func (km *KeyManager) MasterMAC(label string, data []byte) ([]byte, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if len(km.masterKey) == 0 {
+		return nil, errors.New("clé maître non définie")
+	}
+	subKey := hmac.New(sha256.New, km.masterKey)
+	subKey.Write([]byte(label))
+	mac := hmac.New(sha256.New, subKey.Sum(nil))
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// ============================================================================
+// AES-256-GCM ENCRYPTION
+// ============================================================================
+
+// EncryptAESGCM chiffre des données avec AES-256-GCM
+func EncryptAESGCM(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -266,8 +442,8 @@ func EncryptAESGCM(plaintext, key []byte) ([]byte, error) {
 	return ciphertext, nil
 }
 
-// DecryptAESGCM déchiffre des données AES-256-GCM
-func DecryptAESGCM(ciphertext, key []byte) ([]byte, error) {
+// newGCM prépare AES-256-GCM pour une clé de 32 octets
+func newGCM(key []byte) (cipher.AEAD, error) {
 	if len(key) != 32 {
 		return nil, errors.New("clé doit être de 32 bytes (AES-256)")
 	}
@@ -277,7 +453,12 @@ func DecryptAESGCM(ciphertext, key []byte) ([]byte, error) {
 		return nil, err
 	}
 	
-	gcm, err := cipher.NewGCM(block)
+	return cipher.NewGCM(block)
+}
+
+// DecryptAESGCM déchiffre des données AES-256-GCM
+func DecryptAESGCM(ciphertext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -317,15 +498,36 @@ func NewFileEncryptor(km *KeyManager, config *EncryptionConfig) *FileEncryptor {
 	}
 }
 
+// Format des fichiers chiffrés:
+// [4 octets: taille de l'en-tête, big-endian][en-tête JSON][données]
+// Version 1: données = nonce + AES-GCM du fichier entier
+// Version 2: segments de encSegmentSize octets chiffrés séparément (nonce +
+// AES-GCM), authentifiés avec l'en-tête, leur rang et un indicateur de dernier
+// segment: un fichier tronqué ou dont les segments sont réordonnés est rejeté.
+const (
+	encMagic          = "SPENC"
+	encVersion        = 2
+	encSegmentSize    = 1024 * 1024
+	encMaxHeaderSize  = 64 * 1024
+	encMaxSegmentSize = 64 * 1024 * 1024
+)
+
+// Erreurs de lecture des fichiers chiffrés
+var (
+	errNotEncrypted     = errors.New("contenu non chiffré")
+	errEncryptedCorrupt = errors.New("contenu chiffré altéré ou tronqué")
+)
+
 // EncryptedFileHeader en-tête d'un fichier chiffré
 type EncryptedFileHeader struct {
-	Magic     string `json:"magic"`     // "SPENC"
-	Version   int    `json:"version"`   // 1
-	KeyID     string `json:"key_id"`
-	Algorithm string `json:"algorithm"`
-	OrigSize  int64  `json:"orig_size"`
-	OrigName  string `json:"orig_name,omitempty"`
-	OrigHash  string `json:"orig_hash,omitempty"`
+	Magic       string `json:"magic"`   // "SPENC"
+	Version     int    `json:"version"` // 1 (fichier entier) ou 2 (segments)
+	KeyID       string `json:"key_id"`
+	Algorithm   string `json:"algorithm"`
+	OrigSize    int64  `json:"orig_size"`
+	OrigName    string `json:"orig_name,omitempty"`
+	OrigHash    string `json:"orig_hash,omitempty"`
+	SegmentSize int    `json:"segment_size,omitempty"` // Version 2
 }
 
 // EncryptFile chiffre un fichier
@@ -334,126 +536,279 @@ func (fe *FileEncryptor) EncryptFile(srcPath, dstPath string) error {
 		// Simplement copier si chiffrement désactivé
 		return copyFile(srcPath, dstPath)
 	}
-	
-	// Lire le fichier source
-	plaintext, err := os.ReadFile(srcPath)
+
+	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
-	
-	// Obtenir la clé active
-	key, err := fe.keyManager.GetActiveKey()
+	defer src.Close()
+
+	info, err := src.Stat()
 	if err != nil {
 		return err
 	}
-	
-	// Chiffrer
-	ciphertext, err := EncryptAESGCM(plaintext, key.Key)
+
+	// Créer le répertoire de destination
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(dstPath)
 	if err != nil {
 		return err
 	}
-	
-	// Créer le répertoire de destination
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+	if err := fe.EncryptTo(dst, src, info.Size()); err != nil {
+		dst.Close()
+		os.Remove(dstPath)
 		return err
 	}
-	
-	// Écrire le fichier chiffré avec en-tête
-	// Format: [4 bytes: header size][header JSON][ciphertext]
-	header := fmt.Sprintf(`{"magic":"SPENC","version":1,"key_id":"%s","algorithm":"aes-256-gcm","orig_size":%d}`,
-		key.ID, len(plaintext))
-	
-	headerBytes := []byte(header)
-	headerSize := uint32(len(headerBytes))
-	
-	file, err := os.Create(dstPath)
+	return dst.Close()
+}
+
+// EncryptTo chiffre le contenu de src (size octets) avec la clé active et
+// l'écrit dans dst, segment par segment, sans le charger en mémoire
+func (fe *FileEncryptor) EncryptTo(dst io.Writer, src io.Reader, size int64) error {
+	key, err := fe.keyManager.GetActiveKey()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	
-	// Écrire la taille de l'en-tête (4 bytes, big-endian)
-	file.Write([]byte{
-		byte(headerSize >> 24),
-		byte(headerSize >> 16),
-		byte(headerSize >> 8),
-		byte(headerSize),
+	gcm, err := newGCM(key.Key)
+	if err != nil {
+		return err
+	}
+
+	headerBytes, err := json.Marshal(EncryptedFileHeader{
+		Magic:       encMagic,
+		Version:     encVersion,
+		KeyID:       key.ID,
+		Algorithm:   "aes-256-gcm",
+		OrigSize:    size,
+		SegmentSize: encSegmentSize,
 	})
-	
-	// Écrire l'en-tête
-	file.Write(headerBytes)
-	
-	// Écrire le ciphertext
-	file.Write(ciphertext)
-	
+	if err != nil {
+		return err
+	}
+	sizeBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBytes, uint32(len(headerBytes)))
+	if _, err := dst.Write(append(sizeBytes, headerBytes...)); err != nil {
+		return err
+	}
+
+	in := bufio.NewReaderSize(src, 64*1024)
+	buf := make([]byte, encSegmentSize)
+	sealed := make([]byte, 0, gcm.NonceSize()+encSegmentSize+gcm.Overhead())
+	var total int64
+
+	for index := uint64(0); ; index++ {
+		n, readErr := io.ReadFull(in, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		last := readErr != nil
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		nonce := sealed[:gcm.NonceSize()]
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		segment := gcm.Seal(nonce, nonce, buf[:n], segmentAAD(headerBytes, index, last))
+		if _, err := dst.Write(segment); err != nil {
+			return err
+		}
+		total += int64(n)
+
+		if last {
+			break
+		}
+	}
+
+	if total != size {
+		return fmt.Errorf("taille modifiée pendant le chiffrement (%d au lieu de %d octets)", total, size)
+	}
 	key.UsageCount++
-	
 	return nil
 }
 
 // DecryptFile déchiffre un fichier
 func (fe *FileEncryptor) DecryptFile(srcPath, dstPath string) error {
-	file, err := os.Open(srcPath)
+	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	
-	// Lire la taille de l'en-tête
-	headerSizeBytes := make([]byte, 4)
-	if _, err := io.ReadFull(file, headerSizeBytes); err != nil {
-		// Fichier non chiffré, simplement copier
-		return copyFile(srcPath, dstPath)
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
 	}
-	
-	headerSize := uint32(headerSizeBytes[0])<<24 |
-		uint32(headerSizeBytes[1])<<16 |
-		uint32(headerSizeBytes[2])<<8 |
-		uint32(headerSizeBytes[3])
-	
-	// Lire l'en-tête
-	headerBytes := make([]byte, headerSize)
-	if _, err := io.ReadFull(file, headerBytes); err != nil {
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
 		return err
 	}
-	
-	// Parser l'en-tête (simple extraction du key_id)
-	// En production, utiliser encoding/json
-	headerStr := string(headerBytes)
-	if len(headerStr) < 10 || headerStr[:16] != `{"magic":"SPENC"` {
-		// Pas un fichier chiffré
+	_, err = fe.DecryptTo(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if errors.Is(err, errNotEncrypted) {
+		// Fichier non chiffré, simplement copier
 		return copyFile(srcPath, dstPath)
 	}
-	
-	// Extraire key_id (simplification)
-	keyIDStart := indexOf(headerStr, `"key_id":"`) + 10
-	keyIDEnd := indexOf(headerStr[keyIDStart:], `"`) + keyIDStart
-	keyID := headerStr[keyIDStart:keyIDEnd]
-	
-	// Obtenir la clé
-	key, err := fe.keyManager.GetKey(keyID)
 	if err != nil {
-		return fmt.Errorf("clé de déchiffrement non trouvée: %s", keyID)
+		os.Remove(dstPath)
 	}
-	
-	// Lire le ciphertext
-	ciphertext, err := io.ReadAll(file)
+	return err
+}
+
+// DecryptTo déchiffre un contenu écrit par EncryptTo (ou par l'ancien format)
+// et l'écrit dans dst. Retourne errNotEncrypted si src n'a pas d'en-tête SPENC.
+// En cas d'erreur, dst peut avoir reçu une partie du contenu: à supprimer.
+func (fe *FileEncryptor) DecryptTo(dst io.Writer, src io.Reader) (EncryptedFileHeader, error) {
+	header, headerBytes, err := readEncryptedHeader(src)
 	if err != nil {
-		return err
+		return header, err
 	}
-	
-	// Déchiffrer
-	plaintext, err := DecryptAESGCM(ciphertext, key.Key)
+
+	// Obtenir la clé
+	key, err := fe.keyManager.GetKey(header.KeyID)
 	if err != nil {
-		return err
+		return header, fmt.Errorf("clé de déchiffrement non trouvée: %s", header.KeyID)
 	}
-	
-	// Écrire le fichier déchiffré
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-		return err
+
+	if header.Version == 1 {
+		ciphertext, err := io.ReadAll(src)
+		if err != nil {
+			return header, err
+		}
+		plaintext, err := DecryptAESGCM(ciphertext, key.Key)
+		if err != nil {
+			return header, errEncryptedCorrupt
+		}
+		_, err = dst.Write(plaintext)
+		return header, err
 	}
-	
-	return os.WriteFile(dstPath, plaintext, 0644)
+
+	gcm, err := newGCM(key.Key)
+	if err != nil {
+		return header, err
+	}
+
+	in := bufio.NewReaderSize(src, 64*1024)
+	buf := make([]byte, gcm.NonceSize()+header.SegmentSize+gcm.Overhead())
+	var total int64
+
+	for index := uint64(0); ; index++ {
+		n, readErr := io.ReadFull(in, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return header, readErr
+		}
+		last := readErr != nil
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return header, err
+			}
+		}
+		if n < gcm.NonceSize()+gcm.Overhead() {
+			return header, errEncryptedCorrupt
+		}
+
+		nonce := buf[:gcm.NonceSize()]
+		ciphertext := buf[gcm.NonceSize():n]
+		plaintext, err := gcm.Open(ciphertext[:0], nonce, ciphertext, segmentAAD(headerBytes, index, last))
+		if err != nil {
+			return header, errEncryptedCorrupt
+		}
+		if _, err := dst.Write(plaintext); err != nil {
+			return header, err
+		}
+		total += int64(len(plaintext))
+
+		if last {
+			break
+		}
+	}
+
+	if total != header.OrigSize {
+		return header, errEncryptedCorrupt
+	}
+	return header, nil
+}
+
+// EncryptBytes chiffre un contenu en mémoire au format des fichiers chiffrés (EncryptTo)
+func (fe *FileEncryptor) EncryptBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := fe.EncryptTo(&buf, bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecryptBytes déchiffre un contenu en mémoire écrit par EncryptBytes ou EncryptTo
+func (fe *FileEncryptor) DecryptBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := fe.DecryptTo(&buf, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// IsEncryptedContent indique si un contenu commence par l'en-tête d'un fichier chiffré
+func IsEncryptedContent(r io.Reader) bool {
+	_, _, err := readEncryptedHeader(r)
+	return !errors.Is(err, errNotEncrypted)
+}
+
+// readEncryptedHeader lit l'en-tête d'un contenu chiffré
+// Retourne aussi l'en-tête brut, authentifié avec chaque segment.
+func readEncryptedHeader(r io.Reader) (EncryptedFileHeader, []byte, error) {
+	var header EncryptedFileHeader
+
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, sizeBytes); err != nil {
+		return header, nil, errNotEncrypted
+	}
+	headerSize := binary.BigEndian.Uint32(sizeBytes)
+	if headerSize == 0 || headerSize > encMaxHeaderSize {
+		return header, nil, errNotEncrypted
+	}
+
+	headerBytes := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, headerBytes); err != nil {
+		return header, nil, errNotEncrypted
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil || header.Magic != encMagic {
+		return header, nil, errNotEncrypted
+	}
+
+	switch header.Version {
+	case 1:
+	case 2:
+		if header.SegmentSize <= 0 || header.SegmentSize > encMaxSegmentSize {
+			return header, nil, fmt.Errorf("%w: segments de %d octets", errEncryptedCorrupt, header.SegmentSize)
+		}
+	default:
+		return header, nil, fmt.Errorf("version de chiffrement non supportée: %d", header.Version)
+	}
+	return header, headerBytes, nil
+}
+
+// segmentAAD données authentifiées d'un segment: en-tête, rang et dernier segment
+func segmentAAD(header []byte, index uint64, last bool) []byte {
+	aad := make([]byte, len(header), len(header)+9)
+	copy(aad, header)
+	aad = binary.BigEndian.AppendUint64(aad, index)
+	if last {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
 }
 
 // EncryptData chiffre des données en mémoire
@@ -561,39 +916,34 @@ func copyFile(src, dst string) error {
 	return os.WriteFile(dst, data, 0644)
 }
 
-func indexOf(s, substr string) int {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return i
-		}
-	}
-	return -1
-}
-
-// DeriveKey dérive une clé à partir d'un mot de passe
-func DeriveKey(password, salt string) []byte {
-	// Simple dérivation (pour production, utiliser PBKDF2 ou Argon2)
-	h := sha256.New()
-	h.Write([]byte(salt))
-	h.Write([]byte(password))
-	return h.Sum(nil)
-}
-
 // EncryptString chiffre une chaîne avec un mot de passe
+// Le résultat commence par les paramètres de dérivation: <params PHC>$<base64>
 func EncryptString(plaintext, password string) (string, error) {
-	key := DeriveKey(password, "spiralydata_string_")
+	params := NewKDFParams()
+	key := DeriveKey(password, params)
 	
 	encrypted, err := EncryptAESGCM([]byte(plaintext), key)
 	if err != nil {
 		return "", err
 	}
 	
-	return base64.StdEncoding.EncodeToString(encrypted), nil
+	return params.String() + "$" + base64.StdEncoding.EncodeToString(encrypted), nil
 }
 
 // DecryptString déchiffre une chaîne avec un mot de passe
+// Les chaînes sans paramètres ont été chiffrées avec l'ancienne dérivation SHA-256.
 func DecryptString(ciphertext, password string) (string, error) {
-	key := DeriveKey(password, "spiralydata_string_")
+	var key []byte
+	if strings.HasPrefix(ciphertext, "$") {
+		params, rest, err := ParseKDFParams(ciphertext)
+		if err != nil {
+			return "", err
+		}
+		key = DeriveKey(password, params)
+		ciphertext = rest
+	} else {
+		key = legacyDeriveKey(password, "spiralydata_string_")
+	}
 	
 	encrypted, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -28,24 +29,31 @@ type FileTreeItem struct {
 }
 
 type FileExplorer struct {
-	client           *Client
-	win              fyne.Window
-	allItems         map[string]*FileTreeItem
-	currentDir       *FileTreeItem
-	rootDir          *FileTreeItem
-	selectedItems    map[string]bool
-	mu               sync.Mutex
-	loadingLabel     *widget.Label
-	contentContainer *fyne.Container
-	backCallback     func()
-	treeLoaded       bool
-	settings         *ExplorerSettings
-	previewPanel     *PreviewPanel
-	showingPreview   bool
-	searchQuery      string // Requête de recherche actuelle
+	client            *Client
+	win               fyne.Window
+	allItems          map[string]*FileTreeItem
+	currentDir        *FileTreeItem
+	rootDir           *FileTreeItem
+	selectedItems     map[string]bool
+	mu                sync.Mutex
+	loadingLabel      *widget.Label
+	contentContainer  *fyne.Container
+	backCallback      func()
+	treeLoaded        bool
+	settings          *ExplorerSettings
+	previewPanel      *PreviewPanel
+	showingPreview    bool
+	searchQuery       string          // Requête de recherche actuelle
+	subscribed        map[string]bool // Dossiers cochés pour la sync sélective
+	subscriptionDirty bool            // Abonnement modifié, pas encore appliqué
 }
 
 func NewFileExplorer(client *Client, win fyne.Window, backCallback func()) *FileExplorer {
+	subscribed := make(map[string]bool)
+	for _, folder := range GetFilterConfig().Subscription() {
+		subscribed[folder] = true
+	}
+
 	return &FileExplorer{
 		client:        client,
 		win:           win,
@@ -54,6 +62,7 @@ func NewFileExplorer(client *Client, win fyne.Window, backCallback func()) *File
 		backCallback:  backCallback,
 		treeLoaded:    false,
 		settings:      NewExplorerSettings(),
+		subscribed:    subscribed,
 	}
 }
 
@@ -162,7 +171,7 @@ func (fe *FileExplorer) requestAndBuildTree(stopLoading *bool, progressLabel *wi
 	}
 
 	addLog("📤 Envoi de la requête file_tree...")
-	err := fe.client.WriteJSONSafe(reqMsg)
+	err := fe.client.Send(MsgRequestFileTree, reqMsg)
 
 	if err != nil {
 		addLog(fmt.Sprintf("❌ Erreur lors de la demande: %v", err))
@@ -553,6 +562,7 @@ func (fe *FileExplorer) showDirectoryUI() {
 		row := container.NewHBox(check, layout.NewSpacer())
 
 		if itemIsDir {
+			row.Add(fe.newSubscriptionCheck(itemPath))
 			openBtn := widget.NewButton("Ouvrir", func() {
 				fe.currentDir = itemRef
 				fe.showDirectoryUI()
@@ -568,6 +578,14 @@ func (fe *FileExplorer) showDirectoryUI() {
 				previewBtn.Importance = widget.LowImportance
 				row.Add(previewBtn)
 			}
+			// Bouton historique si le serveur conserve les versions
+			if fe.client.HasCapability(CapVersions) {
+				historyBtn := widget.NewButton("Historique", func() {
+					fe.showFileHistory(itemPath)
+				})
+				historyBtn.Importance = widget.LowImportance
+				row.Add(historyBtn)
+			}
 		}
 
 		treeContent.Add(row)
@@ -603,6 +621,18 @@ func (fe *FileExplorer) showDirectoryUI() {
 	fe.mu.Unlock()
 	selectedLabel := widget.NewLabel(fmt.Sprintf("✓ %d sélectionnés", selectedCount))
 
+	// Sync sélective: dossiers cochés "Sync"
+	subscriptionLabel := widget.NewLabel(fe.subscriptionSummary())
+	subscriptionBtn := widget.NewButton("Appliquer sync sélective", func() {
+		fe.applySubscription()
+	})
+	subscriptionBtn.Importance = widget.MediumImportance
+	fe.mu.Lock()
+	if !fe.subscriptionDirty {
+		subscriptionBtn.Disable()
+	}
+	fe.mu.Unlock()
+
 	// Panneau favoris (sidebar gauche)
 	var favoritesPanel fyne.CanvasObject
 	if len(fe.settings.Favorites) > 0 {
@@ -642,6 +672,11 @@ func (fe *FileExplorer) showDirectoryUI() {
 		),
 		container.NewVBox(
 			widget.NewSeparator(),
+			container.NewHBox(
+				subscriptionLabel,
+				layout.NewSpacer(),
+				subscriptionBtn,
+			),
 			container.NewHBox(
 				selectedLabel,
 				layout.NewSpacer(),
@@ -676,6 +711,99 @@ func (fe *FileExplorer) showDirectoryUI() {
 	addLog("✅ Explorateur affiché")
 }
 
+// newSubscriptionCheck crée la case "Sync" d'un dossier (sync sélective)
+// Un dossier couvert par un dossier parent coché est coché et désactivé.
+func (fe *FileExplorer) newSubscriptionCheck(dirPath string) *widget.Check {
+	fe.mu.Lock()
+	checked := fe.subscribed[dirPath]
+	covered := fe.subscribedParent(dirPath)
+	fe.mu.Unlock()
+
+	check := widget.NewCheck("Sync", nil)
+	check.SetChecked(checked || covered)
+	if covered {
+		check.Disable()
+	}
+	// Callback ajouté après SetChecked pour ne pas marquer l'abonnement comme modifié
+	check.OnChanged = func(on bool) {
+		fe.mu.Lock()
+		if on {
+			fe.subscribed[dirPath] = true
+		} else {
+			delete(fe.subscribed, dirPath)
+		}
+		fe.subscriptionDirty = true
+		fe.mu.Unlock()
+		fe.showDirectoryUI()
+	}
+	return check
+}
+
+// subscribedParent vérifie si un dossier parent est coché (fe.mu verrouillé)
+func (fe *FileExplorer) subscribedParent(dirPath string) bool {
+	for parent := path.Dir(dirPath); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if fe.subscribed[parent] {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribedFolders retourne les dossiers cochés, sans ceux couverts par un parent
+func (fe *FileExplorer) subscribedFolders() []string {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+
+	var folders []string
+	for folder, on := range fe.subscribed {
+		if on && !fe.subscribedParent(folder) {
+			folders = append(folders, folder)
+		}
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// subscriptionSummary décrit l'abonnement affiché dans l'explorateur
+func (fe *FileExplorer) subscriptionSummary() string {
+	folders := fe.subscribedFolders()
+	switch len(folders) {
+	case 0:
+		return "📁 Sync: tout le partage"
+	case 1:
+		return "📁 Sync: " + folders[0]
+	default:
+		return fmt.Sprintf("📁 Sync: %d dossiers", len(folders))
+	}
+}
+
+// applySubscription envoie les dossiers cochés au serveur après confirmation
+// Aucun dossier coché: tout le partage est synchronisé.
+func (fe *FileExplorer) applySubscription() {
+	folders := fe.subscribedFolders()
+
+	message := "Synchroniser tout le partage ?"
+	if len(folders) > 0 {
+		message = fmt.Sprintf("Synchroniser uniquement ces dossiers ?\n\n%s\n\n"+
+			"Les copies locales des autres dossiers sont conservées\nmais ne seront plus mises à jour.",
+			strings.Join(folders, "\n"))
+	}
+
+	dialog.ShowConfirm("Sync sélective", message, func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := fe.client.SetSubscription(folders); err != nil {
+			dialog.ShowError(err, fe.win)
+			return
+		}
+		fe.mu.Lock()
+		fe.subscriptionDirty = false
+		fe.mu.Unlock()
+		fe.showDirectoryUI()
+	}, fe.win)
+}
+
 // showFilePreview affiche la prévisualisation d'un fichier
 func (fe *FileExplorer) showFilePreview(relativePath string) {
 	addLog(fmt.Sprintf("👁️ Prévisualisation: %s", relativePath))
@@ -686,13 +814,12 @@ func (fe *FileExplorer) showFilePreview(relativePath string) {
 	fe.client.downloadChan = make(chan FileChange, 10)
 	fe.client.downloadActive = true
 
-	reqMsg := map[string]interface{}{
-		"type":   "download_request",
-		"origin": "client",
-		"items":  []string{relativePath},
+	reqMsg := DownloadRequest{
+		Type:  "download_request",
+		Items: []string{relativePath},
 	}
 
-	if err := fe.client.WriteJSONSafe(reqMsg); err != nil {
+	if err := fe.client.Send(MsgDownloadRequest, reqMsg); err != nil {
 		addLog(fmt.Sprintf("❌ Erreur demande preview: %v", err))
 		fe.client.downloadActive = false
 		return
@@ -712,12 +839,13 @@ func (fe *FileExplorer) showFilePreview(relativePath string) {
 			addLog("⏱️ Timeout prévisualisation")
 			return
 		case msg := <-fe.client.downloadChan:
-			if msg.Content == "" {
+			if msg.Content == "" && msg.LocalFile == "" {
 				addLog("❌ Fichier vide reçu")
 				return
 			}
 
-			data, err := base64.StdEncoding.DecodeString(msg.Content)
+			data, err := msg.ReadContent()
+			msg.Discard()
 			if err != nil {
 				addLog(fmt.Sprintf("❌ Erreur décodage: %v", err))
 				return
@@ -734,6 +862,61 @@ func (fe *FileExplorer) showFilePreview(relativePath string) {
 	}()
 }
 
+// showFileHistory affiche les versions précédentes d'un fichier du serveur
+func (fe *FileExplorer) showFileHistory(relativePath string) {
+	addLog(fmt.Sprintf("🕘 Historique: %s", relativePath))
+
+	go func() {
+		versions, err := fe.client.ListVersions(relativePath)
+		if err != nil {
+			addLog(fmt.Sprintf("❌ Erreur historique: %v", err))
+			dialog.ShowError(err, fe.win)
+			return
+		}
+
+		title := "Historique - " + filepath.Base(relativePath)
+		if len(versions) == 0 {
+			dialog.ShowInformation(title, "Aucune version précédente pour ce fichier", fe.win)
+			return
+		}
+
+		var dlg dialog.Dialog
+		list := container.NewVBox()
+		for _, rev := range versions {
+			rev := rev
+			text := fmt.Sprintf("%s - %s", rev.SavedAt.Format("02/01/2006 15:04:05"), FormatFileSize(rev.Size))
+			if rev.Deleted {
+				text += " (supprimé)"
+			}
+
+			restoreBtn := widget.NewButton("Restaurer", func() {
+				msg := fmt.Sprintf("Remplacer %s par la version du %s ?\nLe contenu actuel sera conservé dans l'historique.",
+					filepath.Base(relativePath), rev.SavedAt.Format("02/01/2006 15:04"))
+				dialog.ShowConfirm("Restaurer", msg, func(ok bool) {
+					if !ok {
+						return
+					}
+					if err := fe.client.RestoreVersion(relativePath, rev.ID); err != nil {
+						addLog(fmt.Sprintf("❌ Erreur restauration: %v", err))
+						dialog.ShowError(err, fe.win)
+						return
+					}
+					dlg.Hide()
+				}, fe.win)
+			})
+			restoreBtn.Importance = widget.LowImportance
+
+			list.Add(container.NewHBox(widget.NewLabel(text), layout.NewSpacer(), restoreBtn))
+		}
+
+		scroll := container.NewVScroll(list)
+		scroll.SetMinSize(fyne.NewSize(420, 250))
+
+		dlg = dialog.NewCustom(title, "Fermer", scroll, fe.win)
+		dlg.Show()
+	}()
+}
+
 // showPreviewPanel affiche le panneau de prévisualisation
 func (fe *FileExplorer) showPreviewPanel(localPath, originalPath string) {
 	addLog(fmt.Sprintf("🖼️ Affichage preview: %s", originalPath))
@@ -835,7 +1018,7 @@ func (fe *FileExplorer) deleteDirectory(dirPath string) {
 		Origin:   "client",
 	}
 
-	err := fe.client.WriteJSONSafe(change)
+	err := fe.client.Send(MsgFileChange, change)
 
 	if err != nil {
 		addLog(fmt.Sprintf("❌ Erreur suppression : %v", err))
@@ -1099,7 +1282,7 @@ func (fe *FileExplorer) performDownload(items []string, targetDir string) {
 		Items: expandedItems,
 	}
 
-	err := fe.client.WriteJSONSafe(reqMsg)
+	err := fe.client.Send(MsgDownloadRequest, reqMsg)
 
 	if err != nil {
 		addLog(fmt.Sprintf("Erreur envoi requete: %v", err))
@@ -1183,6 +1366,13 @@ func (fe *FileExplorer) saveDownloadedFile(msg FileChange, targetDir string) {
 			return
 		}
 
+		if msg.LocalFile != "" {
+			if err := moveFile(msg.LocalFile, targetPath); err != nil {
+				addLog(fmt.Sprintf("❌ Erreur écriture %s: %v", msg.FileName, err))
+			}
+			return
+		}
+
 		data, err := base64.StdEncoding.DecodeString(msg.Content)
 		if err != nil {
 			addLog(fmt.Sprintf("❌ Erreur décodage %s: %v", msg.FileName, err))
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileIdentity retourne l'identifiant d'un fichier sur son volume (périphérique
+// et inode), conservé par un renommage. "" si le système ne le fournit pas.
+func fileIdentity(path string, info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%x:%x", uint64(st.Dev), uint64(st.Ino))
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileIdentity retourne l'identifiant d'un fichier sur son volume (numéro de
+// série du volume et index NTFS), conservé par un renommage. "" si indisponible.
+func fileIdentity(path string, info os.FileInfo) string {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return ""
+	}
+
+	// FILE_FLAG_BACKUP_SEMANTICS est nécessaire pour ouvrir un dossier
+	handle, err := syscall.CreateFile(name, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return ""
+	}
+	defer syscall.CloseHandle(handle)
+
+	var data syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(handle, &data); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x:%x%08x", data.VolumeSerialNumber, data.FileIndexHigh, data.FileIndexLow)
+}
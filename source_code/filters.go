@@ -43,11 +43,20 @@ type PathFilter struct {
 	ExcludeSymlinks bool     `json:"exclude_symlinks"` // Exclure liens symboliques
 }
 
+// SubscriptionFilter limite la synchronisation à certains dossiers du partage
+// (sync sélective). Comme pour PathFilter, un dossier couvre tout son contenu;
+// ici les dossiers sont des chemins relatifs complets ("assets/icons").
+// Sans dossier, tout le partage est synchronisé.
+type SubscriptionFilter struct {
+	Folders []string `json:"folders"` // Dossiers abonnés, séparés par "/"
+}
+
 // FileFilters regroupe tous les filtres
 type FileFilters struct {
-	Extension ExtensionFilter `json:"extension"`
-	Size      SizeFilter      `json:"size"`
-	Path      PathFilter      `json:"path"`
+	Extension    ExtensionFilter    `json:"extension"`
+	Size         SizeFilter         `json:"size"`
+	Path         PathFilter         `json:"path"`
+	Subscription SubscriptionFilter `json:"subscription"`
 }
 
 // FilterConfig est la configuration complète des filtres
@@ -320,7 +329,8 @@ func (pf *PathFilter) ShouldFilter(path string) bool {
 		}
 		
 		// Vérifier les fichiers cachés
-		if pf.ExcludeHidden && strings.HasPrefix(part, ".") && part != "." && part != ".." {
+		// Les fichiers .spiralyignore restent synchronisés
+		if pf.ExcludeHidden && strings.HasPrefix(part, ".") && part != "." && part != ".." && part != IgnoreFileName {
 			return true
 		}
 		
@@ -362,6 +372,91 @@ func (pf *PathFilter) RemoveExcludedFolder(folder string) {
 	}
 }
 
+// Includes vérifie si un chemin fait partie des dossiers abonnés
+// Les dossiers parents d'un dossier abonné en font partie (sans le reste de
+// leur contenu) pour que l'arborescence qui y mène existe.
+func (sf *SubscriptionFilter) Includes(path string, isDir bool) bool {
+	if len(sf.Folders) == 0 {
+		return true
+	}
+
+	path = strings.Trim(filepath.ToSlash(path), "/")
+	for _, folder := range sf.Folders {
+		if path == folder || strings.HasPrefix(path, folder+"/") {
+			return true
+		}
+		if isDir && strings.HasPrefix(folder, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSubscribed indique si un dossier est abonné explicitement
+func (sf *SubscriptionFilter) IsSubscribed(folder string) bool {
+	folder = strings.Trim(filepath.ToSlash(folder), "/")
+	for _, f := range sf.Folders {
+		if f == folder {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFolders remplace les dossiers abonnés
+// Les chemins sont normalisés; un dossier déjà couvert par un parent est ignoré.
+func (sf *SubscriptionFilter) SetFolders(folders []string) {
+	var cleaned []string
+	for _, folder := range folders {
+		folder = strings.Trim(filepath.ToSlash(filepath.Clean(folder)), "/")
+		if folder == "" || folder == "." || !isSafeRelPath(folder) {
+			continue
+		}
+		cleaned = append(cleaned, folder)
+	}
+	sort.Strings(cleaned)
+
+	sf.Folders = nil
+	for _, folder := range cleaned {
+		if n := len(sf.Folders); n > 0 {
+			last := sf.Folders[n-1]
+			if folder == last || strings.HasPrefix(folder, last+"/") {
+				continue
+			}
+		}
+		sf.Folders = append(sf.Folders, folder)
+	}
+}
+
+// ShouldFilterPath vérifie si un chemin (fichier ou dossier) est exclu par
+// les dossiers exclus ou hors des dossiers abonnés
+func (fc *FilterConfig) ShouldFilterPath(path string) bool {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.Filters.Path.ShouldFilter(path) || !fc.Filters.Subscription.Includes(path, true)
+}
+
+// Subscription retourne les dossiers abonnés (vide: tout le partage)
+func (fc *FilterConfig) Subscription() []string {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return append([]string(nil), fc.Filters.Subscription.Folders...)
+}
+
+// SetSubscription remplace les dossiers abonnés
+func (fc *FilterConfig) SetSubscription(folders []string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.Filters.Subscription.SetFolders(folders)
+}
+
+// IncludesPath vérifie si un chemin fait partie des dossiers abonnés
+func (fc *FilterConfig) IncludesPath(path string, isDir bool) bool {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.Filters.Subscription.Includes(path, isDir)
+}
+
 // ShouldFilterFile vérifie si un fichier doit être filtré (toutes règles)
 // Les dossiers ne sont JAMAIS filtrés par extension (seulement par chemin)
 func (fc *FilterConfig) ShouldFilterFile(path string, size int64, isDir bool) bool {
@@ -370,11 +465,11 @@ func (fc *FilterConfig) ShouldFilterFile(path string, size int64, isDir bool) bo
 
 	// Les dossiers ne sont filtrés QUE par chemin (jamais par extension)
 	if isDir {
-		return fc.Filters.Path.ShouldFilter(path)
+		return fc.Filters.Path.ShouldFilter(path) || !fc.Filters.Subscription.Includes(path, true)
 	}
 
 	// Vérifier le chemin d'abord
-	if fc.Filters.Path.ShouldFilter(path) {
+	if fc.Filters.Path.ShouldFilter(path) || !fc.Filters.Subscription.Includes(path, false) {
 		return true
 	}
 
@@ -467,6 +562,11 @@ func (fc *FilterConfig) GetSummary() string {
 	if fc.Filters.Path.Enabled && len(fc.Filters.Path.ExcludedFolders) > 0 {
 		parts = append(parts, fmt.Sprintf("Dossiers exclus: %d", len(fc.Filters.Path.ExcludedFolders)))
 	}
+
+	// Sync sélective
+	if n := len(fc.Filters.Subscription.Folders); n > 0 {
+		parts = append(parts, fmt.Sprintf("Dossiers synchronisés: %d", n))
+	}
 	
 	if len(parts) == 0 {
 		return "Aucun filtre actif"
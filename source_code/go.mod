@@ -6,6 +6,8 @@ require (
 	fyne.io/fyne/v2 v2.7.2
 	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.33.0
 )
 
 require (
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -36,6 +37,7 @@ var (
 	logBufferMu     sync.Mutex         // Mutex pour le buffer de logs
 	statusBar       *StatusBar         // Barre de statut
 	shortcutHandler *ShortcutHandler   // Gestionnaire de raccourcis clavier
+	headlessMode    bool               // Mode ligne de commande (logs sur la sortie standard)
 )
 
 // Constantes pour les dimensions de fenêtre
@@ -47,7 +49,13 @@ const (
 )
 
 // main est le point d'entrée de l'application
+// Sans sous-commande, l'interface graphique est lancée
 func main() {
+	if len(os.Args) > 1 {
+		if code, handled := runCLI(os.Args[1:]); handled {
+			os.Exit(code)
+		}
+	}
 	StartGUI()
 }
 
@@ -57,6 +65,11 @@ func addLog(message string) {
 	timestamp := time.Now().Format("15:04:05")
 	logEntry := fmt.Sprintf("[%s] %s", timestamp, message)
 
+	if headlessMode {
+		fmt.Println(logEntry)
+		return
+	}
+
 	logBufferMu.Lock()
 	logBuffer = append(logBuffer, logEntry)
 	logBufferMu.Unlock()
@@ -530,7 +543,9 @@ func showHostSetup(win fyne.Window) {
 			return
 		}
 
-		showHostRunning(win, port, hostID)
+		ShowStoreUnlockDialog(win, func() {
+			showHostRunning(win, port, hostID)
+		})
 	})
 	startBtn.Importance = widget.HighImportance
 
@@ -610,7 +625,7 @@ func showHostRunning(win fyne.Window, port, hostID string) {
 	stopLoading := false
 	loadingLabel := widget.NewLabel("⠋ Démarrage du serveur...")
 
-	var currentServer *Server
+	var currentHost *Host
 
 	// Animation de chargement
 	go func() {
@@ -631,8 +646,8 @@ func showHostRunning(win fyne.Window, port, hostID string) {
 	disconnectBtn := widget.NewButton("Arrêter le serveur", func() {
 		addLog("Arrêt du serveur...")
 		stopLoading = true
-		if currentServer != nil {
-			currentServer.Stop()
+		if currentHost != nil {
+			currentHost.Stop()
 		}
 		statusBar.SetConnected(false, "")
 		showHostSetup(win)
@@ -661,27 +676,41 @@ func showHostRunning(win fyne.Window, port, hostID string) {
 				return
 			}
 			go func() {
-				sourceDir := filepath.Join(getExecutableDir(), "Spiralydata")
+				config, _ := LoadConfig()
 				timestamp := time.Now().Format("2006-01-02_15-04-05")
-				destDir := filepath.Join(uri.Path(), fmt.Sprintf("Backup_Spiralydata_%s", timestamp))
-				addLog(fmt.Sprintf("Backup vers: %s", destDir))
-				
-				err := copyDirRecursive(sourceDir, destDir)
-				if err != nil {
-					addLog(fmt.Sprintf("Erreur backup: %v", err))
-				} else {
-					addLog("Backup terminee avec succes")
+				// Un dossier de backup par partage
+				for _, share := range sharesFromConfig(config, "") {
+					name := "Spiralydata"
+					if share.Name != "" {
+						name = share.Name
+					}
+					destDir := filepath.Join(uri.Path(), fmt.Sprintf("Backup_%s_%s", name, timestamp))
+					addLog(fmt.Sprintf("Backup vers: %s", destDir))
+
+					err := copyDirRecursive(share.Path, destDir)
+					if err != nil {
+						addLog(fmt.Sprintf("Erreur backup: %v", err))
+					} else {
+						addLog("Backup terminee avec succes")
+					}
 				}
 			}()
 		}, win)
 	})
 	backupBtn.Importance = widget.MediumImportance
 
+	// Bouton corbeille du serveur
+	trashBtn := widget.NewButton("Corbeille", func() {
+		ShowHostTrashDialog(win, currentHost)
+	})
+	trashBtn.Importance = widget.MediumImportance
+
 	// Boutons d'actions Host
 	actionsContainer := container.NewHBox(
 		filterBtn,
 		securityBtn,
 		backupBtn,
+		trashBtn,
 	)
 
 	content := container.NewVBox(
@@ -737,12 +766,20 @@ func showHostRunning(win fyne.Window, port, hostID string) {
 
 		statusBar.SetConnected(true, localIP+":"+port)
 
-		currentServer = NewServer(hostID)
+		config, _ := LoadConfig()
+		shares := sharesFromConfig(config, "")
+		if err := ValidateShares(shares); err != nil {
+			addLog(fmt.Sprintf("❌ Partages invalides: %v", err))
+			serverInfoCard.SetValue("Erreur")
+			return
+		}
+
+		currentHost = NewHost(hostID, shares, config.Host)
 		addLog(fmt.Sprintf("Port: %s", port))
 		addLog(fmt.Sprintf("ID: %s", hostID))
 		addLog(fmt.Sprintf("IP Locale: %s", localIP))
 		addLog(fmt.Sprintf("IP Publique: %s", pubIP))
-		currentServer.Start(port)
+		currentHost.Start(port)
 	}()
 }
 
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -45,6 +46,27 @@ func showUserSetup(win fyne.Window) {
 		idEntry.SetText(config.HostID)
 	}
 
+	shareLabel := widget.NewLabel("Partage (vide = partage par défaut)")
+	shareLabel.Alignment = fyne.TextAlignLeading
+	shareEntry := widget.NewEntry()
+	shareEntry.SetPlaceHolder("ex: documents")
+	shareEntry.SetText(config.Share)
+
+	userLabel := widget.NewLabel("Utilisateur (si l'hôte l'exige)")
+	userLabel.Alignment = fyne.TextAlignLeading
+	userEntry := widget.NewEntry()
+	userEntry.SetPlaceHolder("ex: alice")
+	userEntry.SetText(config.Username)
+
+	passwordLabel := widget.NewLabel("Mot de passe")
+	passwordLabel.Alignment = fyne.TextAlignLeading
+	passwordEntry := widget.NewPasswordEntry()
+
+	passphraseLabel := widget.NewLabel("Phrase secrète (partage chiffré de bout en bout)")
+	passphraseLabel.Alignment = fyne.TextAlignLeading
+	passphraseEntry := widget.NewPasswordEntry()
+	passphraseEntry.SetPlaceHolder("jamais transmise à l'hôte")
+
 	syncDirLabel := widget.NewLabel("Dossier de synchronisation")
 	syncDirLabel.Alignment = fyne.TextAlignLeading
 
@@ -103,6 +125,17 @@ func showUserSetup(win fyne.Window) {
 		idLabel,
 		idEntry,
 		widget.NewSeparator(),
+		shareLabel,
+		shareEntry,
+		widget.NewSeparator(),
+		userLabel,
+		userEntry,
+		passwordLabel,
+		passwordEntry,
+		widget.NewSeparator(),
+		passphraseLabel,
+		passphraseEntry,
+		widget.NewSeparator(),
 		syncDirLabel,
 		dirContainer,
 		widget.NewSeparator(),
@@ -115,7 +148,9 @@ func showUserSetup(win fyne.Window) {
 		serverIP := serverEntry.Text
 		port := portEntry.Text
 		hostID := idEntry.Text
+		share := strings.TrimSpace(shareEntry.Text)
 		syncDir := syncDirEntry.Text
+		username := strings.TrimSpace(userEntry.Text)
 
 		if serverIP == "" || port == "" || hostID == "" {
 			addLog("IP, port et ID requis")
@@ -133,27 +168,46 @@ func showUserSetup(win fyne.Window) {
 			return
 		}
 
+		if username != "" && passwordEntry.Text == "" {
+			addLog("Mot de passe requis pour l'utilisateur " + username)
+			return
+		}
+
+		// Avec un compte, l'identité de l'utilisateur peut remplacer la phrase secrète
+		if config.E2E && passphraseEntry.Text == "" && username == "" {
+			addLog("Phrase secrète ou compte requis pour le partage chiffré de bout en bout")
+			return
+		}
+
 		serverAddr := serverIP + ":" + port
 
 		if saveCheck.Checked {
-			newConfig := &AppConfig{
-				ServerIP:      serverIP,
-				ServerPort:    port,
-				HostID:        hostID,
-				SyncDirectory: syncDir,
-				SaveConfig:    true,
-				AutoConnect:   autoConnectCheck.Checked,
-				DarkTheme:     GetCurrentTheme() == ThemeDark,
-			}
-
-			if err := SaveConfig(newConfig); err != nil {
+			// Les autres sections (filtres, partages...) sont conservées
+			newConfig := *config
+			newConfig.ServerIP = serverIP
+			newConfig.ServerPort = port
+			newConfig.HostID = hostID
+			newConfig.Share = share
+			newConfig.Username = username
+			newConfig.E2E = passphraseEntry.Text != "" || (config.E2E && username != "")
+			newConfig.SyncDirectory = syncDir
+			newConfig.SaveConfig = true
+			newConfig.AutoConnect = autoConnectCheck.Checked
+			newConfig.DarkTheme = GetCurrentTheme() == ThemeDark
+
+			if err := SaveConfig(&newConfig); err != nil {
 				addLog(fmt.Sprintf("Erreur sauvegarde config: %v", err))
 			} else {
 				addLog("Configuration sauvegardée")
 			}
 		}
 
-		showUserConnecting(win, serverAddr, hostID, syncDir)
+		var creds *Credentials
+		if username != "" || passphraseEntry.Text != "" {
+			creds = &Credentials{Username: username, Password: passwordEntry.Text, Passphrase: passphraseEntry.Text}
+			creds.E2E = passphraseEntry.Text != "" || (config.E2E && username != "")
+		}
+		showUserConnecting(win, serverAddr, hostID, share, syncDir, creds)
 	})
 	connectBtn.Importance = widget.HighImportance
 
@@ -221,6 +275,11 @@ func tryAutoConnect(win fyne.Window) bool {
 		return false
 	}
 
+	// Le mot de passe et la phrase secrète ne sont pas enregistrés: il faut les saisir
+	if config.Username != "" || config.E2E {
+		return false
+	}
+
 	addLog("Connexion automatique...")
 	serverAddr := config.ServerIP + ":" + config.ServerPort
 
@@ -229,6 +288,6 @@ func tryAutoConnect(win fyne.Window) bool {
 		syncDir = filepath.Join(getExecutableDir(), "Spiralydata")
 	}
 
-	showUserConnecting(win, serverAddr, config.HostID, syncDir)
+	showUserConnecting(win, serverAddr, config.HostID, config.Share, syncDir, nil)
 	return true
 }
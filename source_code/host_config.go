@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ============================================================================
+// CONFIGURATION DE L'HÔTE - Section "host" de spiraly_config.json
+// ============================================================================
+
+// HostConfig configuration de l'hôte, utile pour une installation en service
+// (données dans /var/lib, écoute sur une seule interface...)
+// Les limites et la sécurité sont appliquées à chaud quand le fichier change;
+// le dossier des données, l'adresse d'écoute, le certificat, les partages et le
+// chiffrement du stockage au redémarrage.
+type HostConfig struct {
+	DataDir     string       `json:"data_dir,omitempty"`     // Données de l'hôte (vide = dossier de l'exécutable)
+	BindAddress string       `json:"bind_address,omitempty"` // Interface d'écoute (vide = toutes)
+	CertFile    string       `json:"cert_file,omitempty"`    // Certificat TLS (vide = certificat auto-signé généré dans le dossier des données)
+	KeyFile     string       `json:"key_file,omitempty"`     // Clé privée du certificat TLS
+	Limits      HostLimits   `json:"limits"`
+	Security    HostSecurity `json:"security"`
+	Users       []UserConfig `json:"users,omitempty"` // Comptes (vide = ID de l'hôte seul)
+	// Chiffrement au repos: phrase secrète demandée au démarrage, ou lue dans StoreKeyFile
+	EncryptStore   bool   `json:"encrypt_store,omitempty"`   // Objets du stockage dédupliqué chiffrés
+	EncryptBackups bool   `json:"encrypt_backups,omitempty"` // Sauvegardes chiffrées
+	StoreKeyFile   string `json:"store_key_file,omitempty"`  // Fichier contenant la phrase secrète
+}
+
+// NeedsStoreKey indique si la configuration demande le chiffrement au repos
+func (hc HostConfig) NeedsStoreKey() bool {
+	return hc.EncryptStore || hc.EncryptBackups
+}
+
+// HostLimits limites appliquées aux fichiers reçus, par partage (0 = illimité)
+type HostLimits struct {
+	MaxStorageSize     int64 `json:"max_storage_size,omitempty"`     // Taille totale en bytes
+	MaxFileSize        int64 `json:"max_file_size,omitempty"`        // Taille max par fichier
+	MaxFilesCount      int64 `json:"max_files_count,omitempty"`      // Nombre max de fichiers
+	WarnStoragePercent int   `json:"warn_storage_percent,omitempty"` // Alerte à ce % (ex: 80)
+}
+
+// HostSecurity réglages des gestionnaires de sécurité (0 = valeur par défaut)
+type HostSecurity struct {
+	MaxLoginAttempts       int      `json:"max_login_attempts,omitempty"`
+	LockoutMinutes         int      `json:"lockout_minutes,omitempty"`
+	SessionTimeoutHours    int      `json:"session_timeout_hours,omitempty"`
+	IPWhitelistEnabled     bool     `json:"ip_whitelist_enabled,omitempty"`
+	IPWhitelist            []string `json:"ip_whitelist,omitempty"`
+	RateLimitRequests      int      `json:"rate_limit_requests,omitempty"` // Connexions par IP et par fenêtre
+	RateLimitWindowSeconds int      `json:"rate_limit_window_seconds,omitempty"`
+}
+
+// Valeurs par défaut des gestionnaires de sécurité (voir GLOBAL INSTANCES)
+const (
+	defaultMaxLoginAttempts = 5
+	defaultLockoutTime      = 15 * time.Minute
+	defaultLoginWindow      = 5 * time.Minute
+	defaultSessionTimeout   = 24 * time.Hour
+	defaultRateLimit        = 100
+	defaultRateWindow       = time.Minute
+)
+
+// hostConfigReloadDelay délai d'attente après une modification du fichier de
+// configuration, les éditeurs l'écrivant souvent en plusieurs fois
+const hostConfigReloadDelay = 500 * time.Millisecond
+
+var (
+	dataDirOnce sync.Once
+	dataDir     string
+
+	hostLimitsMu sync.RWMutex
+	hostLimits   HostLimits
+
+	// La liste blanche n'est remplacée que si la configuration en définit une,
+	// pour ne pas écraser celle saisie dans l'interface
+	configWhitelist bool
+)
+
+// getDataDir retourne le dossier des données de l'hôte (partage par défaut,
+// sauvegardes, stockage, journaux): host.data_dir, sinon celui de l'exécutable
+func getDataDir() string {
+	dataDirOnce.Do(func() {
+		dataDir = getExecutableDir()
+		config, err := LoadConfig()
+		if err != nil || config.Host.DataDir == "" {
+			return
+		}
+		absDir, err := filepath.Abs(config.Host.DataDir)
+		if err != nil {
+			return
+		}
+		if err := os.MkdirAll(absDir, 0755); err != nil {
+			return
+		}
+		dataDir = absDir
+	})
+	return dataDir
+}
+
+// GetHostLimits retourne les limites de l'hôte en vigueur
+func GetHostLimits() HostLimits {
+	hostLimitsMu.RLock()
+	defer hostLimitsMu.RUnlock()
+	return hostLimits
+}
+
+// ListenAddr retourne l'adresse d'écoute pour un port
+func (hc HostConfig) ListenAddr(port string) string {
+	return net.JoinHostPort(hc.BindAddress, port)
+}
+
+// applyHostConfig applique les limites, les réglages de sécurité et les comptes
+// Les clients connectés sont conservés: seules les opérations suivantes sont
+// concernées, sauf ceux des comptes révoqués, retournés.
+func applyHostConfig(hc HostConfig) []string {
+	hostLimitsMu.Lock()
+	hostLimits = hc.Limits
+	hostLimitsMu.Unlock()
+
+	sec := hc.Security
+	maxAttempts := orDefault(sec.MaxLoginAttempts, defaultMaxLoginAttempts)
+	lockout := durationOrDefault(sec.LockoutMinutes, time.Minute, defaultLockoutTime)
+	sessionTimeout := durationOrDefault(sec.SessionTimeoutHours, time.Hour, defaultSessionTimeout)
+
+	GetLoginLimiter().SetLimits(maxAttempts, lockout, defaultLoginWindow)
+	GetSessionManager().SetTimeout(sessionTimeout)
+	GetRateLimiter().SetLimits(
+		orDefault(sec.RateLimitRequests, defaultRateLimit),
+		durationOrDefault(sec.RateLimitWindowSeconds, time.Second, defaultRateWindow),
+	)
+
+	GetBackupConfig().EncryptBackups = hc.EncryptBackups
+
+	auth := GetAuthConfig()
+	auth.MaxLoginAttempts = maxAttempts
+	auth.LockoutDuration = lockout
+	auth.SessionTimeout = sessionTimeout
+
+	whitelist := GetIPWhitelist()
+	if sec.IPWhitelistEnabled || len(sec.IPWhitelist) > 0 {
+		if err := whitelist.SetIPs(sec.IPWhitelist); err != nil {
+			addLog(fmt.Sprintf("⚠️ Liste blanche ignorée: %v", err))
+		} else {
+			configWhitelist = true
+			auth.IPWhitelist = sec.IPWhitelist
+			auth.IPWhitelistEnabled = sec.IPWhitelistEnabled
+			if sec.IPWhitelistEnabled {
+				whitelist.Enable()
+			} else {
+				whitelist.Disable()
+			}
+		}
+	} else if configWhitelist {
+		// Liste blanche retirée de la configuration
+		configWhitelist = false
+		whitelist.SetIPs(nil)
+		whitelist.Disable()
+		auth.IPWhitelist = []string{}
+		auth.IPWhitelistEnabled = false
+	}
+
+	return applyUsers(hc.Users)
+}
+
+// orDefault retourne value, ou def si value n'est pas renseignée
+func orDefault(value, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
+}
+
+// durationOrDefault convertit value en durée, ou retourne def si value n'est pas renseignée
+func durationOrDefault(value int, unit, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return time.Duration(value) * unit
+}
+
+// watchConfig surveille le fichier de configuration et applique ses
+// changements à chaud jusqu'à l'arrêt de l'hôte
+// Le dossier est surveillé plutôt que le fichier, que les éditeurs remplacent.
+func (h *Host) watchConfig(ctx context.Context, current HostConfig) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		addLog("⚠️ Surveillance de la configuration impossible: " + err.Error())
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(configFilePath)); err != nil {
+		addLog("⚠️ Surveillance de la configuration impossible: " + err.Error())
+		return
+	}
+
+	reload := NewDebouncer(hostConfigReloadDelay)
+	var mu sync.Mutex
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(configFilePath) ||
+				event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			reload.Call(func() {
+				mu.Lock()
+				defer mu.Unlock()
+				current = h.reloadConfig(current)
+			})
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			addLog("⚠️ Erreur surveillance configuration: " + err.Error())
+		}
+	}
+}
+
+// reloadConfig relit la configuration et applique la section host si elle a changé
+// Retourne la configuration en vigueur.
+func (h *Host) reloadConfig(current HostConfig) HostConfig {
+	config, err := LoadConfig()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			addLog(fmt.Sprintf("⚠️ Configuration invalide, changements ignorés: %v", err))
+		}
+		return current
+	}
+
+	next := config.Host
+	if reflect.DeepEqual(next, current) {
+		return current
+	}
+
+	revoked := applyHostConfig(next)
+	addLog("🔄 Configuration de l'hôte rechargée")
+	for _, userID := range revoked {
+		addLog(fmt.Sprintf("🔒 Accès de %s révoqué", userID))
+		for _, s := range h.servers {
+			s.disconnectUser(userID)
+		}
+	}
+
+	if next.DataDir != current.DataDir || next.BindAddress != current.BindAddress ||
+		next.CertFile != current.CertFile || next.KeyFile != current.KeyFile {
+		addLog("ℹ️ Dossier des données, adresse d'écoute et certificat: appliqués au prochain démarrage")
+	}
+	return next
+}
+
+// ============================================================================
+// LIMITES DES PARTAGES
+// ============================================================================
+
+// storageUsageRefresh durée de validité de l'espace occupé calculé
+const storageUsageRefresh = 30 * time.Second
+
+// storageUsage espace occupé par les fichiers d'un partage
+// Recalculé périodiquement, et mis à jour entre-temps par les fichiers acceptés.
+type storageUsage struct {
+	mu         sync.Mutex
+	bytes      int64 // Espace occupé (dernier calcul et fichiers écrits depuis)
+	reserved   int64 // Espace réservé pour les fichiers en cours de réception
+	computedAt time.Time
+	computing  bool
+	warned     bool
+}
+
+// checkLimits vérifie qu'un fichier reçu respecte la taille maximale et le
+// nombre maximal de fichiers de l'hôte. L'espace du partage est vérifié et
+// réservé par reserveStorage.
+func (s *Server) checkLimits(relPath string, size int64) error {
+	limits := GetHostLimits()
+	if limits.MaxFileSize > 0 && size > limits.MaxFileSize {
+		return fmt.Errorf("%s dépasse la taille maximale (%s)", relPath, FormatFileSize(limits.MaxFileSize))
+	}
+
+	if limits.MaxFilesCount > 0 {
+		s.mu.Lock()
+		_, known := s.knownFiles[relPath]
+		count := int64(len(s.knownFiles))
+		s.mu.Unlock()
+		if !known && count >= limits.MaxFilesCount {
+			return fmt.Errorf("%s refusé: nombre maximal de fichiers atteint (%d)", relPath, limits.MaxFilesCount)
+		}
+	}
+	return nil
+}
+
+// reserveStorage vérifie les limites d'un fichier reçu et réserve l'espace
+// qu'il ajoute au partage, jusqu'à son écriture (commitStorage) ou son abandon
+// (releaseStorage). held est l'espace déjà réservé pour ce fichier au début de
+// son transfert: il est remplacé par la nouvelle réservation, ou libéré en cas de refus.
+func (s *Server) reserveStorage(relPath string, size, held int64) (int64, error) {
+	if err := s.checkLimits(relPath, size); err != nil {
+		s.releaseStorage(held)
+		return 0, err
+	}
+	limits := GetHostLimits()
+	if limits.MaxStorageSize <= 0 {
+		s.releaseStorage(held)
+		return 0, nil
+	}
+	delta := size - s.fileSize(relPath)
+	s.refreshUsage()
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	s.usage.reserved -= held
+	if delta <= 0 {
+		return 0, nil
+	}
+	used := s.usage.bytes + s.usage.reserved
+	if used+delta > limits.MaxStorageSize {
+		return 0, fmt.Errorf("%s refusé: espace du partage insuffisant (%s / %s)", relPath,
+			FormatFileSize(used), FormatFileSize(limits.MaxStorageSize))
+	}
+	s.usage.reserved += delta
+
+	if limits.WarnStoragePercent > 0 {
+		percent := (used + delta) * 100 / limits.MaxStorageSize
+		if percent >= int64(limits.WarnStoragePercent) && !s.usage.warned {
+			addLog(fmt.Sprintf("⚠️ Partage %s: %d%% de l'espace utilisé (%s / %s)", s.Share.DisplayName(),
+				percent, FormatFileSize(used+delta), FormatFileSize(limits.MaxStorageSize)))
+		}
+		s.usage.warned = percent >= int64(limits.WarnStoragePercent)
+	}
+	return delta, nil
+}
+
+// releaseStorage libère l'espace réservé pour un fichier abandonné
+func (s *Server) releaseStorage(reserved int64) {
+	if reserved == 0 {
+		return
+	}
+	s.usage.mu.Lock()
+	s.usage.reserved -= reserved
+	s.usage.mu.Unlock()
+}
+
+// commitStorage compte dans l'espace occupé un fichier écrit dans le partage
+func (s *Server) commitStorage(reserved int64) {
+	if reserved == 0 {
+		return
+	}
+	s.usage.mu.Lock()
+	s.usage.reserved -= reserved
+	s.usage.bytes += reserved
+	s.usage.mu.Unlock()
+}
+
+// refreshUsage recalcule l'espace occupé par le partage s'il est trop ancien
+// Le parcours du dossier se fait hors du verrou, par un seul appelant à la fois.
+func (s *Server) refreshUsage() {
+	s.usage.mu.Lock()
+	if s.usage.computing || time.Since(s.usage.computedAt) <= storageUsageRefresh {
+		s.usage.mu.Unlock()
+		return
+	}
+	s.usage.computing = true
+	s.usage.mu.Unlock()
+
+	size := shareSize(s.WatchDir)
+
+	s.usage.mu.Lock()
+	s.usage.bytes = size
+	s.usage.computedAt = time.Now()
+	s.usage.computing = false
+	s.usage.mu.Unlock()
+}
+
+// shareSize retourne la taille cumulée des fichiers d'un partage, hors dossier interne
+func shareSize(root string) int64 {
+	var size int64
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if rel, err := filepath.Rel(root, path); err == nil && isInternalPath(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size
+}
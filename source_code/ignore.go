@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// FICHIERS .spiralyignore - Exclusions par dossier (syntaxe gitignore)
+// ============================================================================
+
+// IgnoreFileName nom des fichiers d'exclusion, synchronisés avec l'arborescence
+const IgnoreFileName = ".spiralyignore"
+
+// ignoreRecheckDelay délai avant de vérifier si un fichier d'exclusion a changé
+const ignoreRecheckDelay = 2 * time.Second
+
+// ignoreRule une ligne d'un fichier .spiralyignore
+type ignoreRule struct {
+	pattern string
+	re      *regexp.Regexp // Chemin relatif au dossier du fichier .spiralyignore
+	negate  bool           // "!motif": réinclut un élément exclu par une règle précédente
+	dirOnly bool           // "motif/": ne s'applique qu'aux dossiers
+}
+
+// ignoreFile règles d'un fichier .spiralyignore et date de lecture
+type ignoreFile struct {
+	rules   []ignoreRule
+	modTime time.Time
+	size    int64
+	checked time.Time
+}
+
+// IgnoreMatcher applique les fichiers .spiralyignore d'un dossier synchronisé
+// Comme pour git, chaque fichier s'applique à son dossier et à ses sous-dossiers,
+// la dernière règle qui correspond l'emporte (les fichiers les plus profonds étant
+// lus en dernier), et un élément d'un dossier exclu ne peut pas être réinclus.
+// Les fichiers .spiralyignore eux-mêmes ne sont jamais exclus.
+type IgnoreMatcher struct {
+	root  string
+	mu    sync.Mutex
+	files map[string]*ignoreFile // Par dossier relatif ("" pour la racine)
+}
+
+// NewIgnoreMatcher crée le gestionnaire des fichiers .spiralyignore de root
+func NewIgnoreMatcher(root string) *IgnoreMatcher {
+	return &IgnoreMatcher{
+		root:  root,
+		files: make(map[string]*ignoreFile),
+	}
+}
+
+// Ignored vérifie si un chemin relatif est exclu par les fichiers .spiralyignore
+func (m *IgnoreMatcher) Ignored(relPath string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+
+	relPath = strings.Trim(filepath.ToSlash(relPath), "/")
+	if relPath == "" || path.Base(relPath) == IgnoreFileName {
+		return false
+	}
+
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matches(parts[:i], true) {
+			return true // Dossier parent exclu
+		}
+	}
+	return m.matches(parts, isDir)
+}
+
+// IgnoredPath comme Ignored, en déterminant sur le disque s'il s'agit d'un dossier
+func (m *IgnoreMatcher) IgnoredPath(relPath string) bool {
+	if m == nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(m.root, filepath.FromSlash(relPath)))
+	return m.Ignored(relPath, err == nil && info.IsDir())
+}
+
+// matches évalue les règles des dossiers parents d'un élément, de la racine
+// au dossier qui le contient
+func (m *IgnoreMatcher) matches(parts []string, isDir bool) bool {
+	ignored := false
+	for depth := 0; depth < len(parts); depth++ {
+		dir := strings.Join(parts[:depth], "/")
+		rel := strings.Join(parts[depth:], "/")
+		for _, rule := range m.rulesFor(dir) {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// rulesFor retourne les règles du fichier .spiralyignore d'un dossier
+// Le fichier est relu quand sa date ou sa taille change
+func (m *IgnoreMatcher) rulesFor(dir string) []ignoreRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cached := m.files[dir]
+	if cached != nil && time.Since(cached.checked) < ignoreRecheckDelay {
+		return cached.rules
+	}
+
+	fullPath := filepath.Join(m.root, filepath.FromSlash(dir), IgnoreFileName)
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		m.files[dir] = &ignoreFile{checked: time.Now()}
+		return nil
+	}
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		cached.checked = time.Now()
+		return cached.rules
+	}
+
+	rules, err := loadIgnoreFile(fullPath)
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ Lecture de %s impossible: %v", path.Join(dir, IgnoreFileName), err))
+	}
+	m.files[dir] = &ignoreFile{
+		rules:   rules,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		checked: time.Now(),
+	}
+	return rules
+}
+
+// loadIgnoreFile lit les règles d'un fichier .spiralyignore
+// Les lignes invalides sont ignorées
+func loadIgnoreFile(fullPath string) ([]ignoreRule, error) {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// parseIgnoreLine convertit une ligne au format gitignore en règle
+// Retourne false pour une ligne vide, un commentaire ou un motif invalide
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	// Un motif contenant "/" est ancré au dossier du fichier .spiralyignore,
+	// sinon il correspond au nom d'un élément à n'importe quelle profondeur
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+
+	re, err := regexp.Compile(ignorePatternToRegexp(line))
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	rule.re = re
+	return rule, true
+}
+
+// ignorePatternToRegexp traduit un motif gitignore en expression régulière
+// "*" et "?" ne traversent pas les "/", "**" correspond à zéro ou plusieurs dossiers
+func ignorePatternToRegexp(pattern string) string {
+	p := []rune(pattern)
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '*':
+			if i+1 < len(p) && p[i+1] == '*' {
+				atStart := i == 0 || p[i-1] == '/'
+				atEnd := i+2 == len(p) || p[i+2] == '/'
+				if atStart && atEnd {
+					if i+2 == len(p) {
+						b.WriteString(".*") // "dossier/**": tout son contenu
+						i++
+					} else {
+						b.WriteString("(?:.*/)?") // "**/": zéro ou plusieurs dossiers
+						i += 2
+					}
+					continue
+				}
+				i++ // "**" collé à un nom: comme "*"
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := i + 1
+			if end < len(p) && (p[end] == '!' || p[end] == '^') {
+				end++
+			}
+			if end < len(p) && p[end] == ']' {
+				end++
+			}
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end >= len(p) {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString("[")
+			class := p[i+1 : end]
+			if len(class) > 0 && (class[0] == '!' || class[0] == '^') {
+				b.WriteString("^")
+				class = class[1:]
+			}
+			for _, r := range class {
+				if r == '\\' || r == '[' || r == ']' {
+					b.WriteRune('\\')
+				}
+				b.WriteRune(r)
+			}
+			b.WriteString("]")
+			i = end
+		case '\\':
+			if i+1 < len(p) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(p[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
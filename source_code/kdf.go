@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ============================================================================
+// DÉRIVATION DE CLÉS - argon2id avec sel aléatoire et paramètres enregistrés
+// ============================================================================
+
+// Paramètres argon2id par défaut (RFC 9106, seconde recommandation)
+const (
+	kdfAlgorithm = "argon2id"
+	kdfTime      = 3
+	kdfMemory    = 64 * 1024 // KiB
+	kdfThreads   = 4
+	kdfSaltLen   = 16
+	kdfKeyLen    = 32
+)
+
+// errInvalidKDF chaîne de paramètres de dérivation illisible
+var errInvalidKDF = errors.New("paramètres de dérivation invalides")
+
+// KDFParams paramètres de dérivation d'une clé ou d'un hash de mot de passe
+// Ils sont enregistrés avec chaque hash (format PHC) ou donnée chiffrée, pour
+// pouvoir renforcer les valeurs par défaut sans invalider l'existant.
+type KDFParams struct {
+	Salt    []byte
+	Time    uint32
+	Memory  uint32 // KiB
+	Threads uint8
+}
+
+// NewKDFParams crée des paramètres par défaut avec un sel aléatoire
+func NewKDFParams() KDFParams {
+	salt := make([]byte, kdfSaltLen)
+	rand.Read(salt) // Ne retourne jamais d'erreur
+	return KDFParams{Salt: salt, Time: kdfTime, Memory: kdfMemory, Threads: kdfThreads}
+}
+
+// IsCurrent indique si les paramètres correspondent aux valeurs par défaut actuelles
+func (p KDFParams) IsCurrent() bool {
+	return p.Time == kdfTime && p.Memory == kdfMemory && p.Threads == kdfThreads && len(p.Salt) >= kdfSaltLen
+}
+
+// String retourne les paramètres au format PHC: $argon2id$v=19$m=65536,t=3,p=4$<sel>
+func (p KDFParams) String() string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s",
+		kdfAlgorithm, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(p.Salt))
+}
+
+// ParseKDFParams lit des paramètres au format PHC
+// Retourne aussi ce qui suit le sel (le hash d'un mot de passe, "" sinon).
+func ParseKDFParams(s string) (KDFParams, string, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) < 5 || parts[0] != "" || parts[1] != kdfAlgorithm {
+		return KDFParams{}, "", errInvalidKDF
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return KDFParams{}, "", fmt.Errorf("%w: version %s", errInvalidKDF, parts[2])
+	}
+	var p KDFParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return KDFParams{}, "", fmt.Errorf("%w: %s", errInvalidKDF, parts[3])
+	}
+	if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return KDFParams{}, "", errInvalidKDF
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return KDFParams{}, "", fmt.Errorf("%w: sel", errInvalidKDF)
+	}
+	p.Salt = salt
+
+	rest := ""
+	if len(parts) > 5 {
+		rest = strings.Join(parts[5:], "$")
+	}
+	return p, rest, nil
+}
+
+// DeriveKey dérive une clé de 32 octets (AES-256) d'un mot de passe
+func DeriveKey(password string, params KDFParams) []byte {
+	return argon2.IDKey([]byte(password), params.Salt, params.Time, params.Memory, params.Threads, kdfKeyLen)
+}
+
+// HashPassword hash un mot de passe avec argon2id et un sel aléatoire
+// Le résultat contient les paramètres: $argon2id$v=19$m=...,t=...,p=...$<sel>$<hash>
+func HashPassword(password string) string {
+	params := NewKDFParams()
+	return params.String() + "$" + base64.RawStdEncoding.EncodeToString(DeriveKey(password, params))
+}
+
+// VerifyPasswordHash compare un mot de passe à un hash enregistré
+// needsUpgrade indique un hash à remplacer par HashPassword: ancien format
+// SHA-256, ou paramètres différents des valeurs actuelles.
+func VerifyPasswordHash(hash, password string) (ok, needsUpgrade bool) {
+	if hash == "" {
+		return false, false
+	}
+	if !strings.HasPrefix(hash, "$") {
+		legacy := legacyHashPassword(password)
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(legacy)) == 1, true
+	}
+
+	params, encoded, err := ParseKDFParams(hash)
+	if err != nil {
+		return false, false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(expected) == 0 {
+		return false, false
+	}
+	computed := argon2.IDKey([]byte(password), params.Salt, params.Time, params.Memory, params.Threads, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(expected, computed) != 1 {
+		return false, false
+	}
+	return true, !params.IsCurrent()
+}
+
+// legacyHashPassword ancien hash SHA-256 à sel fixe, vérifié uniquement pour
+// la migration des hashes existants
+func legacyHashPassword(password string) string {
+	h := sha256.New()
+	h.Write([]byte("spiralydata_salt_"))
+	h.Write([]byte(password))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// legacyDeriveKey ancienne dérivation SHA-256, pour relire les données chiffrées avant argon2id
+func legacyDeriveKey(password, salt string) []byte {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(password))
+	return h.Sum(nil)
+}
@@ -676,7 +676,7 @@ func init() {
 	globalAdvancedLogger = NewAdvancedLogger(5000)
 	
 	// Configurer le répertoire de logs
-	logDir := filepath.Join(getExecutableDir(), "logs")
+	logDir := filepath.Join(getDataDir(), "logs")
 	globalAdvancedLogger.SetLogDir(logDir)
 }
 
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ============================================================================
+// FUSION À TROIS VOIES (diff3)
+// ============================================================================
+//
+// La version de base est celle de la dernière synchronisation (voir
+// SyncState.Ancestor). Les deux versions sont comparées ligne à ligne à la base:
+//   - les zones modifiées d'un seul côté sont reprises telles quelles
+//   - les zones modifiées de la même façon des deux côtés ne sont gardées qu'une fois
+//   - les zones modifiées différemment des deux côtés sont entourées de marqueurs
+
+const (
+	mergeMarkerLocal  = "<<<<<<< local"
+	mergeMarkerSep    = "======="
+	mergeMarkerRemote = ">>>>>>> remote"
+
+	// maxMergeEdits nombre maximal de lignes ajoutées ou supprimées entre la
+	// base et une version: au-delà, la comparaison ligne à ligne serait trop
+	// longue et les deux versions sont gardées
+	maxMergeEdits = 4000
+)
+
+// ErrMergeTooLarge les versions diffèrent trop de la base pour être fusionnées
+var ErrMergeTooLarge = errors.New("versions trop différentes pour une fusion automatique")
+
+// MergeResult résultat d'une fusion à trois voies
+type MergeResult struct {
+	Content   []byte
+	Clean     bool // Aucune zone en conflit
+	Conflicts int  // Nombre de zones entourées de marqueurs
+}
+
+// Merge3 fusionne local et remote à partir de leur ancêtre commun base
+// Retourne ErrMergeTooLarge si une version s'écarte trop de la base.
+func Merge3(base, local, remote []byte) (MergeResult, error) {
+	if bytes.Equal(local, remote) {
+		return MergeResult{Content: local, Clean: true}, nil
+	}
+	if bytes.Equal(base, local) {
+		return MergeResult{Content: remote, Clean: true}, nil
+	}
+	if bytes.Equal(base, remote) {
+		return MergeResult{Content: local, Clean: true}, nil
+	}
+
+	o := splitLines(base)
+	a := splitLines(local)
+	b := splitLines(remote)
+
+	matchA, okA := matchLines(o, a)
+	matchB, okB := matchLines(o, b)
+	if !okA || !okB {
+		return MergeResult{}, ErrMergeTooLarge
+	}
+
+	var out bytes.Buffer
+	result := MergeResult{Clean: true}
+	lo, la, lb := 0, 0, 0
+
+	for lo < len(o) || la < len(a) || lb < len(b) {
+		// Zone stable: lignes de la base conservées des deux côtés, dans l'ordre
+		k := 0
+		for lo+k < len(o) && matchA[lo+k] == la+k && matchB[lo+k] == lb+k {
+			k++
+		}
+		if k > 0 {
+			writeLines(&out, o[lo:lo+k])
+			lo, la, lb = lo+k, la+k, lb+k
+			continue
+		}
+
+		// Zone instable: jusqu'à la prochaine ligne de base présente des deux côtés
+		next, ja, jb := len(o), len(a), len(b)
+		for i := lo; i < len(o); i++ {
+			if matchA[i] >= 0 && matchB[i] >= 0 {
+				next, ja, jb = i, matchA[i], matchB[i]
+				break
+			}
+		}
+
+		chunkO, chunkA, chunkB := o[lo:next], a[la:ja], b[lb:jb]
+		switch {
+		case equalLines(chunkA, chunkO):
+			writeLines(&out, chunkB)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			writeLines(&out, chunkA)
+		default:
+			result.Clean = false
+			result.Conflicts++
+			writeMarker(&out, mergeMarkerLocal)
+			writeLines(&out, chunkA)
+			writeMarker(&out, mergeMarkerSep)
+			writeLines(&out, chunkB)
+			writeMarker(&out, mergeMarkerRemote)
+		}
+		lo, la, lb = next, ja, jb
+	}
+
+	result.Content = out.Bytes()
+	return result, nil
+}
+
+// splitLines découpe un texte en lignes en conservant les fins de ligne
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.SplitAfter(string(data), "\n")
+}
+
+func equalLines(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeMarker écrit un marqueur de conflit sur sa propre ligne
+func writeMarker(out *bytes.Buffer, marker string) {
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	out.WriteString(marker)
+	out.WriteByte('\n')
+}
+
+// matchLines associe chaque ligne de x à la ligne de y correspondante dans
+// une plus longue sous-séquence commune (-1 si la ligne n'est pas conservée)
+// Retourne false si les deux textes diffèrent de plus de maxMergeEdits lignes.
+func matchLines(x, y []string) ([]int, bool) {
+	match := make([]int, len(x))
+	for i := range match {
+		match[i] = -1
+	}
+
+	d := lineDiff{x: x, y: y}
+	if !d.compare(0, len(x), 0, len(y), maxMergeEdits) {
+		return nil, false
+	}
+	for _, p := range d.pairs {
+		match[p[0]] = p[1]
+	}
+	return match, true
+}
+
+// lineDiff calcule le plus court script d'édition entre deux textes
+// (algorithme de Myers en espace linéaire: le chemin est coupé en deux à son
+// "serpent du milieu", puis chaque moitié est comparée récursivement).
+// Mémoire en O(N+M), temps en O((N+M)·D).
+type lineDiff struct {
+	x, y  []string
+	pairs [][2]int // Lignes identiques conservées (i, j), dans l'ordre
+}
+
+// compare ajoute les lignes conservées entre x[x0:x1] et y[y0:y1]
+// limit borne le nombre d'éditions (lignes ajoutées ou supprimées)
+func (d *lineDiff) compare(x0, x1, y0, y1, limit int) bool {
+	// Préfixe et suffixe communs: pas besoin de les comparer
+	for x0 < x1 && y0 < y1 && d.x[x0] == d.y[y0] {
+		d.pairs = append(d.pairs, [2]int{x0, y0})
+		x0++
+		y0++
+	}
+	suffix := 0
+	for x1-suffix > x0 && y1-suffix > y0 && d.x[x1-suffix-1] == d.y[y1-suffix-1] {
+		suffix++
+	}
+	x1 -= suffix
+	y1 -= suffix
+
+	// Sans préfixe ni suffixe commun, il reste au moins deux éditions:
+	// chaque moitié autour du serpent du milieu en a strictement moins
+	if x0 < x1 && y0 < y1 {
+		sx, sy, ex, ey, ok := d.middleSnake(x0, x1, y0, y1, limit)
+		if !ok {
+			return false
+		}
+		d.compare(x0, sx, y0, sy, x1-x0+y1-y0)
+		for i := sx; i < ex; i++ {
+			d.pairs = append(d.pairs, [2]int{i, sy + i - sx})
+		}
+		d.compare(ex, x1, ey, y1, x1-x0+y1-y0)
+	} else if x1-x0+y1-y0 > limit {
+		return false
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.pairs = append(d.pairs, [2]int{x1 + i, y1 + i})
+	}
+	return true
+}
+
+// middleSnake cherche le serpent du milieu entre x[x0:x1] et y[y0:y1] en
+// avançant à la fois depuis le début et depuis la fin. Retourne ses extrémités
+// (début sx, sy et fin ex, ey), ou false si le script dépasse limit éditions.
+func (d *lineDiff) middleSnake(x0, x1, y0, y1, limit int) (int, int, int, int, bool) {
+	x, y := d.x[x0:x1], d.y[y0:y1]
+	n, m := len(x), len(y)
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+
+	// forward[k]: x le plus loin atteint sur la diagonale k depuis le début
+	// backward[k]: idem depuis la fin, sur les textes parcourus à l'envers
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+
+	for step := 0; step <= maxD; step++ {
+		if 2*step-1 > limit {
+			return 0, 0, 0, 0, false
+		}
+
+		for k := -step; k <= step; k += 2 {
+			var i int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				i = forward[offset+k+1]
+			} else {
+				i = forward[offset+k-1] + 1
+			}
+			j := i - k
+			si, sj := i, j
+			for i < n && j < m && x[i] == y[j] {
+				i++
+				j++
+			}
+			forward[offset+k] = i
+
+			// Chevauchement avec un chemin de l'étape précédente depuis la fin
+			if kr := delta - k; odd && kr >= -(step-1) && kr <= step-1 && i+backward[offset+kr] >= n {
+				if 2*step-1 > limit {
+					return 0, 0, 0, 0, false
+				}
+				return x0 + si, y0 + sj, x0 + i, y0 + j, true
+			}
+		}
+
+		for kr := -step; kr <= step; kr += 2 {
+			var i int
+			if kr == -step || (kr != step && backward[offset+kr-1] < backward[offset+kr+1]) {
+				i = backward[offset+kr+1]
+			} else {
+				i = backward[offset+kr-1] + 1
+			}
+			j := i - kr
+			si, sj := i, j
+			for i < n && j < m && x[n-1-i] == y[m-1-j] {
+				i++
+				j++
+			}
+			backward[offset+kr] = i
+
+			// Chevauchement avec un chemin de la même étape depuis le début
+			if k := delta - kr; !odd && k >= -step && k <= step && forward[offset+k]+i >= n {
+				if 2*step > limit {
+					return 0, 0, 0, 0, false
+				}
+				return x0 + n - i, y0 + m - j, x0 + n - si, y0 + m - sj, true
+			}
+		}
+	}
+
+	return 0, 0, 0, 0, false
+}
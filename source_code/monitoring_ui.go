@@ -470,7 +470,11 @@ func createBackupTab(window fyne.Window) fyne.CanvasObject {
 			}
 			backup := backups[len(backups)-1-id]
 			box := item.(*fyne.Container)
-			box.Objects[0].(*widget.Label).SetText(backup.ID)
+			if backup.Encrypted {
+				box.Objects[0].(*widget.Label).SetText("🔒 " + backup.ID)
+			} else {
+				box.Objects[0].(*widget.Label).SetText(backup.ID)
+			}
 			box.Objects[2].(*widget.Label).SetText(FormatFileSize(backup.Size))
 			box.Objects[3].(*widget.Label).SetText(backup.CreatedAt.Format("02/01 15:04"))
 		},
@@ -532,10 +536,24 @@ func createBackupTab(window fyne.Window) fyne.CanvasObject {
 		}, window)
 	})
 	
+	cleanBtn := widget.NewButtonWithIcon("Nettoyer stockage", theme.DeleteIcon(), func() {
+		go func() {
+			removed, freed, err := GetChunkStore().GC()
+			if err != nil {
+				dialog.ShowError(err, window)
+				return
+			}
+			stats := GetChunkStore().Stats()
+			dialog.ShowInformation("Stockage", fmt.Sprintf(
+				"%d morceaux supprimes (%s liberes)\n%d morceaux conserves (%s)",
+				removed, FormatFileSize(freed), stats.Chunks, FormatFileSize(stats.StoredSize)), window)
+		}()
+	})
+	
 	return container.NewVBox(
 		widget.NewLabelWithStyle("Sauvegardes", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		backupsList,
-		container.NewHBox(createBackupBtn, restoreBtn),
+		container.NewHBox(createBackupBtn, restoreBtn, cleanBtn),
 	)
 }
 
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DÉTECTION DES DÉPLACEMENTS
+// ============================================================================
+//
+// Un renommage apparaît comme un chemin disparu et un chemin nouveau. Ils sont
+// associés si l'élément nouveau a le même identifiant sur le volume (inode)
+// que l'ancien lors de la dernière synchronisation, ou, pour un fichier, la
+// même taille et le même hash. Le déplacement est alors envoyé comme une
+// opération "move" appliquée par os.Rename chez les pairs, sans transfert.
+//
+// Le watcher associe les chemins dès leur apparition; le scan périodique
+// associe ceux apparus et disparus entre deux passages.
+
+// FileMove déplacement détecté d'un fichier ou d'un dossier
+type FileMove struct {
+	From  string
+	To    string
+	IsDir bool
+}
+
+// DetectMoves associe des chemins disparus du disque à des chemins apparus
+// Seuls les déplacements de plus haut niveau sont retournés: celui d'un
+// dossier couvre tout son contenu.
+func (st *SyncState) DetectMoves(vanished, appeared []string) []FileMove {
+	if st == nil || len(vanished) == 0 || len(appeared) == 0 {
+		return nil
+	}
+
+	candidates := make(map[string]SyncRecord)
+	st.mu.RLock()
+	for _, path := range vanished {
+		if rec, ok := st.records[path]; ok {
+			candidates[path] = *rec
+		}
+	}
+	st.mu.RUnlock()
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Les dossiers parents d'abord
+	sorted := make([]string, len(appeared))
+	copy(sorted, appeared)
+	sort.Slice(sorted, func(i, j int) bool {
+		di, dj := strings.Count(sorted[i], "/"), strings.Count(sorted[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	var moves []FileMove
+	for _, to := range sorted {
+		if movedWithParent(moves, to) {
+			continue
+		}
+		from, isDir, ok := st.matchMove(to, candidates, nil)
+		if !ok {
+			continue
+		}
+		moves = append(moves, FileMove{From: from, To: to, IsDir: isDir})
+		for path := range candidates {
+			if isSameOrChild(path, from) {
+				delete(candidates, path)
+			}
+		}
+	}
+	return moves
+}
+
+// MovedFrom cherche l'ancien chemin d'un élément qui vient d'apparaître
+// parmi les chemins enregistrés qui n'existent plus sur le disque
+func (st *SyncState) MovedFrom(relPath string) (FileMove, bool) {
+	if st == nil {
+		return FileMove{}, false
+	}
+
+	candidates := make(map[string]SyncRecord)
+	st.mu.RLock()
+	for path, rec := range st.records {
+		if !isSameOrChild(path, relPath) {
+			candidates[path] = *rec
+		}
+	}
+	st.mu.RUnlock()
+
+	// L'existence n'est vérifiée que pour les candidats qui correspondent
+	gone := func(path string) bool {
+		_, err := os.Lstat(filepath.Join(st.root, filepath.FromSlash(path)))
+		return os.IsNotExist(err)
+	}
+
+	from, isDir, ok := st.matchMove(relPath, candidates, gone)
+	if !ok {
+		return FileMove{}, false
+	}
+	return FileMove{From: from, To: relPath, IsDir: isDir}, true
+}
+
+// matchMove retourne le candidat qui correspond le mieux à l'élément to
+// Par ordre de préférence: même identifiant (et même taille pour un fichier),
+// même hash et même nom, même hash.
+func (st *SyncState) matchMove(to string, candidates map[string]SyncRecord, accept func(string) bool) (string, bool, bool) {
+	fullPath := filepath.Join(st.root, filepath.FromSlash(to))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", false, false
+	}
+	isDir := info.IsDir()
+	id := fileIdentity(fullPath, info)
+
+	hash := ""
+	hashOf := func() string {
+		if hash == "" {
+			hash, _ = GetHashCache().GetHash(fullPath)
+		}
+		return hash
+	}
+
+	best, bestScore := "", 0
+	for path, rec := range candidates {
+		if rec.IsDir != isDir {
+			continue
+		}
+
+		score := 0
+		switch {
+		case id != "" && rec.FileID == id && (isDir || rec.Size == info.Size()):
+			score = 3
+		case isDir || rec.Size != info.Size() || rec.Size == 0 || rec.Hash == "":
+			continue
+		case rec.Hash == hashOf():
+			score = 1
+			if filepath.Base(path) == filepath.Base(to) {
+				score = 2
+			}
+		}
+
+		if score == 0 || score < bestScore || (score == bestScore && path > best) {
+			continue
+		}
+		if accept != nil && !accept(path) {
+			continue
+		}
+		best, bestScore = path, score
+	}
+	return best, isDir, bestScore > 0
+}
+
+// diffPaths retourne les chemins connus absents du scan et les chemins du scan
+// inconnus, candidats à DetectMoves. Les chemins pour lesquels skip retourne
+// true (en cours de synchronisation) sont ignorés.
+func diffPaths(knownFiles, knownDirs, files, dirs map[string]time.Time, skip func(string) bool) (vanished, appeared []string) {
+	collect := func(from, in map[string]time.Time, out *[]string) {
+		for path := range from {
+			if _, exists := in[path]; !exists && (skip == nil || !skip(path)) {
+				*out = append(*out, path)
+			}
+		}
+	}
+	collect(knownDirs, dirs, &vanished)
+	collect(knownFiles, files, &vanished)
+	collect(dirs, knownDirs, &appeared)
+	collect(files, knownFiles, &appeared)
+	return vanished, appeared
+}
+
+// movedWithParent indique si path est dans un dossier déjà déplacé
+func movedWithParent(moves []FileMove, path string) bool {
+	for _, m := range moves {
+		if m.IsDir && strings.HasPrefix(path, m.To+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSameOrChild indique si path est base ou se trouve dans base
+func isSameOrChild(path, base string) bool {
+	return path == base || strings.HasPrefix(path, base+"/")
+}
+
+// movePaths reporte un déplacement dans une table chemin -> date
+func movePaths(paths map[string]time.Time, from, to string) {
+	for path, t := range paths {
+		if isSameOrChild(path, from) {
+			delete(paths, path)
+			paths[to+path[len(from):]] = t
+		}
+	}
+}
+
+// renameSyncedPath applique un déplacement reçu dans le dossier root
+// L'élément déjà présent à la destination est mis à la corbeille.
+// Retourne os.ErrNotExist si la source n'existe pas (ou plus) localement.
+func renameSyncedPath(root string, trash *Trash, move FileMove, author string) error {
+	source := filepath.Join(root, filepath.FromSlash(move.From))
+	target := filepath.Join(root, filepath.FromSlash(move.To))
+
+	if _, err := os.Lstat(source); err != nil {
+		return os.ErrNotExist
+	}
+	if _, err := os.Lstat(target); err == nil {
+		if trash != nil {
+			err = trash.Remove(move.To, author)
+		} else {
+			err = os.RemoveAll(target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err != nil {
+		return err
+	}
+
+	GetChunkStore().MoveTracked(source, target)
+	return nil
+}
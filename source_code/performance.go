@@ -465,9 +465,10 @@ type FileHashCache struct {
 
 // HashEntry entrée de hash
 type HashEntry struct {
-	Hash    string
-	ModTime time.Time
-	Size    int64
+	Hash      string
+	ModTime   time.Time
+	Size      int64
+	Signature *FileSignature // Signature par blocs (delta), calculée à la demande
 }
 
 // NewFileHashCache crée un cache de hash
@@ -508,6 +509,41 @@ func (fhc *FileHashCache) GetHash(path string) (string, error) {
 	return hash, nil
 }
 
+// GetSignature récupère ou calcule la signature par blocs et le hash d'un fichier
+func (fhc *FileHashCache) GetSignature(path string) (*FileSignature, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if info.IsDir() {
+		return nil, "", fmt.Errorf("%s est un dossier", path)
+	}
+	
+	fhc.mu.RLock()
+	entry, ok := fhc.hashes[path]
+	fhc.mu.RUnlock()
+	
+	if ok && entry.Signature != nil && entry.ModTime.Equal(info.ModTime()) && entry.Size == info.Size() {
+		return entry.Signature, entry.Hash, nil
+	}
+	
+	sig, hash, err := computeSignature(path)
+	if err != nil {
+		return nil, "", err
+	}
+	
+	fhc.mu.Lock()
+	fhc.hashes[path] = &HashEntry{
+		Hash:      hash,
+		ModTime:   info.ModTime(),
+		Size:      info.Size(),
+		Signature: sig,
+	}
+	fhc.mu.Unlock()
+	
+	return sig, hash, nil
+}
+
 // Invalidate invalide une entrée
 func (fhc *FileHashCache) Invalidate(path string) {
 	fhc.mu.Lock()
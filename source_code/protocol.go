@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// PROTOCOLE - Enveloppe versionnée et négociation des capacités
+// ============================================================================
+
+// Versions du protocole
+// La version 1 correspond aux anciens messages JSON bruts (sans enveloppe)
+const (
+	LegacyProtocolVersion = 1
+	ProtocolVersion       = 2
+)
+
+// Types de messages
+const (
+	MsgFileChange       = "file_change"
+	MsgRequestAllFiles  = "request_all_files"
+	MsgBackupRequest    = "backup_request"
+	MsgRequestFileTree  = "request_file_tree"
+	MsgDownloadRequest  = "download_request"
+	MsgFileTreeItem     = "file_tree_item"
+	MsgFileTreeComplete = "file_tree_complete"
+	MsgError            = "error"
+	MsgTransferBegin    = "transfer_begin"
+	MsgTransferCommit   = "transfer_commit"
+	MsgTransferAbort    = "transfer_abort"
+	MsgDeltaOffer       = "delta_offer"
+	MsgDeltaSignature   = "delta_signature"
+	MsgSyncManifest     = "sync_manifest"
+	MsgListVersions     = "list_versions"
+	MsgVersionList      = "version_list"
+	MsgRestoreVersion   = "restore_version"
+	MsgListTrash        = "list_trash"
+	MsgTrashList        = "trash_list"
+	MsgRestoreTrash     = "restore_trash"
+	MsgSetSubscription  = "set_subscription"
+	MsgSetFilters       = "set_filters"
+	MsgFilterReport     = "filter_report"
+	MsgAccessDenied     = "access_denied"
+	MsgE2EKeyring       = "e2e_keyring"
+)
+
+// Capacités négociables lors de l'authentification
+const (
+	CapCompression = "compression" // Contenu des fichiers compressé en gzip
+	CapChunked     = "chunked"     // Transfert des gros fichiers par morceaux binaires
+	CapResume      = "resume"      // Reprise des transferts interrompus
+	CapDelta       = "delta"       // Envoi des seuls blocs modifiés des gros fichiers
+	CapManifest    = "manifest"    // Synchronisation initiale à partir d'un manifeste
+	CapVersions    = "versions"    // Historique des versions des fichiers de l'hôte
+	CapTrash       = "trash"       // Corbeille de l'hôte consultable et restaurable
+	CapMove        = "move"        // Renommages envoyés comme déplacements, sans contenu
+	CapSubscribe   = "subscribe"   // Sync sélective: seuls les dossiers abonnés sont envoyés
+	CapFilters     = "filters"     // Filtres du client appliqués par le serveur avant l'envoi
+	CapShares      = "shares"      // Partage choisi dans auth_request, parmi ceux de l'hôte
+	CapUsers       = "users"       // Compte utilisateur: jeton de reconnexion dans auth_success
+	CapE2E         = "e2e"         // Partage chiffré de bout en bout: l'hôte ne voit que du contenu chiffré
+)
+
+// supportedCapabilities liste les capacités implémentées par cette version
+var supportedCapabilities = []string{
+	CapCompression,
+	CapChunked,
+	CapResume,
+	CapDelta,
+	CapManifest,
+	CapVersions,
+	CapTrash,
+	CapMove,
+	CapSubscribe,
+	CapFilters,
+	CapShares,
+	CapUsers,
+	CapE2E,
+}
+
+// e2eExcludedCapabilities capacités inutiles ou impossibles quand les clients
+// chiffrent les noms et le contenu: l'hôte ne peut ni compresser, ni comparer
+// des blocs, ni filtrer par nom de fichier
+var e2eExcludedCapabilities = []string{CapCompression, CapDelta, CapSubscribe, CapFilters}
+
+// clientCapabilities retourne les capacités annoncées par un client, selon
+// qu'il chiffre de bout en bout ou non
+func clientCapabilities(e2e bool) []string {
+	if !e2e {
+		return withoutCapability(supportedCapabilities, CapE2E)
+	}
+	caps := []string{}
+	for _, c := range supportedCapabilities {
+		if !hasCapability(e2eExcludedCapabilities, c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// ErrCodeMoveSourceMissing code d'erreur renvoyé quand l'ancien chemin d'un
+// déplacement n'existe pas chez le destinataire: l'émetteur renvoie le contenu
+const ErrCodeMoveSourceMissing = "move_source_missing"
+
+// Codes d'erreur renvoyés quand le mode ou les accès du partage interdisent
+// une opération: modification (lecture seule) ou réception (envoi seul)
+const (
+	ErrCodeShareReadOnly  = "share_read_only"
+	ErrCodeShareWriteOnly = "share_write_only"
+)
+
+// ErrCodeLimitExceeded code d'erreur renvoyé quand un fichier reçu dépasse
+// les limites de l'hôte (taille, nombre de fichiers ou espace du partage)
+const ErrCodeLimitExceeded = "limit_exceeded"
+
+// ErrCodeE2EKeyring code d'erreur d'un trousseau refusé par l'hôte: il ne
+// correspond pas à celui du partage (autre phrase secrète, clés manquantes)
+const ErrCodeE2EKeyring = "e2e_keyring_rejected"
+
+// ErrCodeAccessDenied code d'erreur d'un accès refusé, pour les clients sans la
+// capacité "users" (les autres reçoivent un message access_denied)
+const ErrCodeAccessDenied = "access_denied"
+
+// Erreurs de décodage des messages
+var (
+	ErrUntypedMessage  = errors.New("message sans type")
+	ErrUnknownMessage  = errors.New("type de message inconnu")
+	ErrInvalidEnvelope = errors.New("enveloppe invalide")
+)
+
+// Envelope est l'enveloppe commune à tous les messages du protocole v2
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Version int             `json:"v"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// ErrorMessage est le contenu d'un message de type "error"
+type ErrorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RefID   string `json:"ref_id,omitempty"` // ID du message à l'origine de l'erreur
+}
+
+var messageCounter uint64
+
+// newMessageID génère un identifiant unique de message
+func newMessageID() string {
+	n := atomic.AddUint64(&messageCounter, 1)
+	return fmt.Sprintf("%x-%x", time.Now().UnixNano(), n)
+}
+
+// encodeFrame prépare un message selon la version négociée
+// En version 1, le contenu est envoyé tel quel pour rester compatible
+func encodeFrame(version int, msgType string, payload interface{}) (interface{}, error) {
+	if version < ProtocolVersion {
+		return payload, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return Envelope{
+		Type:    msgType,
+		ID:      newMessageID(),
+		Version: ProtocolVersion,
+		Payload: data,
+	}, nil
+}
+
+// decodeFrame lit un message brut et retourne son enveloppe
+// Les messages de la version 1 sont convertis en enveloppe à partir de leur champ "type"
+// ou, pour les opérations sur fichiers, de leur champ "op"
+func decodeFrame(raw json.RawMessage) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	if env.Version >= ProtocolVersion {
+		if env.Type == "" {
+			return nil, ErrUntypedMessage
+		}
+		return &env, nil
+	}
+
+	env.Version = LegacyProtocolVersion
+	env.Payload = raw
+
+	if env.Type == "" {
+		var change FileChange
+		if err := json.Unmarshal(raw, &change); err != nil || change.Op == "" {
+			return nil, ErrUntypedMessage
+		}
+		env.Type = MsgFileChange
+	}
+
+	return &env, nil
+}
+
+// negotiateVersion retourne la version commune la plus élevée
+func negotiateVersion(remote int) int {
+	if remote < LegacyProtocolVersion {
+		return LegacyProtocolVersion
+	}
+	if remote > ProtocolVersion {
+		return ProtocolVersion
+	}
+	return remote
+}
+
+// negotiateCapabilities retourne les capacités supportées des deux côtés
+func negotiateCapabilities(remote []string) []string {
+	common := []string{}
+	for _, c := range remote {
+		if hasCapability(supportedCapabilities, c) {
+			common = append(common, c)
+		}
+	}
+	return common
+}
+
+// withoutCapability retourne une copie de la liste sans la capacité c
+func withoutCapability(caps []string, c string) []string {
+	kept := []string{}
+	for _, item := range caps {
+		if item != c {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// hasCapability vérifie la présence d'une capacité dans une liste
+func hasCapability(caps []string, c string) bool {
+	for _, item := range caps {
+		if item == c {
+			return true
+		}
+	}
+	return false
+}
+
+// packFileChange compresse le contenu d'un FileChange si la capacité est négociée
+func packFileChange(change FileChange, compress bool) FileChange {
+	if !compress || change.Content == "" || change.Compressed {
+		return change
+	}
+
+	data, err := base64.StdEncoding.DecodeString(change.Content)
+	if err != nil {
+		return change
+	}
+
+	packed, compressed := SmartCompress(data, change.FileName)
+	if !compressed {
+		return change
+	}
+
+	change.Content = base64.StdEncoding.EncodeToString(packed)
+	change.Compressed = true
+	return change
+}
+
+// unpackFileChange décode un FileChange et décompresse son contenu si besoin
+func unpackFileChange(env *Envelope) (FileChange, error) {
+	var change FileChange
+	if err := json.Unmarshal(env.Payload, &change); err != nil {
+		return change, err
+	}
+
+	if change.Compressed {
+		data, err := DecodeAndDecompress(change.Content)
+		if err != nil {
+			return change, err
+		}
+		change.Content = base64.StdEncoding.EncodeToString(data)
+		change.Compressed = false
+	}
+
+	return change, nil
+}
+
+// ============================================================================
+// SESSION CLIENT (côté serveur)
+// ============================================================================
+
+// ClientSession représente une connexion client authentifiée côté serveur
+type ClientSession struct {
+	Conn            *websocket.Conn
+	Name            string
+	ProtocolVersion int
+	Capabilities    []string
+	writeMu         sync.Mutex
+	receiver        *StreamReceiver // Fichiers reçus par morceaux
+	offers          deltaOffers     // Offres de delta envoyées au client
+	movesMu         sync.Mutex
+	sentMoves       map[string]time.Time // Destinations des déplacements envoyés au client
+	resumeMu        sync.Mutex
+	resumeOffsets   map[string]int64 // Positions annoncées par le client pour reprendre ses réceptions
+	state           *SyncState       // État de synchronisation de l'hôte (vecteurs de version)
+	filterMu        sync.RWMutex
+	subscription    SubscriptionFilter // Dossiers abonnés (capacité "subscribe")
+	filters         *FilterConfig      // Filtres du client (capacité "filters"), nil si aucun
+	User            *User              // Compte authentifié (nil sans comptes sur l'hôte)
+	SessionID       string             // Session ouverte pour ce compte
+	IP              string             // Adresse du client (audit)
+}
+
+// Author retourne l'identité attachée aux changements du client: son compte,
+// ou son nom de connexion sans comptes
+func (cs *ClientSession) Author() string {
+	if cs.User != nil {
+		return cs.User.ID
+	}
+	return cs.Name
+}
+
+// NewClientSession crée une session avec la version et les capacités négociées
+func NewClientSession(conn *websocket.Conn, name string, req AuthRequest) *ClientSession {
+	version := negotiateVersion(req.ProtocolVersion)
+	caps := []string{}
+	if version >= ProtocolVersion {
+		caps = negotiateCapabilities(req.Capabilities)
+	}
+
+	sess := &ClientSession{
+		Conn:            conn,
+		Name:            name,
+		ProtocolVersion: version,
+		Capabilities:    caps,
+		resumeOffsets:   make(map[string]int64),
+	}
+
+	if sess.HasCapability(CapResume) {
+		for _, r := range req.Resume {
+			sess.resumeOffsets[r.TransferID] = r.Offset
+		}
+	}
+
+	if sess.HasCapability(CapSubscribe) {
+		sess.subscription.SetFolders(req.Subscription)
+	}
+	if sess.HasCapability(CapFilters) && len(req.Filters) > 0 {
+		if err := sess.SetFilters(req.Filters); err != nil {
+			addLog(fmt.Sprintf("⚠️ %s: filtres invalides (%v)", name, err))
+		}
+	}
+
+	return sess
+}
+
+// HasCapability vérifie si une capacité a été négociée avec ce client
+func (cs *ClientSession) HasCapability(c string) bool {
+	return hasCapability(cs.Capabilities, c)
+}
+
+// Send envoie un message au client selon le protocole négocié
+func (cs *ClientSession) Send(msgType string, payload interface{}) error {
+	if change, ok := payload.(FileChange); ok {
+		payload = packFileChange(change, cs.HasCapability(CapCompression))
+	}
+
+	frame, err := encodeFrame(cs.ProtocolVersion, msgType, payload)
+	if err != nil {
+		return err
+	}
+
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+	return cs.Conn.WriteJSON(frame)
+}
+
+// sendBinary envoie une trame binaire (morceau de fichier)
+func (cs *ClientSession) sendBinary(header, data []byte) error {
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+	return writeBinaryFrame(cs.Conn, header, data)
+}
+
+// resumeOffset retourne (et consomme) la position de reprise annoncée par le client
+func (cs *ClientSession) resumeOffset(transferID string) int64 {
+	cs.resumeMu.Lock()
+	defer cs.resumeMu.Unlock()
+
+	offset := cs.resumeOffsets[transferID]
+	delete(cs.resumeOffsets, transferID)
+	return offset
+}
+
+// pendingMoveTTL durée pendant laquelle le client peut redemander le contenu
+// d'un déplacement qu'il n'a pas pu appliquer
+const pendingMoveTTL = 10 * time.Minute
+
+// rememberMove enregistre un déplacement envoyé au client
+func (cs *ClientSession) rememberMove(relPath string) {
+	cs.movesMu.Lock()
+	defer cs.movesMu.Unlock()
+	if cs.sentMoves == nil {
+		cs.sentMoves = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for p, sent := range cs.sentMoves {
+		if now.Sub(sent) > pendingMoveTTL {
+			delete(cs.sentMoves, p)
+		}
+	}
+	cs.sentMoves[relPath] = now
+}
+
+// takeMove vérifie (et consomme) qu'un déplacement vers relPath a été envoyé au client
+func (cs *ClientSession) takeMove(relPath string) bool {
+	cs.movesMu.Lock()
+	defer cs.movesMu.Unlock()
+	sent, ok := cs.sentMoves[relPath]
+	delete(cs.sentMoves, relPath)
+	return ok && time.Since(sent) <= pendingMoveTTL
+}
+
+// pendingDeltas retourne les offres de delta envoyées au client
+func (cs *ClientSession) pendingDeltas() *deltaOffers {
+	return &cs.offers
+}
+
+// fileVector retourne le vecteur de version d'un fichier de l'hôte
+func (cs *ClientSession) fileVector(relPath string) VersionVector {
+	return cs.state.VectorFor(relPath)
+}
+
+// Subscribed vérifie si un chemin fait partie des dossiers abonnés du client
+func (cs *ClientSession) Subscribed(path string, isDir bool) bool {
+	cs.filterMu.RLock()
+	defer cs.filterMu.RUnlock()
+	return cs.subscription.Includes(path, isDir)
+}
+
+// SetSubscription remplace les dossiers abonnés du client
+func (cs *ClientSession) SetSubscription(folders []string) {
+	cs.filterMu.Lock()
+	defer cs.filterMu.Unlock()
+	cs.subscription.SetFolders(folders)
+}
+
+// Subscription retourne les dossiers abonnés du client (vide: tout le partage)
+func (cs *ClientSession) Subscription() []string {
+	cs.filterMu.RLock()
+	defer cs.filterMu.RUnlock()
+	return append([]string(nil), cs.subscription.Folders...)
+}
+
+// SetFilters remplace les filtres du client à partir de leur forme JSON
+// (FilterConfig.ToJSON). Les dossiers abonnés sont gérés à part (SetSubscription).
+func (cs *ClientSession) SetFilters(data []byte) error {
+	fc := NewFilterConfig()
+	if err := fc.FromJSON(data); err != nil {
+		return err
+	}
+	fc.SetSubscription(nil)
+
+	cs.filterMu.Lock()
+	defer cs.filterMu.Unlock()
+	cs.filters = fc
+	return nil
+}
+
+// Accepts vérifie si un élément doit être envoyé au client: il fait partie
+// des dossiers abonnés et n'est exclu ni par chemin, ni par extension, ni par taille
+func (cs *ClientSession) Accepts(path string, size int64, isDir bool) bool {
+	cs.filterMu.RLock()
+	defer cs.filterMu.RUnlock()
+	if !cs.subscription.Includes(path, isDir) {
+		return false
+	}
+	return cs.filters == nil || !cs.filters.ShouldFilterFile(path, size, isDir)
+}
+
+// FilterSummary décrit les filtres du client
+func (cs *ClientSession) FilterSummary() string {
+	cs.filterMu.RLock()
+	defer cs.filterMu.RUnlock()
+	if cs.filters == nil {
+		return "Aucun filtre actif"
+	}
+	return cs.filters.GetSummary()
+}
+
+// ReportIgnored signale au client les éléments écartés par ses filtres
+func (cs *ClientSession) ReportIgnored(operation string, ignored int, path string) error {
+	if ignored == 0 || !cs.HasCapability(CapFilters) {
+		return nil
+	}
+	return cs.Send(MsgFilterReport, FilterReport{
+		Operation: operation,
+		Ignored:   ignored,
+		Path:      path,
+	})
+}
+
+// SendAccessDenied signale une opération refusée par les droits du compte
+func (cs *ClientSession) SendAccessDenied(denied AccessDenied) error {
+	if !cs.HasCapability(CapUsers) {
+		return cs.SendError(denied.RefID, ErrCodeAccessDenied, denied.Path+": "+denied.Reason)
+	}
+	return cs.Send(MsgAccessDenied, denied)
+}
+
+// SendError envoie un message d'erreur (ignoré pour les clients v1)
+func (cs *ClientSession) SendError(refID, code, message string) error {
+	if cs.ProtocolVersion < ProtocolVersion {
+		return nil
+	}
+	return cs.Send(MsgError, ErrorMessage{
+		Code:    code,
+		Message: message,
+		RefID:   refID,
+	})
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/hex"
 	"fmt"
@@ -53,22 +52,19 @@ func (ac *AuthConfig) SetPassword(password string) {
 }
 
 // VerifyPassword vérifie le mot de passe
+// Un hash à l'ancien format (SHA-256) est remplacé par un hash argon2id
+// après une vérification réussie.
 func (ac *AuthConfig) VerifyPassword(password string) bool {
 	if !ac.PasswordEnabled {
 		return true
 	}
 	
-	hash := HashPassword(password)
-	return subtle.ConstantTimeCompare([]byte(ac.PasswordHash), []byte(hash)) == 1
-}
-
-// HashPassword hash un mot de passe avec SHA256 + salt
-func HashPassword(password string) string {
-	// Dans une vraie application, utiliser bcrypt ou argon2
-	h := sha256.New()
-	h.Write([]byte("spiralydata_salt_"))
-	h.Write([]byte(password))
-	return hex.EncodeToString(h.Sum(nil))
+	ok, needsUpgrade := VerifyPasswordHash(ac.PasswordHash, password)
+	if ok && needsUpgrade {
+		ac.PasswordHash = HashPassword(password)
+		addLog("🔐 Hash du mot de passe mis à jour (argon2id)")
+	}
+	return ok
 }
 
 // ============================================================================
@@ -180,6 +176,13 @@ func (sm *SessionManager) InvalidateAllSessions() {
 	}
 }
 
+// SetTimeout change la durée des nouvelles sessions et des sessions rafraîchies
+func (sm *SessionManager) SetTimeout(timeout time.Duration) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.timeout = timeout
+}
+
 // GetActiveSessions retourne les sessions actives
 func (sm *SessionManager) GetActiveSessions() []*Session {
 	sm.mu.RLock()
@@ -339,6 +342,16 @@ func (ll *LoginLimiter) UnlockIP(ip string) {
 	}
 }
 
+// SetLimits change le nombre de tentatives et les durées sans perdre les
+// tentatives en cours
+func (ll *LoginLimiter) SetLimits(maxAttempts int, lockoutTime, windowTime time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+	ll.maxAttempts = maxAttempts
+	ll.lockoutTime = lockoutTime
+	ll.windowTime = windowTime
+}
+
 func (ll *LoginLimiter) cleanupLoop() {
 	ticker := time.NewTicker(10 * time.Minute)
 	defer ticker.Stop()
@@ -425,6 +438,28 @@ func (wl *IPWhitelist) RemoveIP(ipStr string) {
 	delete(wl.ips, ipStr)
 }
 
+// SetIPs remplace les IPs et subnets autorisés
+func (wl *IPWhitelist) SetIPs(ipStrs []string) error {
+	ips := make(map[string]bool)
+	var subnets []*net.IPNet
+	for _, ipStr := range ipStrs {
+		if _, subnet, err := net.ParseCIDR(ipStr); err == nil {
+			subnets = append(subnets, subnet)
+			continue
+		}
+		if net.ParseIP(ipStr) == nil {
+			return fmt.Errorf("IP invalide: %s", ipStr)
+		}
+		ips[ipStr] = true
+	}
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.ips = ips
+	wl.subnets = subnets
+	return nil
+}
+
 // IsAllowed vérifie si une IP est autorisée
 func (wl *IPWhitelist) IsAllowed(ipStr string) bool {
 	wl.mu.RLock()
@@ -642,6 +677,14 @@ func (rl *RateLimiter) GetRequestCount(ip string) int {
 	return 0
 }
 
+// SetLimits change le nombre de requêtes autorisées par fenêtre
+func (rl *RateLimiter) SetLimits(maxRequests int, windowTime time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.maxRequests = maxRequests
+	rl.windowTime = windowTime
+}
+
 func (rl *RateLimiter) cleanupLoop() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
@@ -686,11 +729,11 @@ func GenerateHostID() string {
 
 var (
 	globalAuthConfig    = NewAuthConfig()
-	globalSessionMgr    = NewSessionManager(24 * time.Hour)
-	globalLoginLimiter  = NewLoginLimiter(5, 15*time.Minute, 5*time.Minute)
+	globalSessionMgr    = NewSessionManager(defaultSessionTimeout)
+	globalLoginLimiter  = NewLoginLimiter(defaultMaxLoginAttempts, defaultLockoutTime, defaultLoginWindow)
 	globalIPWhitelist   = NewIPWhitelist()
 	globalTokenMgr      = NewTokenManager()
-	globalRateLimiter   = NewRateLimiter(100, time.Minute)
+	globalRateLimiter   = NewRateLimiter(defaultRateLimit, defaultRateWindow)
 )
 
 // GetAuthConfig retourne la config d'auth globale
@@ -549,3 +549,92 @@ func ShowHostPasswordDialog(window fyne.Window, onSuccess func()) {
 		return false
 	})
 }
+
+// ShowStoreUnlockDialog demande la phrase secrète du stockage chiffré avant le
+// démarrage de l'hôte, ou la fait choisir au premier démarrage
+// La phrase secrète est lue dans host.store_key_file si ce fichier est défini.
+func ShowStoreUnlockDialog(window fyne.Window, onSuccess func()) {
+	config, _ := LoadConfig()
+	store := GetChunkStore()
+
+	if !store.NeedsUnlock(config.Host.NeedsStoreKey()) {
+		onSuccess()
+		return
+	}
+	if config.Host.StoreKeyFile != "" {
+		passphrase, err := readStorePassphrase(config.Host.StoreKeyFile, false)
+		if err == nil {
+			err = store.Unlock(passphrase)
+		}
+		if err == nil {
+			onSuccess()
+			return
+		}
+		addLog(fmt.Sprintf("⚠️ %s: %v", config.Host.StoreKeyFile, err))
+	}
+
+	message := "Phrase secrète du stockage chiffré:"
+	if !store.HasKey() {
+		message = fmt.Sprintf("Choisissez la phrase secrète du stockage chiffré\n"+
+			"(%d caractères minimum, elle ne pourra pas être retrouvée):", storeMinPassphrase)
+	}
+
+	ShowPasswordDialog(window, "Stockage chiffré", message, func(passphrase string) bool {
+		if err := store.Unlock(passphrase); err != nil {
+			addLog(fmt.Sprintf("❌ Stockage chiffré: %v", err))
+			return false
+		}
+		onSuccess()
+		return true
+	})
+}
+
+// showCertChangedWarning avertit que le certificat d'un hôte ne correspond plus
+// à l'empreinte enregistrée: l'hôte a été réinstallé, ou la connexion est interceptée
+// L'utilisateur peut accepter le nouveau certificat, puis retry est appelé (si non nil).
+func showCertChangedWarning(changed *CertChangedError, retry func()) {
+	addLog(fmt.Sprintf("🚨 Certificat de %s modifié, connexion refusée", changed.ServerAddr))
+	if headlessMode || myWindow == nil {
+		addLog(fmt.Sprintf("ℹ️ Pour accepter le nouveau certificat, supprimez %s de pinned_certs dans %s",
+			changed.ServerAddr, configFilePath))
+		return
+	}
+
+	message := widget.NewLabel(fmt.Sprintf(
+		"Le certificat présenté par %s ne correspond pas\n"+
+			"à celui enregistré lors de la première connexion.\n\n"+
+			"L'hôte a peut-être été réinstallé, ou quelqu'un\n"+
+			"intercepte la connexion. Vérifiez l'empreinte\n"+
+			"affichée dans les journaux de l'hôte.",
+		changed.ServerAddr,
+	))
+	pinned := widget.NewLabel("Enregistrée:\n" + changed.Pinned)
+	pinned.Wrapping = fyne.TextWrapBreak
+	presented := widget.NewLabel("Présentée:\n" + changed.Presented)
+	presented.Wrapping = fyne.TextWrapBreak
+	presented.TextStyle = fyne.TextStyle{Bold: true}
+
+	content := container.NewVBox(
+		widget.NewIcon(theme.WarningIcon()),
+		message,
+		widget.NewSeparator(),
+		pinned,
+		presented,
+	)
+
+	dlg := dialog.NewCustomConfirm("Certificat modifié", "Faire confiance", "Annuler", content, func(trust bool) {
+		if !trust {
+			return
+		}
+		if err := pinFingerprint(changed.ServerAddr, changed.Presented); err != nil {
+			dialog.ShowError(err, myWindow)
+			return
+		}
+		addLog(fmt.Sprintf("🔏 Nouveau certificat accepté pour %s: %s", changed.ServerAddr, changed.Presented))
+		if retry != nil {
+			retry()
+		}
+	}, myWindow)
+	dlg.Resize(fyne.NewSize(520, 360))
+	dlg.Show()
+}
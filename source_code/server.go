@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -13,34 +13,46 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// Server synchronise un partage de l'hôte avec ses clients
+// Les connexions sont acceptées par Host, qui les oriente vers le partage demandé.
 type Server struct {
 	HostID       string
-	Clients      map[*websocket.Conn]string
-	Upgrader     websocket.Upgrader
+	Share        ShareConfig // Nom, dossier, mode, filtres et accès du partage
+	Clients      map[*websocket.Conn]*ClientSession
 	WatchDir     string
 	mu           sync.Mutex
 	skipNext     map[string]time.Time
 	knownFiles   map[string]time.Time
 	knownDirs    map[string]time.Time
+	state        *SyncState      // État persistant de la dernière synchronisation
+	versions     *VersionHistory // Versions précédentes des fichiers modifiés par les clients
+	trash        *Trash          // Éléments supprimés, restaurables
+	ignores      *IgnoreMatcher  // Règles des fichiers .spiralyignore
+	sync         *SyncConfig     // Mode de synchronisation du partage
+	filters      *FilterConfig   // Filtres du partage (nil = aucun)
+	usage        storageUsage    // Espace occupé, pour les limites de l'hôte
 	clientNum    int
 	shouldExit   bool
-	httpServer   *http.Server
 	pendingMoves map[string]time.Time
 	ctx          context.Context
 	cancel       context.CancelFunc
+	handlers     map[string]serverHandler
+	keyringMu    sync.Mutex // Mises à jour du trousseau (partage chiffré de bout en bout)
 }
 
-func NewServer(hostID string) *Server {
+// serverHandler traite un type de message reçu d'un client
+type serverHandler func(sess *ClientSession, env *Envelope) error
+
+// NewServer crée le serveur d'un partage
+// Le dossier surveillé est celui du partage, <données>/Spiralydata s'il n'est pas défini
+func NewServer(hostID string, share ShareConfig) *Server {
 	ctx, cancel := context.WithCancel(context.Background())
-	
-	return &Server{
-		HostID:  hostID,
-		Clients: make(map[*websocket.Conn]string),
-		Upgrader: websocket.Upgrader{
-			CheckOrigin:     func(r *http.Request) bool { return true },
-			ReadBufferSize:  10 * 1024 * 1024, // 10MB
-			WriteBufferSize: 10 * 1024 * 1024, // 10MB
-		},
+
+	s := &Server{
+		HostID:       hostID,
+		Share:        share,
+		Clients:      make(map[*websocket.Conn]*ClientSession),
+		WatchDir:     share.Path,
 		skipNext:     make(map[string]time.Time),
 		knownFiles:   make(map[string]time.Time),
 		knownDirs:    make(map[string]time.Time),
@@ -49,49 +61,81 @@ func NewServer(hostID string) *Server {
 		shouldExit:   false,
 		ctx:          ctx,
 		cancel:       cancel,
+		handlers:     make(map[string]serverHandler),
+	}
+	if s.WatchDir == "" {
+		s.WatchDir = filepath.Join(getDataDir(), "Spiralydata")
+	}
+
+	s.sync = NewSyncConfig()
+	s.sync.Mode = share.Mode
+	if len(share.Filters) > 0 {
+		s.filters = NewFilterConfig()
+		if err := s.filters.FromJSON(share.Filters); err != nil {
+			addLog(fmt.Sprintf("⚠️ Partage %s: filtres invalides (%v)", share.Name, err))
+			s.filters = nil
+		}
 	}
+	s.registerHandlers()
+
+	return s
+}
+
+// registerHandler associe un gestionnaire à un type de message
+func (s *Server) registerHandler(msgType string, h serverHandler) {
+	s.handlers[msgType] = h
 }
 
-func (s *Server) Start(port string) {
-	s.WatchDir = filepath.Join(getExecutableDir(), "Spiralydata")
+// registerHandlers enregistre les gestionnaires de tous les messages client
+func (s *Server) registerHandlers() {
+	s.registerHandler(MsgFileChange, s.handleFileChangeMsg)
+	s.registerHandler(MsgRequestAllFiles, s.handleRequestAllFiles)
+	s.registerHandler(MsgBackupRequest, s.handleBackupRequest)
+	s.registerHandler(MsgRequestFileTree, s.handleRequestFileTree)
+	s.registerHandler(MsgDownloadRequest, s.handleDownloadRequest)
+	s.registerHandler(MsgError, s.handleErrorMsg)
+	s.registerHandler(MsgTransferBegin, s.handleTransferBegin)
+	s.registerHandler(MsgTransferCommit, s.handleTransferCommit)
+	s.registerHandler(MsgTransferAbort, s.handleTransferAbort)
+	s.registerHandler(MsgDeltaOffer, s.handleDeltaOffer)
+	s.registerHandler(MsgDeltaSignature, s.handleDeltaSignature)
+	s.registerHandler(MsgListVersions, s.handleListVersions)
+	s.registerHandler(MsgRestoreVersion, s.handleRestoreVersion)
+	s.registerHandler(MsgListTrash, s.handleListTrash)
+	s.registerHandler(MsgRestoreTrash, s.handleRestoreTrash)
+	s.registerHandler(MsgSetSubscription, s.handleSetSubscription)
+	s.registerHandler(MsgSetFilters, s.handleSetFilters)
+	s.registerHandler(MsgE2EKeyring, s.handleE2EKeyring)
+}
+
+// open prépare le dossier du partage et lance sa surveillance
+func (s *Server) open() {
 	os.MkdirAll(s.WatchDir, 0755)
+	cleanTransferTemp(s.WatchDir)
+	s.state = openSyncStateOrWarn(s.WatchDir)
+	s.versions = NewVersionHistory(s.WatchDir, versionLimitsFromConfig())
+	s.trash = NewHostTrash(s.WatchDir, GetChunkStore())
+	s.ignores = NewIgnoreMatcher(s.WatchDir)
 
-	addLog("Serveur démarré")
-	addLog(fmt.Sprintf("ID: %s", s.HostID))
-	addLog(fmt.Sprintf("Dossier: %s", s.WatchDir))
-	addLog("En attente de connexions...")
+	addLog(fmt.Sprintf("📁 Partage %s: %s (%s)", s.Share.DisplayName(), s.WatchDir, s.sync.GetModeName()))
+	if s.filters != nil {
+		addLog(fmt.Sprintf("🔍 Partage %s: filtres %s", s.Share.DisplayName(), s.filters.GetSummary()))
+	}
 
 	s.updateKnownFilesAndDirs()
 	go s.watchRecursive()
 	go s.periodicCheck()
 	go s.cleanPendingMoves()
-
-	// Créer un nouveau mux pour éviter les conflits lors du redémarrage
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", s.handleWS)
-	
-	s.httpServer = &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-	addLog(fmt.Sprintf("Port: %s", port))
-	
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		addLog(fmt.Sprintf("Erreur serveur: %v", err))
-	}
 }
 
-func (s *Server) Stop() {
-	addLog("Arrêt du serveur...")
+// close déconnecte les clients du partage et ferme ses bases
+func (s *Server) close() {
 	s.shouldExit = true
-	
+
 	if s.cancel != nil {
 		s.cancel()
 	}
-	
+
 	s.mu.Lock()
 	for client := range s.Clients {
 		client.WriteControl(websocket.CloseMessage, 
@@ -99,191 +143,878 @@ func (s *Server) Stop() {
 			time.Now().Add(time.Second))
 		client.Close()
 	}
-	s.Clients = make(map[*websocket.Conn]string)
+	s.Clients = make(map[*websocket.Conn]*ClientSession)
 	s.mu.Unlock()
-	
-	if s.httpServer != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
-		s.httpServer.Shutdown(ctx)
-		s.httpServer = nil
+}
+
+// disconnectUser ferme les connexions d'un compte révoqué
+func (s *Server) disconnectUser(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ws, sess := range s.Clients {
+		if sess.User != nil && sess.User.ID == userID {
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "accès révoqué"),
+				time.Now().Add(time.Second))
+			ws.Close()
+		}
 	}
-	
-	// Attendre que le port soit libéré
-	time.Sleep(1 * time.Second)
-	addLog("Serveur arrêté")
 }
 
-func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
-	addLog(fmt.Sprintf("🔌 Connexion depuis %s", r.RemoteAddr))
-	
-	ws, err := s.Upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		addLog(fmt.Sprintf("❌ Erreur WebSocket: %v", err))
-		return
+// release ferme les bases du partage, une fois le port libéré
+func (s *Server) release() {
+	s.state.Close()
+	s.versions.Close()
+}
+
+// accessID retourne l'identifiant demandé aux clients du partage
+func (s *Server) accessID() string {
+	if s.Share.AccessID != "" {
+		return s.Share.AccessID
 	}
+	return s.HostID
+}
 
-	// Augmenter la limite de lecture pour les gros fichiers
-	ws.SetReadLimit(50 * 1024 * 1024) // 50MB
+// sendsToClients indique si le mode du partage envoie le contenu aux clients
+func (s *Server) sendsToClients() bool {
+	return s.sync.ShouldSendToUser()
+}
 
-	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
-	var rawMsg json.RawMessage
-	if err := ws.ReadJSON(&rawMsg); err != nil {
-		addLog(fmt.Sprintf("❌ Erreur lecture: %v", err))
-		ws.Close()
-		return
+// receivesFromClients indique si le partage accepte les modifications des clients
+func (s *Server) receivesFromClients() bool {
+	return s.sync.ShouldReceiveFromUser() && !s.Share.ReadOnly
+}
+
+// excluded vérifie si un élément est exclu du partage par les fichiers
+// .spiralyignore ou par les filtres du partage
+func (s *Server) excluded(relPath string, size int64, isDir bool) bool {
+	if s.ignores.Ignored(relPath, isDir) {
+		return true
 	}
-	ws.SetReadDeadline(time.Time{})
+	return s.filters != nil && s.filters.ShouldFilterFile(relPath, size, isDir)
+}
 
-	var authReq AuthRequest
-	if err := json.Unmarshal(rawMsg, &authReq); err != nil {
-		addLog(fmt.Sprintf("❌ Erreur parsing: %v", err))
+// acceptClient authentifie un client du partage puis traite ses messages
+// jusqu'à sa déconnexion
+// Les échecs (ID ou compte) sont comptés par IP par le LoginLimiter.
+func (s *Server) acceptClient(ws *websocket.Conn, authReq AuthRequest, clientIP string) {
+	refuse := func(message string) {
+		ws.WriteJSON(AuthResponse{
+			Type:    "auth_failed",
+			Message: message,
+		})
 		ws.Close()
+	}
+
+	if msg := loginBlocked(clientIP); msg != "" {
+		addLog(fmt.Sprintf("🔒 Connexion refusée (%s bloquée)", clientIP))
+		refuse(msg)
+		return
+	}
+	if authReq.HostID != s.accessID() {
+		addLog(fmt.Sprintf("🚫 Connexion refusée (ID: %s, partage %s)", authReq.HostID, s.Share.DisplayName()))
+		recordLogin(authReq.Username, clientIP, false, "identifiant incorrect")
+		refuse("Identifiant incorrect")
 		return
 	}
 
-	if authReq.Type == "auth_request" {
-		if authReq.HostID == s.HostID {
-			s.mu.Lock()
-			s.clientNum++
-			clientName := fmt.Sprintf("Client_%d", s.clientNum)
-			s.Clients[ws] = clientName
-			totalClients := len(s.Clients)
-			s.mu.Unlock()
+	var user *User
+	if usersRequired() {
+		var reason string
+		user, reason = authenticateUser(authReq)
+		if user == nil {
+			addLog(fmt.Sprintf("🚫 Connexion refusée (%s: %s)", clientIP, reason))
+			recordLogin(authReq.Username, clientIP, false, reason)
+			refuse(reason)
+			return
+		}
+		recordLogin(user.ID, clientIP, true, "")
+		GetUserManager().RecordLogin(user.ID, time.Now())
+	}
 
-			addLog(fmt.Sprintf("✅ %s connecté", clientName))
-			addLog(fmt.Sprintf("👥 Clients: %d", totalClients))
+	var keyring *E2EKeyring
+	if s.Share.E2E {
+		if !hasCapability(authReq.Capabilities, CapE2E) {
+			addLog(fmt.Sprintf("🚫 Connexion refusée (%s: partage %s chiffré de bout en bout)", clientIP, s.Share.DisplayName()))
+			refuse("Partage chiffré de bout en bout: phrase secrète requise")
+			return
+		}
+		var err error
+		if keyring, err = s.loadE2EKeyring(); err != nil {
+			addLog(fmt.Sprintf("❌ Trousseau du partage %s illisible: %v", s.Share.DisplayName(), err))
+			refuse("Trousseau du partage illisible")
+			return
+		}
+		if user != nil && authReq.PublicKey != "" {
+			registerUserPublicKey(user, authReq.PublicKey)
+		}
+	}
 
-			ws.WriteJSON(AuthResponse{
-				Type:    "auth_success",
-				Message: "Connexion établie",
-			})
+	s.mu.Lock()
+	s.clientNum++
+	clientName := fmt.Sprintf("Client_%d", s.clientNum)
+	if user != nil {
+		clientName = fmt.Sprintf("%s (Client_%d)", user.ID, s.clientNum)
+	}
+	if s.Share.Name != "" {
+		clientName = s.Share.Name + "/" + clientName
+	}
+	sess := NewClientSession(ws, clientName, authReq)
+	if !s.Share.E2E {
+		sess.Capabilities = withoutCapability(sess.Capabilities, CapE2E)
+	}
+	sess.receiver = NewStreamReceiver(s.WatchDir)
+	sess.receiver.release = s.releaseStorage
+	sess.state = s.state
+	sess.IP = clientIP
+	if user != nil {
+		sess.User = user
+		sess.SessionID = GetSessionManager().CreateSession(clientIP, user.Role).ID
+	}
+	s.Clients[ws] = sess
+	totalClients := len(s.Clients)
+	s.mu.Unlock()
 
-			addLog(fmt.Sprintf("📤 Envoi structure à %s...", clientName))
-			s.sendAllFilesAndDirs(ws)
-			addLog(fmt.Sprintf("✅ Structure envoyée à %s", clientName))
-			
-			s.handleClientMessages(ws, clientName)
+	addLog(fmt.Sprintf("✅ %s connecté", clientName))
+	if user != nil {
+		addLog(fmt.Sprintf("👤 %s: %s (%s)", clientName, user.Name, user.Role))
+	}
+	addLog(fmt.Sprintf("👥 Clients: %d", totalClients))
+	if sess.ProtocolVersion < ProtocolVersion {
+		addLog(fmt.Sprintf("ℹ️ %s: ancien protocole (v%d)", clientName, sess.ProtocolVersion))
+	} else if len(sess.Capabilities) > 0 {
+		addLog(fmt.Sprintf("🤝 %s: capacités %v", clientName, sess.Capabilities))
+	}
+	if folders := sess.Subscription(); len(folders) > 0 {
+		addLog(fmt.Sprintf("📁 %s: sync sélective %v", clientName, folders))
+	}
+	if len(authReq.Filters) > 0 && sess.HasCapability(CapFilters) {
+		addLog(fmt.Sprintf("🔍 %s: filtres %s", clientName, sess.FilterSummary()))
+	}
 
-		} else {
-			addLog(fmt.Sprintf("🚫 Connexion refusée (ID: %s)", authReq.HostID))
-			ws.WriteJSON(AuthResponse{
-				Type:    "auth_failed",
-				Message: "Identifiant incorrect",
-			})
-			ws.Close()
-			return
+	resp := AuthResponse{
+		Type:    "auth_success",
+		Message: "Connexion établie",
+	}
+	if sess.ProtocolVersion >= ProtocolVersion {
+		resp.ProtocolVersion = sess.ProtocolVersion
+		resp.Capabilities = sess.Capabilities
+	}
+	if sess.HasCapability(CapShares) {
+		resp.Share = s.Share.Name
+		resp.ReadOnly = !s.receivesFromClients()
+		resp.WriteOnly = !s.sendsToClients()
+	}
+	if user != nil && sess.HasCapability(CapUsers) {
+		resp.User = user.ID
+		resp.Role = user.Role.ConfigName()
+		resp.Token = authReq.Token // Reconnexion par jeton: le même reste valable
+		if authReq.Token == "" || authReq.Password != "" {
+			resp.Token = issueUserToken(user)
 		}
 	}
+	if sess.HasCapability(CapE2E) {
+		resp.Keyring = keyring
+		if user != nil && user.Role.CanAdmin() {
+			resp.PublicKeys = userPublicKeys()
+		}
+	}
+	if sess.HasCapability(CapResume) {
+		// Indiquer au client où reprendre les envois interrompus
+		for _, upload := range authReq.Uploads {
+			upload.Offset = resumeIncoming(s.WatchDir, upload)
+			if upload.Offset > 0 {
+				resp.Resume = append(resp.Resume, upload)
+			}
+		}
+		if len(authReq.Resume) > 0 {
+			addLog(fmt.Sprintf("⏩ %s: %d réception(s) à reprendre", clientName, len(authReq.Resume)))
+		}
+	}
+	ws.WriteJSON(resp)
+
+	// Les gros fichiers arrivent par morceaux: inutile d'accepter des trames de 50MB
+	if sess.HasCapability(CapChunked) {
+		ws.SetReadLimit(chunkedReadLimit)
+	}
+
+	if !s.sendsToClients() {
+		addLog(fmt.Sprintf("ℹ️ %s: partage en mode %s, rien à envoyer", clientName, s.sync.GetModeName()))
+	} else if sess.HasCapability(CapManifest) {
+		// Le client ne demandera que les fichiers absents ou différents
+		if err := s.sendManifest(sess); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi manifeste à %s: %v", clientName, err))
+		}
+	} else {
+		addLog(fmt.Sprintf("📤 Envoi structure à %s...", clientName))
+		s.sendAllFilesAndDirs(sess)
+		addLog(fmt.Sprintf("✅ Structure envoyée à %s", clientName))
+	}
+
+	s.handleClientMessages(sess)
 }
 
-func (s *Server) handleClientMessages(ws *websocket.Conn, clientName string) {
+func (s *Server) handleClientMessages(sess *ClientSession) {
+	ws := sess.Conn
+	clientName := sess.Name
+
 	defer func() {
 		s.mu.Lock()
 		delete(s.Clients, ws)
 		remaining := len(s.Clients)
 		s.mu.Unlock()
+		sess.receiver.Close()
+		if sess.SessionID != "" {
+			GetSessionManager().InvalidateSession(sess.SessionID)
+		}
 		ws.Close()
 		addLog(fmt.Sprintf("❌ %s déconnecté", clientName))
 		addLog(fmt.Sprintf("👥 Clients restants: %d", remaining))
 	}()
 
 	for {
-		var rawMsg json.RawMessage
-		if err := ws.ReadJSON(&rawMsg); err != nil {
+		frameType, r, err := ws.NextReader()
+		if err != nil {
 			break
 		}
-		
-		var reqMap map[string]interface{}
-		if err := json.Unmarshal(rawMsg, &reqMap); err == nil {
-			if reqType, ok := reqMap["type"].(string); ok {
-				if reqType == "request_all_files" {
-					addLog(fmt.Sprintf("📥 %s: Demande structure complète", clientName))
-					s.sendAllFilesAndDirs(ws)
-					addLog(fmt.Sprintf("📤 Structure envoyée à %s", clientName))
-					continue
-				}
-				
-				if reqType == "backup_request" {
-					addLog(fmt.Sprintf("💾 %s: Demande backup", clientName))
-					s.sendAllFilesAndDirs(ws)
-					addLog(fmt.Sprintf("📤 Backup envoyée à %s", clientName))
-					continue
-				}
-				
-				if reqType == "request_file_tree" {
-					addLog(fmt.Sprintf("📂 %s: Demande arborescence", clientName))
-					s.sendFileTree(ws)
-					continue
-				}
-				
-				if reqType == "download_request" {
-					if items, ok := reqMap["items"].([]interface{}); ok {
-						itemPaths := make([]string, 0, len(items))
-						for _, item := range items {
-							if path, ok := item.(string); ok {
-								itemPaths = append(itemPaths, path)
-							}
-						}
-						addLog(fmt.Sprintf("⬇️ %s: Download %d elements", clientName, len(itemPaths)))
-						s.sendSelectedFiles(ws, itemPaths)
-						continue
-					}
-				}
+
+		if frameType == websocket.BinaryMessage {
+			if err := sess.receiver.WriteChunk(r); err != nil {
+				addLog(fmt.Sprintf("⚠️ %s: morceau rejeté (%v)", clientName, err))
 			}
+			continue
+		}
+
+		rawMsg, err := io.ReadAll(r)
+		if err != nil {
+			break
 		}
 		
-		var msg FileChange
-		if err := json.Unmarshal(rawMsg, &msg); err == nil {
-			if msg.Origin != "server" {
-				if msg.IsDir {
-					if msg.Op == "mkdir" {
-						addLog(fmt.Sprintf("📥 %s: Dossier créé → %s", clientName, msg.FileName))
-					} else if msg.Op == "remove" {
-						addLog(fmt.Sprintf("📥 %s: Dossier supprimé → %s", clientName, msg.FileName))
-					}
-				} else {
-					if msg.Op == "create" {
-						addLog(fmt.Sprintf("📥 %s: Nouveau → %s", clientName, msg.FileName))
-					} else if msg.Op == "write" {
-						addLog(fmt.Sprintf("📥 %s: Modifié → %s", clientName, msg.FileName))
-					} else if msg.Op == "remove" {
-						addLog(fmt.Sprintf("📥 %s: Supprimé → %s", clientName, msg.FileName))
-					}
-				}
-				
-				s.applyChange(msg)
-				s.broadcastExcept(msg, ws)
-			}
+		env, err := decodeFrame(rawMsg)
+		if err != nil {
+			addLog(fmt.Sprintf("⚠️ %s: message ignoré (%v)", clientName, err))
+			sess.SendError("", "invalid_message", err.Error())
+			continue
+		}
+
+		handler, ok := s.handlers[env.Type]
+		if !ok {
+			addLog(fmt.Sprintf("⚠️ %s: type de message inconnu \"%s\"", clientName, env.Type))
+			sess.SendError(env.ID, "unknown_type", ErrUnknownMessage.Error()+": "+env.Type)
+			continue
+		}
+
+		if err := handler(sess, env); err != nil {
+			addLog(fmt.Sprintf("⚠️ %s: erreur %s (%v)", clientName, env.Type, err))
+			sess.SendError(env.ID, "handler_error", err.Error())
 		}
 	}
 }
 
-func (s *Server) broadcast(msg FileChange) {
+// handleFileChangeMsg applique une opération sur fichier envoyée par un client
+func (s *Server) handleFileChangeMsg(sess *ClientSession, env *Envelope) error {
+	msg, err := unpackFileChange(env)
+	if err != nil {
+		return err
+	}
+	return s.receiveFileChange(sess, msg)
+}
+
+// receiveFileChange applique un changement client et le relaie aux autres clients
+func (s *Server) receiveFileChange(sess *ClientSession, msg FileChange) error {
+	// Espace réservé pour ce fichier, libéré s'il n'est finalement pas écrit
+	held := msg.Reserved
+	defer func() { s.releaseStorage(held) }()
+
+	if msg.Origin == "server" {
+		return nil
+	}
+	fileName, ok := cleanRelPath(msg.FileName)
+	if !ok {
+		msg.Discard()
+		return fmt.Errorf("chemin refusé: %s", msg.FileName)
+	}
+	msg.FileName = fileName
+	if msg.Op == "move" {
+		oldName, ok := cleanRelPath(msg.OldName)
+		if !ok {
+			msg.Discard()
+			return fmt.Errorf("déplacement invalide: %s → %s", msg.OldName, msg.FileName)
+		}
+		msg.OldName = oldName
+	}
+	msg.Author = sess.Author()
+
+	if !s.receivesFromClients() {
+		msg.Discard()
+		addLog(fmt.Sprintf("🔒 %s: %s refusé (partage en lecture seule)", sess.Name, msg.FileName))
+		return sess.SendError("", ErrCodeShareReadOnly, msg.FileName)
+	}
+	if !s.authorizeChange(sess, "", msg) {
+		msg.Discard()
+		return nil
+	}
+	if msg.Op != "move" && s.excluded(msg.FileName, msg.ContentSize(), msg.IsDir) {
+		addLog(fmt.Sprintf("🙈 %s: %s exclu du partage, ignoré", sess.Name, msg.FileName))
+		msg.Discard()
+		return nil
+	}
+	if msg.Op == "remove" && s.sync.ShouldNeverDelete() {
+		addLog(fmt.Sprintf("🛡️ %s: suppression de %s ignorée (mode %s)", sess.Name, msg.FileName, s.sync.GetModeName()))
+		return nil
+	}
+
+	if msg.Op == "move" {
+		// Source absente de l'hôte: le client renvoie le contenu à la destination
+		if _, err := os.Lstat(filepath.Join(s.WatchDir, filepath.FromSlash(msg.OldName))); err != nil {
+			addLog(fmt.Sprintf("⚠️ %s: %s introuvable, contenu de %s demandé", sess.Name, msg.OldName, msg.FileName))
+			return sess.SendError("", ErrCodeMoveSourceMissing, msg.FileName)
+		}
+	}
+
+	if msg.Op == "delta" {
+		resolved, err := resolveDeltaChange(sess, s.WatchDir, msg)
+		if err != nil {
+			return err
+		}
+		msg = resolved
+	}
+
+	clientName := sess.Name
+	if msg.Op == "move" {
+		addLog(fmt.Sprintf("📥 %s: Déplacé → %s → %s", clientName, msg.OldName, msg.FileName))
+	} else if msg.IsDir {
+		if msg.Op == "mkdir" {
+			addLog(fmt.Sprintf("📥 %s: Dossier créé → %s", clientName, msg.FileName))
+		} else if msg.Op == "remove" {
+			addLog(fmt.Sprintf("📥 %s: Dossier supprimé → %s", clientName, msg.FileName))
+		}
+	} else {
+		if msg.Op == "create" {
+			addLog(fmt.Sprintf("📥 %s: Nouveau → %s", clientName, msg.FileName))
+		} else if msg.Op == "write" {
+			addLog(fmt.Sprintf("📥 %s: Modifié → %s", clientName, msg.FileName))
+		} else if msg.Op == "remove" {
+			addLog(fmt.Sprintf("📥 %s: Supprimé → %s", clientName, msg.FileName))
+		}
+	}
+	
+	if !msg.IsDir && (msg.Op == "create" || msg.Op == "write") {
+		reserved, err := s.reserveStorage(msg.FileName, msg.ContentSize(), held)
+		held = reserved
+		if err != nil {
+			msg.Discard()
+			addLog(fmt.Sprintf("🚫 %s: %v", clientName, err))
+			return sess.SendError("", ErrCodeLimitExceeded, err.Error())
+		}
+		if !s.checkQuota(sess, "", msg.FileName, accessUpload, msg.ContentSize()) {
+			msg.Discard()
+			return nil
+		}
+		if !s.acceptVersion(sess, msg) {
+			msg.Discard()
+			return nil
+		}
+	}
+
+	size := msg.ContentSize()
+	before, _ := os.Stat(filepath.Join(s.WatchDir, filepath.FromSlash(msg.FileName)))
+	s.applyChange(msg)
+	s.commitStorage(held)
+	held = 0
+	s.recordUsage(sess, msg.Op, size, before)
+	s.broadcastExcept(msg, sess.Conn)
+	return nil
+}
+
+// acceptVersion vérifie qu'un fichier reçu succède à la version de l'hôte
+// Une modification concurrente ou périmée est refusée: le client reçoit la
+// version de l'hôte et détecte le conflit de son côté.
+func (s *Server) acceptVersion(sess *ClientSession, msg FileChange) bool {
+	if msg.Vector == nil {
+		return true // Client sans vecteurs de version
+	}
+	current := s.state.VectorFor(msg.FileName)
+	if current == nil {
+		return true
+	}
+
+	switch msg.Vector.Compare(current) {
+	case VectorBefore, VectorConcurrent:
+		addLog(fmt.Sprintf("⚠️ %s: %s modifié en parallèle %s / hôte %s, version de l'hôte renvoyée",
+			sess.Name, msg.FileName, msg.Vector, current))
+		if err := s.sendFileTo(sess, msg.FileName, "write"); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi %s: %v", msg.FileName, err))
+		}
+		return false
+	}
+	return true
+}
+
+// handleDeltaOffer répond à une offre de delta avec la signature de la copie du serveur
+func (s *Server) handleDeltaOffer(sess *ClientSession, env *Envelope) error {
+	var offer DeltaOffer
+	if err := json.Unmarshal(env.Payload, &offer); err != nil {
+		return err
+	}
+	fileName, ok := cleanRelPath(offer.FileName)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", offer.FileName)
+	}
+	offer.FileName = fileName
+	if !s.receivesFromClients() {
+		return sess.SendError(env.ID, ErrCodeShareReadOnly, offer.FileName)
+	}
+	if !s.authorize(sess, env.ID, offer.FileName, accessWrite) {
+		return nil
+	}
+
+	return sess.Send(MsgDeltaSignature, buildDeltaSignature(s.WatchDir, offer))
+}
+
+// handleDeltaSignature envoie au client le delta d'un fichier proposé
+func (s *Server) handleDeltaSignature(sess *ClientSession, env *Envelope) error {
+	var msg DeltaSignature
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		return err
+	}
+	fileName, ok := cleanRelPath(msg.FileName)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", msg.FileName)
+	}
+	msg.FileName = fileName
+	if !s.sendsToClients() {
+		return nil
+	}
+	if !s.authorize(sess, env.ID, msg.FileName, accessRead) {
+		return nil
+	}
+
+	return sendDelta(sess, s.WatchDir, msg, "server")
+}
+
+// handleTransferBegin prépare la réception d'un fichier par morceaux
+func (s *Server) handleTransferBegin(sess *ClientSession, env *Envelope) error {
+	var begin TransferBegin
+	if err := json.Unmarshal(env.Payload, &begin); err != nil {
+		return err
+	}
+	fileName, ok := cleanRelPath(begin.FileName)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", begin.FileName)
+	}
+	begin.FileName = fileName
+	if !s.receivesFromClients() {
+		return sess.SendError(env.ID, ErrCodeShareReadOnly, begin.FileName)
+	}
+	if !s.authorize(sess, env.ID, begin.FileName, accessWrite) {
+		return nil
+	}
+	reserved, err := s.reserveStorage(begin.FileName, begin.Size, 0)
+	if err != nil {
+		addLog(fmt.Sprintf("🚫 %s: %v", sess.Name, err))
+		return sess.SendError(env.ID, ErrCodeLimitExceeded, err.Error())
+	}
+	if !s.checkQuota(sess, env.ID, begin.FileName, accessUpload, begin.Size) {
+		s.releaseStorage(reserved)
+		return nil
+	}
+
+	begin.Reserved = reserved
+	if err := sess.receiver.Begin(begin); err != nil {
+		s.releaseStorage(reserved)
+		return err
+	}
+	return nil
+}
+
+// handleTransferCommit vérifie et applique un fichier reçu par morceaux
+func (s *Server) handleTransferCommit(sess *ClientSession, env *Envelope) error {
+	var commit TransferCommit
+	if err := json.Unmarshal(env.Payload, &commit); err != nil {
+		return err
+	}
+
+	msg, err := sess.receiver.Commit(commit)
+	if err != nil {
+		return err
+	}
+
+	if err := s.receiveFileChange(sess, msg); err != nil {
+		msg.Discard()
+		return err
+	}
+	return nil
+}
+
+// handleTransferAbort abandonne un transfert en cours
+func (s *Server) handleTransferAbort(sess *ClientSession, env *Envelope) error {
+	var abort TransferAbort
+	if err := json.Unmarshal(env.Payload, &abort); err != nil {
+		return err
+	}
+	sess.receiver.Abort(abort.TransferID)
+	addLog(fmt.Sprintf("⚠️ %s: transfert annulé (%s)", sess.Name, abort.Reason))
+	return nil
+}
+
+// handleRequestAllFiles renvoie toute la structure au client
+func (s *Server) handleRequestAllFiles(sess *ClientSession, env *Envelope) error {
+	if !s.sendsToClients() {
+		return sess.SendError(env.ID, ErrCodeShareWriteOnly, s.sync.GetModeName())
+	}
+	addLog(fmt.Sprintf("📥 %s: Demande structure complète", sess.Name))
+	s.sendAllFilesAndDirs(sess)
+	addLog(fmt.Sprintf("📤 Structure envoyée à %s", sess.Name))
+	return nil
+}
+
+// handleBackupRequest envoie tous les fichiers pour une sauvegarde
+func (s *Server) handleBackupRequest(sess *ClientSession, env *Envelope) error {
+	if !s.sendsToClients() {
+		return sess.SendError(env.ID, ErrCodeShareWriteOnly, s.sync.GetModeName())
+	}
+	addLog(fmt.Sprintf("💾 %s: Demande backup", sess.Name))
+	s.sendAllFilesAndDirs(sess)
+	addLog(fmt.Sprintf("📤 Backup envoyée à %s", sess.Name))
+	return nil
+}
+
+// handleRequestFileTree envoie l'arborescence pour l'explorateur
+func (s *Server) handleRequestFileTree(sess *ClientSession, env *Envelope) error {
+	addLog(fmt.Sprintf("📂 %s: Demande arborescence", sess.Name))
+	s.sendFileTree(sess)
+	return nil
+}
+
+// handleDownloadRequest envoie les éléments sélectionnés
+func (s *Server) handleDownloadRequest(sess *ClientSession, env *Envelope) error {
+	var req DownloadRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return err
+	}
+	if !s.sendsToClients() {
+		return sess.SendError(env.ID, ErrCodeShareWriteOnly, s.sync.GetModeName())
+	}
+	addLog(fmt.Sprintf("⬇️ %s: Download %d elements", sess.Name, len(req.Items)))
+	s.sendSelectedFiles(sess, env.ID, req.Items)
+	return nil
+}
+
+// handleListVersions envoie au client les versions précédentes d'un fichier
+func (s *Server) handleListVersions(sess *ClientSession, env *Envelope) error {
+	var req VersionListRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return err
+	}
+	cleaned, ok := cleanRelPath(req.Path)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", req.Path)
+	}
+	req.Path = cleaned
+	if !s.authorize(sess, env.ID, req.Path, accessRead) {
+		return nil
+	}
+
+	return sess.Send(MsgVersionList, VersionList{
+		Path:     req.Path,
+		Versions: s.versions.List(req.Path),
+	})
+}
+
+// handleRestoreVersion remplace un fichier par une de ses versions
+// Le fichier restauré est envoyé à tous les clients, y compris le demandeur.
+func (s *Server) handleRestoreVersion(sess *ClientSession, env *Envelope) error {
+	var req RestoreVersionRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return err
+	}
+	cleaned, ok := cleanRelPath(req.Path)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", req.Path)
+	}
+	req.Path = cleaned
+	if !s.receivesFromClients() {
+		return sess.SendError(env.ID, ErrCodeShareReadOnly, req.Path)
+	}
+	if !s.authorize(sess, env.ID, req.Path, accessWrite) {
+		return nil
+	}
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	for client := range s.Clients {
-		client.WriteJSON(msg)
+	s.skipNext[req.Path] = time.Now().Add(5 * time.Second)
+	s.mu.Unlock()
+
+	rev, err := s.versions.Restore(req.Path, req.VersionID)
+	if err != nil {
+		return err
 	}
+
+	s.mu.Lock()
+	s.knownFiles[req.Path] = time.Now()
+	s.mu.Unlock()
+	s.state.Record(req.Path)
+
+	addLog(fmt.Sprintf("⏪ %s: %s restauré (version du %s)", sess.Name, req.Path, rev.SavedAt.Format("02/01/2006 15:04")))
+	s.broadcast(FileChange{FileName: req.Path, Op: "write", Origin: "server", Author: sess.Author()})
+	return nil
 }
 
-func (s *Server) broadcastExcept(msg FileChange, skip *websocket.Conn) {
+// handleListTrash envoie au client le contenu de la corbeille
+func (s *Server) handleListTrash(sess *ClientSession, env *Envelope) error {
+	return sess.Send(MsgTrashList, TrashList{Entries: s.readableTrash(sess)})
+}
+
+// handleRestoreTrash remet un élément de la corbeille à sa place
+// Le watcher le détecte ensuite comme un ajout et l'envoie à tous les clients.
+func (s *Server) handleRestoreTrash(sess *ClientSession, env *Envelope) error {
+	var req TrashRestoreRequest
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return err
+	}
+
+	list := TrashList{}
+	if !s.receivesFromClients() {
+		list.Error = "partage en lecture seule"
+		list.Entries = s.readableTrash(sess)
+		return sess.Send(MsgTrashList, list)
+	}
+	for _, entry := range s.trash.List() {
+		if entry.ID == req.ID && !s.authorize(sess, env.ID, entry.Path, accessCreate) {
+			list.Error = "accès refusé: " + entry.Path
+			list.Entries = s.readableTrash(sess)
+			return sess.Send(MsgTrashList, list)
+		}
+	}
+	entry, err := s.trash.Restore(req.ID)
+	if err != nil {
+		list.Error = err.Error()
+	} else {
+		addLog(fmt.Sprintf("♻️ %s: %s restauré depuis la corbeille", sess.Name, entry.Path))
+	}
+	list.Entries = s.readableTrash(sess)
+	return sess.Send(MsgTrashList, list)
+}
+
+// handleSetSubscription remplace les dossiers abonnés d'un client
+// Avec la capacité "manifest", un nouveau manifeste lui est envoyé: il reçoit
+// ainsi le contenu des dossiers ajoutés à son abonnement.
+func (s *Server) handleSetSubscription(sess *ClientSession, env *Envelope) error {
+	if !sess.HasCapability(CapSubscribe) {
+		return fmt.Errorf("capacité %s non négociée", CapSubscribe)
+	}
+	var req SubscriptionUpdate
+	if err := json.Unmarshal(env.Payload, &req); err != nil {
+		return err
+	}
+
+	sess.SetSubscription(req.Folders)
+	if folders := sess.Subscription(); len(folders) > 0 {
+		addLog(fmt.Sprintf("📁 %s: sync sélective %v", sess.Name, folders))
+	} else {
+		addLog(fmt.Sprintf("📁 %s: sync de tout le partage", sess.Name))
+	}
+
+	if sess.HasCapability(CapManifest) {
+		go func() {
+			if err := s.sendManifest(sess); err != nil {
+				addLog(fmt.Sprintf("❌ Erreur envoi manifeste à %s: %v", sess.Name, err))
+			}
+		}()
+	}
+	return nil
+}
+
+// handleSetFilters remplace les filtres appliqués aux envois vers un client
+func (s *Server) handleSetFilters(sess *ClientSession, env *Envelope) error {
+	if !sess.HasCapability(CapFilters) {
+		return fmt.Errorf("capacité %s non négociée", CapFilters)
+	}
+	if err := sess.SetFilters(env.Payload); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("🔍 %s: filtres %s", sess.Name, sess.FilterSummary()))
+	return nil
+}
+
+// handleErrorMsg journalise une erreur signalée par le client
+func (s *Server) handleErrorMsg(sess *ClientSession, env *Envelope) error {
+	var errMsg ErrorMessage
+	if err := json.Unmarshal(env.Payload, &errMsg); err != nil {
+		return err
+	}
+	addLog(fmt.Sprintf("⚠️ %s: erreur distante %s (%s)", sess.Name, errMsg.Code, errMsg.Message))
+
+	// Déplacement envoyé à ce client qu'il n'a pas pu appliquer: envoi du
+	// contenu, soumis aux mêmes règles qu'un téléchargement
+	if errMsg.Code != ErrCodeMoveSourceMissing {
+		return nil
+	}
+	relPath, ok := cleanRelPath(errMsg.Message)
+	if !ok || !sess.takeMove(relPath) || !s.sendsToClients() {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(s.WatchDir, filepath.FromSlash(relPath)))
+	if err != nil {
+		return nil
+	}
+	size := s.fileSize(relPath)
+	if s.excluded(relPath, size, info.IsDir()) || !sess.CanRead(relPath, info.IsDir()) || !sess.Accepts(relPath, size, info.IsDir()) {
+		return nil
+	}
+	go s.sendMovedContent(sess, FileChange{FileName: relPath, IsDir: info.IsDir()})
+	return nil
+}
+
+// sessions retourne les clients connectés, sauf skip
+// Les envois se font sur cette copie, hors de s.mu: un client lent ne bloque
+// pas les autres traitements.
+func (s *Server) sessions(skip *websocket.Conn) []*ClientSession {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	
+
+	list := make([]*ClientSession, 0, len(s.Clients))
+	for client, sess := range s.Clients {
+		if client != skip {
+			list = append(list, sess)
+		}
+	}
+	return list
+}
+
+func (s *Server) broadcast(msg FileChange) {
+	if msg.Author == "" {
+		msg.Author = "Hôte"
+	}
+
+	for _, sess := range s.sessions(nil) {
+		s.sendChangeTo(sess, msg)
+	}
+}
+
+func (s *Server) broadcastExcept(msg FileChange, skip *websocket.Conn) {
 	msg.Origin = "server"
 	
-	for client := range s.Clients {
-		if client != skip {
-			client.WriteJSON(msg)
+	for _, sess := range s.sessions(skip) {
+		s.sendChangeTo(sess, msg)
+	}
+}
+
+// sendChangeTo envoie un changement à un client
+// Le contenu des fichiers est relu sur le disque pour pouvoir être envoyé par morceaux.
+// Les changements hors des dossiers abonnés ou exclus par les filtres du client
+// ne lui sont pas envoyés, pas plus que ceux exclus du partage (fichiers
+// .spiralyignore, filtres du partage), ceux que son compte ne peut pas lire,
+// ou tous si le mode du partage n'envoie rien.
+func (s *Server) sendChangeTo(sess *ClientSession, msg FileChange) error {
+	if !s.sendsToClients() {
+		return nil
+	}
+	if msg.Op == "move" {
+		size := s.fileSize(msg.FileName)
+		from := !s.excluded(msg.OldName, size, msg.IsDir) && sess.CanRead(msg.OldName, msg.IsDir) && sess.Accepts(msg.OldName, size, msg.IsDir)
+		to := !s.excluded(msg.FileName, size, msg.IsDir) && sess.CanRead(msg.FileName, msg.IsDir) && sess.Accepts(msg.FileName, size, msg.IsDir)
+		switch {
+		case !from && !to:
+			return nil
+		case !to:
+			// Déplacé hors de ce que reçoit le client: supprimé chez lui
+			remove := msg
+			remove.Op = "remove"
+			remove.FileName = msg.OldName
+			remove.OldName = ""
+			return sess.Send(MsgFileChange, remove)
+		case !from:
+			// Le client ne connaît pas l'ancien chemin: envoi du contenu
+			go s.sendMovedContent(sess, msg)
+			return nil
+		}
+	} else if s.excluded(msg.FileName, s.fileSize(msg.FileName), msg.IsDir) || !sess.CanRead(msg.FileName, msg.IsDir) {
+		return nil
+	} else if !sess.Accepts(msg.FileName, s.fileSize(msg.FileName), msg.IsDir) {
+		if msg.Op == "create" || msg.Op == "write" {
+			return sess.ReportIgnored("broadcast", 1, msg.FileName)
+		}
+		return nil
+	}
+
+	if msg.Op == "create" || msg.Op == "write" {
+		return s.sendFileTo(sess, msg.FileName, msg.Op)
+	}
+	if msg.Op == "move" && !sess.HasCapability(CapMove) {
+		// Client sans la capacité "move": suppression puis envoi du nouveau chemin
+		remove := msg
+		remove.Op = "remove"
+		remove.FileName = msg.OldName
+		remove.OldName = ""
+		if err := sess.Send(MsgFileChange, remove); err != nil {
+			return err
+		}
+		go s.sendMovedContent(sess, msg)
+		return nil
+	}
+	if msg.Op == "move" {
+		sess.rememberMove(msg.FileName)
+	}
+	return sess.Send(MsgFileChange, msg)
+}
+
+// sendMovedContent envoie le contenu d'un élément déplacé à un client qui ne
+// connaît pas l'opération "move" (ou n'a pas pu l'appliquer)
+// Un fichier est soumis aux droits et au quota de téléchargement du compte.
+func (s *Server) sendMovedContent(sess *ClientSession, msg FileChange) {
+	if !msg.IsDir {
+		size := s.fileSize(msg.FileName)
+		if !s.authorize(sess, "", msg.FileName, accessDownload) || !s.checkQuota(sess, "", msg.FileName, accessDownload, size) {
+			return
 		}
+		if err := s.sendFileTo(sess, msg.FileName, "create"); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur envoi %s: %v", msg.FileName, err))
+			return
+		}
+		s.recordUsage(sess, accessDownload, size, nil)
+		return
+	}
+
+	sess.Send(MsgFileChange, FileChange{
+		FileName: msg.FileName,
+		Op:       "mkdir",
+		IsDir:    true,
+		Origin:   "server",
+	})
+	s.sendDirRecursiveWithDelay(sess, s.WatchDir, filepath.FromSlash(msg.FileName), 0)
+}
+
+// fileSize retourne la taille d'un fichier du dossier partagé (0 si absent)
+func (s *Server) fileSize(relPath string) int64 {
+	info, err := os.Stat(filepath.Join(s.WatchDir, filepath.FromSlash(relPath)))
+	if err != nil || info.IsDir() {
+		return 0
 	}
+	return info.Size()
 }
 
+// sendFileTo envoie un fichier du dossier partagé à un client
+func (s *Server) sendFileTo(sess *ClientSession, relPath, op string) error {
+	fullPath := filepath.Join(s.WatchDir, filepath.FromSlash(relPath))
+	return sendFileContent(sess, fullPath, relPath, op, "server", sess.HasCapability(CapChunked))
+}
+
+// updateKnownFilesAndDirs initialise l'état connu du dossier
+// Avec un état persistant, la référence est celle de la dernière exécution:
+// les changements faits pendant l'arrêt sont détectés par periodicCheck.
 func (s *Server) updateKnownFilesAndDirs() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	if s.state.Len() > 0 {
+		s.knownFiles, s.knownDirs = s.state.Known()
+		addLog(fmt.Sprintf("📋 État de synchronisation chargé (%d éléments)", s.state.Len()))
+		return
+	}
+
 	s.scanDirRecursive(s.WatchDir, "")
+	s.state.Sync(s.knownFiles, s.knownDirs, nil)
 }
 
 func (s *Server) scanDirRecursive(basePath, relPath string) {
@@ -295,6 +1026,9 @@ func (s *Server) scanDirRecursive(basePath, relPath string) {
 
 	for _, entry := range entries {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) {
+			continue
+		}
 		info, _ := entry.Info()
 		
 		if entry.IsDir() {
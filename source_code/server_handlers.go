@@ -8,44 +8,111 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/gorilla/websocket"
 )
 
-func (s *Server) sendAllFilesAndDirs(ws *websocket.Conn) {
+func (s *Server) sendAllFilesAndDirs(sess *ClientSession) {
 	addLog("📤 Début envoi structure...")
 	time.Sleep(200 * time.Millisecond)
-	s.sendDirRecursiveWithDelay(ws, s.WatchDir, "", 0)
+	ignored := s.sendDirRecursiveWithDelay(sess, s.WatchDir, "", 0)
 	time.Sleep(300 * time.Millisecond)
+	if ignored > 0 {
+		addLog(fmt.Sprintf("🔍 %d éléments ignorés (filtres de %s)", ignored, sess.Name))
+		sess.ReportIgnored(MsgRequestAllFiles, ignored, "")
+	}
 	addLog("✅ Envoi structure terminé")
 }
 
-func (s *Server) sendFileTree(ws *websocket.Conn) {
+// manifestBatchSize nombre d'entrées par message du manifeste (et par demande du client)
+const manifestBatchSize = 1000
+
+// sendManifest envoie par lots l'état des fichiers du serveur (capacité "manifest")
+// Chaque fichier est décrit par sa taille, sa date et son hash, sans son contenu:
+// le client demande ensuite les fichiers manquants par download_request, soumis
+// au quota de téléchargement de son compte
+func (s *Server) sendManifest(sess *ClientSession) error {
+	var batch []ManifestEntry
+	count := 0
+
+	err := filepath.Walk(s.WatchDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(s.WatchDir, path)
+		if relPath == "." {
+			return nil
+		}
+		relPath = filepath.ToSlash(relPath)
+		if isInternalPath(relPath) || s.excluded(relPath, info.Size(), info.IsDir()) || !sess.Subscribed(relPath, info.IsDir()) || !sess.CanRead(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entry := ManifestEntry{
+			Path:    relPath,
+			IsDir:   info.IsDir(),
+			ModTime: info.ModTime(),
+		}
+		if !info.IsDir() {
+			hash, err := GetHashCache().GetHash(path)
+			if err != nil {
+				return nil
+			}
+			entry.Size = info.Size()
+			entry.Hash = hash
+			entry.Vector = s.state.VectorFor(relPath)
+		}
+
+		batch = append(batch, entry)
+		count++
+		if len(batch) >= manifestBatchSize {
+			err := sess.Send(MsgSyncManifest, SyncManifest{Entries: batch})
+			batch = nil
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sess.Send(MsgSyncManifest, SyncManifest{Entries: batch, Complete: true}); err != nil {
+		return err
+	}
+
+	addLog(fmt.Sprintf("📋 Manifeste envoyé à %s (%d éléments)", sess.Name, count))
+	return nil
+}
+
+func (s *Server) sendFileTree(sess *ClientSession) {
 	addLog("📂 Envoi de l'arborescence des fichiers...")
 	addLog(fmt.Sprintf("📂 Dossier surveillé: %s", s.WatchDir))
 	
 	// Vérifier que le dossier existe
 	if _, err := os.Stat(s.WatchDir); os.IsNotExist(err) {
 		addLog(fmt.Sprintf("❌ Le dossier n'existe pas: %s", s.WatchDir))
-		ws.WriteJSON(FileTreeItemMessage{
-			Type: "file_tree_complete",
+		sess.Send(MsgFileTreeComplete, FileTreeItemMessage{
+			Type: MsgFileTreeComplete,
 		})
 		return
 	}
 	
 	time.Sleep(200 * time.Millisecond)
-	count := s.sendTreeRecursiveCount(ws, s.WatchDir, "", 0)
+	count := s.sendTreeRecursiveCount(sess, s.WatchDir, "", 0)
 	
 	addLog(fmt.Sprintf("📂 %d éléments envoyés", count))
 
-	ws.WriteJSON(FileTreeItemMessage{
-		Type: "file_tree_complete",
+	sess.Send(MsgFileTreeComplete, FileTreeItemMessage{
+		Type: MsgFileTreeComplete,
 	})
 
 	time.Sleep(100 * time.Millisecond)
 	addLog("✅ Arborescence envoyée")
 }
 
-func (s *Server) sendTreeRecursiveCount(ws *websocket.Conn, basePath, relPath string, count int) int {
+func (s *Server) sendTreeRecursiveCount(sess *ClientSession, basePath, relPath string, count int) int {
 	fullPath := filepath.Join(basePath, relPath)
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
@@ -57,6 +124,10 @@ func (s *Server) sendTreeRecursiveCount(ws *websocket.Conn, basePath, relPath st
 	var files []os.DirEntry
 
 	for _, entry := range entries {
+		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) || s.excluded(itemRelPath, entrySize(entry), entry.IsDir()) || !sess.CanRead(itemRelPath, entry.IsDir()) {
+			continue
+		}
 		if entry.IsDir() {
 			dirs = append(dirs, entry)
 		} else {
@@ -67,8 +138,8 @@ func (s *Server) sendTreeRecursiveCount(ws *websocket.Conn, basePath, relPath st
 	for i, entry := range dirs {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
 
-		err := ws.WriteJSON(FileTreeItemMessage{
-			Type:  "file_tree_item",
+		err := sess.Send(MsgFileTreeItem, FileTreeItemMessage{
+			Type:  MsgFileTreeItem,
 			Path:  itemRelPath,
 			Name:  entry.Name(),
 			IsDir: true,
@@ -84,14 +155,14 @@ func (s *Server) sendTreeRecursiveCount(ws *websocket.Conn, basePath, relPath st
 			time.Sleep(50 * time.Millisecond)
 		}
 
-		count = s.sendTreeRecursiveCount(ws, basePath, itemRelPath, count)
+		count = s.sendTreeRecursiveCount(sess, basePath, itemRelPath, count)
 	}
 
 	for i, entry := range files {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
 
-		err := ws.WriteJSON(FileTreeItemMessage{
-			Type:  "file_tree_item",
+		err := sess.Send(MsgFileTreeItem, FileTreeItemMessage{
+			Type:  MsgFileTreeItem,
 			Path:  itemRelPath,
 			Name:  entry.Name(),
 			IsDir: false,
@@ -111,14 +182,23 @@ func (s *Server) sendTreeRecursiveCount(ws *websocket.Conn, basePath, relPath st
 	return count
 }
 
-func (s *Server) sendSelectedFiles(ws *websocket.Conn, items []string) {
+// sendSelectedFiles envoie les éléments demandés par un client (download_request)
+// Chaque élément est soumis aux droits et au quota de téléchargement de son compte.
+func (s *Server) sendSelectedFiles(sess *ClientSession, refID string, items []string) {
 	addLog(fmt.Sprintf("📤 Envoi de %d elements...", len(items)))
 	
 	filesSent := 0
 	dirsSent := 0
 	errors := 0
+	ignored := 0
+	denied := 0
 	
 	for _, itemPath := range items {
+		itemPath, ok := cleanRelPath(itemPath)
+		if !ok {
+			errors++
+			continue
+		}
 		fullPath := filepath.Join(s.WatchDir, filepath.FromSlash(itemPath))
 		
 		info, err := os.Stat(fullPath)
@@ -126,9 +206,21 @@ func (s *Server) sendSelectedFiles(ws *websocket.Conn, items []string) {
 			errors++
 			continue
 		}
+		if s.excluded(itemPath, info.Size(), info.IsDir()) || !sess.Accepts(itemPath, info.Size(), info.IsDir()) {
+			ignored++
+			continue
+		}
+		if !info.IsDir() && (!s.authorize(sess, refID, itemPath, accessDownload) || !s.checkQuota(sess, refID, itemPath, accessDownload, info.Size())) {
+			denied++
+			continue
+		}
+		if info.IsDir() && !sess.CanRead(itemPath, true) {
+			denied++
+			continue
+		}
 		
 		if info.IsDir() {
-			ws.WriteJSON(FileChange{
+			sess.Send(MsgFileChange, FileChange{
 				FileName: itemPath,
 				Op:       "mkdir",
 				IsDir:    true,
@@ -137,44 +229,67 @@ func (s *Server) sendSelectedFiles(ws *websocket.Conn, items []string) {
 			dirsSent++
 			time.Sleep(30 * time.Millisecond)
 		} else {
-			data, err := os.ReadFile(fullPath)
-			if err != nil {
+			// Envoyer même si vide (le client gérera)
+			if err := s.sendFileTo(sess, itemPath, "create"); err != nil {
 				errors++
 				continue
 			}
-			
-			// Envoyer même si vide (le client gérera)
-			encoded := base64.StdEncoding.EncodeToString(data)
-			
-			ws.WriteJSON(FileChange{
-				FileName: itemPath,
-				Op:       "create",
-				Content:  encoded,
-				IsDir:    false,
-				Origin:   "server",
-			})
 			filesSent++
+			s.recordUsage(sess, accessDownload, info.Size(), nil)
 			time.Sleep(50 * time.Millisecond)
 		}
 	}
 	
 	addLog(fmt.Sprintf("✅ Envoyes: %d dossiers, %d fichiers", dirsSent, filesSent))
+	if ignored > 0 {
+		addLog(fmt.Sprintf("🔍 %d éléments ignorés (filtres de %s)", ignored, sess.Name))
+		sess.ReportIgnored(MsgDownloadRequest, ignored, "")
+	}
+	if denied > 0 {
+		addLog(fmt.Sprintf("🚫 %d éléments refusés (compte de %s)", denied, sess.Name))
+	}
 	if errors > 0 {
 		addLog(fmt.Sprintf("⚠️ %d erreurs", errors))
 	}
 }
 
-func (s *Server) sendDirRecursiveWithDelay(ws *websocket.Conn, basePath, relPath string, level int) {
+// entrySize retourne la taille d'un fichier lu dans un dossier (0 pour un dossier)
+func entrySize(entry os.DirEntry) int64 {
+	if entry.IsDir() {
+		return 0
+	}
+	if info, err := entry.Info(); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+// sendDirRecursiveWithDelay envoie le contenu d'un dossier à un client
+// Retourne le nombre d'éléments écartés par les filtres du client
+func (s *Server) sendDirRecursiveWithDelay(sess *ClientSession, basePath, relPath string, level int) int {
 	fullPath := filepath.Join(basePath, relPath)
 	entries, err := os.ReadDir(fullPath)
 	if err != nil {
-		return
+		return 0
 	}
 
 	var dirs []os.DirEntry
 	var files []os.DirEntry
+	ignored := 0
 	
 	for _, entry := range entries {
+		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) || !sess.Subscribed(itemRelPath, entry.IsDir()) || !sess.CanRead(itemRelPath, entry.IsDir()) {
+			continue
+		}
+		size := entrySize(entry)
+		if s.excluded(itemRelPath, size, entry.IsDir()) {
+			continue
+		}
+		if !sess.Accepts(itemRelPath, size, entry.IsDir()) {
+			ignored++
+			continue
+		}
 		if entry.IsDir() {
 			dirs = append(dirs, entry)
 		} else {
@@ -185,7 +300,7 @@ func (s *Server) sendDirRecursiveWithDelay(ws *websocket.Conn, basePath, relPath
 	for i, entry := range dirs {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
 		
-		ws.WriteJSON(FileChange{
+		sess.Send(MsgFileChange, FileChange{
 			FileName: itemRelPath,
 			Op:       "mkdir",
 			IsDir:    true,
@@ -202,27 +317,21 @@ func (s *Server) sendDirRecursiveWithDelay(ws *websocket.Conn, basePath, relPath
 			time.Sleep(50 * time.Millisecond)
 		}
 		
-		s.sendDirRecursiveWithDelay(ws, basePath, filepath.Join(relPath, entry.Name()), level+1)
+		ignored += s.sendDirRecursiveWithDelay(sess, basePath, filepath.Join(relPath, entry.Name()), level+1)
 	}
 	
 	for i, entry := range files {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
 		fullFilePath := filepath.Join(basePath, relPath, entry.Name())
-		
-		data, err := os.ReadFile(fullFilePath)
-		if err != nil {
+		size := entrySize(entry)
+		if !s.checkQuota(sess, "", itemRelPath, accessDownload, size) {
 			continue
 		}
 		
-		encoded := base64.StdEncoding.EncodeToString(data)
-		
-		ws.WriteJSON(FileChange{
-			FileName: itemRelPath,
-			Op:       "create",
-			Content:  encoded,
-			IsDir:    false,
-			Origin:   "server",
-		})
+		if err := sendFileContent(sess, fullFilePath, itemRelPath, "create", "server", sess.HasCapability(CapChunked)); err != nil {
+			continue
+		}
+		s.recordUsage(sess, accessDownload, size, nil)
 		
 		time.Sleep(40 * time.Millisecond)
 		
@@ -230,6 +339,7 @@ func (s *Server) sendDirRecursiveWithDelay(ws *websocket.Conn, basePath, relPath
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
+	return ignored
 }
 
 func (s *Server) watchRecursive() {
@@ -252,6 +362,9 @@ func (s *Server) watchRecursive() {
 			if !ok {
 				return
 			}
+			if isInternalFile(s.WatchDir, event.Name) {
+				continue
+			}
 			
 			time.Sleep(50 * time.Millisecond)
 			s.handleEvent(event)
@@ -287,6 +400,9 @@ func (s *Server) addDirToWatcher(watcher *fsnotify.Watcher, dir string) {
 	for i, entry := range entries {
 		if entry.IsDir() {
 			subDir := filepath.Join(dir, entry.Name())
+			if isInternalFile(s.WatchDir, subDir) {
+				continue
+			}
 			s.addDirToWatcher(watcher, subDir)
 			
 			if i > 0 && i%5 == 0 {
@@ -331,6 +447,11 @@ func (s *Server) handleEvent(event fsnotify.Event) {
 		if _, err := os.Stat(event.Name); err != nil {
 			return
 		}
+
+		// Élément renommé: déplacé chez les clients, sans renvoyer son contenu
+		if s.detectMove(relPath) {
+			return
+		}
 		
 		if isDir {
 			s.mu.Lock()
@@ -358,19 +479,14 @@ func (s *Server) handleEvent(event fsnotify.Event) {
 			}
 			s.mu.Unlock()
 			
-			data, err := readFileWithRetry(event.Name)
-			if err != nil {
-				return
-			}
-			
 			s.mu.Lock()
 			s.knownFiles[relPath] = time.Now()
 			s.mu.Unlock()
 			
+			// Le contenu est lu à l'envoi, par morceaux si nécessaire
 			msg := FileChange{
 				FileName: relPath,
 				Op:       "create",
-				Content:  base64.StdEncoding.EncodeToString(data),
 				IsDir:    false,
 				Origin:   "server",
 			}
@@ -383,8 +499,7 @@ func (s *Server) handleEvent(event fsnotify.Event) {
 	if event.Op&fsnotify.Write != 0 && !isDir {
 		time.Sleep(100 * time.Millisecond)
 		
-		data, err := readFileWithRetry(event.Name)
-		if err != nil {
+		if _, err := os.Stat(event.Name); err != nil {
 			return
 		}
 		
@@ -395,7 +510,6 @@ func (s *Server) handleEvent(event fsnotify.Event) {
 		msg := FileChange{
 			FileName: relPath,
 			Op:       "write",
-			Content:  base64.StdEncoding.EncodeToString(data),
 			IsDir:    false,
 			Origin:   "server",
 		}
@@ -432,6 +546,59 @@ func (s *Server) handleEvent(event fsnotify.Event) {
 	}
 }
 
+// detectMove envoie comme déplacement un élément apparu qui correspond à un
+// chemin connu disparu du disque. Retourne false si ce n'est pas un déplacement.
+func (s *Server) detectMove(relPath string) bool {
+	s.mu.Lock()
+	_, knownDir := s.knownDirs[relPath]
+	_, knownFile := s.knownFiles[relPath]
+	s.mu.Unlock()
+	if knownDir || knownFile {
+		return false
+	}
+
+	move, ok := s.state.MovedFrom(relPath)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	_, fromDir := s.knownDirs[move.From]
+	_, fromFile := s.knownFiles[move.From]
+	if !fromDir && !fromFile {
+		s.mu.Unlock()
+		return false
+	}
+	msg := s.recordMove(move)
+	s.mu.Unlock()
+
+	s.broadcast(msg)
+	time.Sleep(150 * time.Millisecond)
+	return true
+}
+
+// recordMove reporte un déplacement local dans l'état du serveur et retourne
+// le message à envoyer aux clients (s.mu verrouillé)
+func (s *Server) recordMove(move FileMove) FileChange {
+	movePaths(s.knownFiles, move.From, move.To)
+	movePaths(s.knownDirs, move.From, move.To)
+	s.state.Move(move.From, move.To)
+	s.versions.Move(move.From, move.To)
+	GetChunkStore().MoveTracked(
+		filepath.Join(s.WatchDir, filepath.FromSlash(move.From)),
+		filepath.Join(s.WatchDir, filepath.FromSlash(move.To)))
+
+	addLog(fmt.Sprintf("📤 Déplacé: %s → %s", move.From, move.To))
+	return FileChange{
+		FileName: move.To,
+		OldName:  move.From,
+		Op:       "move",
+		IsDir:    move.IsDir,
+		Origin:   "server",
+		Author:   "Hôte",
+	}
+}
+
 func (s *Server) applyChange(msg FileChange) {
 	normalizedPath := filepath.FromSlash(msg.FileName)
 	path := filepath.Join(s.WatchDir, normalizedPath)
@@ -448,33 +615,78 @@ func (s *Server) applyChange(msg FileChange) {
 		s.mu.Lock()
 		s.knownDirs[msg.FileName] = time.Now()
 		s.mu.Unlock()
+		s.state.Record(msg.FileName)
 		
 	case "create", "write":
 		dir := filepath.Dir(path)
 		os.MkdirAll(dir, 0755)
+		s.versions.Capture(msg.FileName, false)
 		
-		data, err := base64.StdEncoding.DecodeString(msg.Content)
-		if err != nil {
-			return
+		if msg.LocalFile != "" {
+			// Fichier reçu par morceaux: enregistré puis reconstruit depuis le stockage
+			if err := GetChunkStore().CommitFile(s.WatchDir, msg.LocalFile, path); err != nil {
+				addLog(fmt.Sprintf("❌ Erreur écriture %s: %v", msg.FileName, err))
+				return
+			}
+		} else {
+			data, err := base64.StdEncoding.DecodeString(msg.Content)
+			if err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+			if err := GetChunkStore().WriteFile(s.WatchDir, path, data); err != nil {
+				addLog(fmt.Sprintf("❌ Erreur écriture %s: %v", msg.FileName, err))
+				return
+			}
 		}
-		time.Sleep(50 * time.Millisecond)
-		os.WriteFile(path, data, 0644)
 		s.mu.Lock()
 		s.knownFiles[msg.FileName] = time.Now()
 		s.mu.Unlock()
+		s.state.RecordRemote(msg.FileName, msg.Vector)
 		
+	case "move":
+		s.mu.Lock()
+		s.skipNext[msg.OldName] = time.Now().Add(5 * time.Second)
+		s.mu.Unlock()
+
+		// Un élément déjà présent à la destination part à la corbeille
+		move := FileMove{From: msg.OldName, To: msg.FileName, IsDir: msg.IsDir}
+		if info, err := os.Lstat(path); err == nil {
+			if info.IsDir() {
+				s.versions.CaptureDir(msg.FileName)
+				GetChunkStore().UntrackDir(path)
+			} else {
+				s.versions.Capture(msg.FileName, true)
+				GetChunkStore().Untrack(path)
+			}
+		}
+		if err := renameSyncedPath(s.WatchDir, s.trash, move, msg.Author); err != nil {
+			addLog(fmt.Sprintf("❌ Erreur déplacement %s: %v", msg.OldName, err))
+			return
+		}
+		s.mu.Lock()
+		movePaths(s.knownFiles, move.From, move.To)
+		movePaths(s.knownDirs, move.From, move.To)
+		s.mu.Unlock()
+		s.versions.Move(move.From, move.To)
+		s.state.Move(move.From, move.To)
+
 	case "remove":
 		if msg.IsDir {
-			os.RemoveAll(path)
+			s.versions.CaptureDir(msg.FileName)
+			GetChunkStore().UntrackDir(path)
 			s.mu.Lock()
 			delete(s.knownDirs, msg.FileName)
 			s.mu.Unlock()
 		} else {
-			os.Remove(path)
+			s.versions.Capture(msg.FileName, true)
+			GetChunkStore().Untrack(path)
 			s.mu.Lock()
 			delete(s.knownFiles, msg.FileName)
 			s.mu.Unlock()
 		}
+		s.trash.Remove(msg.FileName, msg.Author)
+		s.state.Forget(msg.FileName)
 	}
 	
 	time.Sleep(100 * time.Millisecond)
@@ -499,7 +711,19 @@ func (s *Server) periodicCheck() {
 			currentDirs := make(map[string]time.Time)
 			s.scanCurrentState(s.WatchDir, "", currentFiles, currentDirs)
 
+			// Les changements sont envoyés après avoir relâché s.mu: un client
+			// lent ne doit pas bloquer les autres
+			var outgoing []FileChange
 			s.mu.Lock()
+
+			// Déplacements: chemins connus disparus associés aux chemins apparus
+			vanished, appeared := diffPaths(s.knownFiles, s.knownDirs, currentFiles, currentDirs, func(path string) bool {
+				until, exists := s.skipNext[path]
+				return exists && time.Now().Before(until)
+			})
+			for _, move := range s.state.DetectMoves(vanished, appeared) {
+				outgoing = append(outgoing, s.recordMove(move))
+			}
 			
 			for oldDir := range s.knownDirs {
 				if until, exists := s.skipNext[oldDir]; exists && time.Now().Before(until) {
@@ -513,11 +737,8 @@ func (s *Server) periodicCheck() {
 						Origin:   "server",
 					}
 					delete(s.knownDirs, oldDir)
-					for c := range s.Clients {
-						c.WriteJSON(msg)
-					}
+					outgoing = append(outgoing, msg)
 					addLog("🗑️ Dossier supprimé: " + oldDir)
-					time.Sleep(150 * time.Millisecond)
 				}
 			}
 			
@@ -533,11 +754,8 @@ func (s *Server) periodicCheck() {
 						Origin:   "server",
 					}
 					delete(s.knownFiles, oldFile)
-					for c := range s.Clients {
-						c.WriteJSON(msg)
-					}
+					outgoing = append(outgoing, msg)
 					addLog("🗑️ Supprimé: " + oldFile)
-					time.Sleep(150 * time.Millisecond)
 				}
 			}
 
@@ -553,11 +771,8 @@ func (s *Server) periodicCheck() {
 						Origin:   "server",
 					}
 					s.knownDirs[newDir] = modTime
-					for c := range s.Clients {
-						c.WriteJSON(msg)
-					}
+					outgoing = append(outgoing, msg)
 					addLog("📤 Dossier créé: " + newDir)
-					time.Sleep(150 * time.Millisecond)
 				}
 			}
 
@@ -567,25 +782,44 @@ func (s *Server) periodicCheck() {
 						continue
 					}
 
-					data, err := readFileWithRetry(filepath.Join(s.WatchDir, name))
-					if err == nil {
+					// Date changée mais contenu identique à la dernière synchronisation
+					if exists && s.state.Unchanged(name) {
+						s.knownFiles[name] = modTime
+						continue
+					}
+
+					if _, err := os.Stat(filepath.Join(s.WatchDir, name)); err == nil {
 						msg := FileChange{
 							FileName: name,
 							Op:       "write",
-							Content:  base64.StdEncoding.EncodeToString(data),
 							IsDir:    false,
 							Origin:   "server",
 						}
-						for c := range s.Clients {
-							c.WriteJSON(msg)
-						}
+						outgoing = append(outgoing, msg)
 						s.knownFiles[name] = modTime
 						addLog("📤 Modifié: " + name)
-						time.Sleep(150 * time.Millisecond)
 					}
 				}
 			}
+			// Les fichiers en cours d'écriture par un client sont enregistrés par applyChange
+			busy := make(map[string]bool)
+			for path, until := range s.skipNext {
+				if time.Now().Before(until) {
+					busy[path] = true
+				}
+			}
 			s.mu.Unlock()
+
+			for _, msg := range outgoing {
+				for _, sess := range s.sessions(nil) {
+					s.sendChangeTo(sess, msg)
+				}
+				if msg.Op != "move" {
+					time.Sleep(150 * time.Millisecond)
+				}
+			}
+
+			s.state.Sync(currentFiles, currentDirs, busy)
 		}
 	}
 }
@@ -599,6 +833,9 @@ func (s *Server) scanCurrentState(basePath, relPath string, files map[string]tim
 
 	for i, entry := range entries {
 		itemRelPath := filepath.ToSlash(filepath.Join(relPath, entry.Name()))
+		if isInternalPath(itemRelPath) {
+			continue
+		}
 		info, _ := entry.Info()
 		
 		if entry.IsDir() {
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// PARTAGES - Plusieurs dossiers synchronisés publiés par un même hôte
+// ============================================================================
+
+// ShareConfig configuration d'un partage de l'hôte (section "shares" de spiraly_config.json)
+type ShareConfig struct {
+	Name string   `json:"name"`           // Nom choisi par les clients (vide = partage par défaut)
+	Path string   `json:"path"`           // Dossier partagé
+	Mode SyncMode `json:"mode,omitempty"` // Mode de synchronisation (0 = bidirectionnel)
+	// Filtres appliqués par l'hôte, forme JSON de FileFilters
+	Filters json.RawMessage `json:"filters,omitempty"`
+	// Accès: identifiant propre au partage (vide = ID de l'hôte) et lecture seule
+	AccessID string `json:"access_id,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	// Chiffrement de bout en bout: seuls les clients connaissant la phrase
+	// secrète s'y connectent, l'hôte ne conserve que des noms et contenus chiffrés
+	E2E bool `json:"e2e,omitempty"`
+}
+
+// DisplayName retourne le nom du partage pour les logs
+func (sc ShareConfig) DisplayName() string {
+	return shareDisplayName(sc.Name)
+}
+
+// shareDisplayName retourne le nom d'un partage, "par défaut" s'il n'en a pas
+func shareDisplayName(name string) string {
+	if name == "" {
+		return "par défaut"
+	}
+	return name
+}
+
+// ShareMount association d'un partage distant à un dossier local (côté client)
+type ShareMount struct {
+	Server string `json:"server"`          // Adresse de l'hôte (ip:port)
+	HostID string `json:"host_id"`         // ID de l'hôte ou du partage
+	Share  string `json:"share,omitempty"` // Nom du partage (vide = partage par défaut)
+	Dir    string `json:"dir"`             // Dossier local
+	User   string `json:"user,omitempty"`  // Compte utilisateur (mot de passe: SPIRALY_PASSWORD)
+	E2E    bool   `json:"e2e,omitempty"`   // Partage chiffré de bout en bout (phrase secrète: SPIRALY_PASSPHRASE)
+}
+
+// ValidateShares vérifie les noms et dossiers des partages
+// Deux partages ne peuvent ni porter le même nom ni se chevaucher.
+func ValidateShares(shares []ShareConfig) error {
+	names := make(map[string]bool)
+	var paths []string
+	for _, share := range shares {
+		if strings.ContainsAny(share.Name, `/\`) {
+			return fmt.Errorf("nom de partage invalide: %s", share.Name)
+		}
+		if names[share.Name] {
+			return fmt.Errorf("partage en double: %s", share.DisplayName())
+		}
+		names[share.Name] = true
+
+		if share.Path == "" {
+			return fmt.Errorf("partage %s: dossier manquant", share.DisplayName())
+		}
+		absPath, err := filepath.Abs(share.Path)
+		if err != nil {
+			return fmt.Errorf("partage %s: %v", share.DisplayName(), err)
+		}
+		for _, other := range paths {
+			if isSameOrSubdir(absPath, other) || isSameOrSubdir(other, absPath) {
+				return fmt.Errorf("partage %s: dossier %s déjà partagé", share.DisplayName(), absPath)
+			}
+		}
+		paths = append(paths, absPath)
+	}
+	return nil
+}
+
+// isSameOrSubdir indique si dir est base ou l'un de ses sous-dossiers
+func isSameOrSubdir(dir, base string) bool {
+	rel, err := filepath.Rel(base, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// sharesFromConfig retourne les partages configurés, ou le partage par défaut
+// dans dir (<données>/Spiralydata si dir est vide)
+// Les dossiers relatifs des partages sont pris dans le dossier des données.
+func sharesFromConfig(config *AppConfig, dir string) []ShareConfig {
+	if config != nil && len(config.Shares) > 0 && dir == "" {
+		shares := make([]ShareConfig, len(config.Shares))
+		for i, share := range config.Shares {
+			if share.Path != "" && !filepath.IsAbs(share.Path) {
+				share.Path = filepath.Join(getDataDir(), share.Path)
+			}
+			shares[i] = share
+		}
+		return shares
+	}
+	if dir == "" {
+		dir = filepath.Join(getDataDir(), "Spiralydata")
+	}
+	return []ShareConfig{{Path: dir}}
+}
+
+// Host publie un ou plusieurs partages sur un même port
+// Chaque client choisit son partage dans auth_request; sans choix, il reçoit
+// le partage sans nom, ou le premier partage configuré.
+type Host struct {
+	HostID     string
+	Config     HostConfig // Section host de la configuration au démarrage
+	Upgrader   websocket.Upgrader
+	servers    []*Server // Un serveur par partage, dans l'ordre de la configuration
+	mu         sync.Mutex
+	httpServer *http.Server
+	cancel     context.CancelFunc
+}
+
+// NewHost crée l'hôte de partages déjà validés par ValidateShares
+func NewHost(hostID string, shares []ShareConfig, config HostConfig) *Host {
+	h := &Host{
+		HostID: hostID,
+		Config: config,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin:     func(r *http.Request) bool { return true },
+			ReadBufferSize:  10 * 1024 * 1024, // 10MB
+			WriteBufferSize: 10 * 1024 * 1024, // 10MB
+		},
+	}
+	for _, share := range shares {
+		h.servers = append(h.servers, NewServer(hostID, share))
+	}
+	return h
+}
+
+// Servers retourne les serveurs des partages
+func (h *Host) Servers() []*Server {
+	return h.servers
+}
+
+// server retourne le serveur du partage demandé par un client
+func (h *Host) server(name string) *Server {
+	for _, s := range h.servers {
+		if s.Share.Name == name {
+			return s
+		}
+	}
+	if name == "" && len(h.servers) > 0 {
+		return h.servers[0]
+	}
+	return nil
+}
+
+// Start ouvre les partages et bloque jusqu'à l'arrêt de l'hôte
+// Un stockage chiffré doit avoir été déverrouillé (ChunkStore.Unlock).
+func (h *Host) Start(port string) error {
+	store := GetChunkStore()
+	if store.NeedsUnlock(h.Config.NeedsStoreKey()) {
+		addLog(fmt.Sprintf("❌ %v", errStoreLocked))
+		return errStoreLocked
+	}
+	store.SetEncrypt(h.Config.EncryptStore)
+
+	configureTrashRetention()
+	go store.GC()
+	applyHostConfig(h.Config)
+
+	cert, err := loadHostCertificate(h.Config)
+	if err != nil {
+		addLog(fmt.Sprintf("❌ Certificat TLS: %v", err))
+		return err
+	}
+
+	addLog("Serveur démarré")
+	addLog(fmt.Sprintf("ID: %s", h.HostID))
+	addLog(fmt.Sprintf("🔏 Empreinte du certificat: %s", certFingerprint(cert.Certificate[0])))
+	for _, s := range h.servers {
+		s.open()
+	}
+	addLog("En attente de connexions...")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.watchConfig(ctx, h.Config)
+
+	// Créer un nouveau mux pour éviter les conflits lors du redémarrage
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", h.handleWS)
+
+	h.mu.Lock()
+	h.httpServer = &http.Server{
+		Addr:         h.Config.ListenAddr(port),
+		Handler:      mux,
+		TLSConfig:    hostTLSConfig(cert),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	httpServer := h.httpServer
+	h.cancel = cancel
+	h.mu.Unlock()
+	if h.Config.BindAddress != "" {
+		addLog(fmt.Sprintf("Écoute: %s", httpServer.Addr))
+	} else {
+		addLog(fmt.Sprintf("Port: %s", port))
+	}
+
+	if err := httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		addLog(fmt.Sprintf("Erreur serveur: %v", err))
+		return err
+	}
+	return nil
+}
+
+// Stop déconnecte les clients de tous les partages et libère le port
+func (h *Host) Stop() {
+	addLog("Arrêt du serveur...")
+	for _, s := range h.servers {
+		s.close()
+	}
+
+	h.mu.Lock()
+	httpServer := h.httpServer
+	h.httpServer = nil
+	if h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+	}
+	h.mu.Unlock()
+	if httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}
+
+	// Attendre que le port soit libéré
+	time.Sleep(1 * time.Second)
+	for _, s := range h.servers {
+		s.release()
+	}
+	addLog("Serveur arrêté")
+}
+
+// handleWS lit la demande d'authentification et confie la connexion au
+// serveur du partage demandé
+// La liste blanche et la limite de connexions par IP sont vérifiées avant tout.
+func (h *Host) handleWS(w http.ResponseWriter, r *http.Request) {
+	addLog(fmt.Sprintf("🔌 Connexion depuis %s", r.RemoteAddr))
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !GetIPWhitelist().IsAllowed(ip) {
+		addLog(fmt.Sprintf("🚫 Connexion refusée (%s hors liste blanche)", ip))
+		http.Error(w, "Adresse non autorisée", http.StatusForbidden)
+		return
+	}
+	if !GetRateLimiter().Allow(ip) {
+		addLog(fmt.Sprintf("🚫 Connexion refusée (%s: trop de tentatives)", ip))
+		http.Error(w, "Trop de connexions", http.StatusTooManyRequests)
+		return
+	}
+
+	ws, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		addLog(fmt.Sprintf("❌ Erreur WebSocket: %v", err))
+		return
+	}
+
+	// Augmenter la limite de lecture pour les gros fichiers
+	ws.SetReadLimit(50 * 1024 * 1024) // 50MB
+
+	ws.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var rawMsg json.RawMessage
+	if err := ws.ReadJSON(&rawMsg); err != nil {
+		addLog(fmt.Sprintf("❌ Erreur lecture: %v", err))
+		ws.Close()
+		return
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	var authReq AuthRequest
+	if err := json.Unmarshal(rawMsg, &authReq); err != nil {
+		addLog(fmt.Sprintf("❌ Erreur parsing: %v", err))
+		ws.Close()
+		return
+	}
+	if authReq.Type != "auth_request" {
+		ws.Close()
+		return
+	}
+
+	s := h.server(authReq.Share)
+	if s == nil {
+		addLog(fmt.Sprintf("🚫 Connexion refusée (partage inconnu: %s)", authReq.Share))
+		ws.WriteJSON(AuthResponse{
+			Type:    "auth_failed",
+			Message: "Partage inconnu: " + authReq.Share,
+		})
+		ws.Close()
+		return
+	}
+	s.acceptClient(ws, authReq, ip)
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ============================================================================
+// CHIFFREMENT AU REPOS - Stockage dédupliqué et sauvegardes de l'hôte
+// ============================================================================
+//
+// La clé du stockage est créée au premier déverrouillage et enregistrée dans
+// .spiralydata/store_key.json du dossier des données, chiffrée par la clé
+// maître dérivée de la phrase secrète de l'hôte (argon2id, sel et paramètres
+// dans le fichier). Les objets chiffrés (morceaux, manifestes, listes des
+// sauvegardes) ont le format de FileEncryptor (EncryptedFileHeader); les objets
+// écrits en clair restent lisibles.
+//   - host.encrypt_store: tout nouvel objet du stockage est chiffré (fichiers
+//     synchronisés, versions, sauvegardes, snapshots), ainsi que les index qui
+//     nomment les fichiers (index.json, versions.json, backups.json, snapshots)
+//     et la corbeille de l'hôte (contenu et index.json);
+//   - host.encrypt_backups: les objets d'une sauvegarde et sa liste de
+//     fichiers sont chiffrés, un morceau déjà présent en clair est rechiffré.
+// Les noms des objets restent les hashes SHA-256 du contenu en clair. Le dossier
+// partagé reste une copie de travail en clair: l'hôte le sert et le surveille.
+
+const (
+	storeKeyFileName   = "store_key.json"
+	storeMinPassphrase = 8
+	storeKeyLifetime   = 365 * 24 * time.Hour // Indicative: la clé n'expire pas
+)
+
+// Erreurs du stockage chiffré
+var (
+	errStoreLocked     = errors.New("stockage chiffré verrouillé: phrase secrète de l'hôte requise")
+	errStorePassphrase = errors.New("phrase secrète du stockage incorrecte")
+)
+
+// StoreKeyFile clés du stockage, chiffrées par la clé maître de l'hôte
+type StoreKeyFile struct {
+	KDF    string          `json:"kdf"` // Sel et paramètres de la clé maître (format PHC)
+	Active string          `json:"active"`
+	Keys   []E2EWrappedKey `json:"keys"`
+}
+
+// keyPath emplacement de la clé du stockage
+func (cs *ChunkStore) keyPath() string {
+	return filepath.Join(cs.base, internalDirName, storeKeyFileName)
+}
+
+// HasKey indique si une clé a été créée: le stockage peut contenir des objets chiffrés
+func (cs *ChunkStore) HasKey() bool {
+	_, err := os.Stat(cs.keyPath())
+	return err == nil
+}
+
+// Unlocked indique si la clé du stockage est déverrouillée
+func (cs *ChunkStore) Unlocked() bool {
+	cs.keyMu.RLock()
+	defer cs.keyMu.RUnlock()
+	return cs.crypt != nil
+}
+
+// NeedsUnlock indique si la phrase secrète doit être demandée avant de démarrer
+// l'hôte: la clé existe déjà, ou la configuration demande le chiffrement
+func (cs *ChunkStore) NeedsUnlock(encrypt bool) bool {
+	return (encrypt || cs.HasKey()) && !cs.Unlocked()
+}
+
+// Unlock déverrouille la clé du stockage avec la phrase secrète de l'hôte
+// Au premier appel, la clé est créée et chiffrée avec cette phrase secrète.
+func (cs *ChunkStore) Unlock(passphrase string) error {
+	data, err := os.ReadFile(cs.keyPath())
+	if os.IsNotExist(err) {
+		return cs.createKey(passphrase)
+	}
+	if err != nil {
+		return err
+	}
+
+	var file StoreKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s illisible: %w", storeKeyFileName, err)
+	}
+	params, _, err := ParseKDFParams(file.KDF)
+	if err != nil {
+		return err
+	}
+
+	km := NewKeyManager()
+	km.UnlockMasterKey(passphrase, params)
+	for _, key := range file.Keys {
+		if err := km.ImportKey(key.ID, key.Key); err != nil {
+			return errStorePassphrase
+		}
+	}
+	if err := km.SetActiveKey(file.Active); err != nil {
+		return err
+	}
+
+	cs.setKeys(km)
+	addLog("🔓 Stockage chiffré déverrouillé")
+	cs.reloadSealed()
+	return nil
+}
+
+// createKey génère la clé du stockage et l'enregistre chiffrée par la phrase secrète
+func (cs *ChunkStore) createKey(passphrase string) error {
+	if len(passphrase) < storeMinPassphrase {
+		return fmt.Errorf("phrase secrète trop courte (min %d caractères)", storeMinPassphrase)
+	}
+
+	km := NewKeyManager()
+	km.SetMasterKey(passphrase)
+	key, err := km.GenerateKey(storeKeyLifetime)
+	if err != nil {
+		return err
+	}
+	wrapped, err := wrapE2EKey(km, key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(StoreKeyFile{
+		KDF:    km.MasterKeyParams().String(),
+		Active: key.ID,
+		Keys:   []E2EWrappedKey{wrapped},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cs.keyPath()), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(cs.base, cs.keyPath(), data); err != nil {
+		return err
+	}
+
+	cs.setKeys(km)
+	addLog(fmt.Sprintf("🔑 Clé du stockage créée (%s): conservez la phrase secrète, elle ne pourra pas être retrouvée", cs.keyPath()))
+	return nil
+}
+
+// setKeys remplace les clés du stockage déverrouillé
+func (cs *ChunkStore) setKeys(km *KeyManager) {
+	config := NewEncryptionConfig()
+	config.Enabled = true
+
+	cs.keyMu.Lock()
+	cs.crypt = NewFileEncryptor(km, config)
+	cs.keyMu.Unlock()
+}
+
+// SetEncrypt chiffre (ou non) les nouveaux objets du stockage (host.encrypt_store)
+// Les index déjà enregistrés en clair sont alors chiffrés.
+func (cs *ChunkStore) SetEncrypt(enabled bool) {
+	cs.keyMu.Lock()
+	cs.encrypt = enabled
+	cs.keyMu.Unlock()
+	if enabled {
+		cs.reloadSealed()
+	}
+}
+
+// Encryptor retourne le chiffreur du stockage déverrouillé (sauvegardes chiffrées)
+func (cs *ChunkStore) Encryptor() (*FileEncryptor, error) {
+	return cs.sealer(true)
+}
+
+// sealer retourne le chiffreur des nouveaux objets, nil s'ils restent en clair
+// force: objets d'une sauvegarde chiffrée, quelle que soit host.encrypt_store
+func (cs *ChunkStore) sealer(force bool) (*FileEncryptor, error) {
+	cs.keyMu.RLock()
+	defer cs.keyMu.RUnlock()
+
+	if !force && !cs.encrypt {
+		return nil, nil
+	}
+	if cs.crypt == nil {
+		return nil, errStoreLocked
+	}
+	return cs.crypt, nil
+}
+
+// sealObject chiffre le contenu d'un objet, inchangé si fe est nil
+func sealObject(fe *FileEncryptor, payload []byte) ([]byte, error) {
+	if fe == nil {
+		return payload, nil
+	}
+	return fe.EncryptBytes(payload)
+}
+
+// openObject déchiffre un objet lu dans le stockage
+// Un objet écrit en clair est retourné tel quel.
+func (cs *ChunkStore) openObject(data []byte) ([]byte, error) {
+	if !IsEncryptedContent(bytes.NewReader(data)) {
+		return data, nil
+	}
+
+	cs.keyMu.RLock()
+	fe := cs.crypt
+	cs.keyMu.RUnlock()
+	if fe == nil {
+		return nil, errStoreLocked
+	}
+	return fe.DecryptBytes(data)
+}
+
+// isSealedObject indique si un objet du stockage est déjà chiffré
+func isSealedObject(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	return IsEncryptedContent(file)
+}
+
+// ============================================================================
+// INDEX ET CORBEILLE
+// ============================================================================
+
+// RegisterSealed ajoute un index chargé avant le déverrouillage du stockage
+// (liste des sauvegardes, snapshots). reload est appelé après Unlock et quand
+// le chiffrement est activé: il relit l'index resté illisible et chiffre celui
+// qui est encore en clair.
+func (cs *ChunkStore) RegisterSealed(name string, reload func()) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.sealed[name] = reload
+}
+
+// reloadSealed relit et chiffre les index enregistrés
+func (cs *ChunkStore) reloadSealed() {
+	cs.mu.Lock()
+	reloads := make([]func(), 0, len(cs.sealed))
+	for _, fn := range cs.sealed {
+		reloads = append(reloads, fn)
+	}
+	cs.mu.Unlock()
+
+	for _, fn := range reloads {
+		fn()
+	}
+}
+
+// WriteSealed écrit un index, chiffré si host.encrypt_store est activé
+// Le fichier est écrit via le dossier temporaire de root puis renommé.
+func (cs *ChunkStore) WriteSealed(root, path string, data []byte) error {
+	fe, err := cs.sealer(false)
+	if err != nil {
+		return err
+	}
+	if data, err = sealObject(fe, data); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(root, path, data)
+}
+
+// ReadSealed lit un index écrit par WriteSealed, chiffré ou en clair
+// Retourne errStoreLocked si l'index est chiffré et la clé verrouillée.
+func (cs *ChunkStore) ReadSealed(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return cs.openObject(data)
+}
+
+// SealsNewObjects indique si les nouveaux objets et index sont chiffrés
+func (cs *ChunkStore) SealsNewObjects() bool {
+	fe, err := cs.sealer(false)
+	return err == nil && fe != nil
+}
+
+// NeedsSealing indique si un fichier encore en clair doit être chiffré
+func (cs *ChunkStore) NeedsSealing(path string) bool {
+	if !cs.SealsNewObjects() {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	return !isSealedObject(path)
+}
+
+// SealFile chiffre un fichier sur place si host.encrypt_store est activé
+// Retourne false si le fichier reste en clair.
+func (cs *ChunkStore) SealFile(path string) (bool, error) {
+	fe, err := cs.sealer(false)
+	if err != nil || fe == nil {
+		return false, err
+	}
+
+	return true, replaceFile(path, func(dst io.Writer, src *os.File) error {
+		info, err := src.Stat()
+		if err != nil {
+			return err
+		}
+		return fe.EncryptTo(dst, src, info.Size())
+	})
+}
+
+// UnsealFile déchiffre sur place un fichier chiffré par SealFile
+// Un fichier en clair est laissé tel quel.
+func (cs *ChunkStore) UnsealFile(path string) error {
+	if !isSealedObject(path) {
+		return nil
+	}
+
+	cs.keyMu.RLock()
+	fe := cs.crypt
+	cs.keyMu.RUnlock()
+	if fe == nil {
+		return errStoreLocked
+	}
+
+	return replaceFile(path, func(dst io.Writer, src *os.File) error {
+		_, err := fe.DecryptTo(dst, src)
+		return err
+	})
+}
+
+// replaceFile réécrit un fichier via un fichier temporaire voisin puis un renommage
+func replaceFile(path string, convert func(dst io.Writer, src *os.File) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".seal-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if err := convert(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	src.Close()
+	return os.Rename(tmpPath, path)
+}
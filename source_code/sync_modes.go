@@ -60,15 +60,19 @@ type TransferItem struct {
 	Compressed bool
 	Retries    int
 	AddedAt    time.Time
+	Direction  string // TransferIn (réception) ou TransferOut (envoi)
+	Offset     int64  // Octets déjà transférés et vérifiés
+	Resumable  bool   // Transfert interrompu, repris à la reconnexion
 }
 
 // TransferQueue gère la file d'attente des transferts
 type TransferQueue struct {
-	items    []*TransferItem
-	mu       sync.Mutex
-	maxSize  int
-	paused   bool
-	throttle int64 // bytes/sec
+	items     []*TransferItem
+	resumable []*TransferItem // Transferts interrompus en attente de reprise
+	mu        sync.Mutex
+	maxSize   int
+	paused    bool
+	throttle  int64 // bytes/sec
 }
 
 // NewTransferQueue crée une nouvelle file de transfert
@@ -193,6 +197,23 @@ func (tq *TransferQueue) GetItems() []*TransferItem {
 	return copy
 }
 
+// SetResumable remplace la liste des transferts interrompus
+func (tq *TransferQueue) SetResumable(items []*TransferItem) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	tq.resumable = items
+}
+
+// GetResumable retourne une copie des transferts interrompus
+func (tq *TransferQueue) GetResumable() []*TransferItem {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	
+	items := make([]*TransferItem, len(tq.resumable))
+	copy(items, tq.resumable)
+	return items
+}
+
 // RemoveByPath retire un élément par son chemin
 func (tq *TransferQueue) RemoveByPath(path string) bool {
 	tq.mu.Lock()
@@ -542,6 +563,7 @@ const (
 	ActionCreate ActionType = iota
 	ActionModify
 	ActionDelete
+	ActionMove
 )
 
 // PendingAction représente une action locale en attente d'envoi au serveur
@@ -552,6 +574,7 @@ type PendingAction struct {
 	ModTime time.Time
 	IsDir   bool
 	AddedAt time.Time
+	OldPath string // Ancien chemin (ActionMove)
 }
 
 // GetDescription retourne une description lisible de l'action
@@ -563,6 +586,8 @@ func (pa *PendingAction) GetDescription() string {
 		return "Modifié"
 	case ActionDelete:
 		return "Supprimé"
+	case ActionMove:
+		return "Déplacé"
 	default:
 		return "Inconnu"
 	}
@@ -577,6 +602,8 @@ func (pa *PendingAction) GetIcon() string {
 		return "✏️"
 	case ActionDelete:
 		return "🗑️"
+	case ActionMove:
+		return "🔀"
 	default:
 		return "❓"
 	}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ============================================================================
+// ÉTAT DE SYNCHRONISATION PERSISTANT
+// ============================================================================
+//
+// Chaque dossier synchronisé (hôte et client) garde dans .spiralydata/state.db
+// l'état de chaque chemin lors de la dernière synchronisation: hash, date,
+// taille et numéro de version. Au redémarrage, cet état sert de référence:
+//   - présent dans la base mais absent du disque: supprimé hors connexion
+//   - présent sur le disque mais absent de la base: jamais synchronisé
+//   - date différente mais même hash: simplement touché, rien à envoyer
+//
+// La base (bbolt, pur Go) est chargée en mémoire à l'ouverture; chaque
+// modification est écrite immédiatement dans une transaction.
+//
+// Le contenu synchronisé des petits fichiers texte est aussi conservé dans
+// .spiralydata/ancestors/: c'est l'ancêtre commun des fusions à trois voies.
+//
+// La base contient aussi l'identifiant du nœud (hôte ou client) utilisé dans
+// les vecteurs de version: une modification locale incrémente son compteur,
+// un contenu reçu d'un pair reprend le vecteur qui l'accompagne.
+//
+// L'identifiant de chaque chemin sur le volume (inode) est aussi noté: il
+// permet de reconnaître un élément renommé (voir DetectMoves).
+
+const (
+	syncStateFileName = "state.db"
+	syncStateBucket   = "files"
+	syncMetaBucket    = "meta"
+	syncNodeIDKey     = "node_id"
+	syncAncestorsDir  = "ancestors"
+
+	// maxAncestorSize taille maximale d'un fichier texte dont l'ancêtre est conservé
+	maxAncestorSize = 1024 * 1024
+)
+
+// SyncRecord état d'un chemin lors de sa dernière synchronisation
+type SyncRecord struct {
+	Path     string    `json:"path"`
+	IsDir    bool      `json:"is_dir,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+	ModTime  time.Time `json:"mtime"`
+	Size     int64     `json:"size,omitempty"`
+	Version  uint64    `json:"version"` // Incrémenté à chaque changement de contenu
+	SyncedAt time.Time `json:"synced_at"`
+	// Modifications par nœud (fichiers uniquement)
+	Vector VersionVector `json:"vector,omitempty"`
+	// Identifiant sur le volume (inode), conservé par un renommage
+	FileID string `json:"file_id,omitempty"`
+}
+
+// SyncState base d'état d'un dossier synchronisé
+// Toutes les méthodes acceptent un SyncState nil (base indisponible): elles
+// ne font alors rien et le comportement en mémoire seule est conservé.
+type SyncState struct {
+	root    string
+	nodeID  string // Identifiant de ce nœud dans les vecteurs de version
+	db      *bolt.DB
+	mu      sync.RWMutex
+	records map[string]*SyncRecord
+}
+
+// OpenSyncState ouvre (ou crée) la base d'état de root
+func OpenSyncState(root string) (*SyncState, error) {
+	dir := filepath.Join(root, internalDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Un autre processus sur le même dossier garde le verrou: ne pas bloquer
+	db, err := bolt.Open(filepath.Join(dir, syncStateFileName), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("ouverture de l'état de synchronisation: %w", err)
+	}
+
+	st := &SyncState{
+		root:    root,
+		db:      db,
+		records: make(map[string]*SyncRecord),
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(syncMetaBucket))
+		if err != nil {
+			return err
+		}
+		if id := meta.Get([]byte(syncNodeIDKey)); id != nil {
+			st.nodeID = string(id)
+		} else {
+			st.nodeID = newNodeID()
+			if err := meta.Put([]byte(syncNodeIDKey), []byte(st.nodeID)); err != nil {
+				return err
+			}
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists([]byte(syncStateBucket))
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var rec SyncRecord
+			if json.Unmarshal(v, &rec) == nil {
+				st.records[string(k)] = &rec
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// newNodeID génère l'identifiant d'un nouveau nœud
+func newNodeID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// openSyncStateOrWarn ouvre la base d'état, ou retourne nil en le signalant
+func openSyncStateOrWarn(root string) *SyncState {
+	st, err := OpenSyncState(root)
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ État de synchronisation indisponible: %v", err))
+		return nil
+	}
+	return st
+}
+
+// Close ferme la base
+func (st *SyncState) Close() error {
+	if st == nil {
+		return nil
+	}
+	return st.db.Close()
+}
+
+// NodeID retourne l'identifiant de ce nœud ("" sans base)
+func (st *SyncState) NodeID() string {
+	if st == nil {
+		return ""
+	}
+	return st.nodeID
+}
+
+// Len retourne le nombre de chemins enregistrés
+func (st *SyncState) Len() int {
+	if st == nil {
+		return 0
+	}
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return len(st.records)
+}
+
+// Get retourne l'état enregistré d'un chemin
+func (st *SyncState) Get(relPath string) (SyncRecord, bool) {
+	if st == nil {
+		return SyncRecord{}, false
+	}
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	rec, ok := st.records[relPath]
+	if !ok {
+		return SyncRecord{}, false
+	}
+	return *rec, true
+}
+
+// Known retourne les fichiers et dossiers enregistrés avec leur date
+func (st *SyncState) Known() (files, dirs map[string]time.Time) {
+	files = make(map[string]time.Time)
+	dirs = make(map[string]time.Time)
+	if st == nil {
+		return files, dirs
+	}
+
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for path, rec := range st.records {
+		if rec.IsDir {
+			dirs[path] = rec.ModTime
+		} else {
+			files[path] = rec.ModTime
+		}
+	}
+	return files, dirs
+}
+
+// Unchanged indique si le fichier sur le disque a le contenu enregistré
+// Une date différente avec le même hash signifie que le fichier a seulement été touché
+func (st *SyncState) Unchanged(relPath string) bool {
+	rec, ok := st.Get(relPath)
+	if !ok || rec.IsDir {
+		return false
+	}
+
+	fullPath := filepath.Join(st.root, filepath.FromSlash(relPath))
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() || info.Size() != rec.Size {
+		return false
+	}
+
+	hash, err := GetHashCache().GetHash(fullPath)
+	return err == nil && hash == rec.Hash
+}
+
+// VectorFor retourne le vecteur de version du fichier tel qu'il est sur le disque
+// Un contenu différent de celui enregistré est une modification locale: le
+// compteur de ce nœud est incrémenté. Retourne nil si le fichier est inconnu.
+func (st *SyncState) VectorFor(relPath string) VersionVector {
+	if st == nil {
+		return nil
+	}
+
+	rec, ok := st.Get(relPath)
+	if ok && !rec.IsDir && st.Unchanged(relPath) {
+		return rec.Vector.Copy()
+	}
+
+	if _, err := os.Stat(filepath.Join(st.root, filepath.FromSlash(relPath))); err != nil {
+		if ok {
+			return rec.Vector.Copy()
+		}
+		return nil
+	}
+	return rec.Vector.Increment(st.nodeID)
+}
+
+// Ancestor retourne le contenu d'un fichier texte lors de sa dernière synchronisation
+func (st *SyncState) Ancestor(relPath string) ([]byte, bool) {
+	rec, ok := st.Get(relPath)
+	if !ok || rec.IsDir {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(st.ancestorPath(rec.Hash))
+	if err != nil || HashData(data) != rec.Hash {
+		return nil, false
+	}
+	return data, true
+}
+
+// Record enregistre l'état actuel des chemins après leur synchronisation
+// Les chemins absents du disque sont ignorés.
+func (st *SyncState) Record(relPaths ...string) error {
+	if st == nil {
+		return nil
+	}
+
+	var records []*SyncRecord
+	for _, relPath := range relPaths {
+		rec, err := st.readDisk(relPath)
+		if err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+	return st.commit(records, nil, nil)
+}
+
+// RecordRemote enregistre un fichier reçu d'un pair avec son vecteur de version
+func (st *SyncState) RecordRemote(relPath string, vector VersionVector) error {
+	return st.RecordRemoteAll(map[string]VersionVector{relPath: vector})
+}
+
+// RecordRemoteAll enregistre plusieurs fichiers reçus dans une seule transaction
+// Le vecteur enregistré succède à la fois à l'ancien et à celui reçu.
+func (st *SyncState) RecordRemoteAll(vectors map[string]VersionVector) error {
+	if st == nil {
+		return nil
+	}
+
+	var records []*SyncRecord
+	for relPath := range vectors {
+		rec, err := st.readDisk(relPath)
+		if err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+	return st.commit(records, nil, vectors)
+}
+
+// AcknowledgeRemote note qu'une version d'un pair a été vue sans être écrite
+// (conflit résolu en gardant la version locale ou une fusion): le contenu
+// local devient une modification qui succède aux deux versions.
+func (st *SyncState) AcknowledgeRemote(relPath, hash string, size int64, vector VersionVector) error {
+	if st == nil {
+		return nil
+	}
+
+	rec := &SyncRecord{Path: relPath, Hash: hash, Size: size}
+	if old, ok := st.Get(relPath); ok {
+		rec.ModTime = old.ModTime
+	}
+	return st.commit([]*SyncRecord{rec}, nil, map[string]VersionVector{relPath: vector})
+}
+
+// Forget retire un chemin supprimé (et tout son contenu pour un dossier)
+func (st *SyncState) Forget(relPath string) error {
+	if st == nil {
+		return nil
+	}
+
+	prefix := relPath + "/"
+	var removed []string
+
+	st.mu.RLock()
+	for path := range st.records {
+		if path == relPath || strings.HasPrefix(path, prefix) {
+			removed = append(removed, path)
+		}
+	}
+	st.mu.RUnlock()
+
+	if len(removed) == 0 {
+		return nil
+	}
+	return st.commit(nil, removed, nil)
+}
+
+// Move reporte un déplacement (et tout le contenu d'un dossier)
+// Le contenu n'a pas changé: hash, versions et vecteurs sont conservés.
+// L'état d'un élément remplacé à la destination est oublié.
+func (st *SyncState) Move(from, to string) error {
+	if st == nil {
+		return nil
+	}
+
+	fromPrefix := from + "/"
+	toPrefix := to + "/"
+	var moved []*SyncRecord
+	var removed []string
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for path, rec := range st.records {
+		if path == to || strings.HasPrefix(path, toPrefix) {
+			removed = append(removed, path)
+		}
+		if path == from || strings.HasPrefix(path, fromPrefix) {
+			m := *rec
+			m.Path = to + path[len(from):]
+			moved = append(moved, &m)
+			removed = append(removed, path)
+		}
+	}
+	if len(moved) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	err := st.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(syncStateBucket))
+		for _, path := range removed {
+			if err := bucket.Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+		for _, rec := range moved {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(rec.Path), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for _, path := range removed {
+		stale = append(stale, st.records[path].Hash)
+		delete(st.records, path)
+	}
+	for _, rec := range moved {
+		st.records[rec.Path] = rec
+	}
+	st.pruneAncestors(stale)
+	return nil
+}
+
+// Reset oublie tout l'état (dossier local vidé)
+func (st *SyncState) Reset() error {
+	if st == nil {
+		return nil
+	}
+
+	st.mu.RLock()
+	removed := make([]string, 0, len(st.records))
+	for path := range st.records {
+		removed = append(removed, path)
+	}
+	st.mu.RUnlock()
+
+	return st.commit(nil, removed, nil)
+}
+
+// Sync aligne la base sur un scan complet du dossier
+// Seuls les chemins dont la date a changé sont relus; le hash n'est recalculé
+// que pour ceux-là (et mis en cache par FileHashCache). Les chemins de busy,
+// en cours d'écriture par une synchronisation, sont laissés tels quels.
+func (st *SyncState) Sync(files, dirs map[string]time.Time, busy map[string]bool) error {
+	if st == nil {
+		return nil
+	}
+
+	var updated []*SyncRecord
+	var removed []string
+	var missingID []SyncRecord // Enregistrés avant le suivi des identifiants
+
+	st.mu.RLock()
+	for path, modTime := range files {
+		if busy[path] {
+			continue
+		}
+		if rec, ok := st.records[path]; ok && !rec.IsDir && rec.ModTime.Equal(modTime) {
+			if rec.FileID == "" {
+				missingID = append(missingID, *rec)
+			}
+			continue
+		}
+		updated = append(updated, &SyncRecord{Path: path, ModTime: modTime})
+	}
+	for path, modTime := range dirs {
+		if busy[path] {
+			continue
+		}
+		if rec, ok := st.records[path]; ok && rec.IsDir && rec.FileID != "" {
+			continue
+		}
+		updated = append(updated, &SyncRecord{Path: path, IsDir: true, ModTime: modTime})
+	}
+	for path := range st.records {
+		_, isFile := files[path]
+		_, isDir := dirs[path]
+		if !isFile && !isDir && !busy[path] {
+			removed = append(removed, path)
+		}
+	}
+	st.mu.RUnlock()
+
+	// Relire le disque hors du verrou: le calcul des hash peut être long
+	records := updated[:0]
+	for _, rec := range updated {
+		disk, err := st.readDisk(rec.Path)
+		if err != nil {
+			continue
+		}
+		records = append(records, disk)
+	}
+	// Contenu inchangé: seul l'identifiant est ajouté, sans recalcul du hash
+	for i := range missingID {
+		rec := &missingID[i]
+		fullPath := filepath.Join(st.root, filepath.FromSlash(rec.Path))
+		if info, err := os.Stat(fullPath); err == nil {
+			if rec.FileID = fileIdentity(fullPath, info); rec.FileID != "" {
+				records = append(records, rec)
+			}
+		}
+	}
+
+	if len(records) == 0 && len(removed) == 0 {
+		return nil
+	}
+	return st.commit(records, removed, nil)
+}
+
+// readDisk lit l'état d'un chemin sur le disque
+func (st *SyncState) readDisk(relPath string) (*SyncRecord, error) {
+	fullPath := filepath.Join(st.root, filepath.FromSlash(relPath))
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &SyncRecord{
+		Path:    relPath,
+		IsDir:   info.IsDir(),
+		ModTime: info.ModTime(),
+		FileID:  fileIdentity(fullPath, info),
+	}
+	if !info.IsDir() {
+		hash, err := GetHashCache().GetHash(fullPath)
+		if err != nil {
+			return nil, err
+		}
+		rec.Hash = hash
+		rec.Size = info.Size()
+	}
+	return rec, nil
+}
+
+// commit écrit les changements dans une seule transaction puis met à jour la mémoire
+// La version d'un chemin n'augmente que si son contenu (ou son type) change.
+// Les chemins de remote ont été reçus d'un pair: leur vecteur reprend celui reçu,
+// les autres changements de contenu sont des modifications de ce nœud.
+func (st *SyncState) commit(updated []*SyncRecord, removed []string, remote map[string]VersionVector) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	for _, rec := range updated {
+		rec.SyncedAt = now
+		old, exists := st.records[rec.Path]
+		if exists {
+			rec.Version = old.Version
+			if old.Hash != rec.Hash || old.IsDir != rec.IsDir {
+				rec.Version++
+			}
+		} else {
+			rec.Version = 1
+		}
+
+		if rec.IsDir {
+			continue
+		}
+		var base VersionVector
+		if exists {
+			base = old.Vector
+		}
+		if vector, received := remote[rec.Path]; received {
+			rec.Vector = base.Merge(vector)
+		} else if !exists || old.Hash != rec.Hash {
+			rec.Vector = base.Increment(st.nodeID)
+		} else {
+			rec.Vector = base
+		}
+	}
+
+	err := st.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(syncStateBucket))
+		for _, rec := range updated {
+			data, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(rec.Path), data); err != nil {
+				return err
+			}
+		}
+		for _, path := range removed {
+			if err := bucket.Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	for _, rec := range updated {
+		if old, ok := st.records[rec.Path]; ok && old.Hash != rec.Hash {
+			stale = append(stale, old.Hash)
+		}
+		st.records[rec.Path] = rec
+		st.saveAncestor(rec)
+	}
+	for _, path := range removed {
+		if old, ok := st.records[path]; ok {
+			stale = append(stale, old.Hash)
+		}
+		delete(st.records, path)
+	}
+	st.pruneAncestors(stale)
+	return nil
+}
+
+// ancestorPath chemin du contenu conservé pour un hash
+func (st *SyncState) ancestorPath(hash string) string {
+	return filepath.Join(st.root, internalDirName, syncAncestorsDir, hash[:2], hash)
+}
+
+// saveAncestor conserve le contenu synchronisé d'un petit fichier texte
+// L'appelant doit détenir st.mu
+func (st *SyncState) saveAncestor(rec *SyncRecord) {
+	if rec.IsDir || rec.Hash == "" || rec.Size > maxAncestorSize || !IsTextFile(rec.Path) {
+		return
+	}
+
+	target := st.ancestorPath(rec.Hash)
+	if _, err := os.Stat(target); err == nil {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(st.root, filepath.FromSlash(rec.Path)))
+	if err != nil || HashData(data) != rec.Hash {
+		return // Modifié depuis l'enregistrement
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return
+	}
+	writeFileAtomic(st.root, target, data)
+}
+
+// pruneAncestors supprime les contenus qui ne correspondent plus à aucun chemin
+// L'appelant doit détenir st.mu
+func (st *SyncState) pruneAncestors(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	used := make(map[string]bool, len(st.records))
+	for _, rec := range st.records {
+		used[rec.Hash] = true
+	}
+	for _, hash := range hashes {
+		if hash != "" && !used[hash] {
+			os.Remove(st.ancestorPath(hash))
+		}
+	}
+}
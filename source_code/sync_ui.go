@@ -291,10 +291,12 @@ func ShowConflictDialog(window fyne.Window, conflict *Conflict, onResolve func(C
 		"📁 Version locale:\n"+
 		"   Taille: %s\n"+
 		"   Modifié: %s\n"+
-		"   Hash: %s...",
+		"   Hash: %s...\n"+
+		"   Versions: %s",
 		FormatFileSize(conflict.LocalVersion.Size),
 		conflict.LocalVersion.ModTime.Format("02/01/2006 15:04:05"),
 		conflict.LocalVersion.Hash[:16],
+		conflict.LocalVersion.Vector,
 	))
 	
 	// Infos version distante
@@ -302,10 +304,12 @@ func ShowConflictDialog(window fyne.Window, conflict *Conflict, onResolve func(C
 		"☁️ Version distante:\n"+
 		"   Taille: %s\n"+
 		"   Modifié: %s\n"+
-		"   Hash: %s...",
+		"   Hash: %s...\n"+
+		"   Versions: %s",
 		FormatFileSize(conflict.RemoteVersion.Size),
 		conflict.RemoteVersion.ModTime.Format("02/01/2006 15:04:05"),
 		conflict.RemoteVersion.Hash[:16],
+		conflict.RemoteVersion.Vector,
 	))
 	
 	infoPanel := container.NewGridWithColumns(2, localInfo, remoteInfo)
@@ -460,6 +464,43 @@ func ShowTransferQueueDialog(window fyne.Window, queue *TransferQueue) {
 		currentActions := pendingActions.GetAll()
 		titleLabel.SetText(fmt.Sprintf("Actions en attente (%d)", len(currentActions)))
 		
+		// Transferts interrompus, repris automatiquement à la reconnexion
+		if resumable := queue.GetResumable(); len(resumable) > 0 {
+			resumeTitle := widget.NewLabelWithStyle(
+				fmt.Sprintf("⏸️ Transferts interrompus (%d) - reprise à la reconnexion", len(resumable)),
+				fyne.TextAlignLeading,
+				fyne.TextStyle{Bold: true},
+			)
+			actionsContainer.Add(resumeTitle)
+			
+			for _, item := range resumable {
+				percent := 0.0
+				if item.Size > 0 {
+					percent = float64(item.Offset) * 100 / float64(item.Size)
+				}
+				
+				dirIcon := "⬇️"
+				progressText := fmt.Sprintf("%s / %s (%.0f%%)",
+					FormatFileSize(item.Offset), FormatFileSize(item.Size), percent)
+				if item.Direction == TransferOut {
+					dirIcon = "⬆️"
+					progressText = FormatFileSize(item.Size)
+				}
+				
+				itemLabel := widget.NewLabel(fmt.Sprintf("%s 📄  %s", dirIcon, item.Path))
+				itemLabel.Wrapping = fyne.TextWrapWord
+				
+				row := container.NewBorder(
+					nil, nil,
+					nil,
+					widget.NewLabel(progressText),
+					itemLabel,
+				)
+				actionsContainer.Add(container.NewPadded(row))
+			}
+			actionsContainer.Add(widget.NewSeparator())
+		}
+		
 		if len(currentActions) == 0 {
 			emptyLabel := widget.NewLabel("Aucune action en attente.\n\nLes modifications locales apparaîtront ici\naprès création, modification ou suppression de fichiers.")
 			emptyLabel.Alignment = fyne.TextAlignCenter
@@ -477,6 +518,9 @@ func ShowTransferQueueDialog(window fyne.Window, queue *TransferQueue) {
 				
 				// Ligne d'information
 				actionText := fmt.Sprintf("%s %s  %s", action.GetIcon(), typeIcon, action.Path)
+				if action.Type == ActionMove {
+					actionText = fmt.Sprintf("%s %s  %s → %s", action.GetIcon(), typeIcon, action.OldPath, action.Path)
+				}
 				actionLabel := widget.NewLabel(actionText)
 				actionLabel.Wrapping = fyne.TextWrapWord
 				
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// TLS - Certificat de l'hôte et épinglage côté client
+// ============================================================================
+
+// Fichiers du certificat auto-signé, dans le dossier des données de l'hôte
+const (
+	hostCertFileName = "spiraly_cert.pem"
+	hostKeyFileName  = "spiraly_key.pem"
+	hostCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+// errCertChanged le certificat présenté ne correspond pas à l'empreinte épinglée
+var errCertChanged = errors.New("certificat de l'hôte modifié")
+
+// CertChangedError détail d'un certificat qui ne correspond plus à l'empreinte
+// enregistrée lors de la première connexion
+type CertChangedError struct {
+	ServerAddr string
+	Pinned     string // Empreinte enregistrée
+	Presented  string // Empreinte du certificat reçu
+}
+
+func (e *CertChangedError) Error() string {
+	return fmt.Sprintf("%v (%s): empreinte %s, attendue %s", errCertChanged, e.ServerAddr, e.Presented, e.Pinned)
+}
+
+func (e *CertChangedError) Unwrap() error {
+	return errCertChanged
+}
+
+// certFingerprint retourne l'empreinte SHA-256 d'un certificat (forme AB:CD:...)
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// loadHostCertificate charge le certificat de l'hôte: host.cert_file et
+// host.key_file s'ils sont définis, sinon le certificat auto-signé du dossier
+// des données, généré au premier démarrage
+func loadHostCertificate(hc HostConfig) (tls.Certificate, error) {
+	if hc.CertFile != "" || hc.KeyFile != "" {
+		return tls.LoadX509KeyPair(hc.CertFile, hc.KeyFile)
+	}
+
+	certPath := filepath.Join(getDataDir(), hostCertFileName)
+	keyPath := filepath.Join(getDataDir(), hostKeyFileName)
+	if _, err := os.Stat(certPath); os.IsNotExist(err) {
+		addLog("🔏 Génération du certificat auto-signé de l'hôte...")
+		if err := generateSelfSignedCert(certPath, keyPath); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// generateSelfSignedCert crée un certificat ECDSA P-256 auto-signé
+// La clé privée n'est lisible que par l'utilisateur de l'hôte.
+func generateSelfSignedCert(certPath, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Spiralydata " + hostname, Organization: []string{"Spiralydata"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(hostCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return os.WriteFile(certPath, certPEM, 0644)
+}
+
+// hostTLSConfig configuration TLS du serveur HTTP de l'hôte
+func hostTLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// ============================================================================
+// ÉPINGLAGE DES CERTIFICATS (CLIENT)
+// ============================================================================
+
+// pinMu sérialise les mises à jour de pinned_certs (plusieurs clients avec sync -all)
+var pinMu sync.Mutex
+
+// pinnedFingerprint retourne l'empreinte enregistrée pour un hôte ("" si aucune)
+func pinnedFingerprint(serverAddr string) string {
+	pinMu.Lock()
+	defer pinMu.Unlock()
+
+	config, err := LoadConfig()
+	if err != nil {
+		return ""
+	}
+	return config.PinnedCerts[serverAddr]
+}
+
+// pinFingerprint enregistre l'empreinte d'un hôte dans la configuration
+func pinFingerprint(serverAddr, fingerprint string) error {
+	pinMu.Lock()
+	defer pinMu.Unlock()
+
+	config, _ := LoadConfig()
+	if config.PinnedCerts == nil {
+		config.PinnedCerts = make(map[string]string)
+	}
+	config.PinnedCerts[serverAddr] = fingerprint
+	return SaveConfig(config)
+}
+
+// certCheck vérifie le certificat présenté par l'hôte pendant le handshake
+// Le certificat étant auto-signé, la chaîne n'est pas vérifiée: l'empreinte
+// vue à la première connexion est enregistrée puis exigée (trust on first use).
+type certCheck struct {
+	serverAddr  string
+	pinned      string
+	fingerprint string            // Empreinte du certificat reçu
+	changed     *CertChangedError // Certificat différent de l'empreinte enregistrée
+}
+
+// newCertCheck prépare la vérification du certificat d'un hôte
+func newCertCheck(serverAddr string) *certCheck {
+	return &certCheck{
+		serverAddr: serverAddr,
+		pinned:     pinnedFingerprint(serverAddr),
+	}
+}
+
+// tlsConfig configuration TLS du client pour cet hôte
+func (cc *certCheck) tlsConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify:    true, // Remplacé par verify
+		MinVersion:            tls.VersionTLS12,
+		VerifyPeerCertificate: cc.verify,
+	}
+}
+
+// verify compare le certificat de l'hôte à l'empreinte enregistrée
+func (cc *certCheck) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("aucun certificat présenté par l'hôte")
+	}
+	cc.fingerprint = certFingerprint(rawCerts[0])
+	if cc.pinned != "" && cc.pinned != cc.fingerprint {
+		cc.changed = &CertChangedError{
+			ServerAddr: cc.serverAddr,
+			Pinned:     cc.pinned,
+			Presented:  cc.fingerprint,
+		}
+		return cc.changed
+	}
+	return nil
+}
+
+// pinIfNew enregistre l'empreinte d'un hôte à la première connexion réussie
+func (cc *certCheck) pinIfNew() {
+	if cc.pinned != "" || cc.fingerprint == "" {
+		return
+	}
+	if err := pinFingerprint(cc.serverAddr, cc.fingerprint); err != nil {
+		addLog(fmt.Sprintf("⚠️ Empreinte du certificat non enregistrée: %v", err))
+		return
+	}
+	addLog(fmt.Sprintf("🔏 Nouveau certificat enregistré pour %s: %s", cc.serverAddr, cc.fingerprint))
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ============================================================================
+// TRANSFERT PAR MORCEAUX (capacité "chunked")
+// ============================================================================
+//
+// Déroulement d'un transfert:
+//   1. transfer_begin (JSON)   : nom, opération, taille annoncée
+//   2. N trames binaires       : [type 1o][len id 1o][id][offset 8o BE][données]
+//   3. transfer_commit (JSON)  : taille et hash SHA-256 du fichier complet
+//
+// Le récepteur écrit les morceaux dans un fichier temporaire du dossier interne
+// puis le renomme à destination une fois le hash vérifié.
+
+const (
+	// streamThreshold taille au-delà de laquelle un fichier est envoyé par morceaux
+	streamThreshold = 512 * 1024
+
+	// chunkedReadLimit limite de lecture des trames quand "chunked" est négocié
+	// (morceau de 4MB maximum + en-tête, les petits fichiers restent en JSON)
+	chunkedReadLimit = 8 * 1024 * 1024
+
+	// chunkFrameType identifie une trame binaire de morceau de fichier
+	chunkFrameType byte = 0x01
+)
+
+// Erreurs de transfert
+var (
+	ErrUnknownTransfer = errors.New("transfert inconnu")
+	ErrChunkOffset     = errors.New("offset de morceau inattendu")
+	ErrTransferHash    = errors.New("hash du fichier reçu invalide")
+	ErrTransferSize    = errors.New("taille du fichier reçu invalide")
+	ErrInvalidChunk    = errors.New("trame de morceau invalide")
+)
+
+// frameSender est implémenté par les deux extrémités d'une connexion
+// (ClientSession côté serveur, Client côté utilisateur)
+type frameSender interface {
+	Send(msgType string, payload interface{}) error
+	sendBinary(header, data []byte) error
+	HasCapability(c string) bool             // Capacité négociée avec le pair
+	resumeOffset(transferID string) int64    // Position de reprise annoncée par le pair
+	fileVector(relPath string) VersionVector // Vecteur de version du fichier envoyé
+	pendingDeltas() *deltaOffers             // Offres de delta en attente de signature
+}
+
+// encodeChunkHeader construit l'en-tête d'une trame de morceau
+func encodeChunkHeader(transferID string, offset int64) []byte {
+	header := make([]byte, 0, 2+len(transferID)+8)
+	header = append(header, chunkFrameType, byte(len(transferID)))
+	header = append(header, transferID...)
+	header = binary.BigEndian.AppendUint64(header, uint64(offset))
+	return header
+}
+
+// readChunkHeader lit l'en-tête d'une trame de morceau
+func readChunkHeader(r io.Reader) (string, int64, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return "", 0, err
+	}
+	if prefix[0] != chunkFrameType {
+		return "", 0, ErrInvalidChunk
+	}
+
+	id := make([]byte, prefix[1])
+	if _, err := io.ReadFull(r, id); err != nil {
+		return "", 0, err
+	}
+
+	var offset [8]byte
+	if _, err := io.ReadFull(r, offset[:]); err != nil {
+		return "", 0, err
+	}
+
+	return string(id), int64(binary.BigEndian.Uint64(offset[:])), nil
+}
+
+// writeBinaryFrame écrit en-tête et données dans une seule trame binaire
+// L'appelant doit détenir le verrou d'écriture de la connexion
+func writeBinaryFrame(conn *websocket.Conn, header, data []byte) error {
+	w, err := conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		w.Close()
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// sendFileContent envoie le contenu d'un fichier, par morceaux si le pair
+// supporte "chunked" et que le fichier dépasse streamThreshold.
+// Un gros fichier modifié est proposé en delta si le pair supporte "delta".
+func sendFileContent(fs frameSender, fullPath, relPath, op, origin string, chunked bool) error {
+	if op == "write" && fs.HasCapability(CapDelta) {
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			return err
+		}
+		if info.Size() > deltaThreshold {
+			return offerDelta(fs, fullPath, relPath, info.Size())
+		}
+	}
+
+	return sendFullContent(fs, fullPath, relPath, op, origin, chunked)
+}
+
+// sendFullContent envoie le contenu complet d'un fichier (ou d'un delta déjà calculé)
+func sendFullContent(fs frameSender, fullPath, relPath, op, origin string, chunked bool) error {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+
+	if chunked && info.Size() > streamThreshold {
+		return sendFileStream(fs, fullPath, relPath, op, origin)
+	}
+
+	data, err := readFileWithRetry(fullPath)
+	if err != nil {
+		return err
+	}
+
+	return fs.Send(MsgFileChange, FileChange{
+		FileName: relPath,
+		Op:       op,
+		Content:  base64.StdEncoding.EncodeToString(data),
+		IsDir:    false,
+		Origin:   origin,
+		Vector:   fs.fileVector(relPath),
+	})
+}
+
+// sendFileStream envoie un fichier par morceaux sans le charger en mémoire
+func sendFileStream(fs frameSender, fullPath, relPath, op, origin string) error {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	transferID := newMessageID()
+	chunkSize := GetChunkManager().GetOptimalSize(info.Size())
+
+	// Avec la reprise, le hash est calculé d'abord: il fixe l'identifiant du
+	// transfert et permet de repartir de la position annoncée par le pair
+	var fileHash string
+	var offset int64
+	if fs.HasCapability(CapResume) {
+		fileHash, err = StreamHash(fullPath)
+		if err != nil {
+			return err
+		}
+		transferID = transferKey(relPath, fileHash)
+		offset = fs.resumeOffset(transferID)
+		if offset < 0 || offset > info.Size() {
+			offset = 0
+		}
+		if offset > 0 {
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			addLog(fmt.Sprintf("⏩ Reprise de %s à %s", relPath, FormatFileSize(offset)))
+		}
+	}
+
+	if err := fs.Send(MsgTransferBegin, TransferBegin{
+		TransferID: transferID,
+		FileName:   relPath,
+		Op:         op,
+		Size:       info.Size(),
+		ChunkSize:  chunkSize,
+		Origin:     origin,
+		Hash:       fileHash,
+		Offset:     offset,
+		Vector:     fs.fileVector(relPath),
+	}); err != nil {
+		return err
+	}
+
+	buf := GetBufferPool().Get(chunkSize)
+	defer GetBufferPool().Put(buf)
+	buf = buf[:chunkSize]
+
+	hasher := sha256.New()
+	sentFrom := offset
+	start := time.Now()
+
+	for {
+		n, readErr := io.ReadFull(file, buf)
+		if n > 0 {
+			if fileHash == "" {
+				hasher.Write(buf[:n])
+			}
+			if err := fs.sendBinary(encodeChunkHeader(transferID, offset), buf[:n]); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			fs.Send(MsgTransferAbort, TransferAbort{TransferID: transferID, Reason: readErr.Error()})
+			return readErr
+		}
+	}
+
+	GetChunkManager().UpdateNetSpeed(offset-sentFrom, time.Since(start))
+
+	if fileHash == "" {
+		fileHash = hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	return fs.Send(MsgTransferCommit, TransferCommit{
+		TransferID: transferID,
+		Size:       offset,
+		Hash:       fileHash,
+	})
+}
+
+// ============================================================================
+// RÉCEPTION
+// ============================================================================
+
+// incomingTransfer état d'un fichier en cours de réception
+type incomingTransfer struct {
+	begin     TransferBegin
+	tmpPath   string
+	file      *os.File
+	hasher    hash.Hash
+	received  int64
+	state     *TransferState // État persistant (nil si la reprise n'est pas possible)
+	statePath string
+}
+
+// StreamReceiver reçoit les fichiers envoyés par morceaux pour un dossier synchronisé
+type StreamReceiver struct {
+	root      string
+	tempDir   string
+	mu        sync.Mutex
+	transfers map[string]*incomingTransfer
+	release   func(reserved int64) // Libère l'espace réservé par l'hôte (nil côté client)
+}
+
+// releaseReserved libère l'espace réservé pour un transfert qui n'aboutit pas
+func (sr *StreamReceiver) releaseReserved(t *incomingTransfer) {
+	if sr.release != nil && t.begin.Reserved != 0 {
+		sr.release(t.begin.Reserved)
+	}
+}
+
+// NewStreamReceiver crée un récepteur utilisant le dossier interne de root
+func NewStreamReceiver(root string) *StreamReceiver {
+	return &StreamReceiver{
+		root:      root,
+		tempDir:   internalTempDir(root),
+		transfers: make(map[string]*incomingTransfer),
+	}
+}
+
+// Begin ouvre le fichier temporaire d'un nouveau transfert
+// Un transfert dont le hash est annoncé est conservé en cas de coupure,
+// et reprend là où il s'était arrêté si l'émetteur indique une position
+func (sr *StreamReceiver) Begin(begin TransferBegin) error {
+	if begin.TransferID == "" || begin.FileName == "" {
+		return ErrUnknownTransfer
+	}
+	if begin.Size < 0 {
+		return ErrTransferSize
+	}
+	fileName, ok := cleanRelPath(begin.FileName)
+	if !ok {
+		return fmt.Errorf("chemin refusé: %s", begin.FileName)
+	}
+	begin.FileName = fileName
+
+	var t *incomingTransfer
+	var err error
+	if begin.Hash != "" {
+		t, err = sr.openResumable(begin)
+	} else {
+		t, err = sr.openTemp(begin)
+	}
+	if err != nil {
+		return err
+	}
+
+	sr.mu.Lock()
+	old, exists := sr.transfers[begin.TransferID]
+	sr.transfers[begin.TransferID] = t
+	sr.mu.Unlock()
+
+	if exists {
+		old.file.Close()
+		if old.state == nil {
+			os.Remove(old.tmpPath)
+		}
+		sr.releaseReserved(old)
+	}
+
+	return nil
+}
+
+// openTemp prépare un transfert sans reprise possible
+func (sr *StreamReceiver) openTemp(begin TransferBegin) (*incomingTransfer, error) {
+	if err := os.MkdirAll(sr.tempDir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(sr.tempDir, "transfer-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &incomingTransfer{
+		begin:   begin,
+		tmpPath: file.Name(),
+		file:    file,
+		hasher:  sha256.New(),
+	}, nil
+}
+
+// openResumable prépare un transfert dont l'état est conservé sur disque
+func (sr *StreamReceiver) openResumable(begin TransferBegin) (*incomingTransfer, error) {
+	// L'identifiant sert de nom de fichier: il doit correspondre au chemin et au hash
+	if begin.TransferID != transferKey(begin.FileName, begin.Hash) {
+		return nil, ErrTransferResume
+	}
+
+	statePath, partPath := transferPaths(sr.root, begin.TransferID)
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return nil, err
+	}
+
+	if begin.Offset > 0 {
+		state, err := loadTransferState(statePath)
+		if err != nil || state.Hash != begin.Hash || state.Size != begin.Size {
+			return nil, ErrTransferResume
+		}
+
+		hasher, err := verifyPartial(state, partPath)
+		if err != nil {
+			return nil, err
+		}
+		if state.Offset != begin.Offset {
+			saveTransferState(sr.root, statePath, state)
+			return nil, ErrChunkOffset
+		}
+
+		file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		return &incomingTransfer{
+			begin:     begin,
+			tmpPath:   partPath,
+			file:      file,
+			hasher:    hasher,
+			received:  state.Offset,
+			state:     state,
+			statePath: statePath,
+		}, nil
+	}
+
+	file, err := os.Create(partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &TransferState{
+		TransferID: begin.TransferID,
+		Direction:  TransferIn,
+		FileName:   begin.FileName,
+		Op:         begin.Op,
+		Origin:     begin.Origin,
+		Size:       begin.Size,
+		Hash:       begin.Hash,
+	}
+	if err := saveTransferState(sr.root, statePath, state); err != nil {
+		file.Close()
+		os.Remove(partPath)
+		return nil, err
+	}
+
+	return &incomingTransfer{
+		begin:     begin,
+		tmpPath:   partPath,
+		file:      file,
+		hasher:    sha256.New(),
+		state:     state,
+		statePath: statePath,
+	}, nil
+}
+
+// WriteChunk lit une trame binaire et l'ajoute au fichier temporaire
+func (sr *StreamReceiver) WriteChunk(r io.Reader) error {
+	transferID, offset, err := readChunkHeader(r)
+	if err != nil {
+		return err
+	}
+
+	sr.mu.Lock()
+	t, exists := sr.transfers[transferID]
+	sr.mu.Unlock()
+	if !exists {
+		return ErrUnknownTransfer
+	}
+
+	if offset != t.received {
+		sr.Abort(transferID)
+		return ErrChunkOffset
+	}
+
+	buf := GetBufferPool().Get(65536)
+	defer GetBufferPool().Put(buf)
+
+	// Un octet de plus que la taille annoncée suffit à détecter un dépassement:
+	// les limites et quotas n'ont été vérifiés que pour begin.Size
+	chunkHasher := sha256.New()
+	limited := io.LimitReader(r, t.begin.Size-t.received+1)
+	n, err := io.CopyBuffer(io.MultiWriter(t.file, t.hasher, chunkHasher), limited, buf)
+	t.received += n
+	if t.received > t.begin.Size {
+		sr.Abort(transferID)
+		return ErrTransferSize
+	}
+	if err != nil {
+		if t.state != nil {
+			// Trame incomplète: elle sera écartée à la vérification lors de la reprise
+			sr.suspend(transferID)
+		} else {
+			sr.Abort(transferID)
+		}
+		return err
+	}
+
+	if t.state != nil {
+		t.state.Chunks = append(t.state.Chunks, ChunkChecksum{
+			Size: n,
+			Hash: hex.EncodeToString(chunkHasher.Sum(nil)),
+		})
+		t.state.Offset = t.received
+		saveTransferState(sr.root, t.statePath, t.state)
+	}
+
+	return nil
+}
+
+// Commit vérifie le fichier reçu et retourne le changement à appliquer
+// Le contenu est dans LocalFile, à déplacer vers sa destination
+func (sr *StreamReceiver) Commit(commit TransferCommit) (FileChange, error) {
+	sr.mu.Lock()
+	t, exists := sr.transfers[commit.TransferID]
+	delete(sr.transfers, commit.TransferID)
+	sr.mu.Unlock()
+
+	if !exists {
+		return FileChange{}, ErrUnknownTransfer
+	}
+
+	if t.state != nil {
+		os.Remove(t.statePath)
+	}
+
+	if err := t.file.Close(); err != nil {
+		os.Remove(t.tmpPath)
+		sr.releaseReserved(t)
+		return FileChange{}, err
+	}
+
+	if t.received != commit.Size {
+		os.Remove(t.tmpPath)
+		sr.releaseReserved(t)
+		return FileChange{}, ErrTransferSize
+	}
+	if hex.EncodeToString(t.hasher.Sum(nil)) != commit.Hash {
+		os.Remove(t.tmpPath)
+		sr.releaseReserved(t)
+		return FileChange{}, ErrTransferHash
+	}
+
+	return FileChange{
+		FileName:  t.begin.FileName,
+		Op:        t.begin.Op,
+		IsDir:     false,
+		Origin:    t.begin.Origin,
+		LocalFile: t.tmpPath,
+		Vector:    t.begin.Vector,
+		Reserved:  t.begin.Reserved,
+	}, nil
+}
+
+// Abort annule un transfert et supprime son fichier temporaire
+func (sr *StreamReceiver) Abort(transferID string) {
+	sr.mu.Lock()
+	t, exists := sr.transfers[transferID]
+	delete(sr.transfers, transferID)
+	sr.mu.Unlock()
+
+	if exists {
+		t.file.Close()
+		os.Remove(t.tmpPath)
+		if t.state != nil {
+			os.Remove(t.statePath)
+		}
+		sr.releaseReserved(t)
+	}
+}
+
+// suspend ferme un transfert en conservant son état pour une reprise
+func (sr *StreamReceiver) suspend(transferID string) {
+	sr.mu.Lock()
+	t, exists := sr.transfers[transferID]
+	delete(sr.transfers, transferID)
+	sr.mu.Unlock()
+
+	if exists {
+		t.file.Close()
+		sr.releaseReserved(t)
+	}
+}
+
+// Close interrompt tous les transferts en cours
+// Ceux qui peuvent être repris sont conservés sur disque
+func (sr *StreamReceiver) Close() {
+	sr.mu.Lock()
+	transfers := sr.transfers
+	sr.transfers = make(map[string]*incomingTransfer)
+	sr.mu.Unlock()
+
+	for _, t := range transfers {
+		t.file.Close()
+		if t.state == nil {
+			os.Remove(t.tmpPath)
+		}
+		sr.releaseReserved(t)
+	}
+}
+
+// cleanTransferTemp supprime les fichiers temporaires laissés par une session précédente
+// Les transferts interrompus pouvant être repris sont conservés
+func cleanTransferTemp(root string) {
+	os.RemoveAll(internalTempDir(root))
+	pruneTransferStates(root)
+}
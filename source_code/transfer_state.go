@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// REPRISE DES TRANSFERTS INTERROMPUS (capacité "resume")
+// ============================================================================
+//
+// Le récepteur d'un transfert par morceaux conserve dans .spiralydata/transfers:
+//   <id>.part  les données déjà reçues
+//   <id>.json  l'état du transfert (chemin, position, hash attendu, hash de chaque morceau)
+// L'identifiant dépend du chemin et du hash du fichier: un même contenu garde
+// le même identifiant d'une connexion à l'autre.
+//
+// L'émetteur côté client note ses envois en cours (upload-<clé>.json) pour les
+// reprendre après une coupure ou un redémarrage.
+//
+// À la connexion, le client annonce dans auth_request les fichiers qu'il peut
+// reprendre en réception et ceux qu'il n'a pas fini d'envoyer. Le serveur répond
+// avec la position vérifiée de ces derniers. Chaque envoi repart ensuite du
+// dernier morceau dont le hash a été vérifié.
+
+const (
+	// transferStateDirName sous-dossier du dossier interne contenant les transferts
+	transferStateDirName = "transfers"
+
+	// transferStateMaxAge durée de conservation d'un transfert interrompu
+	transferStateMaxAge = 7 * 24 * time.Hour
+
+	// Sens d'un transfert
+	TransferIn  = "in"
+	TransferOut = "out"
+
+	uploadJournalPrefix = "upload-"
+)
+
+// ErrTransferResume la reprise demandée ne correspond pas à l'état local
+var ErrTransferResume = errors.New("reprise du transfert impossible")
+
+// ChunkChecksum hash d'un morceau reçu
+type ChunkChecksum struct {
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// TransferState état persistant d'un transfert
+type TransferState struct {
+	TransferID string          `json:"transfer_id"`
+	Direction  string          `json:"direction"`
+	FileName   string          `json:"filename"`
+	Op         string          `json:"op"`
+	Origin     string          `json:"origin,omitempty"`
+	Size       int64           `json:"size"`
+	Hash       string          `json:"hash,omitempty"`
+	Offset     int64           `json:"offset"` // Octets reçus et vérifiés
+	Chunks     []ChunkChecksum `json:"chunks,omitempty"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// transferStateDir retourne le dossier des transferts interrompus
+func transferStateDir(root string) string {
+	return filepath.Join(root, internalDirName, transferStateDirName)
+}
+
+// transferKey calcule l'identifiant stable d'un transfert
+func transferKey(relPath, fileHash string) string {
+	sum := sha256.Sum256([]byte(relPath + "\x00" + fileHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// transferPaths retourne les chemins de l'état et des données d'un transfert reçu
+func transferPaths(root, transferID string) (statePath, partPath string) {
+	dir := transferStateDir(root)
+	return filepath.Join(dir, transferID+".json"), filepath.Join(dir, transferID+".part")
+}
+
+// uploadJournalPath retourne le chemin du journal d'envoi d'un fichier
+func uploadJournalPath(root, relPath string) string {
+	return filepath.Join(transferStateDir(root), uploadJournalPrefix+transferKey(relPath, "")+".json")
+}
+
+// loadTransferState lit un état de transfert
+func loadTransferState(path string) (*TransferState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state TransferState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// saveTransferState enregistre un état de transfert
+func saveTransferState(root, path string, state *TransferState) error {
+	state.UpdatedAt = time.Now()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(root, path, data)
+}
+
+// verifyPartial relit les données reçues et vérifie le hash de chaque morceau
+// Le fichier est tronqué après le dernier morceau valide et l'état mis à jour.
+// Retourne le hash cumulé des données conservées, pour poursuivre la réception.
+func verifyPartial(state *TransferState, partPath string) (hash.Hash, error) {
+	file, err := os.Open(partPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileHasher := sha256.New()
+	chunkHasher := sha256.New()
+	var verified int64
+	valid := 0
+
+	for _, chunk := range state.Chunks {
+		chunkHasher.Reset()
+		n, err := io.CopyN(io.MultiWriter(fileHasher, chunkHasher), file, chunk.Size)
+		if err != nil || n != chunk.Size {
+			break
+		}
+		if hex.EncodeToString(chunkHasher.Sum(nil)) != chunk.Hash {
+			// Le hash cumulé contient ce morceau invalide: le recalculer
+			file.Close()
+			state.Chunks = state.Chunks[:valid]
+			state.Offset = verified
+			return verifyPartial(state, partPath)
+		}
+		verified += n
+		valid++
+	}
+	file.Close()
+
+	state.Chunks = state.Chunks[:valid]
+	state.Offset = verified
+
+	if err := os.Truncate(partPath, verified); err != nil {
+		return nil, err
+	}
+	return fileHasher, nil
+}
+
+// resumeIncoming vérifie un transfert reçu en partie et retourne sa position
+// Retourne 0 si aucun état ne correspond au hash et à la taille attendus
+func resumeIncoming(root string, r TransferResume) int64 {
+	// L'identifiant sert de nom de fichier: il doit correspondre au chemin et au hash
+	if r.TransferID != transferKey(r.FileName, r.Hash) {
+		return 0
+	}
+
+	statePath, partPath := transferPaths(root, r.TransferID)
+
+	state, err := loadTransferState(statePath)
+	if err != nil || state.Hash != r.Hash || state.Size != r.Size {
+		return 0
+	}
+
+	if _, err := verifyPartial(state, partPath); err != nil {
+		return 0
+	}
+	saveTransferState(root, statePath, state)
+
+	return state.Offset
+}
+
+// listTransferStates retourne les transferts interrompus du sens demandé
+func listTransferStates(root, direction string) []*TransferState {
+	entries, err := os.ReadDir(transferStateDir(root))
+	if err != nil {
+		return nil
+	}
+
+	var states []*TransferState
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		state, err := loadTransferState(filepath.Join(transferStateDir(root), entry.Name()))
+		if err != nil || state.Direction != direction {
+			continue
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// pruneTransferStates supprime les transferts trop anciens et les données orphelines
+func pruneTransferStates(root string) {
+	dir := transferStateDir(root)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+
+		switch {
+		case strings.HasSuffix(name, ".part"):
+			statePath := strings.TrimSuffix(path, ".part") + ".json"
+			if _, err := os.Stat(statePath); os.IsNotExist(err) {
+				os.Remove(path)
+			}
+		case strings.HasSuffix(name, ".json"):
+			state, err := loadTransferState(path)
+			if err != nil || time.Since(state.UpdatedAt) > transferStateMaxAge {
+				os.Remove(path)
+				os.Remove(strings.TrimSuffix(path, ".json") + ".part")
+			}
+		}
+	}
+}
+
+// saveUploadJournal note un envoi en cours pour pouvoir le reprendre
+func saveUploadJournal(root, relPath, op string, size int64) error {
+	return saveTransferState(root, uploadJournalPath(root, relPath), &TransferState{
+		TransferID: transferKey(relPath, ""),
+		Direction:  TransferOut,
+		FileName:   relPath,
+		Op:         op,
+		Size:       size,
+	})
+}
+
+// removeUploadJournal supprime le journal d'un envoi terminé
+func removeUploadJournal(root, relPath string) {
+	os.Remove(uploadJournalPath(root, relPath))
+}
+
+// loadResumePoints prépare les annonces de reprise envoyées à l'authentification
+// resume: fichiers reçus en partie, uploads: envois interrompus dont le fichier existe encore
+func loadResumePoints(root string) (resume, uploads []TransferResume) {
+	pruneTransferStates(root)
+
+	for _, state := range listTransferStates(root, TransferIn) {
+		r := TransferResume{
+			TransferID: state.TransferID,
+			FileName:   state.FileName,
+			Hash:       state.Hash,
+			Size:       state.Size,
+		}
+		r.Offset = resumeIncoming(root, r)
+		if r.Offset > 0 {
+			resume = append(resume, r)
+		}
+	}
+
+	for _, state := range listTransferStates(root, TransferOut) {
+		fullPath := filepath.Join(root, filepath.FromSlash(state.FileName))
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() {
+			removeUploadJournal(root, state.FileName)
+			continue
+		}
+		fileHash, err := StreamHash(fullPath)
+		if err != nil {
+			continue
+		}
+		uploads = append(uploads, TransferResume{
+			TransferID: transferKey(state.FileName, fileHash),
+			FileName:   state.FileName,
+			Hash:       fileHash,
+			Size:       info.Size(),
+		})
+	}
+
+	return resume, uploads
+}
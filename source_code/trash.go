@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// CORBEILLE
+// ============================================================================
+//
+// Une suppression synchronisée ne détruit plus l'élément: il est déplacé dans
+// la corbeille du dossier synchronisé (hôte et clients):
+//   .spiralydata/trash/<id>/<nom>     élément supprimé (fichier ou dossier)
+//   .spiralydata/trash/index.json     chemin d'origine, auteur et date
+// Les éléments sont purgés selon la politique de rétention "trash"
+// (voir RetentionManager). Sur l'hôte, le contenu des éléments et l'index sont
+// chiffrés avec le stockage si host.encrypt_store est activé.
+
+const (
+	trashDirName    = "trash"
+	trashPolicyName = "trash"
+
+	defaultTrashMaxAge = 30 * 24 * time.Hour
+)
+
+// TrashEntry élément de la corbeille
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // Chemin d'origine
+	IsDir     bool      `json:"is_dir,omitempty"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	Sealed    bool      `json:"sealed,omitempty"` // Contenu chiffré avec le stockage de l'hôte
+}
+
+// Trash corbeille d'un dossier synchronisé
+type Trash struct {
+	root    string
+	store   *ChunkStore // Stockage qui chiffre la corbeille (hôte), nil = en clair
+	mu      sync.Mutex
+	entries []TrashEntry // Du plus ancien au plus récent
+}
+
+// NewTrash ouvre la corbeille d'un dossier synchronisé
+func NewTrash(root string) *Trash {
+	t := &Trash{root: root}
+	t.load()
+	t.Purge()
+	return t
+}
+
+// NewHostTrash ouvre la corbeille d'un partage de l'hôte, chiffrée avec le
+// stockage. Les éléments encore en clair sont chiffrés à l'ouverture.
+func NewHostTrash(root string, store *ChunkStore) *Trash {
+	t := &Trash{root: root, store: store}
+	t.load()
+	t.Purge()
+	t.sealEntries()
+	return t
+}
+
+// configureTrashRetention applique à la politique "trash" les réglages de la configuration
+func configureTrashRetention() {
+	policy := &RetentionPolicy{
+		Name:         trashPolicyName,
+		MaxAge:       defaultTrashMaxAge,
+		ApplyToFiles: true,
+	}
+
+	if config, _ := LoadConfig(); config != nil {
+		if config.TrashRetentionDays > 0 {
+			policy.MaxAge = time.Duration(config.TrashRetentionDays) * 24 * time.Hour
+		}
+		policy.MaxSize = config.TrashMaxSize
+	}
+
+	GetRetentionManager().AddPolicy(policy)
+}
+
+// dir retourne le dossier de la corbeille
+func (t *Trash) dir() string {
+	return filepath.Join(t.root, internalDirName, trashDirName)
+}
+
+// itemPath retourne l'emplacement d'un élément dans la corbeille
+func (t *Trash) itemPath(entry TrashEntry) string {
+	return filepath.Join(t.dir(), entry.ID, filepath.Base(filepath.FromSlash(entry.Path)))
+}
+
+// Remove déplace un élément dans la corbeille
+// Si le déplacement échoue, l'élément est supprimé définitivement comme avant.
+func (t *Trash) Remove(relPath, deletedBy string) error {
+	source := filepath.Join(t.root, filepath.FromSlash(relPath))
+	info, err := os.Lstat(source)
+	if err != nil {
+		return nil // Déjà supprimé
+	}
+
+	entry := TrashEntry{
+		ID:        newMessageID(),
+		Path:      relPath,
+		IsDir:     info.IsDir(),
+		Size:      info.Size(),
+		DeletedAt: time.Now(),
+		DeletedBy: deletedBy,
+	}
+	if entry.IsDir {
+		entry.Size = dirSize(source)
+	}
+
+	dest := t.itemPath(entry)
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err == nil {
+		err = os.Rename(source, dest)
+	}
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ Corbeille %s: %v, suppression définitive", relPath, err))
+		os.RemoveAll(filepath.Dir(dest))
+		return os.RemoveAll(source)
+	}
+	entry.Sealed = t.seal(entry)
+
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	t.mu.Unlock()
+
+	t.Purge()
+	t.save()
+	return nil
+}
+
+// List retourne les éléments de la corbeille, du plus récent au plus ancien
+func (t *Trash) List() []TrashEntry {
+	t.Purge()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]TrashEntry, len(t.entries))
+	for i, entry := range t.entries {
+		result[len(t.entries)-1-i] = entry
+	}
+	return result
+}
+
+// Restore remet un élément à son emplacement d'origine
+func (t *Trash) Restore(id string) (TrashEntry, error) {
+	entry, ok := t.find(id)
+	if !ok {
+		return entry, fmt.Errorf("élément inconnu: %s", id)
+	}
+
+	target := filepath.Join(t.root, filepath.FromSlash(entry.Path))
+	if _, err := os.Lstat(target); err == nil {
+		return entry, fmt.Errorf("%s existe déjà", entry.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return entry, err
+	}
+	if entry.Sealed {
+		if err := t.unsealItem(t.itemPath(entry)); err != nil {
+			return entry, err
+		}
+	}
+	if err := os.Rename(t.itemPath(entry), target); err != nil {
+		if entry.Sealed {
+			t.sealItem(t.itemPath(entry))
+		}
+		return entry, err
+	}
+
+	os.RemoveAll(filepath.Join(t.dir(), entry.ID))
+	t.drop(id)
+	t.save()
+	return entry, nil
+}
+
+// Delete supprime définitivement un élément de la corbeille
+func (t *Trash) Delete(id string) error {
+	entry, ok := t.find(id)
+	if !ok {
+		return fmt.Errorf("élément inconnu: %s", id)
+	}
+
+	t.erase(entry)
+	t.drop(id)
+	t.save()
+	return nil
+}
+
+// Purge supprime les éléments trop anciens, puis les plus anciens tant que
+// la corbeille dépasse la taille maximale de la politique "trash"
+func (t *Trash) Purge() {
+	policy, ok := GetRetentionManager().GetPolicy(trashPolicyName)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	var kept, removed []TrashEntry
+	cutoff := time.Now().Add(-policy.MaxAge)
+	var total int64
+	for _, entry := range t.entries {
+		if policy.MaxAge > 0 && entry.DeletedAt.Before(cutoff) {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+		total += entry.Size
+	}
+	for policy.MaxSize > 0 && total > policy.MaxSize && len(kept) > 0 {
+		removed = append(removed, kept[0])
+		total -= kept[0].Size
+		kept = kept[1:]
+	}
+	t.entries = kept
+	t.mu.Unlock()
+
+	if len(removed) == 0 {
+		return
+	}
+	for _, entry := range removed {
+		t.erase(entry)
+	}
+	addLog(fmt.Sprintf("🧹 Corbeille: %d élément(s) purgé(s)", len(removed)))
+	t.save()
+}
+
+// erase efface le contenu d'un élément, de manière sécurisée si la politique l'exige
+func (t *Trash) erase(entry TrashEntry) {
+	base := filepath.Join(t.dir(), entry.ID)
+
+	if policy, ok := GetRetentionManager().GetPolicy(trashPolicyName); ok && policy.SecureDelete {
+		filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				SecureDelete(path, 0)
+			}
+			return nil
+		})
+	}
+	os.RemoveAll(base)
+}
+
+func (t *Trash) find(id string) (TrashEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, entry := range t.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return TrashEntry{}, false
+}
+
+func (t *Trash) drop(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, entry := range t.entries {
+		if entry.ID == id {
+			t.entries = append(t.entries[:i], t.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// seal chiffre le contenu d'un élément si la corbeille est chiffrée
+// Retourne false si l'élément reste en clair.
+func (t *Trash) seal(entry TrashEntry) bool {
+	if t.store == nil || !t.store.SealsNewObjects() {
+		return false
+	}
+	if err := t.sealItem(t.itemPath(entry)); err != nil {
+		addLog(fmt.Sprintf("⚠️ Corbeille %s: %v, élément conservé en clair", entry.Path, err))
+		return false
+	}
+	return true
+}
+
+// sealItem chiffre tous les fichiers d'un élément, ou aucun en cas d'erreur
+func (t *Trash) sealItem(path string) error {
+	var sealed []string
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		ok, err := t.store.SealFile(p)
+		if ok {
+			sealed = append(sealed, p)
+		}
+		return err
+	})
+	if err != nil {
+		for _, p := range sealed {
+			t.store.UnsealFile(p)
+		}
+	}
+	return err
+}
+
+// unsealItem déchiffre tous les fichiers d'un élément
+func (t *Trash) unsealItem(path string) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		return t.store.UnsealFile(p)
+	})
+}
+
+// sealEntries chiffre les éléments et l'index restés en clair, par exemple
+// supprimés avant l'activation de host.encrypt_store
+func (t *Trash) sealEntries() {
+	if t.store == nil || !t.store.SealsNewObjects() {
+		return
+	}
+
+	t.mu.Lock()
+	pending := make([]TrashEntry, 0)
+	for _, entry := range t.entries {
+		if !entry.Sealed {
+			pending = append(pending, entry)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, entry := range pending {
+		if err := t.sealItem(t.itemPath(entry)); err != nil {
+			addLog(fmt.Sprintf("⚠️ Corbeille %s: %v", entry.Path, err))
+			continue
+		}
+		t.mu.Lock()
+		for i := range t.entries {
+			if t.entries[i].ID == entry.ID {
+				t.entries[i].Sealed = true
+			}
+		}
+		t.mu.Unlock()
+	}
+
+	if len(pending) > 0 || t.store.NeedsSealing(t.indexPath()) {
+		t.save()
+	}
+}
+
+func (t *Trash) indexPath() string {
+	return filepath.Join(t.dir(), "index.json")
+}
+
+func (t *Trash) load() {
+	var data []byte
+	var err error
+	if t.store != nil {
+		data, err = t.store.ReadSealed(t.indexPath())
+	} else {
+		data, err = os.ReadFile(t.indexPath())
+	}
+	if err != nil {
+		if !os.IsNotExist(err) {
+			addLog(fmt.Sprintf("⚠️ Index de la corbeille illisible: %v", err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &t.entries); err != nil {
+		addLog(fmt.Sprintf("⚠️ Index de la corbeille illisible: %v", err))
+	}
+}
+
+func (t *Trash) save() {
+	t.mu.Lock()
+	data, err := json.Marshal(t.entries)
+	t.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if t.store != nil {
+		if err := t.store.WriteSealed(t.root, t.indexPath(), data); err != nil {
+			addLog(fmt.Sprintf("⚠️ Index de la corbeille: %v", err))
+		}
+		return
+	}
+	if err := os.MkdirAll(t.dir(), 0755); err != nil {
+		return
+	}
+	writeFileAtomic(t.root, t.indexPath(), data)
+}
+
+// dirSize retourne la taille cumulée des fichiers d'un dossier
+func dirSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// ============================================================================
+// INTERFACE DE LA CORBEILLE
+// ============================================================================
+
+// trashSource accès à une corbeille: celle de ce poste ou celle de l'hôte
+type trashSource struct {
+	Name    string
+	List    func() ([]TrashEntry, error)
+	Restore func(id string) error
+	Delete  func(id string) error // nil: suppression définitive indisponible
+}
+
+// localTrashSource corbeille du dossier synchronisé de ce poste
+func localTrashSource(name string, trash *Trash) trashSource {
+	return trashSource{
+		Name: name,
+		List: func() ([]TrashEntry, error) {
+			return trash.List(), nil
+		},
+		Restore: func(id string) error {
+			_, err := trash.Restore(id)
+			return err
+		},
+		Delete: trash.Delete,
+	}
+}
+
+// serverTrashSource corbeille de l'hôte, consultée par le protocole
+func serverTrashSource(client *Client) trashSource {
+	return trashSource{
+		Name: "Serveur",
+		List: client.ListServerTrash,
+		Restore: func(id string) error {
+			_, err := client.RestoreServerTrash(id)
+			return err
+		},
+	}
+}
+
+// ShowHostTrashDialog affiche la corbeille de chaque partage du serveur
+func ShowHostTrashDialog(win fyne.Window, host *Host) {
+	var sources []trashSource
+	if host != nil {
+		for _, s := range host.Servers() {
+			if s.trash == nil {
+				continue
+			}
+			name := "Hôte"
+			if len(host.Servers()) > 1 {
+				name = "Partage " + s.Share.DisplayName()
+			}
+			sources = append(sources, localTrashSource(name, s.trash))
+		}
+	}
+	if len(sources) == 0 {
+		dialog.ShowInformation("Corbeille", "Le serveur n'est pas démarré", win)
+		return
+	}
+	showTrashDialog(win, sources...)
+}
+
+// ShowClientTrashDialog affiche la corbeille du serveur et celle de ce poste
+func ShowClientTrashDialog(win fyne.Window, client *Client) {
+	var sources []trashSource
+	if client.HasCapability(CapTrash) {
+		sources = append(sources, serverTrashSource(client))
+	}
+	if client.trash != nil {
+		sources = append(sources, localTrashSource("Ce poste", client.trash))
+	}
+	if len(sources) == 0 {
+		dialog.ShowInformation("Corbeille", "Corbeille non disponible", win)
+		return
+	}
+	showTrashDialog(win, sources...)
+}
+
+// showTrashDialog affiche une ou plusieurs corbeilles, une par onglet
+func showTrashDialog(win fyne.Window, sources ...trashSource) {
+	var content fyne.CanvasObject
+	if len(sources) == 1 {
+		content = createTrashTab(win, sources[0])
+	} else {
+		tabs := container.NewAppTabs()
+		for _, src := range sources {
+			tabs.Append(container.NewTabItem(src.Name, createTrashTab(win, src)))
+		}
+		content = tabs
+	}
+
+	dlg := dialog.NewCustom("Corbeille", "Fermer", content, win)
+	dlg.Resize(fyne.NewSize(560, 420))
+	dlg.Show()
+}
+
+// createTrashTab liste les éléments d'une corbeille avec leurs actions
+func createTrashTab(win fyne.Window, src trashSource) fyne.CanvasObject {
+	listContainer := container.NewVBox()
+
+	var refresh func()
+	refresh = func() {
+		entries, err := src.List()
+
+		listContainer.RemoveAll()
+		if err != nil {
+			listContainer.Add(widget.NewLabel(fmt.Sprintf("Erreur: %v", err)))
+		} else if len(entries) == 0 {
+			emptyLabel := widget.NewLabel("La corbeille est vide")
+			emptyLabel.TextStyle = fyne.TextStyle{Italic: true}
+			listContainer.Add(emptyLabel)
+		}
+
+		for _, entry := range entries {
+			current := entry // Capture de la valeur pour la closure
+
+			text := fmt.Sprintf("%s %s\n%s - %s",
+				getFileIcon(filepath.Base(current.Path), current.IsDir), current.Path,
+				current.DeletedAt.Format("02/01/2006 15:04"), FormatFileSize(current.Size))
+			if current.DeletedBy != "" {
+				text += " - par " + current.DeletedBy
+			}
+
+			restoreBtn := widget.NewButton("Restaurer", func() {
+				go func() {
+					if err := src.Restore(current.ID); err != nil {
+						addLog(fmt.Sprintf("❌ Restauration %s: %v", current.Path, err))
+						dialog.ShowError(err, win)
+					} else {
+						addLog(fmt.Sprintf("♻️ Restauré depuis la corbeille: %s", current.Path))
+					}
+					refresh()
+				}()
+			})
+			restoreBtn.Importance = widget.LowImportance
+			buttons := container.NewHBox(restoreBtn)
+
+			if src.Delete != nil {
+				deleteBtn := widget.NewButton("×", func() {
+					msg := fmt.Sprintf("Supprimer définitivement %s ?", current.Path)
+					dialog.ShowConfirm("Supprimer définitivement", msg, func(ok bool) {
+						if !ok {
+							return
+						}
+						if err := src.Delete(current.ID); err != nil {
+							dialog.ShowError(err, win)
+						}
+						refresh()
+					}, win)
+				})
+				deleteBtn.Importance = widget.DangerImportance
+				buttons.Add(deleteBtn)
+			}
+
+			listContainer.Add(container.NewBorder(nil, nil, nil, buttons, widget.NewLabel(text)))
+		}
+		listContainer.Refresh()
+	}
+
+	listContainer.Add(widget.NewLabel("Chargement..."))
+	go refresh()
+
+	refreshBtn := widget.NewButton("Actualiser", func() {
+		go refresh()
+	})
+
+	scroll := container.NewVScroll(listContainer)
+	scroll.SetMinSize(fyne.NewSize(480, 300))
+
+	return container.NewBorder(nil, container.NewCenter(refreshBtn), nil, nil, scroll)
+}
@@ -1,21 +1,106 @@
 package main
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"time"
+)
+
 type FileChange struct {
 	FileName string `json:"filename"`
 	Op       string `json:"op"`
 	Content  string `json:"content,omitempty"`
 	Origin   string `json:"origin"`
 	IsDir    bool   `json:"is_dir"`
+	// Contenu compressé en gzip (capacité "compression")
+	Compressed bool `json:"compressed,omitempty"`
+	// Fichier temporaire contenant le contenu reçu par morceaux (non transmis)
+	LocalFile string `json:"-"`
+	// Vecteur de version du contenu envoyé (fichiers uniquement)
+	Vector VersionVector `json:"vector,omitempty"`
+	// Auteur du changement, renseigné par le serveur (noté dans la corbeille)
+	Author string `json:"author,omitempty"`
+	// Ancien chemin d'un élément déplacé (op "move", capacité "move")
+	OldName string `json:"old_filename,omitempty"`
+	// Espace réservé par l'hôte au début du transfert par morceaux (non transmis)
+	Reserved int64 `json:"-"`
+}
+
+// ReadContent retourne le contenu du fichier, qu'il soit encodé dans le message
+// ou déjà reçu dans un fichier temporaire
+func (fc FileChange) ReadContent() ([]byte, error) {
+	if fc.LocalFile != "" {
+		return os.ReadFile(fc.LocalFile)
+	}
+	return base64.StdEncoding.DecodeString(fc.Content)
+}
+
+// ContentSize retourne la taille du contenu transmis, compressé ou non
+func (fc FileChange) ContentSize() int64 {
+	if fc.LocalFile != "" {
+		if info, err := os.Stat(fc.LocalFile); err == nil {
+			return info.Size()
+		}
+		return 0
+	}
+	return int64(base64.StdEncoding.DecodedLen(len(fc.Content)))
+}
+
+// Discard supprime le fichier temporaire d'un changement non appliqué
+func (fc FileChange) Discard() {
+	if fc.LocalFile != "" {
+		os.Remove(fc.LocalFile)
+	}
 }
 
 type AuthRequest struct {
-	Type   string `json:"type"`
-	HostID string `json:"host_id"`
+	Type            string   `json:"type"`
+	HostID          string   `json:"host_id"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+	// Fichiers reçus en partie que le client peut reprendre (capacité "resume")
+	Resume []TransferResume `json:"resume,omitempty"`
+	// Envois interrompus dont le client demande la position côté serveur
+	Uploads []TransferResume `json:"uploads,omitempty"`
+	// Dossiers abonnés (capacité "subscribe", vide = tout le partage)
+	Subscription []string `json:"subscription,omitempty"`
+	// Filtres du client, forme JSON de FileFilters (capacité "filters")
+	Filters json.RawMessage `json:"filters,omitempty"`
+	// Partage demandé (vide = partage par défaut de l'hôte)
+	Share string `json:"share,omitempty"`
+	// Compte de l'utilisateur: mot de passe, ou jeton remis lors d'une connexion précédente
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	// Clé publique X25519 de l'utilisateur (capacité "e2e"), enregistrée par
+	// l'hôte à la première connexion du compte
+	PublicKey string `json:"public_key,omitempty"`
 }
 
+// AuthResponse contient la version et les capacités retenues par le serveur
 type AuthResponse struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
+	Type            string   `json:"type"`
+	Message         string   `json:"message"`
+	ProtocolVersion int      `json:"protocol_version,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+	// Position vérifiée côté serveur des envois annoncés par le client
+	Resume []TransferResume `json:"resume,omitempty"`
+	// Partage servi et ce que son mode et ses accès permettent (capacité "shares"):
+	// lecture seule = modifications refusées, envoi seul = l'hôte n'envoie aucun fichier
+	Share     string `json:"share,omitempty"`
+	ReadOnly  bool   `json:"read_only,omitempty"`
+	WriteOnly bool   `json:"write_only,omitempty"`
+	// Compte authentifié, son rôle et son jeton de reconnexion (capacité "users")
+	User  string `json:"user,omitempty"`
+	Role  string `json:"role,omitempty"`
+	Token string `json:"token,omitempty"`
+	// Trousseau du partage chiffré de bout en bout (capacité "e2e"), absent
+	// tant qu'aucun client ne l'a créé
+	Keyring *E2EKeyring `json:"keyring,omitempty"`
+	// Clés publiques enregistrées des comptes, par identifiant (administrateurs
+	// d'un partage chiffré de bout en bout)
+	PublicKeys map[string]string `json:"public_keys,omitempty"`
 }
 
 type FileTreeItemMessage struct {
@@ -28,4 +113,163 @@ type FileTreeItemMessage struct {
 type DownloadRequest struct {
 	Type  string   `json:"type"`
 	Items []string `json:"items"`
-} 
\ No newline at end of file
+}
+
+// TransferBegin annonce l'envoi d'un fichier par morceaux (capacité "chunked")
+// Les morceaux suivent en trames binaires, puis un TransferCommit
+type TransferBegin struct {
+	TransferID string `json:"transfer_id"`
+	FileName   string `json:"filename"`
+	Op         string `json:"op"`
+	Size       int64  `json:"size"`
+	ChunkSize  int    `json:"chunk_size"`
+	Origin     string `json:"origin"`
+	// Hash du fichier complet connu dès le début (capacité "resume")
+	Hash string `json:"hash,omitempty"`
+	// Position de départ quand le transfert reprend après une coupure
+	Offset int64 `json:"offset,omitempty"`
+	// Vecteur de version du fichier envoyé
+	Vector VersionVector `json:"vector,omitempty"`
+	// Espace réservé par l'hôte pour ce fichier (non transmis)
+	Reserved int64 `json:"-"`
+}
+
+// TransferCommit termine un transfert: taille et hash SHA-256 du fichier complet
+type TransferCommit struct {
+	TransferID string `json:"transfer_id"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash"`
+}
+
+// TransferResume point de reprise d'un transfert interrompu
+type TransferResume struct {
+	TransferID string `json:"transfer_id"`
+	FileName   string `json:"filename"`
+	Hash       string `json:"hash"`
+	Size       int64  `json:"size"`
+	Offset     int64  `json:"offset"`
+}
+
+// DeltaOffer propose l'envoi d'une nouvelle version d'un fichier en delta
+type DeltaOffer struct {
+	FileName string `json:"filename"`
+	Size     int64  `json:"size"`
+	Hash     string `json:"hash"`
+}
+
+// DeltaSignature décrit la copie du récepteur pour le calcul du delta
+// BaseHash vide: pas de copie exploitable, le fichier complet est attendu
+type DeltaSignature struct {
+	FileName  string `json:"filename"`
+	Hash      string `json:"hash"` // Hash de la version proposée
+	Same      bool   `json:"same,omitempty"`
+	BaseHash  string `json:"base_hash,omitempty"`
+	BaseSize  int64  `json:"base_size,omitempty"`
+	BlockSize int    `json:"block_size,omitempty"`
+	Blocks    string `json:"blocks,omitempty"` // Somme glissante et hash de chaque bloc (base64)
+}
+
+// ManifestEntry état d'un fichier ou dossier du serveur (capacité "manifest")
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash,omitempty"`
+	// Vecteur de version de la copie de l'hôte
+	Vector VersionVector `json:"vector,omitempty"`
+}
+
+// SyncManifest lot d'entrées du manifeste, Complete sur le dernier lot
+type SyncManifest struct {
+	Entries  []ManifestEntry `json:"entries"`
+	Complete bool            `json:"complete,omitempty"`
+}
+
+// TransferAbort annule un transfert en cours
+type TransferAbort struct {
+	TransferID string `json:"transfer_id"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// VersionListRequest demande les versions précédentes d'un fichier (capacité "versions")
+type VersionListRequest struct {
+	Path string `json:"path"`
+}
+
+// VersionList versions précédentes d'un fichier, de la plus récente à la plus ancienne
+type VersionList struct {
+	Path     string         `json:"path"`
+	Versions []FileRevision `json:"versions"`
+}
+
+// RestoreVersionRequest demande le remplacement d'un fichier par une de ses versions
+type RestoreVersionRequest struct {
+	Path      string `json:"path"`
+	VersionID string `json:"version_id"`
+}
+
+// TrashList contenu de la corbeille de l'hôte (capacité "trash")
+// Error est renseigné quand une restauration a échoué
+type TrashList struct {
+	Entries []TrashEntry `json:"entries"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// TrashRestoreRequest demande la restauration d'un élément de la corbeille de l'hôte
+type TrashRestoreRequest struct {
+	ID string `json:"id"`
+}
+
+// SubscriptionUpdate remplace les dossiers abonnés du client (capacité "subscribe")
+type SubscriptionUpdate struct {
+	Folders []string `json:"folders"`
+}
+
+// FilterReport nombre d'éléments que le serveur n'a pas envoyés à cause des
+// filtres du client (capacité "filters"). Path est renseigné pour une diffusion.
+type FilterReport struct {
+	Operation string `json:"operation"`
+	Ignored   int    `json:"ignored"`
+	Path      string `json:"path,omitempty"`
+}
+
+// AccessDenied opération refusée par les droits ou les quotas du compte du client
+// (capacité "users", sinon erreur de code access_denied)
+type AccessDenied struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	RefID  string `json:"ref_id,omitempty"` // ID du message à l'origine du refus
+}
+
+// E2EKeyring trousseau d'un partage chiffré de bout en bout, conservé par l'hôte
+// Les clés n'y figurent que chiffrées par la clé maître, dérivée de la phrase
+// secrète ou aléatoire et chiffrée pour chaque destinataire: seuls les clients
+// peuvent l'ouvrir.
+type E2EKeyring struct {
+	KDF     string          `json:"kdf,omitempty"`    // Sel et paramètres de dérivation de la phrase secrète (format PHC)
+	Master  string          `json:"master,omitempty"` // Identifiant de la clé maître aléatoire (sans phrase secrète)
+	NameKey E2EWrappedKey   `json:"name_key"`         // Clé des noms de fichiers, jamais remplacée
+	Active  string          `json:"active"`           // Clé de contenu des nouveaux envois
+	Keys    []E2EWrappedKey `json:"keys"`             // Clés de contenu, anciennes comprises
+	// Clé maître chiffrée pour chaque utilisateur autorisé et pour la clé de
+	// secours des administrateurs, liste authentifiée par la clé maître
+	Recipients []E2ERecipient `json:"recipients,omitempty"`
+	Recovery   *E2ERecipient  `json:"recovery,omitempty"`
+	MAC        string         `json:"mac,omitempty"`
+}
+
+// E2ERecipient clé maître d'un trousseau chiffrée pour une clé publique X25519
+type E2ERecipient struct {
+	User      string `json:"user,omitempty"` // Compte de l'hôte (vide = clé de secours)
+	PublicKey string `json:"public_key"`
+	Key       string `json:"key"` // KeyManager.ExportMasterKeyFor
+}
+
+// E2EWrappedKey clé du trousseau, chiffrée par la clé maître (KeyManager.ExportKey)
+type E2EWrappedKey struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
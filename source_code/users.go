@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// COMPTES UTILISATEURS - Authentification des clients de l'hôte
+// ============================================================================
+
+// UserConfig compte d'un utilisateur de l'hôte (section host.users de spiraly_config.json)
+// Dès qu'un compte est défini, les clients doivent s'identifier en plus de l'ID de l'hôte.
+type UserConfig struct {
+	ID           string `json:"id"`
+	Name         string `json:"name,omitempty"`
+	Role         string `json:"role"` // read_only, read_write ou admin
+	PasswordHash string `json:"password_hash"`
+	Disabled     bool   `json:"disabled,omitempty"`
+	// Chemins visibles et modifiables (patterns de matchPath, ex: "docs/*"; vide = tout)
+	AllowedPaths []string              `json:"allowed_paths,omitempty"`
+	DeniedPaths  []string              `json:"denied_paths,omitempty"`
+	Folders      map[string]Permission `json:"folders,omitempty"` // Droits par dossier
+	Quota        *UserQuotaConfig      `json:"quota,omitempty"`
+	// Clé publique X25519 des partages chiffrés de bout en bout, enregistrée à la
+	// première connexion du compte (effacée par "user reset-key")
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// UserQuotaConfig quotas d'un compte (0 = valeur par défaut de NewUser)
+type UserQuotaConfig struct {
+	MaxStorage  int64 `json:"max_storage,omitempty"`  // Octets envoyés conservés sur l'hôte
+	MaxUpload   int64 `json:"max_upload,omitempty"`   // Octets envoyés par jour
+	MaxDownload int64 `json:"max_download,omitempty"` // Octets téléchargés par jour
+	MaxFiles    int64 `json:"max_files,omitempty"`    // Fichiers créés
+}
+
+// Credentials identifiants présentés par un client
+type Credentials struct {
+	Username string
+	Password string
+	Token    string // Jeton remis par l'hôte à la connexion, utilisé pour se reconnecter
+	// Phrase secrète d'un partage chiffré de bout en bout, jamais transmise à
+	// l'hôte, et clés du partage ouvertes à la connexion
+	Passphrase string
+	e2e        *E2ECipher
+	// Partage chiffré de bout en bout ouvert sans phrase secrète, avec l'identité
+	// du compte ou celle du fichier IdentityFile (clé de secours)
+	E2E          bool
+	IdentityFile string
+	identity     *E2EIdentity
+}
+
+// userRoleNames noms des rôles dans la configuration et sur le réseau
+var userRoleNames = map[string]UserRole{
+	"read_only":  RoleReadOnly,
+	"read_write": RoleReadWrite,
+	"admin":      RoleAdmin,
+}
+
+// parseUserRole convertit un nom de rôle de la configuration
+func parseUserRole(name string) (UserRole, error) {
+	role, ok := userRoleNames[name]
+	if !ok {
+		return RoleNone, fmt.Errorf("rôle inconnu: %s (read_only, read_write ou admin)", name)
+	}
+	return role, nil
+}
+
+// ConfigName retourne le nom du rôle dans la configuration
+func (r UserRole) ConfigName() string {
+	for name, role := range userRoleNames {
+		if role == r {
+			return name
+		}
+	}
+	return ""
+}
+
+var (
+	configUsersMu sync.Mutex
+	configUsers   = make(map[string]UserConfig) // Comptes appliqués, par identifiant
+)
+
+// usersRequired indique si l'hôte exige un compte utilisateur
+func usersRequired() bool {
+	configUsersMu.Lock()
+	defer configUsersMu.Unlock()
+	return len(configUsers) > 0
+}
+
+// applyUsers synchronise UserManager avec les comptes de la configuration
+// Retourne les comptes révoqués (supprimés, désactivés ou dont le mot de passe
+// a changé): leurs jetons sont invalidés et leurs connexions doivent être fermées.
+func applyUsers(users []UserConfig) []string {
+	configUsersMu.Lock()
+	defer configUsersMu.Unlock()
+
+	um := GetUserManager()
+	next := make(map[string]UserConfig)
+	for _, uc := range users {
+		if uc.ID == "" {
+			addLog("⚠️ Utilisateur sans identifiant ignoré")
+			continue
+		}
+		role, err := parseUserRole(uc.Role)
+		if err != nil {
+			addLog(fmt.Sprintf("⚠️ Utilisateur %s ignoré: %v", uc.ID, err))
+			continue
+		}
+		if _, ok := um.GetUser(uc.ID); !ok {
+			um.AddUser(NewUser(uc.ID, uc.ID, role))
+		}
+		perms := userPermissions(uc)
+		um.UpdateUser(uc.ID, func(user *User) {
+			if uc.Name != "" {
+				user.Name = uc.Name
+			}
+			user.Role = role
+			user.PasswordHash = uc.PasswordHash
+			user.IsActive = !uc.Disabled
+			user.Permissions = perms
+		})
+		if user, ok := um.GetUser(uc.ID); ok {
+			applyQuota(user, uc.Quota)
+		}
+		next[uc.ID] = uc
+	}
+
+	var revoked []string
+	for id, previous := range configUsers {
+		current, ok := next[id]
+		if ok && !current.Disabled && current.PasswordHash == previous.PasswordHash {
+			continue
+		}
+		if !ok {
+			um.RemoveUser(id)
+		}
+		GetTokenManager().RevokeAllTokens(id)
+		revoked = append(revoked, id)
+	}
+	configUsers = next
+
+	sort.Strings(revoked)
+	return revoked
+}
+
+// userPermissions construit les permissions d'un compte à partir de sa configuration
+func userPermissions(uc UserConfig) *UserPermissions {
+	perms := &UserPermissions{
+		AllowedPaths:   uc.AllowedPaths,
+		DeniedPaths:    uc.DeniedPaths,
+		AllowedActions: make(map[string]bool),
+		FolderPerms:    make(map[string]Permission),
+	}
+	if len(perms.AllowedPaths) == 0 {
+		perms.AllowedPaths = []string{"*"}
+	}
+	for folder, perm := range uc.Folders {
+		perms.FolderPerms[folder] = perm
+	}
+	return perms
+}
+
+// applyQuota fixe les limites des quotas d'un compte
+// Les compteurs d'utilisation sont conservés d'un rechargement à l'autre.
+func applyQuota(user *User, qc *UserQuotaConfig) {
+	limits := *NewUser(user.ID, user.Name, user.Role).Quota
+	if qc != nil {
+		if qc.MaxStorage > 0 {
+			limits.MaxStorage = qc.MaxStorage
+		}
+		if qc.MaxUpload > 0 {
+			limits.MaxUpload = qc.MaxUpload
+		}
+		if qc.MaxDownload > 0 {
+			limits.MaxDownload = qc.MaxDownload
+		}
+		if qc.MaxFiles > 0 {
+			limits.MaxFiles = qc.MaxFiles
+		}
+	}
+
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	user.Quota.MaxStorage = limits.MaxStorage
+	user.Quota.MaxUpload = limits.MaxUpload
+	user.Quota.MaxDownload = limits.MaxDownload
+	user.Quota.MaxFiles = limits.MaxFiles
+}
+
+// activeUser retourne un utilisateur défini dans la configuration et actif
+func activeUser(id string) (*User, bool) {
+	configUsersMu.Lock()
+	_, configured := configUsers[id]
+	configUsersMu.Unlock()
+	if !configured {
+		return nil, false
+	}
+	user, ok := GetUserManager().GetUser(id)
+	if !ok || !user.IsActive {
+		return nil, false
+	}
+	return user, true
+}
+
+// verifyUserPassword compare un mot de passe au hash du compte
+// Un hash à l'ancien format est remplacé par un hash argon2id après une
+// vérification réussie.
+func verifyUserPassword(user *User, password string) bool {
+	if password == "" {
+		return false
+	}
+	ok, needsUpgrade := VerifyPasswordHash(user.PasswordHash, password)
+	if ok && needsUpgrade {
+		upgradeUserHash(user, HashPassword(password))
+	}
+	return ok
+}
+
+// upgradeUserHash remplace le hash d'un compte dans UserManager et dans host.users
+// Le compte appliqué est mis à jour d'abord: le rechargement de la configuration
+// ne le prend pas pour un changement de mot de passe et ne révoque pas ses jetons.
+func upgradeUserHash(user *User, hash string) {
+	previous := user.PasswordHash
+	configUsersMu.Lock()
+	if uc, ok := configUsers[user.ID]; ok && uc.PasswordHash == previous {
+		uc.PasswordHash = hash
+		configUsers[user.ID] = uc
+	}
+	configUsersMu.Unlock()
+	GetUserManager().UpdateUser(user.ID, func(current *User) {
+		if current.PasswordHash == previous {
+			current.PasswordHash = hash
+		}
+	})
+
+	config, err := LoadConfig()
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ Hash du mot de passe de %s non enregistré: %v", user.ID, err))
+		return
+	}
+	for i := range config.Host.Users {
+		if uc := &config.Host.Users[i]; uc.ID == user.ID && uc.PasswordHash == previous {
+			uc.PasswordHash = hash
+		}
+	}
+	if err := SaveConfig(config); err != nil {
+		addLog(fmt.Sprintf("⚠️ Hash du mot de passe de %s non enregistré: %v", user.ID, err))
+		return
+	}
+	addLog(fmt.Sprintf("🔐 Hash du mot de passe de %s mis à jour (argon2id)", user.ID))
+}
+
+// authenticateUser vérifie le jeton ou le mot de passe d'une demande de connexion
+// Retourne l'utilisateur, ou le motif du refus.
+func authenticateUser(req AuthRequest) (*User, string) {
+	if req.Token != "" {
+		if tokenID, secret, ok := strings.Cut(req.Token, "."); ok {
+			if token, valid := GetTokenManager().ValidateToken(tokenID, secret); valid {
+				if user, ok := activeUser(token.ClientID); ok {
+					return user, ""
+				}
+			}
+		}
+		if req.Password == "" {
+			return nil, "jeton invalide ou expiré"
+		}
+	}
+
+	if req.Username == "" {
+		return nil, "identifiants requis"
+	}
+	user, ok := activeUser(req.Username)
+	if !ok || !verifyUserPassword(user, req.Password) {
+		return nil, "utilisateur ou mot de passe incorrect"
+	}
+	return user, ""
+}
+
+// issueUserToken crée le jeton de reconnexion d'un utilisateur ("id.secret")
+func issueUserToken(user *User) string {
+	token := GetTokenManager().CreateToken(user.ID, GetAuthConfig().SessionTimeout, []string{"sync"})
+	return token.ID + "." + token.Secret
+}
+
+// recordLogin comptabilise une tentative de connexion et la journalise dans l'audit
+func recordLogin(userID, clientIP string, success bool, reason string) {
+	GetLoginLimiter().RecordAttempt(clientIP, success)
+	LogLogin(userID, clientIP, success, reason)
+}
+
+// loginBlocked vérifie si une IP est bloquée après trop de tentatives
+// Retourne le message à renvoyer au client ("" si la tentative est permise).
+func loginBlocked(clientIP string) string {
+	allowed, wait := GetLoginLimiter().CanAttempt(clientIP)
+	if allowed {
+		return ""
+	}
+	if wait > 0 {
+		return fmt.Sprintf("Trop de tentatives, réessayez dans %v", wait.Round(time.Second))
+	}
+	return "Trop de tentatives, réessayez plus tard"
+}
@@ -195,4 +195,99 @@ func copyDirRecursive(src, dst string) error {
 	}
 	
 	return nil
-} 
\ No newline at end of file
+} 
+// internalDirName est le dossier caché réservé à Spiralydata à la racine
+// de chaque dossier synchronisé (fichiers temporaires, données internes).
+// Il n'est jamais scanné, surveillé ni synchronisé.
+const internalDirName = ".spiralydata"
+
+// isInternalPath indique si un chemin relatif appartient au dossier interne
+func isInternalPath(relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	return relPath == internalDirName || strings.HasPrefix(relPath, internalDirName+"/")
+}
+
+// isSafeRelPath vérifie qu'un chemin relatif reçu reste dans le dossier synchronisé
+func isSafeRelPath(relPath string) bool {
+	if relPath == "" || filepath.IsAbs(relPath) || strings.HasPrefix(relPath, "/") {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return !isInternalPath(relPath)
+}
+
+// cleanRelPath normalise un chemin relatif reçu d'un pair (séparateurs "/",
+// segments "." et "/" doublés retirés) et vérifie qu'il reste dans le dossier
+// synchronisé. Les droits et limites sont ensuite vérifiés sur ce chemin.
+func cleanRelPath(relPath string) (string, bool) {
+	if !isSafeRelPath(relPath) {
+		return relPath, false
+	}
+	cleaned := normalizePath(relPath)
+	if cleaned == "." || !isSafeRelPath(cleaned) {
+		return relPath, false
+	}
+	return cleaned, true
+}
+
+// isInternalFile indique si un chemin absolu appartient au dossier interne de root
+func isInternalFile(root, fullPath string) bool {
+	relPath, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		return false
+	}
+	return isInternalPath(relPath)
+}
+
+// internalTempDir retourne le dossier des fichiers temporaires de root
+func internalTempDir(root string) string {
+	return filepath.Join(root, internalDirName, "tmp")
+}
+
+// writeFileAtomic écrit un fichier via un fichier temporaire puis un renommage,
+// pour ne jamais laisser de fichier partiellement écrit à destination
+func writeFileAtomic(root, target string, data []byte) error {
+	tmpDir := internalTempDir(root)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(tmpDir, "write-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return moveFile(tmpPath, target)
+}
+
+// moveFile déplace un fichier en remplaçant la destination
+// Si le renommage échoue (volumes différents), le fichier est copié puis supprimé
+func moveFile(src, dst string) error {
+	if err := os.Chmod(src, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		os.Remove(src)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// VECTEURS DE VERSION
+// ============================================================================
+//
+// Chaque nœud (l'hôte et chaque client) a un identifiant stable, conservé dans
+// son état de synchronisation. Le vecteur d'un fichier compte les modifications
+// faites par chaque nœud. Deux versions sont en conflit seulement si aucune ne
+// contient toutes les modifications de l'autre (modifications concurrentes).
+
+// VersionVector nombre de modifications d'un fichier par nœud
+type VersionVector map[string]uint64
+
+// VectorOrder relation entre deux vecteurs de version
+type VectorOrder int
+
+const (
+	VectorEqual      VectorOrder = iota // Même version
+	VectorBefore                        // Version plus ancienne que l'autre
+	VectorAfter                         // Version qui succède à l'autre
+	VectorConcurrent                    // Modifications concurrentes: conflit
+)
+
+// Copy retourne une copie du vecteur
+func (v VersionVector) Copy() VersionVector {
+	c := make(VersionVector, len(v)+1)
+	for node, n := range v {
+		c[node] = n
+	}
+	return c
+}
+
+// Increment retourne une copie du vecteur avec une modification de plus pour node
+func (v VersionVector) Increment(node string) VersionVector {
+	c := v.Copy()
+	c[node]++
+	return c
+}
+
+// Merge retourne le plus petit vecteur qui succède à v et à other
+func (v VersionVector) Merge(other VersionVector) VersionVector {
+	c := v.Copy()
+	for node, n := range other {
+		if n > c[node] {
+			c[node] = n
+		}
+	}
+	return c
+}
+
+// Compare situe v par rapport à other
+func (v VersionVector) Compare(other VersionVector) VectorOrder {
+	newer, older := false, false
+	for node, n := range v {
+		if n > other[node] {
+			newer = true
+		} else if n < other[node] {
+			older = true
+		}
+	}
+	for node, n := range other {
+		if _, ok := v[node]; !ok && n > 0 {
+			older = true
+		}
+	}
+
+	switch {
+	case newer && older:
+		return VectorConcurrent
+	case newer:
+		return VectorAfter
+	case older:
+		return VectorBefore
+	default:
+		return VectorEqual
+	}
+}
+
+// String retourne le vecteur sous forme lisible (nœuds triés)
+func (v VersionVector) String() string {
+	nodes := make([]string, 0, len(v))
+	for node := range v {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = fmt.Sprintf("%s:%d", node, v[node])
+	}
+	return "{" + strings.Join(parts, " ") + "}"
+}
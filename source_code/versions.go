@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================================================
+// HISTORIQUE DES VERSIONS (hôte)
+// ============================================================================
+//
+// Avant qu'un client remplace ou supprime un fichier, le contenu actuel est
+// enregistré dans le stockage dédupliqué. La liste des versions de chaque
+// fichier est conservée dans .spiralydata/versions.json du dossier partagé,
+// chiffrée avec le stockage si host.encrypt_store est activé.
+// Les versions sont limitées en nombre par fichier, en âge et en taille totale.
+
+const versionsFileName = "versions.json"
+
+// Limites par défaut de l'historique
+const (
+	defaultVersionsMaxCount = 10
+	defaultVersionsMaxAge   = 30 * 24 * time.Hour
+	defaultVersionsMaxSize  = 1024 * 1024 * 1024 // 1 GB
+)
+
+// FileRevision version précédente d'un fichier
+type FileRevision struct {
+	ID       string    `json:"id"`
+	Path     string    `json:"path"`
+	Manifest string    `json:"manifest"` // Contenu dans le stockage
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	SavedAt  time.Time `json:"saved_at"`
+	Deleted  bool      `json:"deleted,omitempty"` // Version enregistrée lors d'une suppression
+}
+
+// VersionLimits bornes de l'historique (0 = valeur par défaut)
+type VersionLimits struct {
+	MaxCount int           // Versions conservées par fichier
+	MaxAge   time.Duration // Âge maximum d'une version
+	MaxSize  int64         // Taille cumulée de toutes les versions
+}
+
+// VersionHistory versions précédentes des fichiers d'un dossier partagé
+type VersionHistory struct {
+	root      string
+	limits    VersionLimits
+	mu        sync.Mutex
+	revisions map[string][]FileRevision // Chemin relatif -> versions, de la plus récente à la plus ancienne
+	saver     *Debouncer
+}
+
+// NewVersionHistory charge l'historique d'un dossier partagé
+func NewVersionHistory(root string, limits VersionLimits) *VersionHistory {
+	if limits.MaxCount <= 0 {
+		limits.MaxCount = defaultVersionsMaxCount
+	}
+	if limits.MaxAge <= 0 {
+		limits.MaxAge = defaultVersionsMaxAge
+	}
+	if limits.MaxSize <= 0 {
+		limits.MaxSize = defaultVersionsMaxSize
+	}
+
+	vh := &VersionHistory{
+		root:      root,
+		limits:    limits,
+		revisions: make(map[string][]FileRevision),
+		saver:     NewDebouncer(2 * time.Second),
+	}
+	vh.load()
+	vh.mu.Lock()
+	vh.prune()
+	vh.mu.Unlock()
+
+	GetChunkStore().RegisterRoots("versions:"+root, vh.storeRoots)
+	return vh
+}
+
+// versionLimitsFromConfig lit les limites de l'historique dans la configuration
+func versionLimitsFromConfig() VersionLimits {
+	config, _ := LoadConfig()
+	if config == nil {
+		return VersionLimits{}
+	}
+	return VersionLimits{
+		MaxCount: config.VersionsMaxCount,
+		MaxAge:   time.Duration(config.VersionsMaxAgeDays) * 24 * time.Hour,
+		MaxSize:  config.VersionsMaxSize,
+	}
+}
+
+// Capture enregistre le contenu actuel d'un fichier avant son remplacement
+func (vh *VersionHistory) Capture(relPath string, deleted bool) {
+	if vh == nil {
+		return
+	}
+
+	fullPath := filepath.Join(vh.root, filepath.FromSlash(relPath))
+	info, err := os.Stat(fullPath)
+	if err != nil || info.IsDir() {
+		return
+	}
+
+	id, err := GetChunkStore().ManifestOf(fullPath)
+	if err != nil {
+		addLog(fmt.Sprintf("⚠️ Historique %s: %v", relPath, err))
+		return
+	}
+
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	list := vh.revisions[relPath]
+	if len(list) > 0 && list[0].Manifest == id {
+		// Même contenu que la dernière version: seule la suppression est notée
+		list[0].Deleted = list[0].Deleted || deleted
+		return
+	}
+
+	rev := FileRevision{
+		ID:       newMessageID(),
+		Path:     relPath,
+		Manifest: id,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		SavedAt:  time.Now(),
+		Deleted:  deleted,
+	}
+	vh.revisions[relPath] = append([]FileRevision{rev}, list...)
+	vh.prune()
+	vh.saver.Call(vh.save)
+}
+
+// CaptureDir enregistre tous les fichiers d'un dossier avant sa suppression
+func (vh *VersionHistory) CaptureDir(relPath string) {
+	if vh == nil {
+		return
+	}
+
+	base := filepath.Join(vh.root, filepath.FromSlash(relPath))
+	filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(vh.root, path)
+		if err != nil {
+			return nil
+		}
+		vh.Capture(filepath.ToSlash(rel), true)
+		return nil
+	})
+}
+
+// List retourne les versions d'un fichier, de la plus récente à la plus ancienne
+func (vh *VersionHistory) List(relPath string) []FileRevision {
+	if vh == nil {
+		return nil
+	}
+
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	list := vh.revisions[relPath]
+	result := make([]FileRevision, len(list))
+	copy(result, list)
+	return result
+}
+
+// Find retourne une version d'un fichier
+func (vh *VersionHistory) Find(relPath, id string) (FileRevision, bool) {
+	for _, rev := range vh.List(relPath) {
+		if rev.ID == id {
+			return rev, true
+		}
+	}
+	return FileRevision{}, false
+}
+
+// Restore remplace un fichier par une de ses versions
+// Le contenu remplacé devient lui-même une version: la restauration est réversible.
+func (vh *VersionHistory) Restore(relPath, id string) (FileRevision, error) {
+	if vh == nil {
+		return FileRevision{}, fmt.Errorf("historique indisponible")
+	}
+
+	rev, ok := vh.Find(relPath, id)
+	if !ok {
+		return rev, fmt.Errorf("version inconnue: %s", id)
+	}
+
+	vh.Capture(relPath, false)
+
+	fullPath := filepath.Join(vh.root, filepath.FromSlash(relPath))
+	if err := GetChunkStore().RestoreFile(rev.Manifest, fullPath); err != nil {
+		return rev, err
+	}
+	return rev, nil
+}
+
+// Move reporte l'historique d'un fichier (ou des fichiers d'un dossier) déplacé
+func (vh *VersionHistory) Move(from, to string) {
+	if vh == nil {
+		return
+	}
+
+	prefix := from + "/"
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	moved := false
+	for path, list := range vh.revisions {
+		if path != from && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		newPath := to + path[len(from):]
+		for i := range list {
+			list[i].Path = newPath
+		}
+		delete(vh.revisions, path)
+		vh.revisions[newPath] = append(list, vh.revisions[newPath]...)
+		moved = true
+	}
+	if moved {
+		vh.saver.Call(vh.save)
+	}
+}
+
+// prune applique les limites (mu verrouillé)
+func (vh *VersionHistory) prune() {
+	cutoff := time.Now().Add(-vh.limits.MaxAge)
+
+	var all []FileRevision
+	for path, list := range vh.revisions {
+		kept := list[:0]
+		for _, rev := range list {
+			if len(kept) < vh.limits.MaxCount && rev.SavedAt.After(cutoff) {
+				kept = append(kept, rev)
+			}
+		}
+		if len(kept) == 0 {
+			delete(vh.revisions, path)
+			continue
+		}
+		vh.revisions[path] = kept
+		all = append(all, kept...)
+	}
+
+	// Taille totale: supprimer les plus anciennes versions, tous fichiers confondus
+	var total int64
+	for _, rev := range all {
+		total += rev.Size
+	}
+	if total <= vh.limits.MaxSize {
+		return
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].SavedAt.Before(all[j].SavedAt) })
+	drop := make(map[string]bool)
+	for _, rev := range all {
+		if total <= vh.limits.MaxSize {
+			break
+		}
+		drop[rev.ID] = true
+		total -= rev.Size
+	}
+
+	for path, list := range vh.revisions {
+		kept := list[:0]
+		for _, rev := range list {
+			if !drop[rev.ID] {
+				kept = append(kept, rev)
+			}
+		}
+		if len(kept) == 0 {
+			delete(vh.revisions, path)
+		} else {
+			vh.revisions[path] = kept
+		}
+	}
+}
+
+// storeRoots retourne les manifestes référencés par l'historique
+func (vh *VersionHistory) storeRoots() []string {
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	var ids []string
+	for _, list := range vh.revisions {
+		for _, rev := range list {
+			ids = append(ids, rev.Manifest)
+		}
+	}
+	return ids
+}
+
+// path retourne le fichier de l'historique
+func (vh *VersionHistory) path() string {
+	return filepath.Join(vh.root, internalDirName, versionsFileName)
+}
+
+// load lit l'historique, puis le chiffre s'il était encore en clair
+func (vh *VersionHistory) load() {
+	data, err := GetChunkStore().ReadSealed(vh.path())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			addLog(fmt.Sprintf("⚠️ Historique des versions illisible: %v", err))
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &vh.revisions); err != nil {
+		addLog(fmt.Sprintf("⚠️ Historique des versions illisible: %v", err))
+		vh.revisions = make(map[string][]FileRevision)
+		return
+	}
+	if GetChunkStore().NeedsSealing(vh.path()) {
+		vh.saver.Call(vh.save)
+	}
+}
+
+func (vh *VersionHistory) save() {
+	vh.mu.Lock()
+	data, err := json.Marshal(vh.revisions)
+	vh.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := GetChunkStore().WriteSealed(vh.root, vh.path(), data); err != nil {
+		addLog(fmt.Sprintf("⚠️ Historique des versions: %v", err))
+	}
+}
+
+// Close enregistre l'historique immédiatement
+func (vh *VersionHistory) Close() {
+	if vh == nil {
+		return
+	}
+	vh.save()
+}